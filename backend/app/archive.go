@@ -0,0 +1,29 @@
+package app
+
+import (
+	"LibManSys/model"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (l *LibraryManagementSystemImpl) Backup(w io.Writer) *ApiResult {
+	manifest, err := model.WriteArchive(w, l.Connector)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(manifest)
+}
+
+func (l *LibraryManagementSystemImpl) Restore(r io.Reader) *ApiResult {
+	result, err := model.ReadArchive(r, l.Connector)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	if err := l.rebuildIndex(); err != nil {
+		logrus.Error(err)
+	}
+	return Success(result)
+}
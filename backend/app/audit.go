@@ -0,0 +1,31 @@
+package app
+
+import (
+	"LibManSys/model"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (l *LibraryManagementSystemImpl) WithAudit(audit *model.AuditContext) LibraryManagementSystem {
+	scoped := *l
+	scoped.Connector = l.Connector.WithAudit(audit)
+	return &scoped
+}
+
+func (l *LibraryManagementSystemImpl) ShowAudit(query *model.AuditQuery) *ApiResult {
+	list, err := l.Connector.ShowAudit(query)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(list)
+}
+
+func (l *LibraryManagementSystemImpl) ShowBookHistory(bookId int, page *model.Page) *ApiResult {
+	return l.ShowAudit(&model.AuditQuery{
+		EntityType: model.AuditBook,
+		EntityID:   strconv.Itoa(bookId),
+		Page:       page,
+	})
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"LibManSys/model"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newUser returns a user with the password hashed, or the failure of the checks of the password
+func newUser(username string, password string, role model.UserRole) (*model.User, error) {
+	hash, err := model.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return &model.User{
+		Username:     model.NormalizeUsername(username),
+		PasswordHash: hash,
+		Role:         role,
+	}, nil
+}
+
+func (l *LibraryManagementSystemImpl) BootstrapAdmin(username string, password string) *ApiResult {
+	user, err := newUser(username, password, model.UserAdmin)
+	if err == nil {
+		err = l.Connector.BootstrapUser(user)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	logrus.WithField("username", user.Username).Info("First admin created")
+	return Success(user)
+}
+
+func (l *LibraryManagementSystemImpl) CreateUser(username string, password string, role model.UserRole) *ApiResult {
+	user, err := newUser(username, password, role)
+	if err == nil {
+		err = l.Connector.CreateUser(user)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(user)
+}
+
+func (l *LibraryManagementSystemImpl) ShowUsers() *ApiResult {
+	users, err := l.Connector.ShowUsers()
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(users)
+}
+
+func (l *LibraryManagementSystemImpl) SetUserRole(userId int, role model.UserRole) *ApiResult {
+	err := l.Connector.SetUserRole(userId, role)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(nil)
+}
+
+func (l *LibraryManagementSystemImpl) SetUserPassword(userId int, password string) *ApiResult {
+	hash, err := model.HashPassword(password)
+	var user *model.User
+	if err == nil {
+		err = l.Connector.SetUserPassword(userId, hash)
+	}
+	if err == nil {
+		user, err = l.Connector.QueryUser(userId)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	l.logins.reset(user.Username)
+	return Success(nil)
+}
+
+// ChangePassword counts a wrong old password against the username as Login does
+func (l *LibraryManagementSystemImpl) ChangePassword(userId int, oldPassword string, password string) *ApiResult {
+	user, err := l.Connector.QueryUser(userId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	if l.logins.isLocked(user.Username) {
+		logrus.WithField("username", user.Username).Warn(model.ErrUserLocked)
+		return Failure(model.ErrUserLocked)
+	}
+	if !user.CheckPassword(oldPassword) {
+		l.logins.fail(user.Username)
+		logrus.WithField("username", user.Username).Warn(model.ErrInvalidCredentials)
+		return Failure(model.ErrInvalidCredentials)
+	}
+	return l.SetUserPassword(userId, password)
+}
+
+func (l *LibraryManagementSystemImpl) RemoveUser(userId int) *ApiResult {
+	err := l.Connector.RemoveUser(userId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(nil)
+}
+
+func (l *LibraryManagementSystemImpl) Login(username string, password string) *ApiResult {
+	username = model.NormalizeUsername(username)
+	if l.logins.isLocked(username) {
+		logrus.WithField("username", username).Warn(model.ErrUserLocked)
+		return Failure(model.ErrUserLocked)
+	}
+	user, err := l.Connector.QueryUserByName(username)
+	if err != nil && !errors.Is(err, model.ErrUserNotFound) {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	// the unknown usernames are counted too, so that a lockout does not tell which ones exist
+	if !user.CheckPassword(password) {
+		l.logins.fail(username)
+		logrus.WithField("username", username).Warn(model.ErrInvalidCredentials)
+		return Failure(model.ErrInvalidCredentials)
+	}
+	l.logins.reset(username)
+
+	token, session, err := model.NewSession(user.UserID)
+	if err == nil {
+		err = l.Connector.CreateSession(session)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(&model.LoginResult{Token: token, ExpireTime: session.ExpireTime, User: *user})
+}
+
+func (l *LibraryManagementSystemImpl) Logout(token string) *ApiResult {
+	err := l.Connector.RemoveSession(model.HashToken(token))
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(nil)
+}
+
+func (l *LibraryManagementSystemImpl) Authenticate(token string) *ApiResult {
+	if token == "" {
+		return Failure(model.ErrMissingToken)
+	}
+	user, err := l.Connector.QuerySession(model.HashToken(token))
+	if err != nil {
+		if !errors.Is(err, model.ErrUnauthorized) {
+			logrus.Error(err)
+		}
+		return Failure(err)
+	}
+	return Success(user)
+}
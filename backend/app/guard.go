@@ -0,0 +1,72 @@
+package app
+
+import (
+	"LibManSys/model"
+	"sync"
+	"time"
+)
+
+// attemptGuard counts the failed logins of each key and locks the keys with too many of them in a row for a while
+type attemptGuard[K comparable] struct {
+	mu       sync.Mutex
+	failures map[K]int
+	locked   map[K]time.Time
+	// limit returns the failures in a row locking a key out and how long it stays locked
+	limit func() (int, time.Duration)
+}
+
+// loginLimit locks the staff usernames out after MaxLoginAttempts wrong passwords
+func loginLimit() (int, time.Duration) {
+	policy := model.CurrentAuthPolicy()
+	return policy.MaxLoginAttempts, policy.LoginLockout
+}
+
+// pinLimit locks the cards out of the portal after MaxPINAttempts wrong PINs
+func pinLimit() (int, time.Duration) {
+	policy := model.CurrentAuthPolicy()
+	return policy.MaxPINAttempts, policy.PINLockout
+}
+
+func (g *attemptGuard[K]) isLocked(key K) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	until, ok := g.locked[key]
+	if ok && time.Now().After(until) {
+		delete(g.locked, key)
+		return false
+	}
+	return ok
+}
+
+func (g *attemptGuard[K]) fail(key K) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.failures == nil {
+		g.failures = make(map[K]int)
+		g.locked = make(map[K]time.Time)
+	}
+	attempts, lockout := g.limit()
+	g.failures[key]++
+	if g.failures[key] >= attempts {
+		delete(g.failures, key)
+		g.locked[key] = time.Now().Add(lockout)
+	}
+}
+
+func (g *attemptGuard[K]) reset(key K) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.failures, key)
+	delete(g.locked, key)
+}
+
+func (g *attemptGuard[K]) clear() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.failures = nil
+	g.locked = nil
+}
@@ -7,15 +7,41 @@ import (
 )
 
 type LibraryManagementSystemImpl struct {
-	Connector *model.DatabaseConnector
+	Connector model.Connector
+	// Index is the full-text index of the books, built by Init and kept in sync with the writes to the books
+	Index *model.BookIndex
+	// logins locks the staff usernames out after too many wrong passwords
+	logins *attemptGuard[string]
+	// pins locks the cards out of the portal after too many wrong PINs
+	pins *attemptGuard[int]
+	// resets keeps the resets asked for until they are confirmed
+	resets *resetGuard
 }
 
 var LMS LibraryManagementSystem
 
 func NewLibraryManagementSystemImpl(config *model.ConnectConfig) *LibraryManagementSystemImpl {
-	return &LibraryManagementSystemImpl{Connector: &model.DatabaseConnector{
-		Config: *config,
-	}}
+	return &LibraryManagementSystemImpl{
+		Connector: model.NewConnector(config),
+		Index:     model.NewBookIndex(),
+		logins:    &attemptGuard[string]{limit: loginLimit},
+		pins:      &attemptGuard[int]{limit: pinLimit},
+		resets:    &resetGuard{},
+	}
+}
+
+func NewLibraryManagementSystemSQLite(path string) *LibraryManagementSystemImpl {
+	return NewLibraryManagementSystemImpl(&model.ConnectConfig{
+		Driver: model.DriverSQLite,
+		Path:   path,
+	})
+}
+
+func NewLibraryManagementSystemMemory(snapshot string) *LibraryManagementSystemImpl {
+	return NewLibraryManagementSystemImpl(&model.ConnectConfig{
+		Driver: model.DriverMemory,
+		Path:   snapshot,
+	})
 }
 
 func (l *LibraryManagementSystemImpl) Connect() error {
@@ -31,12 +57,11 @@ func (l *LibraryManagementSystemImpl) Init() error {
 	if err != nil {
 		return err
 	}
-	l.AutoMigrate(
-		model.Book{},
-		model.Card{},
-		model.Borrow{},
-	)
-	return nil
+	err = l.Connector.Migrate()
+	if err != nil {
+		return err
+	}
+	return l.rebuildIndex()
 }
 
 func (l *LibraryManagementSystemImpl) Free() {
@@ -51,11 +76,9 @@ func (l *LibraryManagementSystemImpl) StoreBook(book *model.Book) *ApiResult {
 	err := l.Connector.StoreBook(book)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
+	l.refreshIndex(book.BookID)
 	return Success(nil)
 }
 
@@ -63,10 +86,7 @@ func (l *LibraryManagementSystemImpl) IncBookStock(bookId int, deltaStock int) *
 	err := l.Connector.IncBookStock(bookId, deltaStock)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
 	return Success(nil)
 }
@@ -75,11 +95,13 @@ func (l *LibraryManagementSystemImpl) StoreBooks(books []model.Book) *ApiResult
 	err := l.Connector.StoreBooks(books)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
+	}
+	bookIds := make([]int, len(books))
+	for i := range books {
+		bookIds[i] = books[i].BookID
 	}
+	l.refreshIndex(bookIds...)
 	return Success(nil)
 }
 
@@ -87,11 +109,9 @@ func (l *LibraryManagementSystemImpl) RemoveBook(bookId int) *ApiResult {
 	err := l.Connector.RemoveBook(bookId)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
+	l.Index.Remove(bookId)
 	return Success(nil)
 }
 
@@ -99,58 +119,102 @@ func (l *LibraryManagementSystemImpl) ModifyBookInfo(book *model.Book) *ApiResul
 	err := l.Connector.ModifyBookInfo(book)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
+	l.refreshIndex(book.BookID)
 	return Success(nil)
 }
 
+func (l *LibraryManagementSystemImpl) PatchBook(bookId int, patch *model.BookPatch) *ApiResult {
+	book, err := l.Connector.PatchBook(bookId, patch)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	l.refreshIndex(bookId)
+	return Success(book)
+}
+
 func (l *LibraryManagementSystemImpl) QueryBook(conditions *model.BookQueryConditions) *ApiResult {
 	result, err := l.Connector.QueryBook(conditions)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
 	return Success(result)
 }
 
+func (l *LibraryManagementSystemImpl) QueryBookByID(bookId int) *ApiResult {
+	result, err := l.Connector.QueryBook(model.NewBookQueryConditions().WithBookIDs(bookId))
+	if err == nil && result.Count == 0 {
+		err = model.ErrBookNotFound
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(result.Results[0])
+}
+
+func (l *LibraryManagementSystemImpl) QueryBookByISBN(isbn string) *ApiResult {
+	result, err := l.Connector.QueryBook(model.NewBookQueryConditions().WithISBN(isbn))
+	if err == nil && result.Count == 0 {
+		err = model.ErrBookNotFound
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(result.Results[0])
+}
+
 func (l *LibraryManagementSystemImpl) BorrowBook(borrow *model.Borrow) *ApiResult {
 	err := l.Connector.BorrowBook(borrow)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
 	return Success(nil)
 }
 
+func (l *LibraryManagementSystemImpl) LendBook(userId int, borrow *model.Borrow) *ApiResult {
+	if borrow.DueTime != 0 {
+		user, err := l.Connector.QueryUser(userId)
+		if err != nil {
+			logrus.Error(err)
+			return Failure(err)
+		}
+		if !user.Role.Allows(model.UserLibrarian) {
+			logrus.WithField("username", user.Username).Warn(model.ErrPermissionDenied)
+			return Failure(model.ErrPermissionDenied)
+		}
+	}
+	return l.BorrowBook(borrow)
+}
+
 func (l *LibraryManagementSystemImpl) ReturnBook(borrow *model.Borrow) *ApiResult {
 	err := l.Connector.ReturnBook(borrow)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
 	return Success(nil)
 }
 
-func (l *LibraryManagementSystemImpl) ShowBorrowHistory(cardId int) *ApiResult {
-	borrowHistory, err := l.Connector.ShowBorrowHistory(cardId)
+func (l *LibraryManagementSystemImpl) RenewBook(borrow *model.Borrow) *ApiResult {
+	err := l.Connector.RenewBook(borrow)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
+	}
+	return Success(nil)
+}
+
+func (l *LibraryManagementSystemImpl) ShowBorrowHistory(cardId int, page *model.Page) *ApiResult {
+	borrowHistory, err := l.Connector.ShowBorrowHistory(cardId, page)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
 	}
 	return Success(borrowHistory)
 }
@@ -159,10 +223,7 @@ func (l *LibraryManagementSystemImpl) RegisterCard(card *model.Card) *ApiResult
 	err := l.Connector.RegisterCard(card)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
 	return Success(nil)
 }
@@ -171,10 +232,16 @@ func (l *LibraryManagementSystemImpl) QueryCard(cardId int) *ApiResult {
 	card, err := l.Connector.QueryCard(cardId)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
+	}
+	return Success(card)
+}
+
+func (l *LibraryManagementSystemImpl) PatchCard(cardId int, patch *model.CardPatch) *ApiResult {
+	card, err := l.Connector.PatchCard(cardId, patch)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
 	}
 	return Success(card)
 }
@@ -183,34 +250,147 @@ func (l *LibraryManagementSystemImpl) RemoveCard(cardId int) *ApiResult {
 	err := l.Connector.RemoveCard(cardId)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
 	return Success(nil)
 }
 
-func (l *LibraryManagementSystemImpl) ShowCards() *ApiResult {
-	cardList, err := l.Connector.ShowCards()
+func (l *LibraryManagementSystemImpl) ShowCards(page *model.Page) *ApiResult {
+	cardList, err := l.Connector.ShowCards(page)
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
 	}
 	return Success(cardList)
 }
 
+func (l *LibraryManagementSystemImpl) ShowFines(cardId int) *ApiResult {
+	ledger, err := l.Connector.ShowFines(cardId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(ledger)
+}
+
+func (l *LibraryManagementSystemImpl) SettleFine(entry *model.FineEntry) *ApiResult {
+	err := l.Connector.SettleFine(entry)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(nil)
+}
+
 func (l *LibraryManagementSystemImpl) ResetDatabase() *ApiResult {
 	err := l.Connector.ResetDatabase()
 	if err != nil {
 		logrus.Error(err)
-		return &ApiResult{
-			OK:      false,
-			Message: err.Error(),
-		}
+		return Failure(err)
+	}
+	l.Index.Reset(nil)
+	l.pins.clear()
+	return Success(nil)
+}
+
+func (l *LibraryManagementSystemImpl) PlaceHold(hold *model.Hold) *ApiResult {
+	err := l.Connector.PlaceHold(hold)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(hold)
+}
+
+func (l *LibraryManagementSystemImpl) CancelHold(holdId int) *ApiResult {
+	err := l.Connector.CancelHold(holdId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
 	}
 	return Success(nil)
 }
+
+func (l *LibraryManagementSystemImpl) ShowCardHolds(cardId int) *ApiResult {
+	holdList, err := l.Connector.ShowCardHolds(cardId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(holdList)
+}
+
+func (l *LibraryManagementSystemImpl) ShowBookHolds(bookId int) *ApiResult {
+	holdList, err := l.Connector.ShowBookHolds(bookId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(holdList)
+}
+
+func (l *LibraryManagementSystemImpl) ExpireHolds() *ApiResult {
+	expired, err := l.Connector.ExpireHolds()
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	if expired > 0 {
+		logrus.WithField("count", expired).Info("Expired unclaimed holds")
+	}
+	return Success(expired)
+}
+
+func (l *LibraryManagementSystemImpl) ShowCopies(bookId int) *ApiResult {
+	copyList, err := l.Connector.ShowCopies(bookId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(copyList)
+}
+
+func (l *LibraryManagementSystemImpl) AddCopies(bookId int, copies []model.BookCopy) *ApiResult {
+	err := l.Connector.AddCopies(bookId, copies)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(copies)
+}
+
+func (l *LibraryManagementSystemImpl) WithdrawCopies(barcodes []string) *ApiResult {
+	err := l.Connector.WithdrawCopies(barcodes)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(nil)
+}
+
+func (l *LibraryManagementSystemImpl) UpdateCopy(bookCopy *model.BookCopy) *ApiResult {
+	err := l.Connector.UpdateCopy(bookCopy)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(bookCopy)
+}
+
+func (l *LibraryManagementSystemImpl) ShowAuthors(name string) *ApiResult {
+	authorList, err := l.Connector.ShowAuthors(name)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(authorList)
+}
+
+func (l *LibraryManagementSystemImpl) ShowAuthorWorks(authorId int) *ApiResult {
+	works, err := l.Connector.ShowAuthorWorks(authorId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(works)
+}
@@ -0,0 +1,367 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func backup(t *testing.T, lms app.LibraryManagementSystem) ([]byte, *model.ArchiveManifest) {
+	t.Helper()
+	var archive bytes.Buffer
+	result := lms.Backup(&archive)
+	mustOK(t, result)
+	manifest, ok := result.Payload.(*model.ArchiveManifest)
+	if !ok {
+		t.Fatalf("unexpected Backup payload %T", result.Payload)
+	}
+	return archive.Bytes(), manifest
+}
+
+func restore(t *testing.T, lms app.LibraryManagementSystem, archive []byte) *model.RestoreResult {
+	t.Helper()
+	result := lms.Restore(bytes.NewReader(archive))
+	mustOK(t, result)
+	restored, ok := result.Payload.(*model.RestoreResult)
+	if !ok {
+		t.Fatalf("unexpected Restore payload %T", result.Payload)
+	}
+	return restored
+}
+
+// emptySystems returns new systems of every backend to restore archives into, whatever the backend of the suite
+func emptySystems(t *testing.T) map[string]app.LibraryManagementSystem {
+	t.Helper()
+	systems := map[string]app.LibraryManagementSystem{
+		"memory": app.NewLibraryManagementSystemMemory(""),
+		"sqlite": app.NewLibraryManagementSystemSQLite(t.TempDir() + "/restored.db"),
+	}
+	for name, lms := range systems {
+		if err := lms.Init(); err != nil {
+			t.Fatalf("init %s: %v", name, err)
+		}
+		t.Cleanup(lms.Free)
+	}
+	return systems
+}
+
+// buildArchive writes an archive of the rows of tables, as a version of the backend of the given schema version would
+func buildArchive(t *testing.T, schemaVersion int, tables map[string][]string) []byte {
+	t.Helper()
+	manifest := model.ArchiveManifest{Format: model.ArchiveFormat, Version: model.ArchiveVersion, SchemaVersion: schemaVersion}
+	files := make(map[string]string)
+	for _, schema := range model.Tables {
+		name := model.TableName(schema)
+		rows, ok := tables[name]
+		if !ok {
+			continue
+		}
+		content := ""
+		for _, row := range rows {
+			content += row + "\n"
+		}
+		sum := sha256.Sum256([]byte(content))
+		table := model.ArchiveTable{Name: name, File: "tables/" + name + ".jsonl", Rows: len(rows), SHA256: hex.EncodeToString(sum[:])}
+		manifest.Tables = append(manifest.Tables, table)
+		files[table.File] = content
+	}
+	data, err := json.Marshal(&manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	zw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(zw)
+	write := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("manifest.json", data)
+	for _, table := range manifest.Tables {
+		write(table.File, []byte(files[table.File]))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+// rewriteArchive returns the archive with the files changed by rewrite, the ones it returns nil for are left out
+func rewriteArchive(t *testing.T, archive []byte, rewrite func(name string, content []byte) []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+
+	var rewritten bytes.Buffer
+	zw := gzip.NewWriter(&rewritten)
+	tw := tar.NewWriter(zw)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = rewrite(header.Name, content)
+		if content == nil {
+			continue
+		}
+		header.Size = int64(len(content))
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return rewritten.Bytes()
+}
+
+func testArchiveRoundTrip(t *testing.T, lms app.LibraryManagementSystem) {
+	createUser(t, lms, "keeper", model.UserAdmin)
+	alpha := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	beta := newBook("beta", "math", 2002, 20.5, 2)
+	beta.Author = "Ann & Bob"
+	beta = withISBN(beta, "9780306406157")
+	storeBook(t, lms, beta)
+	reader := registerCard(t, lms, "reader", "S")
+	other := registerCard(t, lms, "other", "T")
+	mustOK(t, lms.SetCardPIN(reader, "1234"))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: reader, BookID: alpha}))
+	placeHold(t, lms, other, alpha)
+	token := patronLogin(t, lms, reader, "1234")
+
+	archive, manifest := backup(t, lms)
+	if manifest.SchemaVersion != model.LatestSchemaVersion() || manifest.Format != model.ArchiveFormat {
+		t.Fatalf("unexpected manifest %+v", *manifest)
+	}
+	rows := make(map[string]int)
+	for _, table := range manifest.Tables {
+		rows[table.Name] = table.Rows
+	}
+	if rows["book"] != 2 || rows["card"] != 2 || rows["borrow"] != 1 || rows["book_copy"] != 3 || rows["users"] != 1 {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+	if _, ok := rows["patron_session"]; ok {
+		t.Fatal("expected the sessions left out of the archive")
+	}
+
+	books := queryBooks(t, lms, model.NewBookQueryConditions())
+	for name, target := range emptySystems(t) {
+		restored := restore(t, target, archive)
+		if restored.Rows["book"] != 2 || restored.Rows["users"] != 1 || len(restored.Skipped) != 0 {
+			t.Fatalf("%s: unexpected restore %+v", name, *restored)
+		}
+
+		got := queryBooks(t, target, model.NewBookQueryConditions())
+		if len(got) != len(books) {
+			t.Fatalf("%s: expected %d books, got %d", name, len(books), len(got))
+		}
+		for i := range books {
+			want, have := books[i], got[i]
+			if want.BookID != have.BookID || want.Title != have.Title || want.Price != have.Price || want.Stock != have.Stock ||
+				want.Version != have.Version || (want.ISBN == nil) != (have.ISBN == nil) || len(want.Contributors) != len(have.Contributors) {
+				t.Fatalf("%s: expected book %+v, got %+v", name, want, have)
+			}
+		}
+		if len(cardList(t, target)) != 2 {
+			t.Fatalf("%s: expected the 2 cards", name)
+		}
+		history := borrowHistory(t, target, reader)
+		if len(history) != 1 || history[0].ReturnTime != 0 {
+			t.Fatalf("%s: unexpected loans %+v", name, history)
+		}
+		holds := holdList(t, target.ShowBookHolds(alpha))
+		if len(holds) != 1 || holds[0].CardID != other || holds[0].Status != model.HoldWaiting {
+			t.Fatalf("%s: unexpected holds %+v", name, holds)
+		}
+		if statuses := copyStatuses(copyList(t, target, alpha)); statuses[model.CopyOnLoan] != 1 {
+			t.Fatalf("%s: unexpected copies %v", name, statuses)
+		}
+		if found := searchBooks(t, target, "beta"); found.Count != 1 {
+			t.Fatalf("%s: expected the restored books indexed, got %d hits", name, found.Count)
+		}
+
+		// the staff and patrons sign in as before, but the sessions were not restored
+		login(t, target, "keeper", "keeper password")
+		mustFail(t, target.AuthenticatePatron(token), utils.E_UNAUTHORIZED)
+		authenticatePatron(t, target, patronLogin(t, target, reader, "1234"))
+		result := target.ShowBookHistory(alpha, nil)
+		mustOK(t, result)
+		if entries := result.Payload.(*model.AuditList).Entries; len(entries) == 0 || entries[len(entries)-1].Action != "create" {
+			t.Fatalf("%s: expected the audit log restored, got %+v", name, entries)
+		}
+		restores := showAudit(t, target, &model.AuditQuery{EntityType: model.AuditDatabase, Action: "restore"})
+		if restores.Count != 1 {
+			t.Fatalf("%s: expected the restore in the audit log, got %d entries", name, restores.Count)
+		}
+
+		// the ids go on after the restored ones
+		if id := storeBook(t, target, newBook("gamma", "cs", 2003, 30, 1)); id <= beta.BookID {
+			t.Fatalf("%s: expected a book id after %d, got %d", name, beta.BookID, id)
+		}
+		if id := registerCard(t, target, "new", "S"); id <= other {
+			t.Fatalf("%s: expected a card id after %d, got %d", name, other, id)
+		}
+	}
+}
+
+func testArchiveNotEmpty(t *testing.T, lms app.LibraryManagementSystem) {
+	createUser(t, lms, "keeper", model.UserAdmin)
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	archive, _ := backup(t, lms)
+
+	mustFail(t, lms.Restore(bytes.NewReader(archive)), utils.E_CONFLICT)
+
+	// the staff and the audit log of the database are kept, the library comes back from the archive
+	mustOK(t, lms.ResetDatabase())
+	restored := restore(t, lms, archive)
+	if len(restored.Skipped) != 2 || restored.Rows["book"] != 1 {
+		t.Fatalf("unexpected restore %+v", *restored)
+	}
+	if stockOf(t, lms, id) != 1 {
+		t.Fatal("expected the book restored")
+	}
+	login(t, lms, "keeper", "keeper password")
+}
+
+func testArchiveInvalid(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	registerCard(t, lms, "reader", "S")
+	archive, _ := backup(t, lms)
+	mustOK(t, lms.ResetDatabase())
+
+	tampered := rewriteArchive(t, archive, func(name string, content []byte) []byte {
+		if name == "tables/card.jsonl" {
+			return bytes.Replace(content, []byte("reader"), []byte("writer"), 1)
+		}
+		return content
+	})
+	missing := rewriteArchive(t, archive, func(name string, content []byte) []byte {
+		if name == "tables/card.jsonl" {
+			return nil
+		}
+		return content
+	})
+	newer := rewriteArchive(t, archive, func(name string, content []byte) []byte {
+		if name == "manifest.json" {
+			var manifest model.ArchiveManifest
+			if err := json.Unmarshal(content, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			manifest.SchemaVersion = model.LatestSchemaVersion() + 1
+			content, _ = json.Marshal(&manifest)
+		}
+		return content
+	})
+	// the manifest lists the cards before the books, though the files still come books first
+	misplaced := rewriteArchive(t, archive, func(name string, content []byte) []byte {
+		if name == "manifest.json" {
+			var manifest model.ArchiveManifest
+			if err := json.Unmarshal(content, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			manifest.Tables[0], manifest.Tables[1] = manifest.Tables[1], manifest.Tables[0]
+			content, _ = json.Marshal(&manifest)
+		}
+		return content
+	})
+	unknown := buildArchive(t, model.LatestSchemaVersion(), map[string][]string{"book": {`{"book_id": 1, "shelf": "A"}`}})
+
+	for name, invalid := range map[string][]byte{
+		"garbage":         []byte("not an archive"),
+		"truncated":       archive[:len(archive)/2],
+		"tampered":        tampered,
+		"missing table":   missing,
+		"newer schema":    newer,
+		"misplaced table": misplaced,
+		"unknown column":  unknown,
+	} {
+		result := lms.Restore(bytes.NewReader(invalid))
+		if result.OK || result.Code != utils.E_VALIDATION {
+			t.Fatalf("%s: expected a validation failure, got %+v", name, result)
+		}
+		// the rows loaded before the failure are rolled back
+		if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 0 {
+			t.Fatalf("%s: expected nothing restored, got %d books", name, len(books))
+		}
+	}
+
+	// nor is an archive with a table larger than the archive policy allows
+	previous := model.CurrentArchivePolicy()
+	t.Cleanup(func() { model.SetArchivePolicy(previous) })
+	model.SetArchivePolicy(&model.ArchivePolicy{MaxUpload: previous.MaxUpload, MaxTable: 16})
+	mustFail(t, lms.Restore(bytes.NewReader(archive)), utils.E_VALIDATION)
+	model.SetArchivePolicy(previous)
+	restore(t, lms, archive)
+}
+
+func testArchiveMigrate(t *testing.T, lms app.LibraryManagementSystem) {
+	// an archive made before copies, ISBNs, authors and versions were tracked
+	archive := buildArchive(t, 5, map[string][]string{
+		"book": {
+			`{"book_id": 3, "category": "cs", "title": "alpha", "press": "press", "publish_year": 2001, "author": "Ann & Bob", "price": 10.5, "stock": 1}`,
+		},
+		"card": {`{"card_id": 7, "name": "reader", "department": "department", "type": "S"}`},
+		"borrow": {
+			`{"card_id": 7, "book_id": 3, "borrow_time": 1000, "return_time": 0, "due_time": 2000, "renewals": 1}`,
+		},
+		"fine_entry": {},
+		"hold":       {},
+	})
+	restored := restore(t, lms, archive)
+	if restored.SchemaVersion != 5 || restored.Rows["book"] != 1 {
+		t.Fatalf("unexpected restore %+v", *restored)
+	}
+
+	book := bookByID(t, lms, 3)
+	if book == nil || book.Version != 1 || book.ISBN != nil || book.Stock != 1 {
+		t.Fatalf("unexpected book %+v", book)
+	}
+	if len(book.Contributors) != 2 || !strings.EqualFold(book.Contributors[1].Name, "bob") {
+		t.Fatalf("expected the authors registry filled from the author, got %+v", book.Contributors)
+	}
+	statuses := copyStatuses(copyList(t, lms, 3))
+	if statuses[model.CopyAvailable] != 1 || statuses[model.CopyOnLoan] != 1 {
+		t.Fatalf("expected a copy in stock and one on loan, got %v", statuses)
+	}
+	history := borrowHistory(t, lms, 7)
+	if len(history) != 1 || history[0].Barcode == "" {
+		t.Fatalf("expected the loan given its copy, got %+v", history)
+	}
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: 7, BookID: 3}))
+	if stockOf(t, lms, 3) != 2 {
+		t.Fatal("expected the copy lent back in stock")
+	}
+}
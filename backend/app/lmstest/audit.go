@@ -0,0 +1,198 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func showAudit(t *testing.T, lms app.LibraryManagementSystem, query *model.AuditQuery) *model.AuditList {
+	t.Helper()
+	result := lms.ShowAudit(query)
+	mustOK(t, result)
+	list, ok := result.Payload.(*model.AuditList)
+	if !ok {
+		t.Fatalf("unexpected ShowAudit payload %T", result.Payload)
+	}
+	return list
+}
+
+func auditActions(entries []model.AuditEntry) []string {
+	actions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	return actions
+}
+
+func expectActions(t *testing.T, entries []model.AuditEntry, actions ...string) {
+	t.Helper()
+	got := auditActions(entries)
+	if len(got) != len(actions) {
+		t.Fatalf("expected actions %v, got %v", actions, got)
+	}
+	for i := range actions {
+		if got[i] != actions[i] {
+			t.Fatalf("expected actions %v, got %v", actions, got)
+		}
+	}
+}
+
+func testAuditTrail(t *testing.T, lms app.LibraryManagementSystem) {
+	desk := lms.WithAudit(&model.AuditContext{Actor: "alice", RequestID: "req-1"})
+	id := storeBook(t, desk, newBook("alpha", "cs", 2001, 10, 3))
+	mustOK(t, desk.IncBookStock(id, 2))
+	price := float32(25)
+	patchBook(t, lms.WithAudit(&model.AuditContext{Actor: "bob", RequestID: "req-2"}), id, &model.BookPatch{Price: &price})
+
+	// failed changes leave no trace
+	mustFail(t, desk.IncBookStock(id, -100), utils.E_INSUFFICIENT_STOCK)
+	mustFail(t, desk.IncBookStock(id+100, 1), utils.E_NOT_FOUND)
+
+	history := showAudit(t, lms, &model.AuditQuery{EntityType: model.AuditBook, EntityID: strconv.Itoa(id)})
+	if history.Count != 3 {
+		t.Fatalf("expected 3 entries about the book, got %d", history.Count)
+	}
+	expectActions(t, history.Entries, "update", "stock", "create")
+	update, stock, create := history.Entries[0], history.Entries[1], history.Entries[2]
+	if update.Actor != "bob" || update.RequestID != "req-2" || create.Actor != "alice" || create.RequestID != "req-1" {
+		t.Fatalf("unexpected actors %+v, %+v", update, create)
+	}
+	if update.CreatedAt == 0 || update.AuditID <= stock.AuditID || stock.AuditID <= create.AuditID {
+		t.Fatalf("unexpected order of the entries %+v", history.Entries)
+	}
+	if string(create.OldState) != "null" {
+		t.Fatalf("expected a created book to have no before state, got %s", create.OldState)
+	}
+
+	var before, after model.Book
+	if err := json.Unmarshal(update.OldState, &before); err != nil {
+		t.Fatalf("unmarshal before: %v", err)
+	}
+	if err := json.Unmarshal(update.NewState, &after); err != nil {
+		t.Fatalf("unmarshal after: %v", err)
+	}
+	if float32(before.Price) != 10 || float32(after.Price) != price || before.Version != 1 || after.Version != 2 {
+		t.Fatalf("unexpected states of the price change: %+v, %+v", before, after)
+	}
+	if err := json.Unmarshal(stock.NewState, &after); err != nil || after.Stock != 5 {
+		t.Fatalf("unexpected state after the stock change: %+v, %v", after, err)
+	}
+
+	// the history of a book lists the same entries, and survives its removal
+	mustOK(t, desk.RemoveBook(id))
+	result := lms.ShowBookHistory(id, nil)
+	mustOK(t, result)
+	expectActions(t, result.Payload.(*model.AuditList).Entries, "remove", "update", "stock", "create")
+
+	card := registerCard(t, lms, "reader", "S")
+	mustOK(t, desk.RemoveCard(card))
+	removals := showAudit(t, lms, &model.AuditQuery{EntityType: model.AuditCard, Action: "remove"})
+	if removals.Count != 1 || removals.Entries[0].EntityID != strconv.Itoa(card) || removals.Entries[0].Actor != "alice" ||
+		string(removals.Entries[0].NewState) != "null" {
+		t.Fatalf("unexpected card removals %+v", removals.Entries)
+	}
+	created := showAudit(t, lms, &model.AuditQuery{EntityType: model.AuditCard, Action: "create"})
+	if created.Count != 1 || created.Entries[0].Actor != model.SystemActor || created.Entries[0].RequestID != "" {
+		t.Fatalf("expected the card created without a context by the system, got %+v", created.Entries)
+	}
+}
+
+func testAuditCirculation(t *testing.T, lms app.LibraryManagementSystem) {
+	desk := lms.WithAudit(&model.AuditContext{Actor: "desk", RequestID: "req-1"})
+	book := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+	other := registerCard(t, lms, "other", "S")
+
+	borrow := model.Borrow{CardID: card, BookID: book}
+	mustOK(t, desk.BorrowBook(&borrow))
+	hold := model.Hold{CardID: other, BookID: book}
+	mustOK(t, desk.PlaceHold(&hold))
+	mustOK(t, desk.CancelHold(hold.HoldID))
+	mustOK(t, desk.ReturnBook(&model.Borrow{CardID: card, BookID: book}))
+	mustOK(t, desk.SetCardPIN(card, "1234"))
+
+	list := showAudit(t, lms, &model.AuditQuery{Actor: "desk"})
+	expectActions(t, list.Entries, "pin", "return", "cancel", "place", "borrow")
+	for _, entry := range list.Entries {
+		if entry.RequestID != "req-1" {
+			t.Fatalf("expected every entry in req-1, got %+v", entry)
+		}
+	}
+	if list.Entries[0].EntityType != model.AuditCard || string(list.Entries[0].NewState) != "null" {
+		t.Fatalf("expected the PIN change recorded without the PIN, got %+v", list.Entries[0])
+	}
+
+	var loan model.Borrow
+	if err := json.Unmarshal(list.Entries[1].NewState, &loan); err != nil || loan.ReturnTime == 0 {
+		t.Fatalf("unexpected state of the returned loan: %+v, %v", loan, err)
+	}
+	var cancelled model.Hold
+	if err := json.Unmarshal(list.Entries[2].NewState, &cancelled); err != nil || cancelled.Status != model.HoldCancelled {
+		t.Fatalf("unexpected state of the cancelled hold: %+v, %v", cancelled, err)
+	}
+}
+
+func testAuditQuery(t *testing.T, lms app.LibraryManagementSystem) {
+	for i, actor := range []string{"alice", "bob", "alice", "bob", "alice"} {
+		scoped := lms.WithAudit(&model.AuditContext{Actor: actor, RequestID: "req-" + strconv.Itoa(i)})
+		storeBook(t, scoped, newBook("book "+strconv.Itoa(i), "cs", 2001, 10, 1))
+	}
+
+	if list := showAudit(t, lms, &model.AuditQuery{Actor: "alice"}); list.Count != 3 {
+		t.Fatalf("expected 3 entries by alice, got %d", list.Count)
+	}
+	if list := showAudit(t, lms, &model.AuditQuery{RequestID: "req-3"}); list.Count != 1 || list.Entries[0].Actor != "bob" {
+		t.Fatalf("unexpected entries of req-3 %+v", list.Entries)
+	}
+	if list := showAudit(t, lms, &model.AuditQuery{Actor: "carol"}); list.Count != 0 || list.Entries == nil {
+		t.Fatalf("expected an empty list, got %+v", *list)
+	}
+	if list := showAudit(t, lms, &model.AuditQuery{Until: 1}); list.Count != 0 {
+		t.Fatalf("expected no entry before 1970, got %d", list.Count)
+	}
+
+	// the log pages by cursor, latest first
+	query := model.AuditQuery{EntityType: model.AuditBook, Page: &model.Page{Limit: 2}}
+	var seen []model.AuditEntry
+	for {
+		list := showAudit(t, lms, &query)
+		if list.Count != 5 {
+			t.Fatalf("expected 5 entries, got %d", list.Count)
+		}
+		seen = append(seen, list.Entries...)
+		if list.NextCursor == "" {
+			break
+		}
+		query.Page.Cursor = list.NextCursor
+	}
+	if len(seen) != 5 || seen[0].RequestID != "req-4" || seen[4].RequestID != "req-0" {
+		t.Fatalf("unexpected pages %+v", seen)
+	}
+	offset := showAudit(t, lms, &model.AuditQuery{EntityType: model.AuditBook, Page: &model.Page{Offset: 4}})
+	if len(offset.Entries) != 1 || offset.Entries[0].RequestID != "req-0" {
+		t.Fatalf("unexpected page at offset 4 %+v", offset.Entries)
+	}
+
+	mustFail(t, lms.ShowAudit(nil), utils.E_VALIDATION)
+	mustFail(t, lms.ShowAudit(&model.AuditQuery{Since: 10, Until: 5}), utils.E_VALIDATION)
+	mustFail(t, lms.ShowAudit(&model.AuditQuery{Page: &model.Page{Limit: -1}}), utils.E_VALIDATION)
+	mustFail(t, lms.ShowAudit(&model.AuditQuery{Page: &model.Page{Cursor: "bogus"}}), utils.E_VALIDATION)
+}
+
+func testAuditReset(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	mustOK(t, lms.WithAudit(&model.AuditContext{Actor: "admin", RequestID: "req-1"}).ResetDatabase())
+
+	// the audit log outlives a reset, which it records
+	resets := showAudit(t, lms, &model.AuditQuery{EntityType: model.AuditDatabase})
+	if resets.Count < 2 || resets.Entries[0].Actor != "admin" || resets.Entries[0].Action != "reset" {
+		t.Fatalf("unexpected resets %+v", resets.Entries)
+	}
+	result := lms.ShowBookHistory(id, nil)
+	mustOK(t, result)
+	expectActions(t, result.Payload.(*model.AuditList).Entries, "create")
+}
@@ -0,0 +1,144 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"strings"
+	"testing"
+)
+
+func authorList(t *testing.T, lms app.LibraryManagementSystem, name string) []model.Author {
+	t.Helper()
+	result := lms.ShowAuthors(name)
+	mustOK(t, result)
+	authors, ok := result.Payload.(*model.AuthorList)
+	if !ok {
+		t.Fatalf("unexpected ShowAuthors payload %T", result.Payload)
+	}
+	if authors.Count != len(authors.Authors) {
+		t.Fatalf("count %d does not match %d authors", authors.Count, len(authors.Authors))
+	}
+	return authors.Authors
+}
+
+func testBookContributors(t *testing.T, lms app.LibraryManagementSystem) {
+	book := newBook("alpha", "cs", 2001, 10, 1)
+	book.Author = "Alice Smith & Bob Jones"
+	id := storeBook(t, lms, book)
+
+	translated := newBook("beta", "cs", 2002, 20, 1)
+	translated.Author = ""
+	translated.Contributors = []model.Contributor{
+		{Name: "alice  smith"},
+		{Name: "Carol White", Role: model.RoleTranslator},
+	}
+	translatedId := storeBook(t, lms, translated)
+
+	contributors := bookByID(t, lms, id).Contributors
+	if len(contributors) != 2 || contributors[0].Name != "Alice Smith" || contributors[1].Name != "Bob Jones" ||
+		contributors[0].Role != model.RoleAuthor || contributors[1].Position != 2 {
+		t.Fatalf("unexpected contributors split from the author %+v", contributors)
+	}
+	alice := contributors[0].AuthorID
+	contributors = bookByID(t, lms, translatedId).Contributors
+	if len(contributors) != 2 || contributors[0].AuthorID != alice || contributors[1].Role != model.RoleTranslator {
+		t.Fatalf("unexpected contributors %+v", contributors)
+	}
+
+	authors := authorList(t, lms, "smith")
+	if len(authors) != 1 || authors[0].AuthorID != alice || authors[0].Works != 2 {
+		t.Fatalf("expected one author with 2 works, got %+v", authors)
+	}
+	if books := queryBooks(t, lms, model.NewBookQueryConditions().WithAuthorID(alice)); len(books) != 2 {
+		t.Fatalf("expected 2 books by author id, got %d", len(books))
+	}
+	if books := queryBooks(t, lms, model.NewBookQueryConditions().WithAuthorName("ALICE SMITH")); len(books) != 2 {
+		t.Fatalf("expected 2 books by author name, got %d", len(books))
+	}
+	if books := queryBooks(t, lms, model.NewBookQueryConditions().WithAuthorName("alice")); len(books) != 0 {
+		t.Fatalf("expected the author name to match whole names only, got %d books", len(books))
+	}
+
+	result := lms.ShowAuthorWorks(alice)
+	mustOK(t, result)
+	works, ok := result.Payload.(*model.AuthorWorks)
+	if !ok || works.Count != 2 || works.Works[0].Book.BookID != id || works.Works[1].Book.BookID != translatedId {
+		t.Fatalf("unexpected works %+v", result.Payload)
+	}
+	mustFail(t, lms.ShowAuthorWorks(alice+100), utils.E_NOT_FOUND)
+
+	// replacing the contributors keeps the registry
+	patchBook(t, lms, id, &model.BookPatch{Contributors: []model.Contributor{{AuthorID: alice, Role: model.RoleEditor}}})
+	contributors = bookByID(t, lms, id).Contributors
+	if len(contributors) != 1 || contributors[0].Role != model.RoleEditor {
+		t.Fatalf("unexpected contributors after the update %+v", contributors)
+	}
+	if authors := authorList(t, lms, ""); len(authors) != 3 {
+		t.Fatalf("expected 3 registered authors, got %+v", authors)
+	}
+}
+
+func testBookContributorsUpdate(t *testing.T, lms app.LibraryManagementSystem) {
+	book := newBook("alpha", "cs", 2001, 10, 1)
+	book.Author = ""
+	book.Contributors = []model.Contributor{{Name: "Alice"}, {Name: "Bob", Role: model.RoleTranslator}}
+	id := storeBook(t, lms, book)
+
+	// an update sending the author as it is, as the edit form does, keeps the contributors
+	edited := newBook("alpha", "cs", 2001, 12, 0)
+	edited.BookID, edited.Author = id, "Alice"
+	mustOK(t, lms.ModifyBookInfo(edited))
+	contributors := bookByID(t, lms, id).Contributors
+	if len(contributors) != 2 || contributors[0].Name != "Alice" || contributors[1].Name != "Bob" ||
+		contributors[1].Role != model.RoleTranslator {
+		t.Fatalf("expected the contributors kept by the update, got %+v", contributors)
+	}
+
+	// a new author replaces the authors only
+	edited = newBook("alpha", "cs", 2001, 12, 0)
+	edited.BookID, edited.Author = id, "Carol & Dan"
+	mustOK(t, lms.ModifyBookInfo(edited))
+	contributors = bookByID(t, lms, id).Contributors
+	if len(contributors) != 3 || contributors[0].Name != "Carol" || contributors[1].Name != "Dan" ||
+		contributors[2].Name != "Bob" || contributors[2].Role != model.RoleTranslator || contributors[2].Position != 3 {
+		t.Fatalf("expected the new authors and the translator, got %+v", contributors)
+	}
+	if stored := bookByID(t, lms, id); stored.Author != "Carol & Dan" {
+		t.Fatalf("expected the author given, got %q", stored.Author)
+	}
+}
+
+func testBookContributorsInvalid(t *testing.T, lms app.LibraryManagementSystem) {
+	book := newBook("alpha", "cs", 2001, 10, 1)
+	book.Contributors = []model.Contributor{{Name: "Alice", Role: "ghostwriter"}}
+	mustFail(t, lms.StoreBook(book), utils.E_VALIDATION)
+
+	book = newBook("alpha", "cs", 2001, 10, 1)
+	book.Contributors = []model.Contributor{{Name: "Alice"}, {Name: "ALICE"}}
+	mustFail(t, lms.StoreBook(book), utils.E_VALIDATION)
+
+	book = newBook("alpha", "cs", 2001, 10, 1)
+	book.Contributors = []model.Contributor{{AuthorID: 42}}
+	mustFail(t, lms.StoreBook(book), utils.E_NOT_FOUND)
+
+	// the names of the authors must fit in the author of the book together
+	long := strings.Repeat("a", 30)
+	book = newBook("alpha", "cs", 2001, 10, 1)
+	book.Author = ""
+	book.Contributors = []model.Contributor{{Name: long + "1"}, {Name: long + "2"}, {Name: long + "3"}}
+	mustFail(t, lms.StoreBook(book), utils.E_VALIDATION)
+
+	if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 0 {
+		t.Fatalf("failed stores must not store any book, got %d books", len(books))
+	}
+	if authors := authorList(t, lms, ""); len(authors) != 0 {
+		t.Fatalf("failed stores must not register authors, got %+v", authors)
+	}
+
+	id := storeBook(t, lms, newBook("beta", "cs", 2002, 20, 1))
+	mustFail(t, lms.PatchBook(id, &model.BookPatch{Contributors: book.Contributors}), utils.E_VALIDATION)
+	if stored := bookByID(t, lms, id); stored.Author != "author" {
+		t.Fatalf("expected the author kept after the failed patch, got %q", stored.Author)
+	}
+}
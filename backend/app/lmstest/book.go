@@ -0,0 +1,277 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+)
+
+func testStoreBook(t *testing.T, lms app.LibraryManagementSystem) {
+	first := storeBook(t, lms, newBook("alpha", "cs", 2001, 10.5, 3))
+	second := storeBook(t, lms, newBook("beta", "cs", 2002, 20, 0))
+	if first == second {
+		t.Fatalf("books share id %d", first)
+	}
+
+	book := bookByID(t, lms, first)
+	if book == nil {
+		t.Fatalf("stored book %d not found", first)
+	}
+	if book.Title != "alpha" || book.Category != "cs" || book.PublishYear != 2001 || book.Stock != 3 {
+		t.Fatalf("stored book mismatch: %+v", *book)
+	}
+	if float32(book.Price) != 10.5 {
+		t.Fatalf("stored price mismatch: %v", book.Price)
+	}
+
+	mustFail(t, lms.StoreBook(nil), utils.E_VALIDATION)
+}
+
+func testStoreBookDuplicate(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	mustFail(t, lms.StoreBook(newBook("alpha", "cs", 2001, 99, 5)), utils.E_DUPLICATE)
+
+	// a book differing in any column of the unique key is not a duplicate
+	storeBook(t, lms, newBook("alpha", "cs", 2002, 10, 1))
+	storeBook(t, lms, newBook("alpha", "math", 2001, 10, 1))
+
+	if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 3 {
+		t.Fatalf("expected 3 books, got %d", len(books))
+	}
+}
+
+func testStoreBooks(t *testing.T, lms app.LibraryManagementSystem) {
+	books := []model.Book{
+		*newBook("alpha", "cs", 2001, 10, 1),
+		*newBook("beta", "cs", 2002, 20, 2),
+		*newBook("gamma", "cs", 2003, 30, 3),
+	}
+	mustOK(t, lms.StoreBooks(books))
+
+	if stored := queryBooks(t, lms, model.NewBookQueryConditions()); len(stored) != 3 {
+		t.Fatalf("expected 3 books, got %d", len(stored))
+	}
+
+	mustFail(t, lms.StoreBooks(nil), utils.E_VALIDATION)
+	mustFail(t, lms.StoreBooks([]model.Book{}), utils.E_VALIDATION)
+}
+
+func testStoreBooksAtomic(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+
+	mustFail(t, lms.StoreBooks([]model.Book{
+		*newBook("beta", "cs", 2002, 20, 2),
+		*newBook("alpha", "cs", 2001, 10, 1),
+	}), utils.E_DUPLICATE)
+	mustFail(t, lms.StoreBooks([]model.Book{
+		*newBook("gamma", "cs", 2003, 30, 3),
+		*newBook("gamma", "cs", 2003, 30, 3),
+	}), utils.E_DUPLICATE)
+
+	if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 1 {
+		t.Fatalf("failed batch must not store any book, got %d books", len(books))
+	}
+}
+
+func testStoreBookNegativeStock(t *testing.T, lms app.LibraryManagementSystem) {
+	mustFail(t, lms.StoreBook(newBook("alpha", "cs", 2001, 10, -1)), utils.E_VALIDATION)
+	mustFail(t, lms.StoreBooks([]model.Book{
+		*newBook("beta", "cs", 2002, 20, 2),
+		*newBook("gamma", "cs", 2003, 30, -2),
+	}), utils.E_VALIDATION)
+
+	if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 0 {
+		t.Fatalf("expected no book stored with a negative stock, got %d books", len(books))
+	}
+}
+
+func withISBN(book *model.Book, isbn string) *model.Book {
+	book.ISBN = &isbn
+	return book
+}
+
+func testStoreBookISBN(t *testing.T, lms app.LibraryManagementSystem) {
+	book := withISBN(newBook("alpha", "cs", 2001, 10, 1), "0-306-40615-2")
+	id := storeBook(t, lms, book)
+	if book.ISBN == nil || *book.ISBN != "9780306406157" {
+		t.Fatalf("expected the ISBN-10 to be stored as 9780306406157, got %v", book.ISBN)
+	}
+	storeBook(t, lms, newBook("beta", "cs", 2002, 20, 1))
+
+	mustFail(t, lms.StoreBook(withISBN(newBook("gamma", "cs", 2003, 30, 1), "978-0-306-40615-7")), utils.E_DUPLICATE)
+	mustFail(t, lms.StoreBook(withISBN(newBook("gamma", "cs", 2003, 30, 1), "0-306-40615-3")), utils.E_VALIDATION)
+	mustFail(t, lms.StoreBook(withISBN(newBook("gamma", "cs", 2003, 30, 1), "977-0-306-40615-4")), utils.E_VALIDATION)
+	mustFail(t, lms.StoreBooks([]model.Book{
+		*withISBN(newBook("gamma", "cs", 2003, 30, 1), "978-3-16-148410-0"),
+		*withISBN(newBook("delta", "cs", 2004, 40, 1), "9783161484100"),
+	}), utils.E_DUPLICATE)
+
+	books := queryBooks(t, lms, model.NewBookQueryConditions().WithISBN("0306406152"))
+	if len(books) != 1 || books[0].BookID != id {
+		t.Fatalf("expected book %d by ISBN, got %+v", id, books)
+	}
+	result := lms.QueryBookByISBN("978-0-306-40615-7")
+	mustOK(t, result)
+	if found, ok := result.Payload.(model.Book); !ok || found.BookID != id {
+		t.Fatalf("unexpected QueryBookByISBN payload %+v", result.Payload)
+	}
+	mustFail(t, lms.QueryBookByISBN("9783161484100"), utils.E_NOT_FOUND)
+	mustFail(t, lms.QueryBookByISBN("12345"), utils.E_VALIDATION)
+
+	// an update keeps the ISBN, even one sending another, an empty ISBN in a patch removes it
+	edited := withISBN(newBook("alpha", "cs", 2001, 15, 0), "978-3-16-148410-0")
+	edited.BookID = id
+	mustOK(t, lms.ModifyBookInfo(edited))
+	if stored := bookByID(t, lms, id); stored.ISBN == nil || *stored.ISBN != "9780306406157" || float32(stored.Price) != 15 {
+		t.Fatalf("expected the ISBN kept by the update, got %+v", *stored)
+	}
+	patchBook(t, lms, id, &model.BookPatch{ISBN: new(string)})
+	if books := queryBooks(t, lms, model.NewBookQueryConditions().WithISBN("0306406152")); len(books) != 0 {
+		t.Fatalf("expected no book with the removed ISBN, got %+v", books)
+	}
+}
+
+func testIncBookStock(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+
+	mustOK(t, lms.IncBookStock(id, 4))
+	if stock := stockOf(t, lms, id); stock != 5 {
+		t.Fatalf("expected stock 5, got %d", stock)
+	}
+
+	mustOK(t, lms.IncBookStock(id, -5))
+	if stock := stockOf(t, lms, id); stock != 0 {
+		t.Fatalf("expected stock 0, got %d", stock)
+	}
+}
+
+func testIncBookStockNegative(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 2))
+
+	mustFail(t, lms.IncBookStock(id, -3), utils.E_INSUFFICIENT_STOCK)
+	if stock := stockOf(t, lms, id); stock != 2 {
+		t.Fatalf("failed decrement changed stock to %d", stock)
+	}
+}
+
+func testIncBookStockNotFound(t *testing.T, lms app.LibraryManagementSystem) {
+	mustFail(t, lms.IncBookStock(12345, 1), utils.E_NOT_FOUND)
+}
+
+func testModifyBookInfo(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 7))
+
+	modified := newBook("omega", "math", 1999, 42, 100)
+	modified.BookID = id
+	mustOK(t, lms.ModifyBookInfo(modified))
+
+	book := bookByID(t, lms, id)
+	if book == nil {
+		t.Fatalf("modified book %d not found", id)
+	}
+	if book.Title != "omega" || book.Category != "math" || book.PublishYear != 1999 || float32(book.Price) != 42 {
+		t.Fatalf("book not modified: %+v", *book)
+	}
+	if book.Stock != 7 {
+		t.Fatalf("ModifyBookInfo must not change stock, got %d", book.Stock)
+	}
+
+	other := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	duplicate := newBook("omega", "math", 1999, 42, 0)
+	duplicate.BookID = other
+	mustFail(t, lms.ModifyBookInfo(duplicate), utils.E_DUPLICATE)
+
+	mustFail(t, lms.ModifyBookInfo(nil), utils.E_VALIDATION)
+}
+
+func testRemoveBook(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: id}))
+
+	mustOK(t, lms.RemoveBook(id))
+	if bookByID(t, lms, id) != nil {
+		t.Fatalf("removed book %d still listed", id)
+	}
+
+	// returned loans of a removed book are cascaded away
+	if items := borrowHistory(t, lms, card); len(items) != 0 {
+		t.Fatalf("expected no history after removing the book, got %d items", len(items))
+	}
+}
+
+func testRemoveBookBorrowed(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	mustFail(t, lms.RemoveBook(id), utils.E_CONFLICT)
+	if bookByID(t, lms, id) == nil {
+		t.Fatalf("borrowed book %d was removed", id)
+	}
+}
+
+func testQueryBookFilter(t *testing.T, lms app.LibraryManagementSystem) {
+	a := storeBook(t, lms, newBook("database systems", "cs", 2001, 10, 1))
+	b := storeBook(t, lms, newBook("operating systems", "cs", 2010, 50, 1))
+	c := storeBook(t, lms, newBook("linear algebra", "math", 2005, 30, 1))
+
+	cases := []struct {
+		name       string
+		conditions *model.BookQueryConditions
+		expected   []int
+	}{
+		{"all", model.NewBookQueryConditions(), []int{a, b, c}},
+		{"category", model.NewBookQueryConditions().WithCategory("cs"), []int{a, b}},
+		{"title", model.NewBookQueryConditions().WithTitle("systems"), []int{a, b}},
+		{"title and category", model.NewBookQueryConditions().WithTitle("a").WithCategory("math"), []int{c}},
+		{"min year", model.NewBookQueryConditions().WithMinPublishYear(2005), []int{b, c}},
+		{"max year", model.NewBookQueryConditions().WithMaxPublishYear(2005), []int{a, c}},
+		{"price range", model.NewBookQueryConditions().WithMinPrice(20).WithMaxPrice(40), []int{c}},
+		{"press", model.NewBookQueryConditions().WithPress("ress"), []int{a, b, c}},
+		{"author", model.NewBookQueryConditions().WithAuthor("nobody"), []int{}},
+	}
+	for _, tc := range cases {
+		got := bookIDs(queryBooks(t, lms, tc.conditions))
+		if !equalIDs(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func testQueryBookSort(t *testing.T, lms app.LibraryManagementSystem) {
+	a := storeBook(t, lms, newBook("charlie", "cs", 2001, 30, 1))
+	b := storeBook(t, lms, newBook("alpha", "cs", 2002, 10, 1))
+	c := storeBook(t, lms, newBook("bravo", "cs", 2003, 30, 1))
+	d := storeBook(t, lms, newBook("delta", "cs", 2004, 20, 1))
+
+	cases := []struct {
+		name       string
+		conditions *model.BookQueryConditions
+		expected   []int
+	}{
+		{"default", model.NewBookQueryConditions(), []int{a, b, c, d}},
+		{"title", model.NewBookQueryConditions().WithSortBy(model.BookColumnTitle), []int{b, c, a, d}},
+		{"title desc", model.NewBookQueryConditions().WithSortBy(model.BookColumnTitle).WithSortOrder(model.Descending), []int{d, a, c, b}},
+		// equal prices fall back to book_id ascending whatever the order
+		{"price asc", model.NewBookQueryConditions().WithSortBy(model.BookColumnPrice).WithSortOrder(model.Ascending), []int{b, d, a, c}},
+		{"price desc", model.NewBookQueryConditions().WithSortBy(model.BookColumnPrice).WithSortOrder(model.Descending), []int{a, c, d, b}},
+		{"year desc", model.NewBookQueryConditions().WithSortBy(model.BookColumnPublishYear).WithSortOrder(model.Descending), []int{d, c, b, a}},
+	}
+	for _, tc := range cases {
+		got := bookIDs(queryBooks(t, lms, tc.conditions))
+		if !equalIDs(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func testQueryBookInvalidSortOrder(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	mustFail(t, lms.QueryBook(model.NewBookQueryConditions().
+		WithSortBy(model.BookColumnTitle).
+		WithSortOrder(model.SortOrder("SIDEWAYS"))), utils.E_VALIDATION)
+}
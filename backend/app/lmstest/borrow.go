@@ -0,0 +1,269 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testBorrowBook(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 2))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	if stock := stockOf(t, lms, id); stock != 1 {
+		t.Fatalf("expected stock 1 after borrowing, got %d", stock)
+	}
+
+	items := borrowHistory(t, lms, card)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 history item, got %d", len(items))
+	}
+	if items[0].BookID != id || items[0].CardID != card || items[0].Title != "alpha" {
+		t.Fatalf("history item mismatch: %+v", items[0])
+	}
+	if items[0].BorrowTime <= 0 || items[0].ReturnTime != 0 {
+		t.Fatalf("open loan has wrong times: %+v", items[0])
+	}
+}
+
+func testBorrowBookOutOfStock(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	first := registerCard(t, lms, "first", "S")
+	second := registerCard(t, lms, "second", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: first, BookID: id}))
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: second, BookID: id}), utils.E_INSUFFICIENT_STOCK)
+	if stock := stockOf(t, lms, id); stock != 0 {
+		t.Fatalf("expected stock 0, got %d", stock)
+	}
+	if items := borrowHistory(t, lms, second); len(items) != 0 {
+		t.Fatalf("refused loan was recorded: %+v", items)
+	}
+}
+
+func testBorrowBookTwice(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 5))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}), utils.E_CONFLICT)
+	if stock := stockOf(t, lms, id); stock != 4 {
+		t.Fatalf("refused loan changed stock to %d", stock)
+	}
+}
+
+func testBorrowBookNotFound(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 5))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id + 1000}), utils.E_NOT_FOUND)
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: card + 1000, BookID: id}), utils.E_NOT_FOUND)
+	if stock := stockOf(t, lms, id); stock != 5 {
+		t.Fatalf("refused loan changed stock to %d", stock)
+	}
+}
+
+func testBorrowBookDueTime(t *testing.T, lms app.LibraryManagementSystem) {
+	a := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	b := storeBook(t, lms, newBook("bravo", "reference", 2002, 10, 1))
+	student := registerCard(t, lms, "student", "S")
+	teacher := registerCard(t, lms, "teacher", "T")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: a}))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: teacher, BookID: b}))
+
+	policy := model.CurrentLoanPolicy()
+	for _, loan := range []struct {
+		card     int
+		cardType string
+		category string
+	}{{student, "S", "cs"}, {teacher, "T", "reference"}} {
+		items := borrowHistory(t, lms, loan.card)
+		if len(items) != 1 {
+			t.Fatalf("expected 1 history item, got %d", len(items))
+		}
+		if want := policy.DueTime(items[0].BorrowTime, loan.cardType, loan.category); items[0].DueTime != want {
+			t.Fatalf("expected due time %d, got %d", want, items[0].DueTime)
+		}
+		if items[0].Overdue {
+			t.Fatal("new loan is overdue")
+		}
+	}
+
+	c := storeBook(t, lms, newBook("charlie", "cs", 2003, 10, 2))
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: c, DueTime: 1}), utils.E_VALIDATION)
+	if stock := stockOf(t, lms, c); stock != 2 {
+		t.Fatalf("refused loan changed stock to %d", stock)
+	}
+
+	due := time.Now().Add(72 * time.Hour).Unix()
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: c, DueTime: due}))
+	for _, item := range borrowHistory(t, lms, student) {
+		if item.BookID == c && item.DueTime != due {
+			t.Fatalf("expected requested due time %d, got %d", due, item.DueTime)
+		}
+	}
+}
+
+func testReturnBook(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: id}))
+	if stock := stockOf(t, lms, id); stock != 1 {
+		t.Fatalf("expected stock 1 after returning, got %d", stock)
+	}
+
+	items := borrowHistory(t, lms, card)
+	if len(items) != 1 {
+		t.Fatalf("expected 1 history item, got %d", len(items))
+	}
+	if items[0].ReturnTime < items[0].BorrowTime || items[0].ReturnTime == 0 {
+		t.Fatalf("returned loan has wrong times: %+v", items[0])
+	}
+}
+
+func testReturnBookNotBorrowed(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+	other := registerCard(t, lms, "other", "S")
+
+	mustFail(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: id}), utils.E_CONFLICT)
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	mustFail(t, lms.ReturnBook(&model.Borrow{CardID: other, BookID: id}), utils.E_CONFLICT)
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: id}))
+	mustFail(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: id}), utils.E_CONFLICT)
+
+	if stock := stockOf(t, lms, id); stock != 1 {
+		t.Fatalf("refused returns changed stock to %d", stock)
+	}
+}
+
+func testShowBorrowHistory(t *testing.T, lms app.LibraryManagementSystem) {
+	a := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	b := storeBook(t, lms, newBook("bravo", "cs", 2002, 10, 2))
+	c := storeBook(t, lms, newBook("charlie", "cs", 2003, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+	other := registerCard(t, lms, "other", "T")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: c}))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: a}))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: other, BookID: b}))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: b}))
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: a}))
+
+	items := borrowHistory(t, lms, card)
+	if len(items) != 3 {
+		t.Fatalf("expected 3 history items, got %d", len(items))
+	}
+	for i := range items {
+		if items[i].CardID != card {
+			t.Fatalf("history of card %d contains item of card %d", card, items[i].CardID)
+		}
+		if i == 0 {
+			continue
+		}
+		prev, cur := items[i-1], items[i]
+		if prev.BorrowTime < cur.BorrowTime ||
+			(prev.BorrowTime == cur.BorrowTime && prev.BookID > cur.BookID) {
+			t.Fatalf("history not ordered by borrow_time DESC, book_id ASC: %+v", items)
+		}
+	}
+
+	if items := borrowHistory(t, lms, other); len(items) != 1 || items[0].BookID != b {
+		t.Fatalf("unexpected history of second card: %+v", items)
+	}
+}
+
+func testShowBorrowHistoryCardNotFound(t *testing.T, lms app.LibraryManagementSystem) {
+	mustFail(t, lms.ShowBorrowHistory(12345, nil), utils.E_NOT_FOUND)
+
+	card := registerCard(t, lms, "reader", "S")
+	if items := borrowHistory(t, lms, card); len(items) != 0 {
+		t.Fatalf("new card has history: %+v", items)
+	}
+}
+
+func testRenewBook(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+	other := registerCard(t, lms, "other", "S")
+
+	mustFail(t, lms.RenewBook(&model.Borrow{CardID: card, BookID: id}), utils.E_CONFLICT)
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+
+	policy := model.CurrentLoanPolicy()
+	due := borrowHistory(t, lms, card)[0].DueTime
+	for i := 1; i <= policy.MaxRenewals; i++ {
+		borrow := model.Borrow{CardID: card, BookID: id}
+		mustOK(t, lms.RenewBook(&borrow))
+		if want := policy.DueTime(due, "S", "cs"); borrow.DueTime != want {
+			t.Fatalf("expected due time %d after renewal %d, got %d", want, i, borrow.DueTime)
+		}
+		due = borrow.DueTime
+
+		items := borrowHistory(t, lms, card)
+		if len(items) != 1 || items[0].Renewals != i || items[0].DueTime != due {
+			t.Fatalf("unexpected history after renewal %d: %+v", i, items)
+		}
+	}
+	mustFail(t, lms.RenewBook(&model.Borrow{CardID: card, BookID: id}), utils.E_CONFLICT)
+
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: id}))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: other, BookID: id}))
+	placeHold(t, lms, card, id)
+	mustFail(t, lms.RenewBook(&model.Borrow{CardID: other, BookID: id}), utils.E_CONFLICT)
+}
+
+func testBorrowBookLimits(t *testing.T, lms app.LibraryManagementSystem) {
+	previous := model.CurrentLoanPolicy()
+	policy := *previous
+	policy.Limits = []model.LoanLimit{
+		{MaxLoans: 3},
+		{CardType: "S", MaxLoans: 2},
+		{CardType: "S", Category: "reference", MaxLoans: 1},
+	}
+	model.SetLoanPolicy(&policy)
+	t.Cleanup(func() { model.SetLoanPolicy(previous) })
+
+	a := storeBook(t, lms, newBook("alpha", "reference", 2001, 10, 2))
+	b := storeBook(t, lms, newBook("bravo", "Reference", 2002, 10, 2))
+	c := storeBook(t, lms, newBook("charlie", "cs", 2003, 10, 2))
+	d := storeBook(t, lms, newBook("delta", "cs", 2004, 10, 2))
+	student := registerCard(t, lms, "student", "S")
+	teacher := registerCard(t, lms, "teacher", "T")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: a}))
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: b}), utils.E_LOAN_LIMIT)
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: c}))
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: d}), utils.E_LOAN_LIMIT)
+	if stock := stockOf(t, lms, d); stock != 2 {
+		t.Fatalf("refused loan changed stock to %d", stock)
+	}
+
+	result := lms.QueryCard(student)
+	mustOK(t, result)
+	card := result.Payload.(*model.Card)
+	if card.Loans == nil || *card.Loans != 2 {
+		t.Fatalf("expected 2 loans, got %v", card.Loans)
+	}
+	want := []model.CardQuota{{Limit: 2, Loans: 2}, {Category: "reference", Limit: 1, Loans: 1}}
+	if !reflect.DeepEqual(card.Quotas, want) {
+		t.Fatalf("expected quotas %+v, got %+v", want, card.Quotas)
+	}
+
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: student, BookID: c}))
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: b}), utils.E_LOAN_LIMIT)
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: d}))
+
+	for _, id := range []int{a, b, c} {
+		mustOK(t, lms.BorrowBook(&model.Borrow{CardID: teacher, BookID: id}))
+	}
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: teacher, BookID: d}), utils.E_LOAN_LIMIT)
+}
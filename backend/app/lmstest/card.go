@@ -0,0 +1,114 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+)
+
+func testRegisterCard(t *testing.T, lms app.LibraryManagementSystem) {
+	teacher := registerCard(t, lms, "teacher", "T")
+	student := registerCard(t, lms, "student", "S")
+	if teacher == student {
+		t.Fatalf("cards share id %d", teacher)
+	}
+
+	result := lms.QueryCard(teacher)
+	mustOK(t, result)
+	card, ok := result.Payload.(*model.Card)
+	if !ok {
+		t.Fatalf("unexpected QueryCard payload %T", result.Payload)
+	}
+	if card.CardID != teacher || card.Name != "teacher" || card.Department != "department" || card.Type != "T" {
+		t.Fatalf("stored card mismatch: %+v", *card)
+	}
+
+	mustFail(t, lms.QueryCard(12345), utils.E_NOT_FOUND)
+	mustFail(t, lms.RegisterCard(nil), utils.E_VALIDATION)
+}
+
+func testRegisterCardInvalid(t *testing.T, lms app.LibraryManagementSystem) {
+	registerCard(t, lms, "reader", "S")
+
+	mustFail(t, lms.RegisterCard(&model.Card{Name: "reader", Department: "department", Type: "S"}), utils.E_DUPLICATE)
+	mustFail(t, lms.RegisterCard(&model.Card{Name: "other", Department: "department", Type: "X"}), utils.E_VALIDATION)
+
+	// the same name with another card type is a different card
+	registerCard(t, lms, "reader", "T")
+
+	if cards := cardList(t, lms); len(cards) != 2 {
+		t.Fatalf("expected 2 cards, got %d", len(cards))
+	}
+}
+
+func testRemoveCard(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: id}))
+
+	mustOK(t, lms.RemoveCard(card))
+	mustFail(t, lms.QueryCard(card), utils.E_NOT_FOUND)
+	mustFail(t, lms.ShowBorrowHistory(card, nil), utils.E_NOT_FOUND)
+
+	// removing the card with its loan history does not affect the book
+	if stock := stockOf(t, lms, id); stock != 1 {
+		t.Fatalf("expected stock 1, got %d", stock)
+	}
+	mustOK(t, lms.RemoveBook(id))
+}
+
+func testRemoveCardWithLoans(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	mustFail(t, lms.RemoveCard(card), utils.E_CONFLICT)
+	mustOK(t, lms.QueryCard(card))
+
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: id}))
+	mustOK(t, lms.RemoveCard(card))
+}
+
+func testShowCards(t *testing.T, lms app.LibraryManagementSystem) {
+	if cards := cardList(t, lms); len(cards) != 0 {
+		t.Fatalf("expected no cards, got %d", len(cards))
+	}
+
+	a := registerCard(t, lms, "alpha", "S")
+	b := registerCard(t, lms, "bravo", "T")
+	c := registerCard(t, lms, "charlie", "S")
+	mustOK(t, lms.RemoveCard(b))
+
+	cards := cardList(t, lms)
+	if len(cards) != 2 || cards[0].CardID != a || cards[1].CardID != c {
+		t.Fatalf("expected cards [%d %d] ordered by id, got %+v", a, c, cards)
+	}
+}
+
+func testResetDatabase(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	createUser(t, lms, "admin", model.UserAdmin)
+	token := login(t, lms, "admin", "admin password")
+
+	mustOK(t, lms.ResetDatabase())
+
+	// the staff stays signed in
+	authenticate(t, lms, token)
+
+	if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 0 {
+		t.Fatalf("expected no books after reset, got %d", len(books))
+	}
+	if cards := cardList(t, lms); len(cards) != 0 {
+		t.Fatalf("expected no cards after reset, got %d", len(cards))
+	}
+
+	// the system is usable again after a reset
+	id = storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card = registerCard(t, lms, "reader", "S")
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+}
@@ -0,0 +1,165 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+)
+
+func copyList(t *testing.T, lms app.LibraryManagementSystem, bookId int) []model.BookCopy {
+	t.Helper()
+	result := lms.ShowCopies(bookId)
+	mustOK(t, result)
+	copies, ok := result.Payload.(*model.CopyList)
+	if !ok {
+		t.Fatalf("unexpected ShowCopies payload %T", result.Payload)
+	}
+	if copies.Count != len(copies.Copies) {
+		t.Fatalf("count %d does not match %d copies", copies.Count, len(copies.Copies))
+	}
+	return copies.Copies
+}
+
+func copyStatuses(copies []model.BookCopy) map[model.CopyStatus]int {
+	statuses := make(map[model.CopyStatus]int)
+	for _, bookCopy := range copies {
+		statuses[bookCopy.Status]++
+	}
+	return statuses
+}
+
+func testShowCopies(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 3))
+
+	copies := copyList(t, lms, id)
+	if len(copies) != 3 || copyStatuses(copies)[model.CopyAvailable] != 3 {
+		t.Fatalf("expected 3 available copies, got %+v", copies)
+	}
+	seen := make(map[string]bool)
+	for _, bookCopy := range copies {
+		if bookCopy.BookID != id || bookCopy.Barcode == "" || seen[bookCopy.Barcode] {
+			t.Fatalf("unexpected copy %+v", bookCopy)
+		}
+		seen[bookCopy.Barcode] = true
+	}
+
+	mustOK(t, lms.IncBookStock(id, 2))
+	mustOK(t, lms.IncBookStock(id, -1))
+	statuses := copyStatuses(copyList(t, lms, id))
+	if statuses[model.CopyAvailable] != 4 || statuses[model.CopyWithdrawn] != 1 || stockOf(t, lms, id) != 4 {
+		t.Fatalf("expected 4 available copies and 1 withdrawn, got %v and stock %d", statuses, stockOf(t, lms, id))
+	}
+	mustFail(t, lms.ShowCopies(id+1), utils.E_NOT_FOUND)
+}
+
+func testAddCopies(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 0))
+
+	copies := []model.BookCopy{{Barcode: "LIB-1", Location: "shelf A"}, {}}
+	mustOK(t, lms.AddCopies(id, copies))
+	if copies[0].CopyID <= 0 || copies[1].Barcode == "" || stockOf(t, lms, id) != 2 {
+		t.Fatalf("unexpected copies %+v with stock %d", copies, stockOf(t, lms, id))
+	}
+
+	mustFail(t, lms.AddCopies(id, []model.BookCopy{{Barcode: "LIB-1"}}), utils.E_DUPLICATE)
+	mustFail(t, lms.AddCopies(id, []model.BookCopy{{Barcode: " LIB-2"}}), utils.E_VALIDATION)
+	mustFail(t, lms.AddCopies(id, nil), utils.E_VALIDATION)
+	mustFail(t, lms.AddCopies(id+1, []model.BookCopy{{}}), utils.E_NOT_FOUND)
+	if stockOf(t, lms, id) != 2 {
+		t.Fatalf("failed additions changed the stock to %d", stockOf(t, lms, id))
+	}
+}
+
+func testBorrowByBarcode(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 0))
+	other := storeBook(t, lms, newBook("bravo", "cs", 2001, 10, 1))
+	a := registerCard(t, lms, "alpha", "S")
+	b := registerCard(t, lms, "bravo", "S")
+	mustOK(t, lms.AddCopies(id, []model.BookCopy{{Barcode: "LIB-1"}, {Barcode: "LIB-2"}}))
+
+	borrow := model.Borrow{CardID: a, Barcode: "LIB-2"}
+	mustOK(t, lms.BorrowBook(&borrow))
+	if borrow.BookID != id || stockOf(t, lms, id) != 1 {
+		t.Fatalf("expected a loan of book %d leaving 1 copy, got %+v and stock %d", id, borrow, stockOf(t, lms, id))
+	}
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: b, Barcode: "LIB-2"}), utils.E_CONFLICT)
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: b, BookID: other, Barcode: "LIB-1"}), utils.E_VALIDATION)
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: b, Barcode: "LIB-3"}), utils.E_NOT_FOUND)
+
+	// a borrow by book lends an available copy
+	borrow = model.Borrow{CardID: b, BookID: id}
+	mustOK(t, lms.BorrowBook(&borrow))
+	if borrow.Barcode != "LIB-1" {
+		t.Fatalf("expected copy LIB-1 to be lent, got %q", borrow.Barcode)
+	}
+	items := borrowHistory(t, lms, a)
+	if len(items) != 1 || items[0].Barcode != "LIB-2" {
+		t.Fatalf("unexpected history %+v", items)
+	}
+
+	mustFail(t, lms.ReturnBook(&model.Borrow{CardID: b, Barcode: "LIB-2"}), utils.E_CONFLICT)
+	mustOK(t, lms.ReturnBook(&model.Borrow{Barcode: "LIB-2"}))
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: b, BookID: id}))
+	statuses := copyStatuses(copyList(t, lms, id))
+	if statuses[model.CopyAvailable] != 2 || stockOf(t, lms, id) != 2 {
+		t.Fatalf("expected 2 available copies after the returns, got %v", statuses)
+	}
+}
+
+func testWithdrawCopies(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 0))
+	a := registerCard(t, lms, "alpha", "S")
+	mustOK(t, lms.AddCopies(id, []model.BookCopy{{Barcode: "LIB-1"}, {Barcode: "LIB-2"}, {Barcode: "LIB-3"}}))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: a, Barcode: "LIB-1"}))
+
+	mustFail(t, lms.WithdrawCopies([]string{"LIB-2", "LIB-1"}), utils.E_CONFLICT)
+	mustFail(t, lms.WithdrawCopies([]string{"LIB-4"}), utils.E_NOT_FOUND)
+	if stockOf(t, lms, id) != 2 {
+		t.Fatalf("failed withdrawals changed the stock to %d", stockOf(t, lms, id))
+	}
+
+	mustOK(t, lms.WithdrawCopies([]string{"LIB-2"}))
+	mustFail(t, lms.WithdrawCopies([]string{"LIB-2"}), utils.E_CONFLICT)
+	if stockOf(t, lms, id) != 1 {
+		t.Fatalf("expected stock 1 after the withdrawal, got %d", stockOf(t, lms, id))
+	}
+	mustFail(t, lms.IncBookStock(id, -2), utils.E_INSUFFICIENT_STOCK)
+}
+
+func testUpdateCopy(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 0))
+	a := registerCard(t, lms, "alpha", "S")
+	b := registerCard(t, lms, "bravo", "S")
+	mustOK(t, lms.AddCopies(id, []model.BookCopy{{Barcode: "LIB-1", Location: "shelf A"}, {Barcode: "LIB-2"}}))
+
+	damaged := model.BookCopy{Barcode: "LIB-2", Status: model.CopyDamaged}
+	mustOK(t, lms.UpdateCopy(&damaged))
+	if damaged.BookID != id || stockOf(t, lms, id) != 1 {
+		t.Fatalf("expected a damaged copy of book %d leaving stock 1, got %+v and stock %d", id, damaged, stockOf(t, lms, id))
+	}
+
+	moved := model.BookCopy{Barcode: "LIB-1", Location: "shelf B"}
+	mustOK(t, lms.UpdateCopy(&moved))
+	if moved.Status != model.CopyAvailable || moved.Location != "shelf B" {
+		t.Fatalf("unexpected moved copy %+v", moved)
+	}
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: a, Barcode: "LIB-1"}))
+	mustFail(t, lms.UpdateCopy(&model.BookCopy{Barcode: "LIB-1", Status: model.CopyLost}), utils.E_CONFLICT)
+	mustFail(t, lms.UpdateCopy(&model.BookCopy{Barcode: "LIB-2", Status: model.CopyOnLoan}), utils.E_VALIDATION)
+	mustFail(t, lms.UpdateCopy(&model.BookCopy{Barcode: "LIB-3", Status: model.CopyLost}), utils.E_NOT_FOUND)
+
+	// a repaired copy is set aside for the waiting hold
+	hold := placeHold(t, lms, b, id)
+	mustOK(t, lms.UpdateCopy(&model.BookCopy{Barcode: "LIB-2", Status: model.CopyAvailable}))
+	statuses := holdStatuses(holdList(t, lms.ShowCardHolds(b)))
+	if statuses[hold.HoldID] != model.HoldReady || stockOf(t, lms, id) != 0 {
+		t.Fatalf("expected the hold to be ready, got %v and stock %d", statuses, stockOf(t, lms, id))
+	}
+	borrow := model.Borrow{CardID: b, BookID: id}
+	mustOK(t, lms.BorrowBook(&borrow))
+	if borrow.Barcode != "LIB-2" {
+		t.Fatalf("expected the copy set aside to be lent, got %q", borrow.Barcode)
+	}
+}
@@ -0,0 +1,132 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"reflect"
+	"testing"
+)
+
+func bookFacets(t *testing.T, lms app.LibraryManagementSystem, conditions *model.BookQueryConditions) *model.BookFacets {
+	t.Helper()
+	page := bookPage(t, lms, conditions)
+	if page.Facets == nil {
+		t.Fatalf("expected facets, got %+v", page)
+	}
+	return page.Facets
+}
+
+func testQueryBookFacets(t *testing.T, lms app.LibraryManagementSystem) {
+	for _, book := range []struct {
+		title    string
+		category string
+		press    string
+		year     int
+		price    float32
+		author   string
+	}{
+		{"a", "cs", "alpha", 1995, 10, "Knuth"},
+		{"b", "cs", "alpha", 2003, 25.5, "Knuth"},
+		{"c", "math", "bravo", 2009, 19.99, "Euler"},
+		{"d", "math", "alpha", 2010, 5, "Euler"},
+		{"e", "poetry", "charlie", 1999, 100, "Homer"},
+	} {
+		b := newBook(book.title, book.category, book.year, book.price, 1)
+		b.Press, b.Author = book.press, book.author
+		storeBook(t, lms, b)
+	}
+	if page := bookPage(t, lms, model.NewBookQueryConditions()); page.Facets != nil {
+		t.Fatalf("expected no facets unless asked for, got %+v", page.Facets)
+	}
+
+	// the categories ignore the category condition, the other facets follow it
+	facets := bookFacets(t, lms, model.NewBookQueryConditions().WithCategory("cs").WithFacets(model.BookFacetOptions{}))
+	expect := model.BookFacets{
+		Categories: []model.FacetCount{{Value: "cs", Count: 2}, {Value: "math", Count: 2}, {Value: "poetry", Count: 1}},
+		Presses:    []model.FacetCount{{Value: "alpha", Count: 2}},
+		Authors:    []model.FacetCount{{Value: "Knuth", Count: 2}},
+		Years:      []model.FacetBucket{{From: 1990, To: 2000, Count: 1}, {From: 2000, To: 2010, Count: 1}},
+		Prices:     []model.FacetBucket{{From: 10, To: 20, Count: 1}, {From: 20, To: 30, Count: 1}},
+	}
+	for i := range facets.Authors {
+		facets.Authors[i].AuthorID = 0
+	}
+	if !reflect.DeepEqual(*facets, expect) {
+		t.Fatalf("expected facets %+v, got %+v", expect, *facets)
+	}
+
+	// the prices ignore the price conditions, the other facets follow them
+	facets = bookFacets(t, lms, model.NewBookQueryConditions().WithMinPrice(15).WithMaxPublishYear(2009).
+		WithFacets(model.BookFacetOptions{Top: 1, YearWidth: 5, PriceWidth: 5}))
+	expect = model.BookFacets{
+		Categories: []model.FacetCount{{Value: "cs", Count: 1}, {Value: "math", Count: 1}, {Value: "poetry", Count: 1}},
+		Presses:    []model.FacetCount{{Value: "alpha", Count: 1}},
+		Authors:    []model.FacetCount{{Value: "Euler", Count: 1}},
+		Years:      []model.FacetBucket{{From: 1995, To: 2000, Count: 1}, {From: 2000, To: 2005, Count: 1}, {From: 2005, To: 2010, Count: 1}},
+		Prices:     []model.FacetBucket{{From: 10, To: 15, Count: 1}, {From: 15, To: 20, Count: 1}, {From: 25, To: 30, Count: 1}, {From: 100, To: 105, Count: 1}},
+	}
+	for i := range facets.Authors {
+		facets.Authors[i].AuthorID = 0
+	}
+	if !reflect.DeepEqual(*facets, expect) {
+		t.Fatalf("expected facets %+v, got %+v", expect, *facets)
+	}
+
+	// the authors are the registered ones, and selecting one by id leaves the others counted
+	facets = bookFacets(t, lms, model.NewBookQueryConditions().WithFacets(model.BookFacetOptions{}))
+	if len(facets.Authors) != 3 || facets.Authors[0].Value != "Euler" || facets.Authors[0].AuthorID == 0 {
+		t.Fatalf("unexpected authors %+v", facets.Authors)
+	}
+	euler := facets.Authors[0].AuthorID
+	facets = bookFacets(t, lms, model.NewBookQueryConditions().WithAuthorID(euler).WithFacets(model.BookFacetOptions{}))
+	if len(facets.Authors) != 3 || !reflect.DeepEqual(facets.Categories, []model.FacetCount{{Value: "math", Count: 2}}) {
+		t.Fatalf("unexpected facets %+v", facets)
+	}
+
+	// the filter expression and the available only flag apply to all facets
+	mustOK(t, lms.IncBookStock(bookIDs(queryBooks(t, lms, model.NewBookQueryConditions().WithTitle("e")))[0], -1))
+	facets = bookFacets(t, lms, model.NewBookQueryConditions().WithAvailableOnly().
+		WithFilter(&model.BookFilter{Field: model.BookColumnPress, Op: model.FilterIn, Values: []any{"alpha", "charlie"}}).
+		WithFacets(model.BookFacetOptions{}))
+	if !reflect.DeepEqual(facets.Categories, []model.FacetCount{{Value: "cs", Count: 2}, {Value: "math", Count: 1}}) {
+		t.Fatalf("unexpected categories %+v", facets.Categories)
+	}
+	// but for the conditions on the column of the facet it requires
+	if !reflect.DeepEqual(facets.Presses, []model.FacetCount{{Value: "alpha", Count: 3}, {Value: "bravo", Count: 1}}) {
+		t.Fatalf("unexpected presses %+v", facets.Presses)
+	}
+	facets = bookFacets(t, lms, model.NewBookQueryConditions().WithAvailableOnly().
+		WithFilter(&model.BookFilter{And: []model.BookFilter{
+			{Field: model.BookColumnCategory, Op: model.FilterIn, Values: []any{"cs"}},
+			{Field: model.BookColumnPrice, Op: model.FilterLt, Value: 20},
+		}}).
+		WithFacets(model.BookFacetOptions{}))
+	if !reflect.DeepEqual(facets.Categories, []model.FacetCount{{Value: "math", Count: 2}, {Value: "cs", Count: 1}}) {
+		t.Fatalf("unexpected categories %+v", facets.Categories)
+	}
+	// the ones under or are kept
+	facets = bookFacets(t, lms, model.NewBookQueryConditions().
+		WithFilter(&model.BookFilter{Or: []model.BookFilter{
+			{Field: model.BookColumnCategory, Op: model.FilterEq, Value: "cs"},
+			{Field: model.BookColumnTitle, Op: model.FilterEq, Value: "e"},
+		}}).
+		WithFacets(model.BookFacetOptions{}))
+	if !reflect.DeepEqual(facets.Categories, []model.FacetCount{{Value: "cs", Count: 2}, {Value: "poetry", Count: 1}}) {
+		t.Fatalf("unexpected categories %+v", facets.Categories)
+	}
+}
+
+func testQueryBookFacetsInvalid(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+
+	for _, options := range []model.BookFacetOptions{
+		{Top: -1},
+		{Top: model.MaxFacetTop + 1},
+		{YearWidth: -1},
+		{PriceWidth: -5},
+		{PriceWidth: 0.001},
+	} {
+		mustFail(t, lms.QueryBook(model.NewBookQueryConditions().WithFacets(options)), utils.E_VALIDATION)
+	}
+}
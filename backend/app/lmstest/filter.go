@@ -0,0 +1,207 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func parseFilter(t *testing.T, s string) *model.BookFilter {
+	t.Helper()
+	filter, err := model.ParseBookFilter(s)
+	if err != nil {
+		t.Fatalf("failed to parse filter %q: %v", s, err)
+	}
+	return filter
+}
+
+func testQueryBookFilterExpression(t *testing.T, lms app.LibraryManagementSystem) {
+	deep := newBook("Deep Learning", "cs", 2016, 80, 1)
+	deep.Author = "Ian Goodfellow"
+	art := newBook("The Art of Computer Programming", "cs", 1968, 150, 0)
+	art.Author = "Donald Knuth"
+	calculus := newBook("Calculus", "math", 1990, 45.5, 2)
+	poems := newBook("Poems", "poetry", 1990, 12, 3)
+	deepId := storeBook(t, lms, deep)
+	artId := storeBook(t, lms, art)
+	calculusId := storeBook(t, lms, calculus)
+	poemsId := storeBook(t, lms, poems)
+
+	for _, tc := range []struct {
+		filter string
+		want   []int
+	}{
+		{`category=[CS,math]`, []int{deepId, artId, calculusId}},
+		{`category=cs title~"learning"`, []int{deepId}},
+		{`category=cs AND (title~learning OR author^donald)`, []int{deepId, artId}},
+		{`NOT category=cs`, []int{calculusId, poemsId}},
+		{`title^"the art"`, []int{artId}},
+		{`title^art`, nil},
+		{`title=calculus`, []int{calculusId}},
+		{`title=calc`, nil},
+		{`price>=45.5 price<100`, []int{deepId, calculusId}},
+		{`price>45.5`, []int{deepId, artId}},
+		{`publish_year=1990 OR stock=0`, []int{artId, calculusId, poemsId}},
+		{`book_id=[` + strconv.Itoa(deepId) + `,` + strconv.Itoa(poemsId) + `]`, []int{deepId, poemsId}},
+	} {
+		got := bookIDs(queryBooks(t, lms, model.NewBookQueryConditions().WithFilter(parseFilter(t, tc.filter))))
+		if !equalIDs(got, tc.want) {
+			t.Fatalf("filter %q: expected books %v, got %v", tc.filter, tc.want, got)
+		}
+	}
+
+	// the filter is applied with the other conditions
+	got := bookIDs(queryBooks(t, lms, model.NewBookQueryConditions().WithMaxPublishYear(2000).
+		WithFilter(parseFilter(t, `category=[cs,math]`)).WithAvailableOnly()))
+	if !equalIDs(got, []int{calculusId}) {
+		t.Fatalf("expected book %d, got %v", calculusId, got)
+	}
+
+	// a filter built in code works as one parsed
+	filter := &model.BookFilter{Or: []model.BookFilter{
+		{Field: model.BookColumnPublishYear, Op: model.FilterLt, Value: 1970},
+		{Not: &model.BookFilter{Field: model.BookColumnCategory, Op: model.FilterIn, Values: []any{"cs", "math"}}},
+	}}
+	got = bookIDs(queryBooks(t, lms, model.NewBookQueryConditions().WithFilter(filter)))
+	if !equalIDs(got, []int{artId, poemsId}) {
+		t.Fatalf("expected books %v, got %v", []int{artId, poemsId}, got)
+	}
+}
+
+func testQueryBookFilterEscape(t *testing.T, lms app.LibraryManagementSystem) {
+	percent := storeBook(t, lms, newBook("100% Go", "cs", 2001, 10, 1))
+	storeBook(t, lms, newBook("1000 Go Puzzles", "cs", 2001, 10, 1))
+	underscore := storeBook(t, lms, newBook("snake_case", "cs", 2001, 10, 1))
+	storeBook(t, lms, newBook("snakeXcase", "cs", 2001, 10, 1))
+	backslash := storeBook(t, lms, newBook(`C:\Windows`, "cs", 2001, 10, 1))
+
+	for _, tc := range []struct {
+		filter string
+		want   []int
+	}{
+		{`title~"0%"`, []int{percent}},
+		{`title^"100%"`, []int{percent}},
+		{`title~e_c`, []int{underscore}},
+		{`title~"\\"`, []int{backslash}},
+	} {
+		got := bookIDs(queryBooks(t, lms, model.NewBookQueryConditions().WithFilter(parseFilter(t, tc.filter))))
+		if !equalIDs(got, tc.want) {
+			t.Fatalf("filter %q: expected books %v, got %v", tc.filter, tc.want, got)
+		}
+	}
+
+	// the wildcards are matched literally by the title, press and author conditions too
+	if got := bookIDs(queryBooks(t, lms, model.NewBookQueryConditions().WithTitle("e_c"))); !equalIDs(got, []int{underscore}) {
+		t.Fatalf("expected book %d, got %v", underscore, got)
+	}
+	if got := queryBooks(t, lms, model.NewBookQueryConditions().WithTitle("%")); len(got) != 1 || got[0].BookID != percent {
+		t.Fatalf("expected book %d, got %v", percent, bookIDs(got))
+	}
+}
+
+func testQueryBookMultiSort(t *testing.T, lms app.LibraryManagementSystem) {
+	type entry struct {
+		title    string
+		category string
+		price    float32
+	}
+	ids := map[string]int{}
+	for _, e := range []entry{
+		{"a", "math", 20}, {"b", "cs", 10}, {"c", "math", 10}, {"d", "cs", 30}, {"e", "cs", 10}, {"f", "math", 20},
+	} {
+		ids[e.title] = storeBook(t, lms, newBook(e.title, e.category, 2001, e.price, 1))
+	}
+	sorted := func() *model.BookQueryConditions {
+		return model.NewBookQueryConditions().WithSort(
+			model.SortKey{Column: model.BookColumnCategory},
+			model.SortKey{Column: model.BookColumnPrice, Order: model.Descending},
+			model.SortKey{Column: model.BookColumnTitle, Order: model.Descending},
+		)
+	}
+	var want []int
+	for _, title := range []string{"d", "e", "b", "f", "a", "c"} {
+		want = append(want, ids[title])
+	}
+	if got := bookIDs(queryBooks(t, lms, sorted())); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected books %v, got %v", want, got)
+	}
+
+	// the cursors follow all the keys
+	var got []int
+	cursor := ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		if pages > len(want) {
+			t.Fatalf("cursors do not end, got %v", got)
+		}
+		page := bookPage(t, lms, sorted().WithPage(model.NewPage(0, 2, cursor)))
+		got = append(got, bookIDs(page.Results)...)
+		cursor = page.NextCursor
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected books %v, got %v", want, got)
+	}
+
+	keys, err := model.ParseBookSort("category, -price,-title")
+	if err != nil {
+		t.Fatalf("failed to parse sort: %v", err)
+	}
+	if got := bookIDs(queryBooks(t, lms, model.NewBookQueryConditions().WithSort(keys...))); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected books %v, got %v", want, got)
+	}
+}
+
+func testQueryBookFilterInvalid(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+
+	for _, s := range []string{
+		``,
+		`title`,
+		`title~`,
+		`(category=cs`,
+		`category=cs)`,
+		`category=[cs,`,
+		`title="open`,
+		`category=cs OR`,
+	} {
+		if _, err := model.ParseBookFilter(s); err == nil {
+			t.Fatalf("expected filter %q not to parse", s)
+		}
+	}
+
+	for _, filter := range []*model.BookFilter{
+		{},
+		{Field: "isbn", Op: model.FilterEq, Value: "x"},
+		{Field: "title; DROP TABLE book", Op: model.FilterEq, Value: "x"},
+		{Field: model.BookColumnTitle, Op: "like", Value: "x"},
+		{Field: model.BookColumnTitle, Op: model.FilterLt, Value: "x"},
+		{Field: model.BookColumnPrice, Op: model.FilterContains, Value: "1"},
+		{Field: model.BookColumnPrice, Op: model.FilterEq, Value: "cheap"},
+		{Field: model.BookColumnStock, Op: model.FilterEq, Value: 1.5},
+		{Field: model.BookColumnCategory, Op: model.FilterIn},
+		{Field: model.BookColumnCategory, Op: model.FilterEq},
+		{Field: model.BookColumnCategory, Op: model.FilterEq, Value: "cs", And: []model.BookFilter{{}}},
+	} {
+		mustFail(t, lms.QueryBook(model.NewBookQueryConditions().WithFilter(filter)), utils.E_VALIDATION)
+	}
+	deep := &model.BookFilter{Field: model.BookColumnCategory, Op: model.FilterEq, Value: "cs"}
+	for i := 0; i < 10; i++ {
+		deep = &model.BookFilter{Not: deep}
+	}
+	mustFail(t, lms.QueryBook(model.NewBookQueryConditions().WithFilter(deep)), utils.E_VALIDATION)
+
+	for _, keys := range [][]model.SortKey{
+		{{Column: "isbn"}},
+		{{Column: model.BookColumnTitle, Order: "up"}},
+		{{Column: model.BookColumnTitle}, {Column: model.BookColumnTitle, Order: model.Descending}},
+	} {
+		mustFail(t, lms.QueryBook(model.NewBookQueryConditions().WithSort(keys...)), utils.E_VALIDATION)
+	}
+	mustFail(t, lms.QueryBook(model.NewBookQueryConditions().WithSortBy(model.BookColumnTitle).
+		WithSort(model.SortKey{Column: model.BookColumnPrice})), utils.E_VALIDATION)
+	if _, err := model.ParseBookSort("title,,price"); err == nil {
+		t.Fatalf("expected an empty sort column to be rejected")
+	}
+}
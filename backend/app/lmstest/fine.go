@@ -0,0 +1,81 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+	"time"
+)
+
+func fineLedger(t *testing.T, lms app.LibraryManagementSystem, cardId int) *model.FineLedger {
+	t.Helper()
+	result := lms.ShowFines(cardId)
+	mustOK(t, result)
+	ledger, ok := result.Payload.(*model.FineLedger)
+	if !ok {
+		t.Fatalf("unexpected ShowFines payload %T", result.Payload)
+	}
+	if ledger.Count != len(ledger.Entries) {
+		t.Fatalf("count %d does not match %d entries", ledger.Count, len(ledger.Entries))
+	}
+	return ledger
+}
+
+func testShowFinesEmpty(t *testing.T, lms app.LibraryManagementSystem) {
+	card := registerCard(t, lms, "reader", "S")
+
+	ledger := fineLedger(t, lms, card)
+	if ledger.Balance != 0 || ledger.Count != 0 {
+		t.Fatalf("expected an empty ledger, got balance %v and %d entries", ledger.Balance, ledger.Count)
+	}
+
+	mustFail(t, lms.ShowFines(card+1), utils.E_NOT_FOUND)
+	mustFail(t, lms.SettleFine(model.NewSettlement(card, model.FinePayment, 1, "cash")), utils.E_VALIDATION)
+	mustFail(t, lms.SettleFine(model.NewSettlement(card+1, model.FinePayment, 1, "cash")), utils.E_NOT_FOUND)
+}
+
+func testFineOverdue(t *testing.T, lms app.LibraryManagementSystem) {
+	previous := model.CurrentFinePolicy()
+	model.SetFinePolicy(&model.FinePolicy{
+		BlockThreshold: 0.5,
+		Rules:          []model.FineRule{{DailyRate: 1.5, MaxFine: 20}},
+	})
+	t.Cleanup(func() { model.SetFinePolicy(previous) })
+
+	a := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	b := storeBook(t, lms, newBook("bravo", "cs", 2002, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: a, DueTime: time.Now().Unix() + 2}))
+	time.Sleep(3 * time.Second)
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: a}))
+
+	ledger := fineLedger(t, lms, card)
+	if ledger.Count != 1 || ledger.Entries[0].Kind != model.FineCharge || ledger.Entries[0].BookID != a {
+		t.Fatalf("expected one charge for book %d, got %+v", a, ledger.Entries)
+	}
+	if ledger.Balance != 1.5 {
+		t.Fatalf("expected balance 1.5, got %v", ledger.Balance)
+	}
+
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: b}), utils.E_CONFLICT)
+	mustFail(t, lms.RemoveCard(card), utils.E_CONFLICT)
+
+	mustFail(t, lms.SettleFine(model.NewSettlement(card, model.FinePayment, 2, "cash")), utils.E_VALIDATION)
+	mustFail(t, lms.SettleFine(model.NewSettlement(card, model.FinePayment, 1, "")), utils.E_VALIDATION)
+	mustFail(t, lms.SettleFine(model.NewSettlement(card, model.FineCharge, 1, "cash")), utils.E_VALIDATION)
+	mustOK(t, lms.SettleFine(model.NewSettlement(card, model.FineWaiver, 0.5, "first offence")))
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: b}), utils.E_CONFLICT)
+
+	mustOK(t, lms.SettleFine(model.NewSettlement(card, model.FinePayment, 0.5, "cash")))
+	ledger = fineLedger(t, lms, card)
+	if ledger.Balance != 0.5 || ledger.Count != 3 {
+		t.Fatalf("expected balance 0.5 over 3 entries, got %v over %d", ledger.Balance, ledger.Count)
+	}
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: b}))
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: b}))
+
+	mustOK(t, lms.SettleFine(model.NewSettlement(card, model.FinePayment, 0.5, "cash")))
+	mustOK(t, lms.RemoveCard(card))
+}
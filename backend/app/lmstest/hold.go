@@ -0,0 +1,166 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+)
+
+func placeHold(t *testing.T, lms app.LibraryManagementSystem, cardId int, bookId int) *model.Hold {
+	t.Helper()
+	hold := model.Hold{CardID: cardId, BookID: bookId}
+	mustOK(t, lms.PlaceHold(&hold))
+	if hold.HoldID <= 0 {
+		t.Fatalf("PlaceHold did not assign an id: %d", hold.HoldID)
+	}
+	return &hold
+}
+
+func holdList(t *testing.T, result *app.ApiResult) []model.Hold {
+	t.Helper()
+	mustOK(t, result)
+	holds, ok := result.Payload.(*model.HoldList)
+	if !ok {
+		t.Fatalf("unexpected hold list payload %T", result.Payload)
+	}
+	if holds.Count != len(holds.Holds) {
+		t.Fatalf("count %d does not match %d holds", holds.Count, len(holds.Holds))
+	}
+	return holds.Holds
+}
+
+func holdStatuses(holds []model.Hold) map[int]model.HoldStatus {
+	statuses := make(map[int]model.HoldStatus)
+	for _, hold := range holds {
+		statuses[hold.HoldID] = hold.Status
+	}
+	return statuses
+}
+
+func testPlaceHold(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	a := registerCard(t, lms, "alpha", "S")
+	b := registerCard(t, lms, "bravo", "S")
+	c := registerCard(t, lms, "charlie", "S")
+
+	mustFail(t, lms.PlaceHold(&model.Hold{CardID: a, BookID: id}), utils.E_CONFLICT)
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: a, BookID: id}))
+
+	mustFail(t, lms.PlaceHold(&model.Hold{CardID: a, BookID: id}), utils.E_CONFLICT)
+	mustFail(t, lms.PlaceHold(&model.Hold{CardID: b, BookID: id + 1}), utils.E_NOT_FOUND)
+	mustFail(t, lms.PlaceHold(&model.Hold{CardID: c + 1, BookID: id}), utils.E_NOT_FOUND)
+
+	first := placeHold(t, lms, b, id)
+	second := placeHold(t, lms, c, id)
+	if first.Status != model.HoldWaiting || first.Position != 1 || second.Position != 2 {
+		t.Fatalf("expected waiting holds at positions 1 and 2, got %+v and %+v", first, second)
+	}
+	mustFail(t, lms.PlaceHold(&model.Hold{CardID: b, BookID: id}), utils.E_DUPLICATE)
+
+	queue := holdList(t, lms.ShowBookHolds(id))
+	if len(queue) != 2 || queue[0].HoldID != first.HoldID || queue[1].HoldID != second.HoldID {
+		t.Fatalf("unexpected queue %+v", queue)
+	}
+	mine := holdList(t, lms.ShowCardHolds(c))
+	if len(mine) != 1 || mine[0].Position != 2 {
+		t.Fatalf("unexpected holds of card %d: %+v", c, mine)
+	}
+	mustFail(t, lms.ShowBookHolds(id+1), utils.E_NOT_FOUND)
+	mustFail(t, lms.ShowCardHolds(c+1), utils.E_NOT_FOUND)
+}
+
+func testHoldSetAside(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	a := registerCard(t, lms, "alpha", "S")
+	b := registerCard(t, lms, "bravo", "S")
+	c := registerCard(t, lms, "charlie", "S")
+	walkIn := registerCard(t, lms, "delta", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: a, BookID: id}))
+	first := placeHold(t, lms, b, id)
+	second := placeHold(t, lms, c, id)
+
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: a, BookID: id}))
+	if stock := stockOf(t, lms, id); stock != 0 {
+		t.Fatalf("copy set aside for a hold left stock %d", stock)
+	}
+	queue := holdList(t, lms.ShowBookHolds(id))
+	if len(queue) != 2 || queue[0].HoldID != first.HoldID || queue[0].Status != model.HoldReady ||
+		queue[0].ExpireTime <= queue[0].ReadyAt || queue[1].Position != 1 {
+		t.Fatalf("unexpected queue after return %+v", queue)
+	}
+
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: walkIn, BookID: id}), utils.E_INSUFFICIENT_STOCK)
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: c, BookID: id}), utils.E_INSUFFICIENT_STOCK)
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: b, BookID: id}))
+	if stock := stockOf(t, lms, id); stock != 0 {
+		t.Fatalf("borrowing a copy set aside changed stock to %d", stock)
+	}
+	if status := holdStatuses(holdList(t, lms.ShowCardHolds(b)))[first.HoldID]; status != model.HoldFulfilled {
+		t.Fatalf("expected fulfilled hold, got %s", status)
+	}
+
+	mustOK(t, lms.IncBookStock(id, 2))
+	if stock := stockOf(t, lms, id); stock != 1 {
+		t.Fatalf("expected 1 copy in stock after setting one aside, got %d", stock)
+	}
+	if status := holdStatuses(holdList(t, lms.ShowCardHolds(c)))[second.HoldID]; status != model.HoldReady {
+		t.Fatalf("expected ready hold, got %s", status)
+	}
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: walkIn, BookID: id}))
+}
+
+func testCancelHold(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	a := registerCard(t, lms, "alpha", "S")
+	b := registerCard(t, lms, "bravo", "S")
+	c := registerCard(t, lms, "charlie", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: a, BookID: id}))
+	first := placeHold(t, lms, b, id)
+	second := placeHold(t, lms, c, id)
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: a, BookID: id}))
+
+	mustOK(t, lms.CancelHold(first.HoldID))
+	mustFail(t, lms.CancelHold(first.HoldID), utils.E_CONFLICT)
+	mustFail(t, lms.CancelHold(second.HoldID+1), utils.E_NOT_FOUND)
+
+	queue := holdList(t, lms.ShowBookHolds(id))
+	if len(queue) != 1 || queue[0].HoldID != second.HoldID || queue[0].Status != model.HoldReady {
+		t.Fatalf("cancelled hold did not pass its copy on: %+v", queue)
+	}
+
+	mustOK(t, lms.RemoveCard(c))
+	if stock := stockOf(t, lms, id); stock != 1 {
+		t.Fatalf("removing a card did not put its copy back in stock: %d", stock)
+	}
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: b, BookID: id}))
+}
+
+func testHoldPriority(t *testing.T, lms app.LibraryManagementSystem) {
+	previous := model.CurrentHoldPolicy()
+	policy := *previous
+	policy.PrioritizeTeachers = true
+	model.SetHoldPolicy(&policy)
+	t.Cleanup(func() { model.SetHoldPolicy(previous) })
+
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	reader := registerCard(t, lms, "reader", "S")
+	student := registerCard(t, lms, "student", "S")
+	teacher := registerCard(t, lms, "teacher", "T")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: reader, BookID: id}))
+	placeHold(t, lms, student, id)
+	hold := placeHold(t, lms, teacher, id)
+	if hold.Position != 1 {
+		t.Fatalf("expected the teacher first in the queue, got position %d", hold.Position)
+	}
+
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: reader, BookID: id}))
+	mustFail(t, lms.BorrowBook(&model.Borrow{CardID: student, BookID: id}), utils.E_INSUFFICIENT_STOCK)
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: teacher, BookID: id}))
+	if result := lms.ExpireHolds(); !result.OK || result.Payload != 0 {
+		t.Fatalf("expected no hold to expire, got %+v", result)
+	}
+}
@@ -0,0 +1,166 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"reflect"
+	"testing"
+)
+
+func bookPage(t *testing.T, lms app.LibraryManagementSystem, conditions *model.BookQueryConditions) *model.BookQueryResult {
+	t.Helper()
+	result := lms.QueryBook(conditions)
+	mustOK(t, result)
+	page, ok := result.Payload.(*model.BookQueryResult)
+	if !ok {
+		t.Fatalf("unexpected QueryBook payload %T", result.Payload)
+	}
+	return page
+}
+
+func testQueryBookPages(t *testing.T, lms app.LibraryManagementSystem) {
+	for i, price := range []float32{30, 10, 20, 10, 30, 10, 20} {
+		category := "cs"
+		if i%2 == 1 {
+			category = "math"
+		}
+		storeBook(t, lms, newBook(string(rune('a'+i)), category, 2001, price, 1))
+	}
+	sorted := func() *model.BookQueryConditions {
+		return model.NewBookQueryConditions().WithSortBy(model.BookColumnPrice).WithSortOrder(model.Descending)
+	}
+	want := bookIDs(queryBooks(t, lms, sorted()))
+
+	// the cursors walk the books in order, ties on the price included
+	var got []int
+	cursor := ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		if pages > len(want) {
+			t.Fatalf("cursors do not end, got %v", got)
+		}
+		page := bookPage(t, lms, sorted().WithPage(model.NewPage(0, 3, cursor)))
+		if page.Count != len(want) || len(page.Results) > 3 {
+			t.Fatalf("expected a page of at most 3 of %d books, got %d of %d", len(want), len(page.Results), page.Count)
+		}
+		got = append(got, bookIDs(page.Results)...)
+		cursor = page.NextCursor
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected books %v, got %v", want, got)
+	}
+
+	page := bookPage(t, lms, sorted().WithPage(model.NewPage(5, 3, "")))
+	if !reflect.DeepEqual(bookIDs(page.Results), want[5:]) || page.NextCursor != "" {
+		t.Fatalf("expected the last books %v, got %v with cursor %q", want[5:], bookIDs(page.Results), page.NextCursor)
+	}
+	if page := bookPage(t, lms, sorted().WithPage(model.NewPage(10, 3, ""))); len(page.Results) != 0 || page.Count != 7 {
+		t.Fatalf("expected no book past the end, got %+v", page)
+	}
+
+	// the count is the number of books matching the filters
+	page = bookPage(t, lms, model.NewBookQueryConditions().WithCategory("math").WithPage(model.NewPage(0, 1, "")))
+	if page.Count != 3 || len(page.Results) != 1 || page.NextCursor == "" {
+		t.Fatalf("expected 1 of 3 math books and a cursor, got %+v", page)
+	}
+	page = bookPage(t, lms, model.NewBookQueryConditions().WithCategory("math").WithPage(model.NewPage(0, 1, page.NextCursor)))
+	if page.Count != 3 || len(page.Results) != 1 || page.Results[0].Category != "math" {
+		t.Fatalf("expected the second math book, got %+v", page)
+	}
+}
+
+func testQueryBookPagesInvalid(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	storeBook(t, lms, newBook("bravo", "cs", 2001, 10, 1))
+	cursor := bookPage(t, lms, model.NewBookQueryConditions().WithPage(model.NewPage(0, 1, ""))).NextCursor
+	if cursor == "" {
+		t.Fatalf("expected a cursor")
+	}
+
+	for _, page := range []*model.Page{
+		model.NewPage(0, -1, ""),
+		model.NewPage(0, model.MaxPageSize+1, ""),
+		model.NewPage(-1, 1, ""),
+		model.NewPage(1, 1, cursor),
+		model.NewPage(0, 1, "not a cursor"),
+	} {
+		mustFail(t, lms.QueryBook(model.NewBookQueryConditions().WithPage(page)), utils.E_VALIDATION)
+	}
+	// a cursor only continues a listing in the same order
+	mustFail(t, lms.QueryBook(model.NewBookQueryConditions().WithSortBy(model.BookColumnTitle).
+		WithPage(model.NewPage(0, 1, cursor))), utils.E_VALIDATION)
+	mustFail(t, lms.ShowCards(model.NewPage(0, 1, cursor)), utils.E_VALIDATION)
+}
+
+func testShowCardsPages(t *testing.T, lms app.LibraryManagementSystem) {
+	var want []int
+	for _, name := range []string{"alpha", "bravo", "charlie", "delta", "echo"} {
+		want = append(want, registerCard(t, lms, name, "S"))
+	}
+
+	var got []int
+	cursor := ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		if pages > len(want) {
+			t.Fatalf("cursors do not end, got %v", got)
+		}
+		result := lms.ShowCards(model.NewPage(0, 2, cursor))
+		mustOK(t, result)
+		cards := result.Payload.(*model.CardList)
+		if cards.Count != len(want) || len(cards.Cards) > 2 {
+			t.Fatalf("expected a page of at most 2 of %d cards, got %d of %d", len(want), len(cards.Cards), cards.Count)
+		}
+		for _, card := range cards.Cards {
+			got = append(got, card.CardID)
+		}
+		cursor = cards.NextCursor
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected cards %v, got %v", want, got)
+	}
+
+	result := lms.ShowCards(model.NewPage(4, 2, ""))
+	mustOK(t, result)
+	if cards := result.Payload.(*model.CardList); len(cards.Cards) != 1 || cards.Cards[0].CardID != want[4] || cards.NextCursor != "" {
+		t.Fatalf("expected the last card %d, got %+v", want[4], cards)
+	}
+}
+
+func testShowBorrowHistoryPages(t *testing.T, lms app.LibraryManagementSystem) {
+	card := registerCard(t, lms, "reader", "T")
+	var want []int
+	for i, borrowTime := range []int64{3000, 2000, 2000, 1000} {
+		id := storeBook(t, lms, newBook(string(rune('a'+i)), "cs", 2001, 10, 1))
+		want = append(want, id)
+		mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id, BorrowTime: borrowTime}))
+	}
+
+	var got []int
+	cursor := ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		if pages > len(want) {
+			t.Fatalf("cursors do not end, got %v", got)
+		}
+		result := lms.ShowBorrowHistory(card, model.NewPage(0, 1, cursor))
+		mustOK(t, result)
+		histories := result.Payload.(*model.BorrowHistories)
+		if histories.Count != len(want) || len(histories.Items) > 1 {
+			t.Fatalf("expected a page of at most 1 of %d items, got %d of %d", len(want), len(histories.Items), histories.Count)
+		}
+		for _, item := range histories.Items {
+			got = append(got, item.BookID)
+		}
+		cursor = histories.NextCursor
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected books %v, got %v", want, got)
+	}
+
+	result := lms.ShowBorrowHistory(card, model.NewPage(1, 2, ""))
+	mustOK(t, result)
+	histories := result.Payload.(*model.BorrowHistories)
+	if len(histories.Items) != 2 || histories.Items[0].BookID != want[1] || histories.NextCursor == "" {
+		t.Fatalf("expected books %v and a cursor, got %+v", want[1:3], histories)
+	}
+	mustFail(t, lms.ShowBorrowHistory(card, model.NewPage(0, 0, "bm90IGEgY3Vyc29y")), utils.E_VALIDATION)
+}
@@ -0,0 +1,151 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+)
+
+func patchBook(t *testing.T, lms app.LibraryManagementSystem, bookId int, patch *model.BookPatch) *model.Book {
+	t.Helper()
+	result := lms.PatchBook(bookId, patch)
+	mustOK(t, result)
+	book, ok := result.Payload.(*model.Book)
+	if !ok {
+		t.Fatalf("unexpected PatchBook payload %T", result.Payload)
+	}
+	return book
+}
+
+func patchCard(t *testing.T, lms app.LibraryManagementSystem, cardId int, patch *model.CardPatch) *model.Card {
+	t.Helper()
+	result := lms.PatchCard(cardId, patch)
+	mustOK(t, result)
+	card, ok := result.Payload.(*model.Card)
+	if !ok {
+		t.Fatalf("unexpected PatchCard payload %T", result.Payload)
+	}
+	return card
+}
+
+func testPatchBook(t *testing.T, lms app.LibraryManagementSystem) {
+	book := newBook("alpha", "cs", 2001, 10, 3)
+	book.Author = "Ann, Bob"
+	isbn := "9780262033848"
+	book.ISBN = &isbn
+	id := storeBook(t, lms, book)
+	stored := bookByID(t, lms, id)
+	if stored.Version != 1 || stored.UpdatedAt == 0 {
+		t.Fatalf("expected a new book at version 1, got %+v", *stored)
+	}
+
+	// only the fields set change
+	price := float32(25)
+	patched := patchBook(t, lms, id, &model.BookPatch{Price: &price})
+	if patched.Version != 2 || float32(patched.Price) != price {
+		t.Fatalf("expected the price patched at version 2, got %+v", *patched)
+	}
+	stored = bookByID(t, lms, id)
+	if stored.Title != "alpha" || stored.Author != "Ann, Bob" || stored.ISBN == nil || stored.Stock != 3 ||
+		float32(stored.Price) != price || stored.Version != 2 || len(stored.Contributors) != 2 {
+		t.Fatalf("unexpected book after patch: %+v", *stored)
+	}
+
+	// a patch made from an older version is refused
+	title := "beta"
+	stale := 1
+	mustFail(t, lms.PatchBook(id, &model.BookPatch{Title: &title, Version: &stale}), utils.E_CONFLICT)
+	current := 2
+	patched = patchBook(t, lms, id, &model.BookPatch{Title: &title, Version: &current})
+	if patched.Title != "beta" || patched.Version != 3 {
+		t.Fatalf("expected the title patched at version 3, got %+v", *patched)
+	}
+
+	// a new author replaces the contributors, an empty ISBN removes it
+	author := "Carol"
+	empty := ""
+	patched = patchBook(t, lms, id, &model.BookPatch{Author: &author, ISBN: &empty})
+	if patched.ISBN != nil || len(patched.Contributors) != 1 || patched.Contributors[0].Name != "Carol" {
+		t.Fatalf("unexpected book after patch: %+v", *patched)
+	}
+	patched = patchBook(t, lms, id, &model.BookPatch{Contributors: []model.Contributor{{Name: "Dan"}, {Name: "Eve", Role: model.RoleEditor}}})
+	if patched.Author != "Dan" || len(patched.Contributors) != 2 {
+		t.Fatalf("unexpected book after patch: %+v", *patched)
+	}
+
+	// stock changes do not change the version
+	mustOK(t, lms.IncBookStock(id, 1))
+	if stored := bookByID(t, lms, id); stored.Version != patched.Version || stored.Stock != 4 {
+		t.Fatalf("expected version %d and stock 4, got %+v", patched.Version, *stored)
+	}
+
+	storeBook(t, lms, newBook("gamma", "cs", 2001, 10, 1))
+	taken, takenAuthor := "gamma", "author"
+	mustFail(t, lms.PatchBook(id, &model.BookPatch{Title: &taken, Author: &takenAuthor}), utils.E_DUPLICATE)
+	invalid := "12345"
+	mustFail(t, lms.PatchBook(id, &model.BookPatch{ISBN: &invalid}), utils.E_VALIDATION)
+	if stored := bookByID(t, lms, id); stored.Title != "beta" || stored.Version != patched.Version {
+		t.Fatalf("expected a failed patch to change nothing, got %+v", *stored)
+	}
+
+	mustFail(t, lms.PatchBook(id+100, &model.BookPatch{Title: &title}), utils.E_NOT_FOUND)
+	mustFail(t, lms.PatchBook(id, nil), utils.E_VALIDATION)
+}
+
+func testModifyBookInfoVersion(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+
+	modified := newBook("beta", "cs", 2001, 10, 0)
+	modified.BookID = id
+	mustOK(t, lms.ModifyBookInfo(modified))
+	if stored := bookByID(t, lms, id); stored.Title != "beta" || stored.Version != 2 {
+		t.Fatalf("expected the book modified at version 2, got %+v", *stored)
+	}
+
+	// a book carrying the version it was read at is refused once the book changed
+	stale := newBook("gamma", "cs", 2001, 10, 0)
+	stale.BookID, stale.Version = id, 1
+	mustFail(t, lms.ModifyBookInfo(stale), utils.E_CONFLICT)
+	stale.Version = 2
+	mustOK(t, lms.ModifyBookInfo(stale))
+
+	missing := newBook("delta", "cs", 2001, 10, 0)
+	missing.BookID = id + 100
+	mustFail(t, lms.ModifyBookInfo(missing), utils.E_NOT_FOUND)
+}
+
+func testPatchCard(t *testing.T, lms app.LibraryManagementSystem) {
+	id := registerCard(t, lms, "reader", "S")
+	registerCard(t, lms, "other", "T")
+	result := lms.QueryCard(id)
+	mustOK(t, result)
+	if card := result.Payload.(*model.Card); card.Version != 1 || card.UpdatedAt == 0 {
+		t.Fatalf("expected a new card at version 1, got %+v", *card)
+	}
+
+	department := "physics"
+	card := patchCard(t, lms, id, &model.CardPatch{Department: &department})
+	if card.Name != "reader" || card.Department != "physics" || card.Type != "S" || card.Version != 2 {
+		t.Fatalf("unexpected card after patch: %+v", *card)
+	}
+	result = lms.QueryCard(id)
+	mustOK(t, result)
+	if card := result.Payload.(*model.Card); card.Department != "physics" || card.Version != 2 {
+		t.Fatalf("expected the patched card, got %+v", *card)
+	}
+
+	name := "renamed"
+	stale, current := 1, 2
+	mustFail(t, lms.PatchCard(id, &model.CardPatch{Name: &name, Version: &stale}), utils.E_CONFLICT)
+	if card := patchCard(t, lms, id, &model.CardPatch{Name: &name, Version: &current}); card.Name != "renamed" || card.Version != 3 {
+		t.Fatalf("unexpected card after patch: %+v", *card)
+	}
+
+	invalid := "X"
+	mustFail(t, lms.PatchCard(id, &model.CardPatch{Type: &invalid}), utils.E_VALIDATION)
+	taken, takenDepartment, takenType := "other", "department", "T"
+	mustFail(t, lms.PatchCard(id, &model.CardPatch{Name: &taken, Department: &takenDepartment, Type: &takenType}), utils.E_DUPLICATE)
+	mustFail(t, lms.PatchCard(id+100, &model.CardPatch{Name: &name}), utils.E_NOT_FOUND)
+	mustFail(t, lms.PatchCard(id, nil), utils.E_VALIDATION)
+}
@@ -0,0 +1,146 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+	"time"
+)
+
+func patronLogin(t *testing.T, lms app.LibraryManagementSystem, cardId int, pin string) string {
+	t.Helper()
+	result := lms.PatronLogin(cardId, pin)
+	mustOK(t, result)
+	login, ok := result.Payload.(*model.PatronLoginResult)
+	if !ok {
+		t.Fatalf("unexpected PatronLogin payload %T", result.Payload)
+	}
+	if login.Token == "" || login.CardID != cardId || login.ExpireTime <= time.Now().Unix() {
+		t.Fatalf("unexpected patron login %+v", *login)
+	}
+	return login.Token
+}
+
+func authenticatePatron(t *testing.T, lms app.LibraryManagementSystem, token string) int {
+	t.Helper()
+	result := lms.AuthenticatePatron(token)
+	mustOK(t, result)
+	return result.Payload.(int)
+}
+
+func testPatronLogin(t *testing.T, lms app.LibraryManagementSystem) {
+	card := registerCard(t, lms, "reader", "S")
+
+	// a card has no PIN until the desk sets one
+	mustFail(t, lms.PatronLogin(card, "1234"), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.SetCardPIN(card, "12"), utils.E_VALIDATION)
+	mustFail(t, lms.SetCardPIN(card+100, "1234"), utils.E_NOT_FOUND)
+	mustOK(t, lms.SetCardPIN(card, "1234"))
+
+	token := patronLogin(t, lms, card, "1234")
+	if got := authenticatePatron(t, lms, token); got != card {
+		t.Fatalf("expected card %d, got %d", card, got)
+	}
+	mustFail(t, lms.PatronLogin(card, "4321"), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.PatronLogin(card+100, "1234"), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.AuthenticatePatron(""), utils.E_UNAUTHORIZED)
+
+	// staff and patron tokens do not open each other's routes
+	createUser(t, lms, "desk", model.UserCirculation)
+	mustFail(t, lms.Authenticate(token), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.AuthenticatePatron(login(t, lms, "desk", "desk password")), utils.E_UNAUTHORIZED)
+
+	mustOK(t, lms.PatronLogout(token))
+	mustFail(t, lms.AuthenticatePatron(token), utils.E_UNAUTHORIZED)
+}
+
+func testPatronPIN(t *testing.T, lms app.LibraryManagementSystem) {
+	card := registerCard(t, lms, "reader", "S")
+	mustOK(t, lms.SetCardPIN(card, "1234"))
+	token := patronLogin(t, lms, card, "1234")
+
+	// a new PIN signs the patron out
+	mustFail(t, lms.ChangeCardPIN(card, "0000", "5678"), utils.E_UNAUTHORIZED)
+	mustOK(t, lms.ChangeCardPIN(card, "1234", "5678"))
+	mustFail(t, lms.AuthenticatePatron(token), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.PatronLogin(card, "1234"), utils.E_UNAUTHORIZED)
+	patronLogin(t, lms, card, "5678")
+
+	// removing the card ends its sessions and drops its PIN
+	token = patronLogin(t, lms, card, "5678")
+	mustOK(t, lms.RemoveCard(card))
+	mustFail(t, lms.AuthenticatePatron(token), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.PatronLogin(card, "5678"), utils.E_UNAUTHORIZED)
+}
+
+func testPatronLockout(t *testing.T, lms app.LibraryManagementSystem) {
+	card := registerCard(t, lms, "reader", "S")
+	other := registerCard(t, lms, "other", "S")
+	mustOK(t, lms.SetCardPIN(card, "1234"))
+	mustOK(t, lms.SetCardPIN(other, "1234"))
+	t.Cleanup(func() { model.SetAuthPolicy(model.DefaultAuthPolicy()) })
+
+	policy := model.DefaultAuthPolicy()
+	policy.MaxPINAttempts = 3
+	model.SetAuthPolicy(policy)
+	for i := 0; i < policy.MaxPINAttempts; i++ {
+		mustFail(t, lms.PatronLogin(card, "0000"), utils.E_UNAUTHORIZED)
+	}
+	// even the right PIN is refused while the card is locked, the other cards are not
+	mustFail(t, lms.PatronLogin(card, "1234"), utils.E_TOO_MANY_ATTEMPTS)
+	patronLogin(t, lms, other, "1234")
+
+	// the desk setting a new PIN unlocks the card
+	mustOK(t, lms.SetCardPIN(card, "5678"))
+	patronLogin(t, lms, card, "5678")
+
+	// wrong old PINs on a change count against the card as well, and lock its login
+	for i := 0; i < policy.MaxPINAttempts; i++ {
+		mustFail(t, lms.ChangeCardPIN(card, "0000", "9999"), utils.E_UNAUTHORIZED)
+	}
+	mustFail(t, lms.ChangeCardPIN(card, "5678", "9999"), utils.E_TOO_MANY_ATTEMPTS)
+	mustFail(t, lms.PatronLogin(card, "5678"), utils.E_TOO_MANY_ATTEMPTS)
+
+	// a right PIN clears the failures before the limit
+	mustOK(t, lms.SetCardPIN(card, "5678"))
+	for i := 0; i < policy.MaxPINAttempts-1; i++ {
+		mustFail(t, lms.PatronLogin(card, "0000"), utils.E_UNAUTHORIZED)
+	}
+	mustOK(t, lms.ChangeCardPIN(card, "5678", "1234"))
+	mustFail(t, lms.ChangeCardPIN(card, "0000", "5678"), utils.E_UNAUTHORIZED)
+	patronLogin(t, lms, card, "1234")
+}
+
+func testPatronScope(t *testing.T, lms app.LibraryManagementSystem) {
+	alpha := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 2))
+	beta := storeBook(t, lms, newBook("beta", "cs", 2002, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+	other := registerCard(t, lms, "other", "S")
+
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: alpha}))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: beta}))
+	mustOK(t, lms.ReturnBook(&model.Borrow{CardID: card, BookID: beta}))
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: other, BookID: alpha}))
+
+	// the loans are the books not returned yet, the history keeps the returned ones too
+	result := lms.ShowLoans(card)
+	mustOK(t, result)
+	loans := result.Payload.(*model.BorrowHistories)
+	if loans.Count != 1 || len(loans.Items) != 1 || loans.Items[0].BookID != alpha || loans.Items[0].DueTime == 0 {
+		t.Fatalf("unexpected loans %+v", *loans)
+	}
+	if items := borrowHistory(t, lms, card); len(items) != 2 {
+		t.Fatalf("expected 2 borrow records, got %+v", items)
+	}
+	mustFail(t, lms.ShowLoans(card+100), utils.E_NOT_FOUND)
+
+	// a patron cancels only the holds of their own card
+	third := registerCard(t, lms, "third", "S")
+	hold := placeHold(t, lms, third, alpha)
+	mustFail(t, lms.CancelCardHold(card, hold.HoldID), utils.E_NOT_FOUND)
+	mustOK(t, lms.CancelCardHold(third, hold.HoldID))
+	if holds := holdList(t, lms.ShowCardHolds(third)); len(holds) != 1 || holds[0].Status != model.HoldCancelled {
+		t.Fatalf("unexpected holds %+v", holds)
+	}
+}
@@ -0,0 +1,272 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const fixtureYAML = `books:
+  - key: alpha
+    category: cs
+    title: alpha
+    press: press
+    publish_year: 2001
+    author: Ann
+    price: 10
+    stock: 2
+  - key: bravo
+    category: math
+    title: bravo
+    press: press
+    publish_year: 2002
+    author: Bob
+    price: 20
+    stock: 1
+cards:
+  - key: reader
+    name: reader
+    department: department
+    type: S
+loans:
+  - card: reader
+    book: alpha
+  - card: reader
+    book: bravo
+    returned: true
+`
+
+// fixtureOverdrawn lends its single copy twice, it breaks no rule of the fixture itself but only of the connectors
+const fixtureOverdrawn = `books:
+  - {key: alpha, category: cs, title: alpha, press: press, publish_year: 2001, author: Ann, price: 10, stock: 1}
+cards:
+  - {key: first, name: first, department: department, type: S}
+  - {key: second, name: second, department: department, type: S}
+loans:
+  - {card: first, book: alpha}
+  - {card: second, book: alpha}
+`
+
+const fixtureJSON = `{"cards": [{"key": "teacher", "name": "teacher", "department": "department", "type": "T"}]}`
+
+// allowResets lets the suite reset the database through PrepareReset and ConfirmReset, with the fixture sets of dir
+func allowResets(t *testing.T, dir string, ttl time.Duration) {
+	t.Helper()
+	previous := model.CurrentResetPolicy()
+	model.SetResetPolicy(&model.ResetPolicy{Environment: model.EnvTest, ConfirmTTL: ttl, Fixtures: dir})
+	t.Cleanup(func() { model.SetResetPolicy(previous) })
+}
+
+func prepareReset(t *testing.T, lms app.LibraryManagementSystem, userId int, options *model.ResetOptions) *model.ResetConfirmation {
+	t.Helper()
+	result := lms.PrepareReset(userId, options)
+	mustOK(t, result)
+	confirmation, ok := result.Payload.(*model.ResetConfirmation)
+	if !ok {
+		t.Fatalf("unexpected PrepareReset payload %T", result.Payload)
+	}
+	if confirmation.Token == "" || confirmation.ExpireTime < time.Now().Unix() {
+		t.Fatalf("unexpected confirmation %+v", *confirmation)
+	}
+	return confirmation
+}
+
+func confirmReset(t *testing.T, lms app.LibraryManagementSystem, userId int, token string) *model.ResetResult {
+	t.Helper()
+	result := lms.ConfirmReset(userId, token)
+	mustOK(t, result)
+	reset, ok := result.Payload.(*model.ResetResult)
+	if !ok {
+		t.Fatalf("unexpected ConfirmReset payload %T", result.Payload)
+	}
+	return reset
+}
+
+func testResetGuard(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+
+	// resets are refused unless the environment is dev or test
+	mustFail(t, lms.PrepareReset(1, nil), utils.E_FORBIDDEN)
+	allowResets(t, t.TempDir(), time.Minute)
+
+	confirmation := prepareReset(t, lms, 1, nil)
+	if len(confirmation.Tables) == 0 {
+		t.Fatal("expected a full reset to list the tables it clears")
+	}
+	for _, table := range confirmation.Tables {
+		if table == "users" || table == "audit_log" {
+			t.Fatalf("expected %s to be kept on reset", table)
+		}
+	}
+	mustFail(t, lms.ConfirmReset(1, "bogus"), utils.E_FORBIDDEN)
+	mustFail(t, lms.ConfirmReset(2, confirmation.Token), utils.E_FORBIDDEN)
+	if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 1 {
+		t.Fatalf("expected the book kept until the reset is confirmed, got %d books", len(books))
+	}
+
+	confirmReset(t, lms, 1, confirmation.Token)
+	if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 0 {
+		t.Fatalf("expected no books after reset, got %d", len(books))
+	}
+	// a token confirms a single reset
+	mustFail(t, lms.ConfirmReset(1, confirmation.Token), utils.E_FORBIDDEN)
+
+	// nor does it outlive the reset policy
+	confirmation = prepareReset(t, lms, 1, nil)
+	model.SetResetPolicy(model.DefaultResetPolicy())
+	mustFail(t, lms.ConfirmReset(1, confirmation.Token), utils.E_FORBIDDEN)
+
+	allowResets(t, t.TempDir(), time.Nanosecond)
+	confirmation = prepareReset(t, lms, 1, nil)
+	mustFail(t, lms.ConfirmReset(1, confirmation.Token), utils.E_FORBIDDEN)
+}
+
+func testResetTables(t *testing.T, lms app.LibraryManagementSystem) {
+	allowResets(t, t.TempDir(), time.Minute)
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+	other := registerCard(t, lms, "other", "S")
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: id}))
+	mustOK(t, lms.PlaceHold(&model.Hold{CardID: other, BookID: id}))
+
+	mustFail(t, lms.PrepareReset(1, &model.ResetOptions{Tables: []string{"users"}}), utils.E_VALIDATION)
+	mustFail(t, lms.PrepareReset(1, &model.ResetOptions{Tables: []string{"nothing"}}), utils.E_VALIDATION)
+
+	// clearing the loans and holds keeps the books and cards, and puts the copies lent back on the shelf
+	confirmation := prepareReset(t, lms, 1, &model.ResetOptions{Tables: []string{"borrow", "hold"}})
+	if len(confirmation.Tables) != 2 {
+		t.Fatalf("expected borrow and hold only, got %v", confirmation.Tables)
+	}
+	confirmReset(t, lms, 1, confirmation.Token)
+	if len(cardList(t, lms)) != 2 || stockOf(t, lms, id) != 1 || len(borrowHistory(t, lms, card)) != 0 {
+		t.Fatalf("unexpected library after clearing the loans: %d cards, stock %d", len(cardList(t, lms)), stockOf(t, lms, id))
+	}
+	result := lms.ShowCardHolds(other)
+	mustOK(t, result)
+	if holds := result.Payload.(*model.HoldList); holds.Count != 0 {
+		t.Fatalf("expected no holds, got %d", holds.Count)
+	}
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: other, BookID: id}))
+
+	// the tables referencing the cards are cleared with them
+	confirmation = prepareReset(t, lms, 1, &model.ResetOptions{Tables: []string{"card"}})
+	for _, table := range []string{"card", "borrow", "hold", "card_credential", "patron_session"} {
+		found := false
+		for _, cleared := range confirmation.Tables {
+			found = found || cleared == table
+		}
+		if !found {
+			t.Fatalf("expected %s cleared with the cards, got %v", table, confirmation.Tables)
+		}
+	}
+	confirmReset(t, lms, 1, confirmation.Token)
+	if len(cardList(t, lms)) != 0 || len(queryBooks(t, lms, model.NewBookQueryConditions())) != 1 || stockOf(t, lms, id) != 1 {
+		t.Fatal("expected the cards cleared and the book back in stock")
+	}
+
+	// the ids go on from where they were
+	if next := registerCard(t, lms, "reader", "S"); next <= other {
+		t.Fatalf("expected a card id after %d, got %d", other, next)
+	}
+}
+
+func testResetFixture(t *testing.T, lms app.LibraryManagementSystem) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"demo.yaml":   fixtureYAML,
+		"staff.json":  fixtureJSON,
+		"broken.yml":  "loans:\n  - card: nobody\n    book: nothing\n",
+		"garbage.yml": "books: [",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	allowResets(t, dir, time.Minute)
+	storeBook(t, lms, newBook("old", "cs", 2001, 10, 1))
+
+	mustFail(t, lms.PrepareReset(1, &model.ResetOptions{Fixture: "missing"}), utils.E_NOT_FOUND)
+	mustFail(t, lms.PrepareReset(1, &model.ResetOptions{Fixture: "../demo"}), utils.E_VALIDATION)
+	mustFail(t, lms.PrepareReset(1, &model.ResetOptions{Fixture: "broken"}), utils.E_VALIDATION)
+	mustFail(t, lms.PrepareReset(1, &model.ResetOptions{Fixture: "garbage"}), utils.E_VALIDATION)
+
+	confirmation := prepareReset(t, lms, 1, &model.ResetOptions{Fixture: "demo"})
+	reset := confirmReset(t, lms, 1, confirmation.Token)
+	if reset.Fixture != "demo" || reset.Books != 2 || reset.Cards != 1 || reset.Loans != 2 {
+		t.Fatalf("unexpected reset %+v", *reset)
+	}
+	books := queryBooks(t, lms, model.NewBookQueryConditions())
+	if len(books) != 2 {
+		t.Fatalf("expected the 2 books of the fixture, got %d", len(books))
+	}
+	cards := cardList(t, lms)
+	if len(cards) != 1 {
+		t.Fatalf("expected the card of the fixture, got %d", len(cards))
+	}
+	history := borrowHistory(t, lms, cards[0].CardID)
+	open := 0
+	for _, item := range history {
+		if item.ReturnTime == 0 {
+			open++
+		}
+	}
+	if len(history) != 2 || open != 1 {
+		t.Fatalf("expected a loan and a returned one, got %+v", history)
+	}
+	// the search index follows the books of the fixture
+	if found := searchBooks(t, lms, "old"); found.Count != 0 {
+		t.Fatalf("expected the old book gone from the index, got %d hits", found.Count)
+	}
+	if found := searchBooks(t, lms, "alpha"); found.Count != 1 {
+		t.Fatalf("expected the fixture book indexed, got %d hits", found.Count)
+	}
+
+	// a fixture may be loaded after clearing some tables only
+	confirmation = prepareReset(t, lms, 1, &model.ResetOptions{Tables: []string{"card"}, Fixture: "staff"})
+	reset = confirmReset(t, lms, 1, confirmation.Token)
+	cards = cardList(t, lms)
+	if reset.Cards != 1 || len(cards) != 1 || cards[0].Type != "T" || len(queryBooks(t, lms, model.NewBookQueryConditions())) != 2 {
+		t.Fatalf("unexpected library after loading the staff fixture: %+v", cards)
+	}
+}
+
+func testResetFixtureInvalid(t *testing.T, lms app.LibraryManagementSystem) {
+	dir := t.TempDir()
+	write := func(name string, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("overdrawn.yaml", fixtureOverdrawn)
+	write("demo.yaml", fixtureYAML)
+	allowResets(t, dir, time.Minute)
+	old := storeBook(t, lms, newBook("old", "cs", 2001, 10, 1))
+	card := registerCard(t, lms, "reader", "S")
+	mustOK(t, lms.BorrowBook(&model.Borrow{CardID: card, BookID: old}))
+	untouched := func() {
+		t.Helper()
+		if books := queryBooks(t, lms, model.NewBookQueryConditions()); len(books) != 1 || books[0].BookID != old {
+			t.Fatalf("expected the library untouched, got books %+v", books)
+		}
+		if items := borrowHistory(t, lms, card); len(items) != 1 {
+			t.Fatalf("expected the library untouched, got loans %+v", items)
+		}
+	}
+
+	// the fixture is loaded into an empty database before the reset, its second loan fails there
+	mustFail(t, lms.PrepareReset(1, &model.ResetOptions{Fixture: "overdrawn"}), utils.E_VALIDATION)
+	// a fixture loads into the tables the reset clears only, not on top of the books kept
+	mustFail(t, lms.PrepareReset(1, &model.ResetOptions{Tables: []string{"card"}, Fixture: "demo"}), utils.E_VALIDATION)
+	untouched()
+
+	// the fixture changed since the reset was asked for is checked again before anything is cleared
+	confirmation := prepareReset(t, lms, 1, &model.ResetOptions{Fixture: "demo"})
+	write("demo.yaml", fixtureOverdrawn)
+	mustFail(t, lms.ConfirmReset(1, confirmation.Token), utils.E_VALIDATION)
+	untouched()
+}
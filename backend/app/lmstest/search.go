@@ -0,0 +1,133 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+)
+
+func searchBooks(t *testing.T, lms app.LibraryManagementSystem, query string) *model.SearchResult {
+	t.Helper()
+	result := lms.SearchBooks(query, 0)
+	mustOK(t, result)
+	found, ok := result.Payload.(*model.SearchResult)
+	if !ok {
+		t.Fatalf("unexpected SearchBooks payload %T", result.Payload)
+	}
+	return found
+}
+
+func hitIDs(found *model.SearchResult) []int {
+	ids := make([]int, 0, len(found.Hits))
+	for _, hit := range found.Hits {
+		ids = append(ids, hit.Book.BookID)
+	}
+	return ids
+}
+
+func testSearchBooks(t *testing.T, lms app.LibraryManagementSystem) {
+	learning := newBook("Deep Learning", "cs", 2016, 80, 1)
+	learning.Author = "Ian Goodfellow"
+	learnt := newBook("Lessons Learnt", "history", 2001, 20, 1)
+	learnt.Press = "Deep Press"
+	database := newBook("Database System Concepts", "cs", 2010, 60, 1)
+	database.Author = ""
+	database.Contributors = []model.Contributor{{Name: "Abraham Silberschatz"}, {Name: "Jane Doe", Role: model.RoleTranslator}}
+	learningId := storeBook(t, lms, learning)
+	learntId := storeBook(t, lms, learnt)
+	databaseId := storeBook(t, lms, database)
+
+	// a match in the title ranks above a match in the press
+	found := searchBooks(t, lms, "deep")
+	if ids := hitIDs(found); found.Count != 2 || len(ids) != 2 || ids[0] != learningId || ids[1] != learntId {
+		t.Fatalf("expected books %d then %d, got %v", learningId, learntId, ids)
+	}
+	if found.Hits[0].Score <= found.Hits[1].Score {
+		t.Fatalf("expected decreasing scores, got %+v", found.Hits)
+	}
+	if highlight := found.Hits[0].Highlights[model.SearchFieldTitle]; highlight != "<em>Deep</em> Learning" {
+		t.Fatalf("unexpected title highlight %q", highlight)
+	}
+
+	// words match in any order, and all of them must
+	if ids := hitIDs(searchBooks(t, lms, "learning DEEP")); len(ids) != 1 || ids[0] != learningId {
+		t.Fatalf("expected book %d, got %v", learningId, ids)
+	}
+	if found := searchBooks(t, lms, "deep concepts"); found.Count != 0 {
+		t.Fatalf("expected no book, got %+v", found.Hits)
+	}
+
+	// a phrase keeps the order of its words
+	if found := searchBooks(t, lms, `"learning deep"`); found.Count != 0 {
+		t.Fatalf("expected no book, got %+v", found.Hits)
+	}
+	if ids := hitIDs(searchBooks(t, lms, `"system concepts"`)); len(ids) != 1 || ids[0] != databaseId {
+		t.Fatalf("expected book %d, got %v", databaseId, ids)
+	}
+
+	// a prefix matches the words it begins
+	if ids := hitIDs(searchBooks(t, lms, "learn*")); len(ids) != 2 {
+		t.Fatalf("expected 2 books, got %v", ids)
+	}
+	if found := searchBooks(t, lms, "learn"); found.Count != 0 {
+		t.Fatalf("expected no book without a prefix query, got %+v", found.Hits)
+	}
+
+	// contributors are searched with the author
+	found = searchBooks(t, lms, "jane")
+	if ids := hitIDs(found); len(ids) != 1 || ids[0] != databaseId {
+		t.Fatalf("expected book %d, got %v", databaseId, ids)
+	}
+	if highlight := found.Hits[0].Highlights[model.SearchFieldAuthor]; highlight != "Abraham Silberschatz, <em>Jane</em> Doe" {
+		t.Fatalf("unexpected author highlight %q", highlight)
+	}
+}
+
+func testSearchBooksSync(t *testing.T, lms app.LibraryManagementSystem) {
+	id := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	mustOK(t, lms.StoreBooks([]model.Book{*newBook("bravo", "cs", 2001, 10, 1), *newBook("charlie", "cs", 2001, 10, 1)}))
+	if found := searchBooks(t, lms, "charlie"); found.Count != 1 || found.Hits[0].Book.Title != "charlie" {
+		t.Fatalf("expected the book stored in a batch, got %+v", found.Hits)
+	}
+
+	modified := newBook("delta", "cs", 2001, 10, 1)
+	modified.BookID = id
+	mustOK(t, lms.ModifyBookInfo(modified))
+	if found := searchBooks(t, lms, "alpha"); found.Count != 0 {
+		t.Fatalf("expected the old title to be forgotten, got %+v", found.Hits)
+	}
+	if ids := hitIDs(searchBooks(t, lms, "delta")); len(ids) != 1 || ids[0] != id {
+		t.Fatalf("expected book %d, got %v", id, ids)
+	}
+
+	// hits carry the current state of the book
+	mustOK(t, lms.IncBookStock(id, 2))
+	if found := searchBooks(t, lms, "delta"); found.Hits[0].Book.Stock != 3 {
+		t.Fatalf("expected stock 3, got %+v", found.Hits[0].Book)
+	}
+
+	mustOK(t, lms.RemoveBook(id))
+	if found := searchBooks(t, lms, "delta"); found.Count != 0 {
+		t.Fatalf("expected the removed book to be forgotten, got %+v", found.Hits)
+	}
+	if found := searchBooks(t, lms, "cs"); found.Count != 2 {
+		t.Fatalf("expected 2 books, got %+v", found.Hits)
+	}
+}
+
+func testSearchBooksInvalid(t *testing.T, lms app.LibraryManagementSystem) {
+	storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 1))
+	storeBook(t, lms, newBook("bravo", "cs", 2001, 10, 1))
+
+	mustFail(t, lms.SearchBooks("", 0), utils.E_VALIDATION)
+	mustFail(t, lms.SearchBooks(` " * - `, 0), utils.E_VALIDATION)
+	mustFail(t, lms.SearchBooks("cs", -1), utils.E_VALIDATION)
+	mustFail(t, lms.SearchBooks("cs", model.MaxSearchLimit+1), utils.E_VALIDATION)
+
+	result := lms.SearchBooks("cs", 1)
+	mustOK(t, result)
+	if found := result.Payload.(*model.SearchResult); found.Count != 2 || len(found.Hits) != 1 {
+		t.Fatalf("expected 1 of 2 hits, got %d of %d", len(found.Hits), found.Count)
+	}
+}
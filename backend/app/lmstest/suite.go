@@ -0,0 +1,274 @@
+// Package lmstest is a conformance suite for app.LibraryManagementSystem implementations.
+//
+// Call Run from a test of the backend package:
+//
+//	func TestConformance(t *testing.T) {
+//		lmstest.Run(t, func(t *testing.T) app.LibraryManagementSystem {
+//			return app.NewLibraryManagementSystemSQLite(t.TempDir() + "/library.db")
+//		})
+//	}
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"testing"
+)
+
+// Factory returns a new, not yet initialized library management system.
+// The suite calls Init, ResetDatabase and Free on it.
+type Factory func(t *testing.T) app.LibraryManagementSystem
+
+type scenario struct {
+	name string
+	run  func(t *testing.T, lms app.LibraryManagementSystem)
+}
+
+var scenarios = []scenario{
+	{"StoreBook", testStoreBook},
+	{"StoreBookDuplicate", testStoreBookDuplicate},
+	{"StoreBooks", testStoreBooks},
+	{"StoreBooksAtomic", testStoreBooksAtomic},
+	{"StoreBookNegativeStock", testStoreBookNegativeStock},
+	{"StoreBookISBN", testStoreBookISBN},
+	{"BookContributors", testBookContributors},
+	{"BookContributorsUpdate", testBookContributorsUpdate},
+	{"BookContributorsInvalid", testBookContributorsInvalid},
+	{"IncBookStock", testIncBookStock},
+	{"IncBookStockNegative", testIncBookStockNegative},
+	{"IncBookStockNotFound", testIncBookStockNotFound},
+	{"ModifyBookInfo", testModifyBookInfo},
+	{"ModifyBookInfoVersion", testModifyBookInfoVersion},
+	{"PatchBook", testPatchBook},
+	{"RemoveBook", testRemoveBook},
+	{"RemoveBookBorrowed", testRemoveBookBorrowed},
+	{"QueryBookFilter", testQueryBookFilter},
+	{"QueryBookSort", testQueryBookSort},
+	{"QueryBookInvalidSortOrder", testQueryBookInvalidSortOrder},
+	{"QueryBookFilterExpression", testQueryBookFilterExpression},
+	{"QueryBookFilterEscape", testQueryBookFilterEscape},
+	{"QueryBookFilterInvalid", testQueryBookFilterInvalid},
+	{"QueryBookMultiSort", testQueryBookMultiSort},
+	{"QueryBookFacets", testQueryBookFacets},
+	{"QueryBookFacetsInvalid", testQueryBookFacetsInvalid},
+	{"QueryBookPages", testQueryBookPages},
+	{"QueryBookPagesInvalid", testQueryBookPagesInvalid},
+	{"SearchBooks", testSearchBooks},
+	{"SearchBooksSync", testSearchBooksSync},
+	{"SearchBooksInvalid", testSearchBooksInvalid},
+	{"ShowCopies", testShowCopies},
+	{"AddCopies", testAddCopies},
+	{"BorrowByBarcode", testBorrowByBarcode},
+	{"WithdrawCopies", testWithdrawCopies},
+	{"UpdateCopy", testUpdateCopy},
+	{"BorrowBook", testBorrowBook},
+	{"BorrowBookOutOfStock", testBorrowBookOutOfStock},
+	{"BorrowBookTwice", testBorrowBookTwice},
+	{"BorrowBookNotFound", testBorrowBookNotFound},
+	{"BorrowBookDueTime", testBorrowBookDueTime},
+	{"BorrowBookLimits", testBorrowBookLimits},
+	{"ReturnBook", testReturnBook},
+	{"ReturnBookNotBorrowed", testReturnBookNotBorrowed},
+	{"RenewBook", testRenewBook},
+	{"ShowBorrowHistory", testShowBorrowHistory},
+	{"ShowBorrowHistoryCardNotFound", testShowBorrowHistoryCardNotFound},
+	{"ShowBorrowHistoryPages", testShowBorrowHistoryPages},
+	{"RegisterCard", testRegisterCard},
+	{"RegisterCardInvalid", testRegisterCardInvalid},
+	{"PatchCard", testPatchCard},
+	{"RemoveCard", testRemoveCard},
+	{"RemoveCardWithLoans", testRemoveCardWithLoans},
+	{"ShowCards", testShowCards},
+	{"ShowCardsPages", testShowCardsPages},
+	{"ShowFinesEmpty", testShowFinesEmpty},
+	{"FineOverdue", testFineOverdue},
+	{"PlaceHold", testPlaceHold},
+	{"HoldSetAside", testHoldSetAside},
+	{"CancelHold", testCancelHold},
+	{"HoldPriority", testHoldPriority},
+	{"BootstrapAdmin", testBootstrapAdmin},
+	{"BootstrapAdminConcurrent", testBootstrapAdminConcurrent},
+	{"LoginLockout", testLoginLockout},
+	{"CreateUser", testCreateUser},
+	{"UserRoles", testUserRoles},
+	{"LendBookDueTime", testLendBookDueTime},
+	{"UserPassword", testUserPassword},
+	{"SessionExpiry", testSessionExpiry},
+	{"PatronLogin", testPatronLogin},
+	{"PatronPIN", testPatronPIN},
+	{"PatronLockout", testPatronLockout},
+	{"PatronScope", testPatronScope},
+	{"AuditTrail", testAuditTrail},
+	{"AuditCirculation", testAuditCirculation},
+	{"AuditQuery", testAuditQuery},
+	{"AuditReset", testAuditReset},
+	{"ResetDatabase", testResetDatabase},
+	{"ResetGuard", testResetGuard},
+	{"ResetTables", testResetTables},
+	{"ResetFixture", testResetFixture},
+	{"ResetFixtureInvalid", testResetFixtureInvalid},
+	{"ArchiveRoundTrip", testArchiveRoundTrip},
+	{"ArchiveNotEmpty", testArchiveNotEmpty},
+	{"ArchiveInvalid", testArchiveInvalid},
+	{"ArchiveMigrate", testArchiveMigrate},
+}
+
+// Run executes every scenario of the suite against a fresh system built by factory.
+func Run(t *testing.T, factory Factory) {
+	for _, s := range scenarios {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			s.run(t, setup(t, factory))
+		})
+	}
+}
+
+func setup(t *testing.T, factory Factory) app.LibraryManagementSystem {
+	t.Helper()
+
+	lms := factory(t)
+	if err := lms.Init(); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	t.Cleanup(lms.Free)
+
+	mustOK(t, lms.ResetDatabase())
+	return lms
+}
+
+func mustOK(t *testing.T, result *app.ApiResult) {
+	t.Helper()
+	if result == nil {
+		t.Fatal("nil result")
+	}
+	if !result.OK {
+		t.Fatalf("expected success, got failure: %s", result.Message)
+	}
+}
+
+func mustFail(t *testing.T, result *app.ApiResult, code utils.ErrorCode) {
+	t.Helper()
+	if result == nil {
+		t.Fatal("nil result")
+	}
+	if result.OK {
+		t.Fatal("expected failure, got success")
+	}
+	if result.Code != code {
+		t.Fatalf("expected error code %d, got %d: %s", code, result.Code, result.Message)
+	}
+}
+
+func newBook(title string, category string, year int, price float32, stock int) *model.Book {
+	book := model.Book{
+		Category:    category,
+		Title:       title,
+		Press:       "press",
+		PublishYear: year,
+		Author:      "author",
+		Stock:       stock,
+	}
+	book.SetPrice(price)
+	return &book
+}
+
+func storeBook(t *testing.T, lms app.LibraryManagementSystem, book *model.Book) int {
+	t.Helper()
+	mustOK(t, lms.StoreBook(book))
+	if book.BookID <= 0 {
+		t.Fatalf("StoreBook did not assign an id: %d", book.BookID)
+	}
+	return book.BookID
+}
+
+func registerCard(t *testing.T, lms app.LibraryManagementSystem, name string, cardType string) int {
+	t.Helper()
+	card := model.Card{Name: name, Department: "department", Type: cardType}
+	mustOK(t, lms.RegisterCard(&card))
+	if card.CardID <= 0 {
+		t.Fatalf("RegisterCard did not assign an id: %d", card.CardID)
+	}
+	return card.CardID
+}
+
+func queryBooks(t *testing.T, lms app.LibraryManagementSystem, conditions *model.BookQueryConditions) []model.Book {
+	t.Helper()
+	result := lms.QueryBook(conditions)
+	mustOK(t, result)
+	books, ok := result.Payload.(*model.BookQueryResult)
+	if !ok {
+		t.Fatalf("unexpected QueryBook payload %T", result.Payload)
+	}
+	if books.Count != len(books.Results) {
+		t.Fatalf("count %d does not match %d results", books.Count, len(books.Results))
+	}
+	return books.Results
+}
+
+func bookByID(t *testing.T, lms app.LibraryManagementSystem, bookId int) *model.Book {
+	t.Helper()
+	for _, book := range queryBooks(t, lms, model.NewBookQueryConditions()) {
+		if book.BookID == bookId {
+			return &book
+		}
+	}
+	return nil
+}
+
+func stockOf(t *testing.T, lms app.LibraryManagementSystem, bookId int) int {
+	t.Helper()
+	book := bookByID(t, lms, bookId)
+	if book == nil {
+		t.Fatalf("book %d not found", bookId)
+	}
+	return book.Stock
+}
+
+func borrowHistory(t *testing.T, lms app.LibraryManagementSystem, cardId int) []model.Item {
+	t.Helper()
+	result := lms.ShowBorrowHistory(cardId, nil)
+	mustOK(t, result)
+	histories, ok := result.Payload.(*model.BorrowHistories)
+	if !ok {
+		t.Fatalf("unexpected ShowBorrowHistory payload %T", result.Payload)
+	}
+	if histories.Count != len(histories.Items) {
+		t.Fatalf("count %d does not match %d items", histories.Count, len(histories.Items))
+	}
+	return histories.Items
+}
+
+func cardList(t *testing.T, lms app.LibraryManagementSystem) []model.Card {
+	t.Helper()
+	result := lms.ShowCards(nil)
+	mustOK(t, result)
+	cards, ok := result.Payload.(*model.CardList)
+	if !ok {
+		t.Fatalf("unexpected ShowCards payload %T", result.Payload)
+	}
+	if cards.Count != len(cards.Cards) {
+		t.Fatalf("count %d does not match %d cards", cards.Count, len(cards.Cards))
+	}
+	return cards.Cards
+}
+
+func bookIDs(books []model.Book) []int {
+	ids := make([]int, 0, len(books))
+	for _, book := range books {
+		ids = append(ids, book.BookID)
+	}
+	return ids
+}
+
+func equalIDs(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
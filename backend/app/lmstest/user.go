@@ -0,0 +1,250 @@
+package lmstest
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func createUser(t *testing.T, lms app.LibraryManagementSystem, username string, role model.UserRole) *model.User {
+	t.Helper()
+	result := lms.CreateUser(username, username+" password", role)
+	mustOK(t, result)
+	user, ok := result.Payload.(*model.User)
+	if !ok {
+		t.Fatalf("unexpected CreateUser payload %T", result.Payload)
+	}
+	if user.UserID <= 0 {
+		t.Fatalf("CreateUser did not assign an id: %d", user.UserID)
+	}
+	return user
+}
+
+func login(t *testing.T, lms app.LibraryManagementSystem, username string, password string) string {
+	t.Helper()
+	result := lms.Login(username, password)
+	mustOK(t, result)
+	login, ok := result.Payload.(*model.LoginResult)
+	if !ok {
+		t.Fatalf("unexpected Login payload %T", result.Payload)
+	}
+	if login.Token == "" || login.ExpireTime <= time.Now().Unix() {
+		t.Fatalf("unexpected login %+v", *login)
+	}
+	return login.Token
+}
+
+func authenticate(t *testing.T, lms app.LibraryManagementSystem, token string) *model.User {
+	t.Helper()
+	result := lms.Authenticate(token)
+	mustOK(t, result)
+	return result.Payload.(*model.User)
+}
+
+func testBootstrapAdmin(t *testing.T, lms app.LibraryManagementSystem) {
+	mustFail(t, lms.BootstrapAdmin("root", "short"), utils.E_VALIDATION)
+	result := lms.BootstrapAdmin(" Root ", "root password")
+	mustOK(t, result)
+	if admin := result.Payload.(*model.User); admin.Username != "root" || admin.Role != model.UserAdmin {
+		t.Fatalf("unexpected first admin %+v", *admin)
+	}
+	mustFail(t, lms.BootstrapAdmin("other", "other password"), utils.E_FORBIDDEN)
+
+	// usernames are matched whatever their case
+	token := login(t, lms, "ROOT", "root password")
+	if user := authenticate(t, lms, token); user.Username != "root" || user.Role != model.UserAdmin || user.PasswordHash == "" {
+		t.Fatalf("unexpected user %+v", *user)
+	}
+	mustFail(t, lms.Login("root", "wrong password"), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.Login("nobody", "root password"), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.Authenticate(""), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.Authenticate(token+"x"), utils.E_UNAUTHORIZED)
+
+	mustOK(t, lms.Logout(token))
+	mustFail(t, lms.Authenticate(token), utils.E_UNAUTHORIZED)
+}
+
+func testBootstrapAdminConcurrent(t *testing.T, lms app.LibraryManagementSystem) {
+	results := make([]*app.ApiResult, 8)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = lms.BootstrapAdmin(fmt.Sprintf("root%d", i), "root password")
+		}(i)
+	}
+	wg.Wait()
+
+	// a single bootstrap creates its admin, the others find it
+	created := 0
+	for _, result := range results {
+		if result.OK {
+			created++
+		} else if result.Code != utils.E_FORBIDDEN {
+			t.Fatalf("unexpected bootstrap failure %d: %s", result.Code, result.Message)
+		}
+	}
+	result := lms.ShowUsers()
+	mustOK(t, result)
+	if users := result.Payload.(*model.UserList); created != 1 || users.Count != 1 {
+		t.Fatalf("expected a single admin, %d bootstraps created %+v", created, *users)
+	}
+}
+
+func testLoginLockout(t *testing.T, lms app.LibraryManagementSystem) {
+	alice := createUser(t, lms, "alice", model.UserLibrarian)
+	createUser(t, lms, "bob", model.UserLibrarian)
+	t.Cleanup(func() { model.SetAuthPolicy(model.DefaultAuthPolicy()) })
+
+	policy := model.DefaultAuthPolicy()
+	policy.MaxLoginAttempts = 3
+	model.SetAuthPolicy(policy)
+	for i := 0; i < policy.MaxLoginAttempts; i++ {
+		mustFail(t, lms.Login("alice", "wrong password"), utils.E_UNAUTHORIZED)
+	}
+	// even the right password is refused while the username is locked, the other users are not
+	mustFail(t, lms.Login("Alice", "alice password"), utils.E_TOO_MANY_ATTEMPTS)
+	login(t, lms, "bob", "bob password")
+
+	// unknown usernames are locked out alike
+	for i := 0; i < policy.MaxLoginAttempts; i++ {
+		mustFail(t, lms.Login("nobody", "wrong password"), utils.E_UNAUTHORIZED)
+	}
+	mustFail(t, lms.Login("nobody", "wrong password"), utils.E_TOO_MANY_ATTEMPTS)
+
+	// an admin setting a new password unlocks the user
+	mustOK(t, lms.SetUserPassword(alice.UserID, "new alice password"))
+	login(t, lms, "alice", "new alice password")
+
+	// wrong old passwords on a change count against the username as well, a right password clears them
+	for i := 0; i < policy.MaxLoginAttempts-1; i++ {
+		mustFail(t, lms.ChangePassword(alice.UserID, "wrong password", "alice password"), utils.E_UNAUTHORIZED)
+	}
+	login(t, lms, "alice", "new alice password")
+	for i := 0; i < policy.MaxLoginAttempts; i++ {
+		mustFail(t, lms.ChangePassword(alice.UserID, "wrong password", "alice password"), utils.E_UNAUTHORIZED)
+	}
+	mustFail(t, lms.ChangePassword(alice.UserID, "new alice password", "alice password"), utils.E_TOO_MANY_ATTEMPTS)
+	mustFail(t, lms.Login("alice", "new alice password"), utils.E_TOO_MANY_ATTEMPTS)
+}
+
+func testLendBookDueTime(t *testing.T, lms app.LibraryManagementSystem) {
+	alpha := storeBook(t, lms, newBook("alpha", "cs", 2001, 10, 2))
+	beta := storeBook(t, lms, newBook("beta", "cs", 2002, 10, 2))
+	card := registerCard(t, lms, "reader", "S")
+	desk := createUser(t, lms, "desk", model.UserCirculation)
+	librarian := createUser(t, lms, "librarian", model.UserLibrarian)
+
+	// the circulation desk lends by the loan policy only
+	due := time.Now().Add(72 * time.Hour).Unix()
+	mustFail(t, lms.LendBook(desk.UserID, &model.Borrow{CardID: card, BookID: alpha, DueTime: due}), utils.E_FORBIDDEN)
+	if items := borrowHistory(t, lms, card); len(items) != 0 {
+		t.Fatalf("refused loan was recorded: %+v", items)
+	}
+	mustOK(t, lms.LendBook(desk.UserID, &model.Borrow{CardID: card, BookID: alpha}))
+
+	mustOK(t, lms.LendBook(librarian.UserID, &model.Borrow{CardID: card, BookID: beta, DueTime: due}))
+	for _, item := range borrowHistory(t, lms, card) {
+		if item.BookID == beta && item.DueTime != due || item.BookID == alpha && item.DueTime == due {
+			t.Fatalf("expected the due time %d set by the librarian only, got %+v", due, item)
+		}
+	}
+	mustFail(t, lms.LendBook(librarian.UserID+100, &model.Borrow{CardID: card, BookID: beta, DueTime: due}), utils.E_NOT_FOUND)
+}
+
+func testCreateUser(t *testing.T, lms app.LibraryManagementSystem) {
+	createUser(t, lms, "alice", model.UserLibrarian)
+	createUser(t, lms, "bob", model.UserReadOnly)
+
+	mustFail(t, lms.CreateUser("Alice", "another password", model.UserReadOnly), utils.E_DUPLICATE)
+	mustFail(t, lms.CreateUser("carol", "carol password", "owner"), utils.E_VALIDATION)
+	mustFail(t, lms.CreateUser("carol", "short", model.UserReadOnly), utils.E_VALIDATION)
+	mustFail(t, lms.CreateUser("carol smith", "carol password", model.UserReadOnly), utils.E_VALIDATION)
+	mustFail(t, lms.CreateUser("", "carol password", model.UserReadOnly), utils.E_VALIDATION)
+
+	result := lms.ShowUsers()
+	mustOK(t, result)
+	users := result.Payload.(*model.UserList)
+	if users.Count != 2 || users.Users[0].Username != "alice" || users.Users[1].Role != model.UserReadOnly {
+		t.Fatalf("unexpected users %+v", *users)
+	}
+
+	// once there are users, the first admin cannot be created any more
+	mustFail(t, lms.BootstrapAdmin("root", "root password"), utils.E_FORBIDDEN)
+}
+
+func testUserRoles(t *testing.T, lms app.LibraryManagementSystem) {
+	for _, tc := range []struct {
+		role     model.UserRole
+		required model.UserRole
+		allowed  bool
+	}{
+		{model.UserAdmin, model.UserLibrarian, true},
+		{model.UserLibrarian, model.UserLibrarian, true},
+		{model.UserCirculation, model.UserLibrarian, false},
+		{model.UserReadOnly, model.UserCirculation, false},
+		{"owner", model.UserReadOnly, false},
+	} {
+		if got := tc.role.Allows(tc.required); got != tc.allowed {
+			t.Fatalf("expected %s allowing %s to be %v", tc.role, tc.required, tc.allowed)
+		}
+	}
+
+	admin := createUser(t, lms, "admin", model.UserAdmin)
+	desk := createUser(t, lms, "desk", model.UserReadOnly)
+	token := login(t, lms, "desk", "desk password")
+
+	// a new role applies to the sessions already open
+	mustOK(t, lms.SetUserRole(desk.UserID, model.UserCirculation))
+	if user := authenticate(t, lms, token); user.Role != model.UserCirculation {
+		t.Fatalf("expected the circulation role, got %s", user.Role)
+	}
+	mustFail(t, lms.SetUserRole(desk.UserID, "owner"), utils.E_VALIDATION)
+	mustFail(t, lms.SetUserRole(desk.UserID+100, model.UserLibrarian), utils.E_NOT_FOUND)
+
+	// the last admin can be neither demoted nor removed
+	mustFail(t, lms.SetUserRole(admin.UserID, model.UserLibrarian), utils.E_CONFLICT)
+	mustFail(t, lms.RemoveUser(admin.UserID), utils.E_CONFLICT)
+	mustOK(t, lms.SetUserRole(desk.UserID, model.UserAdmin))
+	mustOK(t, lms.SetUserRole(admin.UserID, model.UserLibrarian))
+	mustFail(t, lms.RemoveUser(desk.UserID), utils.E_CONFLICT)
+
+	mustOK(t, lms.RemoveUser(admin.UserID))
+	mustFail(t, lms.RemoveUser(admin.UserID), utils.E_NOT_FOUND)
+	mustFail(t, lms.Login("admin", "admin password"), utils.E_UNAUTHORIZED)
+}
+
+func testUserPassword(t *testing.T, lms app.LibraryManagementSystem) {
+	user := createUser(t, lms, "alice", model.UserLibrarian)
+	token := login(t, lms, "alice", "alice password")
+
+	// a new password signs the user out
+	mustFail(t, lms.SetUserPassword(user.UserID, "short"), utils.E_VALIDATION)
+	mustOK(t, lms.SetUserPassword(user.UserID, "new password"))
+	mustFail(t, lms.Authenticate(token), utils.E_UNAUTHORIZED)
+	mustFail(t, lms.Login("alice", "alice password"), utils.E_UNAUTHORIZED)
+	login(t, lms, "alice", "new password")
+
+	mustFail(t, lms.ChangePassword(user.UserID, "alice password", "newer password"), utils.E_UNAUTHORIZED)
+	mustOK(t, lms.ChangePassword(user.UserID, "new password", "newer password"))
+	login(t, lms, "alice", "newer password")
+	mustFail(t, lms.SetUserPassword(user.UserID+100, "some password"), utils.E_NOT_FOUND)
+}
+
+func testSessionExpiry(t *testing.T, lms app.LibraryManagementSystem) {
+	createUser(t, lms, "alice", model.UserLibrarian)
+	t.Cleanup(func() { model.SetAuthPolicy(model.DefaultAuthPolicy()) })
+
+	model.SetAuthPolicy(&model.AuthPolicy{TokenTTL: time.Nanosecond, MinPasswordLength: 8})
+	result := lms.Login("alice", "alice password")
+	mustOK(t, result)
+	mustFail(t, lms.Authenticate(result.Payload.(*model.LoginResult).Token), utils.E_UNAUTHORIZED)
+
+	model.SetAuthPolicy(model.DefaultAuthPolicy())
+	authenticate(t, lms, login(t, lms, "alice", "alice password"))
+}
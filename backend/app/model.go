@@ -1,6 +1,11 @@
 package app
 
-import "LibManSys/model"
+import (
+	"LibManSys/model"
+	"LibManSys/utils"
+	"errors"
+	"io"
+)
 
 type LibraryManagementSystem interface {
 	Init() error
@@ -11,19 +16,81 @@ type LibraryManagementSystem interface {
 	StoreBooks([]model.Book) *ApiResult
 	RemoveBook(bookId int) *ApiResult
 	ModifyBookInfo(*model.Book) *ApiResult
+	PatchBook(bookId int, patch *model.BookPatch) *ApiResult
 	QueryBook(*model.BookQueryConditions) *ApiResult
+	QueryBookByID(bookId int) *ApiResult
+	QueryBookByISBN(isbn string) *ApiResult
+	SearchBooks(query string, limit int) *ApiResult
+	ShowCopies(bookId int) *ApiResult
+	AddCopies(bookId int, copies []model.BookCopy) *ApiResult
+	WithdrawCopies(barcodes []string) *ApiResult
+	UpdateCopy(*model.BookCopy) *ApiResult
+	ShowAuthors(name string) *ApiResult
+	ShowAuthorWorks(authorId int) *ApiResult
 	BorrowBook(*model.Borrow) *ApiResult
+	// LendBook borrows a book for a staff user, only librarians may set the due time instead of the loan policy
+	LendBook(userId int, borrow *model.Borrow) *ApiResult
 	ReturnBook(*model.Borrow) *ApiResult
-	ShowBorrowHistory(cardId int) *ApiResult
+	RenewBook(*model.Borrow) *ApiResult
+	ShowBorrowHistory(cardId int, page *model.Page) *ApiResult
 	RegisterCard(*model.Card) *ApiResult
 	QueryCard(cardId int) *ApiResult
+	PatchCard(cardId int, patch *model.CardPatch) *ApiResult
 	RemoveCard(cardId int) *ApiResult
-	ShowCards() *ApiResult
+	ShowCards(page *model.Page) *ApiResult
+	ShowFines(cardId int) *ApiResult
+	SettleFine(*model.FineEntry) *ApiResult
+	PlaceHold(*model.Hold) *ApiResult
+	CancelHold(holdId int) *ApiResult
+	ShowCardHolds(cardId int) *ApiResult
+	ShowBookHolds(bookId int) *ApiResult
+	ExpireHolds() *ApiResult
 	ResetDatabase() *ApiResult
+	// PrepareReset returns the confirmation of a reset the user asks for, if the reset policy allows resets
+	PrepareReset(userId int, options *model.ResetOptions) *ApiResult
+	// ConfirmReset does the reset the user asked for with the token of its confirmation, and loads its fixture
+	ConfirmReset(userId int, token string) *ApiResult
+	// Backup writes an archive of the whole library to w, its payload is the manifest of the archive
+	Backup(w io.Writer) *ApiResult
+	// Restore loads the archive read from r into the empty database
+	Restore(r io.Reader) *ApiResult
+
+	// BootstrapAdmin creates an admin only if there are no users yet
+	BootstrapAdmin(username string, password string) *ApiResult
+	CreateUser(username string, password string, role model.UserRole) *ApiResult
+	ShowUsers() *ApiResult
+	SetUserRole(userId int, role model.UserRole) *ApiResult
+	SetUserPassword(userId int, password string) *ApiResult
+	// ChangePassword sets the password of a user who gave the current one
+	ChangePassword(userId int, oldPassword string, password string) *ApiResult
+	RemoveUser(userId int) *ApiResult
+	Login(username string, password string) *ApiResult
+	Logout(token string) *ApiResult
+	// Authenticate returns the user signed in with the token
+	Authenticate(token string) *ApiResult
+
+	SetCardPIN(cardId int, pin string) *ApiResult
+	// ChangeCardPIN sets the PIN of a patron who gave the current one
+	ChangeCardPIN(cardId int, oldPin string, pin string) *ApiResult
+	PatronLogin(cardId int, pin string) *ApiResult
+	PatronLogout(token string) *ApiResult
+	// AuthenticatePatron returns the id of the card signed in with the token
+	AuthenticatePatron(token string) *ApiResult
+	// ShowLoans returns the books a card has not returned yet
+	ShowLoans(cardId int) *ApiResult
+	// CancelCardHold cancels a hold only if it was placed for the card
+	CancelCardHold(cardId int, holdId int) *ApiResult
+
+	// WithAudit returns the system recording the changes made through it in the audit log as made in the context
+	WithAudit(audit *model.AuditContext) LibraryManagementSystem
+	ShowAudit(*model.AuditQuery) *ApiResult
+	// ShowBookHistory returns the entries of the audit log about a book, even a removed one
+	ShowBookHistory(bookId int, page *model.Page) *ApiResult
 }
 
 type ApiResult struct {
 	OK      bool
+	Code    utils.ErrorCode
 	Message string
 	Payload any
 }
@@ -31,7 +98,55 @@ type ApiResult struct {
 func Success(payload any) *ApiResult {
 	return &ApiResult{
 		OK:      true,
+		Code:    utils.SUCCESS,
 		Message: "success",
 		Payload: payload,
 	}
 }
+
+func Failure(err error) *ApiResult {
+	return &ApiResult{
+		OK:      false,
+		Code:    ErrorCode(err),
+		Message: err.Error(),
+	}
+}
+
+// ErrorCode returns the code of the kind of model error err wraps
+func ErrorCode(err error) utils.ErrorCode {
+	switch {
+	case err == nil:
+		return utils.SUCCESS
+	case errors.Is(err, model.ErrNotFound):
+		return utils.E_NOT_FOUND
+	case errors.Is(err, model.ErrDuplicate):
+		return utils.E_DUPLICATE
+	case errors.Is(err, model.ErrInsufficientStock):
+		return utils.E_INSUFFICIENT_STOCK
+	case errors.Is(err, model.ErrLimitReached):
+		return utils.E_LOAN_LIMIT
+	case errors.Is(err, model.ErrConflict):
+		return utils.E_CONFLICT
+	case errors.Is(err, model.ErrForbidden):
+		return utils.E_FORBIDDEN
+	case errors.Is(err, model.ErrUnauthorized):
+		return utils.E_UNAUTHORIZED
+	case errors.Is(err, model.ErrTooManyAttempts):
+		return utils.E_TOO_MANY_ATTEMPTS
+	case errors.Is(err, model.ErrValidation):
+		return utils.E_VALIDATION
+	}
+	return utils.E_INTERNAL_SERVER_ERROR
+}
+
+// Err returns the failure of the result as the error a web handler returns
+func (r *ApiResult) Err() error {
+	if r.OK {
+		return nil
+	}
+	return utils.Error{
+		Code: r.Code,
+		Msg:  r.Message,
+		Data: nil,
+	}
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"LibManSys/model"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+func (l *LibraryManagementSystemImpl) SetCardPIN(cardId int, pin string) *ApiResult {
+	hash, err := model.HashPIN(pin)
+	if err == nil {
+		err = l.Connector.SetCardPIN(cardId, hash)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	l.pins.reset(cardId)
+	return Success(nil)
+}
+
+// ChangeCardPIN counts a wrong old PIN against the card as PatronLogin does, so the patron route cannot guess the PIN either
+func (l *LibraryManagementSystemImpl) ChangeCardPIN(cardId int, oldPin string, pin string) *ApiResult {
+	if l.pins.isLocked(cardId) {
+		logrus.WithField("card_id", cardId).Warn(model.ErrCardLocked)
+		return Failure(model.ErrCardLocked)
+	}
+	credential, err := l.Connector.QueryCardCredential(cardId)
+	if err != nil && !errors.Is(err, model.ErrCredentialNotFound) {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	if !credential.CheckPIN(oldPin) {
+		l.pins.fail(cardId)
+		logrus.WithField("card_id", cardId).Warn(model.ErrInvalidPIN)
+		return Failure(model.ErrInvalidPIN)
+	}
+	return l.SetCardPIN(cardId, pin)
+}
+
+func (l *LibraryManagementSystemImpl) PatronLogin(cardId int, pin string) *ApiResult {
+	if l.pins.isLocked(cardId) {
+		logrus.WithField("card_id", cardId).Warn(model.ErrCardLocked)
+		return Failure(model.ErrCardLocked)
+	}
+	credential, err := l.Connector.QueryCardCredential(cardId)
+	if err != nil && !errors.Is(err, model.ErrCredentialNotFound) {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	if !credential.CheckPIN(pin) {
+		l.pins.fail(cardId)
+		logrus.WithField("card_id", cardId).Warn(model.ErrInvalidPIN)
+		return Failure(model.ErrInvalidPIN)
+	}
+	l.pins.reset(cardId)
+
+	token, session, err := model.NewPatronSession(cardId)
+	if err == nil {
+		err = l.Connector.CreatePatronSession(session)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(&model.PatronLoginResult{Token: token, ExpireTime: session.ExpireTime, CardID: cardId})
+}
+
+func (l *LibraryManagementSystemImpl) PatronLogout(token string) *ApiResult {
+	err := l.Connector.RemovePatronSession(model.HashToken(token))
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(nil)
+}
+
+func (l *LibraryManagementSystemImpl) AuthenticatePatron(token string) *ApiResult {
+	if token == "" {
+		return Failure(model.ErrMissingToken)
+	}
+	cardId, err := l.Connector.QueryPatronSession(model.HashToken(token))
+	if err != nil {
+		if !errors.Is(err, model.ErrUnauthorized) {
+			logrus.Error(err)
+		}
+		return Failure(err)
+	}
+	return Success(cardId)
+}
+
+func (l *LibraryManagementSystemImpl) ShowLoans(cardId int) *ApiResult {
+	loans := &model.BorrowHistories{Items: make([]model.Item, 0)}
+	page := model.NewPage(0, model.MaxPageSize, "")
+	for {
+		history, err := l.Connector.ShowBorrowHistory(cardId, page)
+		if err != nil {
+			logrus.Error(err)
+			return Failure(err)
+		}
+		for _, item := range history.Items {
+			if item.ReturnTime == 0 {
+				loans.Items = append(loans.Items, item)
+			}
+		}
+		if history.NextCursor == "" {
+			break
+		}
+		page.Cursor = history.NextCursor
+	}
+	loans.Count = len(loans.Items)
+	return Success(loans)
+}
+
+func (l *LibraryManagementSystemImpl) CancelCardHold(cardId int, holdId int) *ApiResult {
+	holds, err := l.Connector.ShowCardHolds(cardId)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	for _, hold := range holds.Holds {
+		if hold.HoldID == holdId {
+			return l.CancelHold(holdId)
+		}
+	}
+	// the holds of other cards are not found, rather than refused, so patrons cannot probe them
+	return Failure(model.ErrHoldNotFound)
+}
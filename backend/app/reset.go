@@ -0,0 +1,133 @@
+package app
+
+import (
+	"LibManSys/model"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// resetGuard keeps the resets asked for until they are confirmed, by the hash of their token
+type resetGuard struct {
+	mu      sync.Mutex
+	pending map[string]pendingReset
+}
+
+type pendingReset struct {
+	userId     int
+	options    model.ResetOptions
+	expireTime int64
+}
+
+func (g *resetGuard) add(tokenHash string, reset pendingReset) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.pending == nil {
+		g.pending = make(map[string]pendingReset)
+	}
+	now := time.Now().Unix()
+	for hash, pending := range g.pending {
+		if pending.expireTime <= now {
+			delete(g.pending, hash)
+		}
+	}
+	g.pending[tokenHash] = reset
+}
+
+// take removes the reset the user asked for with the token and returns it, a token confirms a single reset
+func (g *resetGuard) take(tokenHash string, userId int) (*pendingReset, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	reset, ok := g.pending[tokenHash]
+	if !ok || reset.userId != userId {
+		return nil, false
+	}
+	delete(g.pending, tokenHash)
+	if reset.expireTime <= time.Now().Unix() {
+		return nil, false
+	}
+	return &reset, true
+}
+
+func (l *LibraryManagementSystemImpl) PrepareReset(userId int, options *model.ResetOptions) *ApiResult {
+	policy := model.CurrentResetPolicy()
+	if !policy.Allowed() {
+		logrus.WithField("environment", policy.Environment).Warn(model.ErrResetDisabled)
+		return Failure(model.ErrResetDisabled)
+	}
+	if options == nil {
+		options = &model.ResetOptions{}
+	}
+
+	var fixture *model.Fixture
+	tables, err := model.ResolveResetTables(options.Tables)
+	if err == nil && options.Fixture != "" {
+		fixture, err = model.LoadFixture(policy.Fixtures, options.Fixture)
+	}
+	if err == nil && fixture != nil {
+		err = fixture.Validate(tables)
+	}
+	var confirmation *model.ResetConfirmation
+	if err == nil {
+		confirmation, err = model.NewResetConfirmation(tables, options.Fixture)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	l.resets.add(model.HashToken(confirmation.Token), pendingReset{
+		userId:     userId,
+		options:    *options,
+		expireTime: confirmation.ExpireTime,
+	})
+	return Success(confirmation)
+}
+
+func (l *LibraryManagementSystemImpl) ConfirmReset(userId int, token string) *ApiResult {
+	policy := model.CurrentResetPolicy()
+	if !policy.Allowed() {
+		logrus.WithField("environment", policy.Environment).Warn(model.ErrResetDisabled)
+		return Failure(model.ErrResetDisabled)
+	}
+	reset, ok := l.resets.take(model.HashToken(token), userId)
+	if !ok {
+		logrus.WithField("user_id", userId).Warn(model.ErrInvalidConfirmation)
+		return Failure(model.ErrInvalidConfirmation)
+	}
+
+	// the fixture is read again, its file may have changed since the reset was asked for
+	var fixture *model.Fixture
+	tables, err := model.ResolveResetTables(reset.options.Tables)
+	if err == nil && reset.options.Fixture != "" {
+		fixture, err = model.LoadFixture(policy.Fixtures, reset.options.Fixture)
+	}
+	if err == nil && fixture != nil {
+		err = fixture.Validate(tables)
+	}
+	if err == nil {
+		err = l.Connector.ResetDatabase(reset.options.Tables...)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+
+	result := &model.ResetResult{Tables: tables, Fixture: reset.options.Fixture}
+	if fixture != nil {
+		err = fixture.Apply(l.Connector)
+		result.Books, result.Cards, result.Loans = len(fixture.Books), len(fixture.Cards), len(fixture.Loans)
+	}
+	l.pins.clear()
+	// the index follows the books left, even if the database failed while loading the fixture
+	if indexErr := l.rebuildIndex(); indexErr != nil {
+		logrus.Error(indexErr)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	return Success(result)
+}
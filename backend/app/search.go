@@ -0,0 +1,92 @@
+package app
+
+import (
+	"LibManSys/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// allBooks returns all the books matching the conditions, reading them a page at a time
+func (l *LibraryManagementSystemImpl) allBooks(conditions *model.BookQueryConditions) ([]model.Book, error) {
+	var books []model.Book
+	conditions.WithPage(model.NewPage(0, model.MaxPageSize, ""))
+	for {
+		result, err := l.Connector.QueryBook(conditions)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, result.Results...)
+		if result.NextCursor == "" {
+			return books, nil
+		}
+		conditions.Cursor = result.NextCursor
+	}
+}
+
+// rebuildIndex indexes all the books of the database again
+func (l *LibraryManagementSystemImpl) rebuildIndex() error {
+	books, err := l.allBooks(model.NewBookQueryConditions())
+	if err != nil {
+		return err
+	}
+	l.Index.Reset(books)
+	return nil
+}
+
+// refreshIndex indexes the books again after they were written, the ones no longer in the database are removed
+func (l *LibraryManagementSystemImpl) refreshIndex(bookIds ...int) {
+	if len(bookIds) == 0 {
+		return
+	}
+	books, err := l.allBooks(model.NewBookQueryConditions().WithBookIDs(bookIds...))
+	if err != nil {
+		// the write succeeded, the books are indexed again when the system restarts
+		logrus.Error(err)
+		return
+	}
+	l.Index.Remove(bookIds...)
+	l.Index.Index(books...)
+}
+
+func (l *LibraryManagementSystemImpl) SearchBooks(query string, limit int) *ApiResult {
+	if limit == 0 {
+		limit = model.DefaultSearchLimit
+	}
+	if limit < 0 || limit > model.MaxSearchLimit {
+		logrus.Error(model.ErrInvalidSearchLimit)
+		return Failure(model.ErrInvalidSearchLimit)
+	}
+	parsed, err := model.ParseSearchQuery(query)
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+
+	matches, count := l.Index.Search(parsed, limit)
+	result := model.SearchResult{Count: count, Hits: make([]model.SearchHit, 0, len(matches))}
+	if len(matches) == 0 {
+		return Success(&result)
+	}
+
+	bookIds := make([]int, len(matches))
+	for i, match := range matches {
+		bookIds[i] = match.BookID
+	}
+	books, err := l.Connector.QueryBook(model.NewBookQueryConditions().WithBookIDs(bookIds...).WithPage(model.NewPage(0, len(bookIds), "")))
+	if err != nil {
+		logrus.Error(err)
+		return Failure(err)
+	}
+	byID := make(map[int]model.Book, len(books.Results))
+	for _, book := range books.Results {
+		byID[book.BookID] = book
+	}
+	for _, match := range matches {
+		book, ok := byID[match.BookID]
+		if !ok {
+			continue
+		}
+		result.Hits = append(result.Hits, model.SearchHit{Book: book, Score: match.Score, Highlights: match.Highlights})
+	}
+	return Success(&result)
+}
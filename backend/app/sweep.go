@@ -0,0 +1,14 @@
+package app
+
+import (
+	"LibManSys/model"
+	"time"
+)
+
+// SweepHolds expires the unclaimed holds of lms forever, waiting the sweep interval of the hold policy between runs
+func SweepHolds(lms LibraryManagementSystem) {
+	for {
+		time.Sleep(model.CurrentHoldPolicy().SweepInterval)
+		lms.ExpireHolds()
+	}
+}
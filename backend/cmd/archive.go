@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"LibManSys/model"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	output := flags.String("o", "", "file to write the archive to, - for the standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = "library-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	}
+
+	lms, err := userSystem()
+	if err != nil {
+		return err
+	}
+	defer lms.Close()
+
+	// the summary goes to the standard error when the archive takes the standard output
+	var w io.Writer = os.Stdout
+	summary := os.Stdout
+	if *output == "-" {
+		summary = os.Stderr
+	} else {
+		file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	result := lms.Backup(w)
+	if !result.OK {
+		if *output != "-" {
+			os.Remove(*output)
+		}
+		return errors.New(result.Message)
+	}
+	manifest := result.Payload.(*model.ArchiveManifest)
+	for _, table := range manifest.Tables {
+		fmt.Fprintf(summary, "%-20s %8d rows\n", table.Name, table.Rows)
+	}
+	fmt.Fprintf(summary, "-- schema version %d written to %s\n", manifest.SchemaVersion, *output)
+	return nil
+}
+
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	input := flags.String("i", "", "file to read the archive from, - for the standard input")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		return errors.New("missing -i")
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	lms, err := userSystem()
+	if err != nil {
+		return err
+	}
+	defer lms.Close()
+
+	result := lms.Restore(r)
+	if !result.OK {
+		return errors.New(result.Message)
+	}
+	restored := result.Payload.(*model.RestoreResult)
+	tables := make([]string, 0, len(restored.Rows))
+	for table := range restored.Rows {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fmt.Printf("%-20s %8d rows\n", table, restored.Rows[table])
+	}
+	for _, table := range restored.Skipped {
+		fmt.Printf("-- %s kept as it was, it has rows already\n", table)
+	}
+	fmt.Printf("-- restored from schema version %d\n", restored.SchemaVersion)
+	return nil
+}
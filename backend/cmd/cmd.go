@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = make(map[string]command)
+
+func init() {
+	commands["migrate"] = command{
+		usage: "migrate <up|down|status|diff> [-dry-run] [-to version] [-steps n] [-apply]",
+		run:   runMigrate,
+	}
+	commands["user"] = command{
+		usage: "user <bootstrap|create|role|password|remove|list> [-username name] [-password password] [-role role]",
+		run:   runUser,
+	}
+	commands["backup"] = command{
+		usage: "backup [-o file]",
+		run:   runBackup,
+	}
+	commands["restore"] = command{
+		usage: "restore -i file",
+		run:   runRestore,
+	}
+}
+
+func Run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printUsage()
+		return errors.New("unknown command: " + args[0])
+	}
+	return cmd.run(args[1:])
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	for _, cmd := range commands {
+		fmt.Fprintln(os.Stderr, "  "+cmd.usage)
+	}
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"LibManSys/conf"
+	"LibManSys/model"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+func databaseConnector() (*model.DatabaseConnector, error) {
+	connector, ok := model.NewConnector(conf.GetConnectConfig()).(*model.DatabaseConnector)
+	if !ok {
+		return nil, errors.New("storage driver " + conf.GetStorageDriver() + " has no database to migrate")
+	}
+	err := connector.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return connector, nil
+}
+
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return errors.New("missing migrate subcommand")
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "print the statements instead of executing them")
+	target := flags.Int("to", 0, "version to migrate up to, 0 for the latest")
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	apply := flags.Bool("apply", false, "execute the statements diff prints")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	connector, err := databaseConnector()
+	if err != nil {
+		return err
+	}
+	defer connector.Close()
+
+	var statements []string
+	switch args[0] {
+	case "up":
+		statements, err = connector.MigrateUp(*target, *dryRun)
+	case "down":
+		statements, err = connector.MigrateDown(*steps, *dryRun)
+	case "diff":
+		var warnings []string
+		statements, warnings, err = connector.SchemaDiff()
+		for _, warning := range warnings {
+			fmt.Println("-- warning: " + warning)
+		}
+		if err == nil && *apply && !*dryRun {
+			statements, err = connector.SyncSchema(false)
+		}
+	case "status":
+		return printMigrationStatus(connector)
+	default:
+		printUsage()
+		return errors.New("unknown migrate subcommand: " + args[0])
+	}
+
+	for _, statement := range statements {
+		fmt.Println(statement)
+	}
+	if len(statements) == 0 {
+		fmt.Println("-- nothing to do")
+	}
+	return err
+}
+
+func printMigrationStatus(connector *model.DatabaseConnector) error {
+	statuses, err := connector.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + time.Unix(status.AppliedAt, 0).Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-40s %s\n", status.Version, status.Name, state)
+	}
+	return nil
+}
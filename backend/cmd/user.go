@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"LibManSys/app"
+	"LibManSys/conf"
+	"LibManSys/model"
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// userSystem opens the storage for the user and archive commands, without building the search index the server needs
+func userSystem() (*app.LibraryManagementSystemImpl, error) {
+	lms := app.NewLibraryManagementSystemImpl(conf.GetConnectConfig())
+	err := lms.Connect()
+	if err != nil {
+		return nil, err
+	}
+	err = lms.Connector.Migrate()
+	if err != nil {
+		lms.Close()
+		return nil, err
+	}
+	return lms, nil
+}
+
+// readPassword returns the password flag, or the first line of the standard input if it was not given
+func readPassword(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password given")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runUser(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return errors.New("missing user subcommand")
+	}
+
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	username := flags.String("username", "", "name the user signs in with")
+	password := flags.String("password", "", "password of the user, read from the standard input if not given")
+	role := flags.String("role", "", "readonly, circulation, librarian or admin")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if args[0] != "list" && *username == "" {
+		return errors.New("missing -username")
+	}
+
+	lms, err := userSystem()
+	if err != nil {
+		return err
+	}
+	defer lms.Close()
+
+	var result *app.ApiResult
+	switch args[0] {
+	case "list":
+		return printUsers(lms)
+	case "bootstrap", "create":
+		if args[0] == "create" && *role == "" {
+			return errors.New("missing -role")
+		}
+		secret, err := readPassword(*password)
+		if err != nil {
+			return err
+		}
+		if args[0] == "bootstrap" {
+			result = lms.BootstrapAdmin(*username, secret)
+		} else {
+			result = lms.CreateUser(*username, secret, model.UserRole(*role))
+		}
+	case "role", "password", "remove":
+		user, err := lms.Connector.QueryUserByName(model.NormalizeUsername(*username))
+		if err != nil {
+			return err
+		}
+		switch args[0] {
+		case "role":
+			result = lms.SetUserRole(user.UserID, model.UserRole(*role))
+		case "password":
+			secret, err := readPassword(*password)
+			if err != nil {
+				return err
+			}
+			result = lms.SetUserPassword(user.UserID, secret)
+		default:
+			result = lms.RemoveUser(user.UserID)
+		}
+	default:
+		printUsage()
+		return errors.New("unknown user subcommand: " + args[0])
+	}
+
+	if !result.OK {
+		return errors.New(result.Message)
+	}
+	fmt.Println("-- done")
+	return nil
+}
+
+func printUsers(lms *app.LibraryManagementSystemImpl) error {
+	users, err := lms.Connector.ShowUsers()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users.Users {
+		fmt.Printf("%4d  %-32s %-12s created %s\n", user.UserID, user.Username, user.Role,
+			time.Unix(user.CreatedAt, 0).Format(time.RFC3339))
+	}
+	if users.Count == 0 {
+		fmt.Println("-- no users, create the first admin with user bootstrap")
+	}
+	return nil
+}
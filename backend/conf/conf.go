@@ -2,7 +2,9 @@ package conf
 
 import (
 	"LibManSys/model"
+	"fmt"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -18,15 +20,82 @@ func Init() {
 	logrus.Info("Configuration file loaded")
 
 	var confItems = map[string][]string{
-		"mysql":  {"user", "password", "host", "port", "db_name"},
 		"server": {"port"},
 	}
 
+	switch GetStorageDriver() {
+	case model.DriverMySQL:
+		confItems["mysql"] = []string{"user", "password", "host", "port", "db_name"}
+	case model.DriverPostgres:
+		confItems["postgres"] = []string{"user", "password", "host", "port", "db_name"}
+	case model.DriverSQLite:
+		confItems["sqlite"] = []string{"path"}
+	case model.DriverMemory:
+		// memory storage runs without any database, snapshot file is optional
+	default:
+		logrus.WithField("storage.driver", GetStorageDriver()).Fatal("Unsupported storage driver")
+	}
+
 	for k, v := range confItems {
 		checkConfIsSet(k, v)
 	}
 
 	logrus.Info("All required values in configuration file are set")
+
+	err := loadPolicies()
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid policy")
+	}
+}
+
+// Watch reloads the parts of the configuration that can change while the server runs when the file is saved
+func Watch() {
+	viper.OnConfigChange(func(event fsnotify.Event) {
+		err := loadPolicies()
+		if err != nil {
+			logrus.WithError(err).Error("Invalid policy, keeping the previous ones")
+			return
+		}
+		logrus.WithField("file", event.Name).Info("Policies reloaded")
+	})
+	viper.WatchConfig()
+}
+
+// loadPolicies reads the loan, fine, hold, auth, reset and archive sections and replaces the policies in use only if
+// all are valid
+func loadPolicies() error {
+	loanPolicy, err := GetLoanPolicy()
+	if err != nil {
+		return fmt.Errorf("loan: %w", err)
+	}
+	finePolicy, err := GetFinePolicy()
+	if err != nil {
+		return fmt.Errorf("fine: %w", err)
+	}
+	holdPolicy, err := GetHoldPolicy()
+	if err != nil {
+		return fmt.Errorf("hold: %w", err)
+	}
+	authPolicy, err := GetAuthPolicy()
+	if err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	resetPolicy, err := GetResetPolicy()
+	if err != nil {
+		return fmt.Errorf("reset: %w", err)
+	}
+	archivePolicy, err := GetArchivePolicy()
+	if err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+
+	model.SetLoanPolicy(loanPolicy)
+	model.SetFinePolicy(finePolicy)
+	model.SetHoldPolicy(holdPolicy)
+	model.SetAuthPolicy(authPolicy)
+	model.SetResetPolicy(resetPolicy)
+	model.SetArchivePolicy(archivePolicy)
+	return nil
 }
 
 func checkConfIsSet(name string, keys []string) {
@@ -39,8 +108,55 @@ func checkConfIsSet(name string, keys []string) {
 	}
 }
 
+func GetStorageDriver() string {
+	if !viper.IsSet("storage.driver") {
+		return model.DriverMySQL
+	}
+	return viper.GetString("storage.driver")
+}
+
+func GetConnectConfig() *model.ConnectConfig {
+	switch GetStorageDriver() {
+	case model.DriverPostgres:
+		return GetPostgresConfig()
+	case model.DriverSQLite:
+		return GetSQLiteConfig()
+	case model.DriverMemory:
+		return GetMemoryConfig()
+	default:
+		return GetMysqlLoginConfig()
+	}
+}
+
+func GetPostgresConfig() *model.ConnectConfig {
+	return &model.ConnectConfig{
+		Driver:   model.DriverPostgres,
+		User:     viper.GetString("postgres.user"),
+		Password: viper.GetString("postgres.password"),
+		Host:     viper.GetString("postgres.host"),
+		Port:     viper.GetInt("postgres.port"),
+		DBName:   viper.GetString("postgres.db_name"),
+		SSLMode:  viper.GetString("postgres.sslmode"),
+	}
+}
+
+func GetSQLiteConfig() *model.ConnectConfig {
+	return &model.ConnectConfig{
+		Driver: model.DriverSQLite,
+		Path:   viper.GetString("sqlite.path"),
+	}
+}
+
+func GetMemoryConfig() *model.ConnectConfig {
+	return &model.ConnectConfig{
+		Driver: model.DriverMemory,
+		Path:   viper.GetString("memory.snapshot"),
+	}
+}
+
 func GetMysqlLoginConfig() *model.ConnectConfig {
 	return &model.ConnectConfig{
+		Driver:   model.DriverMySQL,
 		User:     viper.GetString("mysql.user"),
 		Password: viper.GetString("mysql.password"),
 		Host:     viper.GetString("mysql.host"),
@@ -48,3 +164,85 @@ func GetMysqlLoginConfig() *model.ConnectConfig {
 		DBName:   viper.GetString("mysql.db_name"),
 	}
 }
+
+// GetLoanPolicy reads the loan section, loans last 30 days if it is missing
+func GetLoanPolicy() (*model.LoanPolicy, error) {
+	policy := model.DefaultLoanPolicy()
+	if !viper.IsSet("loan") {
+		return policy, nil
+	}
+	err := viper.UnmarshalKey("loan", policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, policy.Validate()
+}
+
+// GetFinePolicy reads the fine section, nothing is charged if it is missing
+func GetFinePolicy() (*model.FinePolicy, error) {
+	policy := model.DefaultFinePolicy()
+	if !viper.IsSet("fine") {
+		return policy, nil
+	}
+	err := viper.UnmarshalKey("fine", policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, policy.Validate()
+}
+
+// GetHoldPolicy reads the hold section, copies wait 3 days for their patron if it is missing
+func GetHoldPolicy() (*model.HoldPolicy, error) {
+	policy := model.DefaultHoldPolicy()
+	if !viper.IsSet("hold") {
+		return policy, nil
+	}
+	err := viper.UnmarshalKey("hold", policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, policy.Validate()
+}
+
+// GetAuthPolicy reads the auth section, logins last 12 hours if it is missing
+func GetAuthPolicy() (*model.AuthPolicy, error) {
+	policy := model.DefaultAuthPolicy()
+	if !viper.IsSet("auth") {
+		return policy, nil
+	}
+	err := viper.UnmarshalKey("auth", policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, policy.Validate()
+}
+
+// GetResetPolicy reads the environment and the reset section, the database cannot be reset through the API unless
+// the environment is dev or test
+func GetResetPolicy() (*model.ResetPolicy, error) {
+	policy := model.DefaultResetPolicy()
+	if viper.IsSet("reset") {
+		err := viper.UnmarshalKey("reset", policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if viper.IsSet("environment") {
+		policy.Environment = viper.GetString("environment")
+	}
+	return policy, policy.Validate()
+}
+
+// GetArchivePolicy reads the archive section, archives of up to 256 MiB with tables of up to 1 GiB are restored if it
+// is missing
+func GetArchivePolicy() (*model.ArchivePolicy, error) {
+	policy := model.DefaultArchivePolicy()
+	if !viper.IsSet("archive") {
+		return policy, nil
+	}
+	err := viper.UnmarshalKey("archive", policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, policy.Validate()
+}
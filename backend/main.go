@@ -2,17 +2,36 @@ package main
 
 import (
 	"LibManSys/app"
+	"LibManSys/cmd"
 	"LibManSys/conf"
+	"LibManSys/model"
 	"LibManSys/web"
+	"os"
+
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
 	conf.Init()
 
-	app.LMS = app.NewLibraryManagementSystemImpl(conf.GetMysqlLoginConfig())
-	app.LMS.Init()
+	if len(os.Args) > 1 {
+		if err := cmd.Run(os.Args[1:]); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	app.LMS = app.NewLibraryManagementSystemImpl(conf.GetConnectConfig())
+	if err := app.LMS.Init(); err != nil {
+		logrus.Fatal(err)
+	}
 	defer app.LMS.Free()
+	if result := app.LMS.ShowUsers(); result.OK && result.Payload.(*model.UserList).Count == 0 {
+		logrus.Warn("No users yet, create the first admin with the user bootstrap command or POST /auth/bootstrap")
+	}
 
+	conf.Watch()
+	go app.SweepHolds(app.LMS)
 	web.InitWebFramework()
 	web.StartServer()
 }
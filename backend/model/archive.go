@@ -0,0 +1,635 @@
+package model
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveFormat  = "libmansys-archive"
+	ArchiveVersion = 1
+
+	archiveManifest    = "manifest.json"
+	maxManifestSize    = 1 << 20
+	archiveTablePrefix = "tables/"
+)
+
+// notArchived are the tables left out of archives, a restore signs nobody in and the bootstrap row only guards the
+// database it was inserted in
+var notArchived = map[string]bool{
+	TableName(UserSession{}):   true,
+	TableName(PatronSession{}): true,
+	TableName(UserBootstrap{}): true,
+}
+
+// ArchiveManifest is the first file of an archive, it describes the files of the tables that follow it
+type ArchiveManifest struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// SchemaVersion is the version of the last migration applied to the database the archive was made of
+	SchemaVersion int            `json:"schema_version"`
+	CreatedAt     int64          `json:"created_at"`
+	Tables        []ArchiveTable `json:"tables"`
+}
+
+// ArchiveTable is the file of the rows of a table in an archive, one JSON object by line keyed by column
+type ArchiveTable struct {
+	Name   string `json:"name"`
+	File   string `json:"file"`
+	Rows   int    `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+type RestoreResult struct {
+	SchemaVersion int `json:"schema_version"`
+	// Rows are the numbers of rows restored by table
+	Rows map[string]int `json:"rows"`
+	// Skipped are the tables kept on reset the database had rows in, they keep them and the rows of the archive are
+	// left out
+	Skipped []string `json:"skipped"`
+}
+
+// LatestSchemaVersion returns the version of the last migration
+func LatestSchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+func archiveFile(table string) string {
+	return archiveTablePrefix + table + ".jsonl"
+}
+
+func invalidArchive(format string, args ...any) error {
+	return WrapError(ErrValidation, fmt.Errorf("%w: "+format, append([]any{ErrInvalidArchive}, args...)...))
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+// archivedTable maps the columns of a table in archives to the fields of its struct
+type archivedTable struct {
+	schema  *TableSchema
+	rowType reflect.Type
+	// fields are the indexes of the fields of the columns of schema
+	fields []int
+}
+
+// archivedTables returns the tables archived in the order of Tables
+func archivedTables() ([]*archivedTable, error) {
+	tables := make([]*archivedTable, 0, len(Tables))
+	for _, table := range Tables {
+		if notArchived[TableName(table)] {
+			continue
+		}
+		schema, err := ParseTable(table)
+		if err != nil {
+			return nil, err
+		}
+		archived := archivedTable{schema: schema, rowType: reflect.TypeOf(table)}
+		// ParseTable makes a column of every field but the ones it skips, in order
+		for i := 0; i < archived.rowType.NumField(); i++ {
+			field := archived.rowType.Field(i)
+			if field.Tag.Get("sql") == "-" || !field.IsExported() {
+				continue
+			}
+			archived.fields = append(archived.fields, i)
+		}
+		tables = append(tables, &archived)
+	}
+	return tables, nil
+}
+
+// columns returns the indexes of the columns of the table among names, all of them if names is nil
+func (t *archivedTable) columns(names map[string]bool) []int {
+	columns := make([]int, 0, len(t.schema.Columns))
+	for i, column := range t.schema.Columns {
+		if names == nil || names[column.Name] {
+			columns = append(columns, i)
+		}
+	}
+	return columns
+}
+
+func (t *archivedTable) columnNames(columns []int) []string {
+	names := make([]string, 0, len(columns))
+	for _, i := range columns {
+		names = append(names, t.schema.Columns[i].Name)
+	}
+	return names
+}
+
+// encode returns the values of the columns of a row, row is a struct of the table
+func (t *archivedTable) encode(row reflect.Value, columns []int) map[string]any {
+	values := make(map[string]any, len(columns))
+	for _, i := range columns {
+		values[t.schema.Columns[i].Name] = row.Field(t.fields[i]).Interface()
+	}
+	return values
+}
+
+// decode returns the row of the values of its columns. The columns missing from an archive of an older schema take
+// their default values.
+func (t *archivedTable) decode(values map[string]json.RawMessage) (reflect.Value, error) {
+	row := reflect.New(t.rowType).Elem()
+	for name := range values {
+		if t.schema.Column(name) == nil {
+			return row, fmt.Errorf("unknown column %s.%s", t.schema.Name, name)
+		}
+	}
+	for i := range t.schema.Columns {
+		column := &t.schema.Columns[i]
+		field := row.Field(t.fields[i])
+		value, ok := values[column.Name]
+		if !ok && column.Default != nil {
+			if field.Kind() == reflect.String {
+				field.SetString(strings.Trim(*column.Default, "'"))
+				continue
+			}
+			value = json.RawMessage(*column.Default)
+		} else if !ok && column.NotNull {
+			return row, fmt.Errorf("missing column %s.%s", t.schema.Name, column.Name)
+		} else if !ok {
+			continue
+		}
+		err := json.Unmarshal(value, field.Addr().Interface())
+		if err != nil {
+			return row, fmt.Errorf("column %s.%s: %v", t.schema.Name, column.Name, err)
+		}
+	}
+	return row, nil
+}
+
+// scanTargets returns the destinations to scan the columns of a row into, and the function to call after the scan
+func (t *archivedTable) scanTargets(row reflect.Value, columns []int) ([]any, func()) {
+	dest := make([]any, 0, len(columns))
+	var texts []func()
+	for _, i := range columns {
+		field := row.Field(t.fields[i])
+		if field.Type() != rawMessageType {
+			dest = append(dest, field.Addr().Interface())
+			continue
+		}
+		// JSON columns are read as text
+		text := new(string)
+		dest = append(dest, text)
+		texts = append(texts, func() { field.Set(reflect.ValueOf(json.RawMessage(*text))) })
+	}
+	return dest, func() {
+		for _, f := range texts {
+			f()
+		}
+	}
+}
+
+// args returns the values of the columns of a row to bind to a statement
+func (t *archivedTable) args(row reflect.Value, columns []int) []any {
+	args := make([]any, 0, len(columns))
+	for _, i := range columns {
+		value := row.Field(t.fields[i]).Interface()
+		if text, ok := value.(json.RawMessage); ok {
+			value = string(text)
+		}
+		args = append(args, value)
+	}
+	return args
+}
+
+func (t *archivedTable) autoIncrement() string {
+	for _, column := range t.schema.Columns {
+		if column.AutoIncrement {
+			return column.Name
+		}
+	}
+	return ""
+}
+
+func (m *ArchiveManifest) check() error {
+	if m.Format != ArchiveFormat {
+		return invalidArchive("unknown format %q", m.Format)
+	}
+	if m.Version != ArchiveVersion {
+		return invalidArchive("unsupported archive version %d", m.Version)
+	}
+	if m.SchemaVersion < 1 {
+		return invalidArchive("invalid schema version %d", m.SchemaVersion)
+	}
+	if m.SchemaVersion > LatestSchemaVersion() {
+		return WrapError(ErrValidation, fmt.Errorf("%w: %d after %d", ErrArchiveTooNew, m.SchemaVersion, LatestSchemaVersion()))
+	}
+
+	tables, err := archivedTables()
+	if err != nil {
+		return err
+	}
+	order := make(map[string]int)
+	for i, table := range tables {
+		order[table.schema.Name] = i + 1
+	}
+	// the rows are loaded as they are read, the tables come in the order of Tables for the ones referenced to be
+	// loaded first
+	last := 0
+	for _, table := range m.Tables {
+		if order[table.Name] <= last {
+			return invalidArchive("unknown, duplicate or misplaced table %q", table.Name)
+		}
+		if table.File != archiveFile(table.Name) || table.Rows < 0 {
+			return invalidArchive("invalid file of table %s", table.Name)
+		}
+		last = order[table.Name]
+	}
+	return nil
+}
+
+// archiveSpool keeps the rows of a table in a temporary file until the archive is written, the manifest before
+// them needs their checksum
+type archiveSpool struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	hash    hash.Hash
+	encoder *json.Encoder
+	rows    int
+}
+
+func newArchiveSpool(dir string, table string) (*archiveSpool, error) {
+	file, err := os.Create(filepath.Join(dir, table+".jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	spool := archiveSpool{file: file, buffer: bufio.NewWriter(file), hash: sha256.New()}
+	spool.encoder = json.NewEncoder(io.MultiWriter(spool.buffer, spool.hash))
+	spool.encoder.SetEscapeHTML(false)
+	return &spool, nil
+}
+
+// WriteArchive exports the library through connector into a gzipped tar archive written to w: the manifest, then
+// a file of rows for every archived table
+func WriteArchive(w io.Writer, connector Connector) (*ArchiveManifest, error) {
+	tables, err := archivedTables()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "libmansys-archive-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	spools := make(map[string]*archiveSpool)
+	defer func() {
+		for _, spool := range spools {
+			spool.file.Close()
+		}
+	}()
+	for _, table := range tables {
+		spools[table.schema.Name], err = newArchiveSpool(dir, table.schema.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	schemaVersion, err := connector.Export(func(table string, row map[string]any) error {
+		spool, ok := spools[table]
+		if !ok {
+			return fmt.Errorf("table %s is not archived", table)
+		}
+		spool.rows++
+		return spool.encoder.Encode(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := ArchiveManifest{
+		Format:        ArchiveFormat,
+		Version:       ArchiveVersion,
+		SchemaVersion: schemaVersion,
+		CreatedAt:     time.Now().Unix(),
+		Tables:        make([]ArchiveTable, 0, len(tables)),
+	}
+	for _, table := range tables {
+		spool := spools[table.schema.Name]
+		if err := spool.buffer.Flush(); err != nil {
+			return nil, err
+		}
+		manifest.Tables = append(manifest.Tables, ArchiveTable{
+			Name:   table.schema.Name,
+			File:   archiveFile(table.schema.Name),
+			Rows:   spool.rows,
+			SHA256: hex.EncodeToString(spool.hash.Sum(nil)),
+		})
+	}
+	data, err := json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	modTime := time.Unix(manifest.CreatedAt, 0)
+	err = tw.WriteHeader(&tar.Header{Name: archiveManifest, Mode: 0o644, Size: int64(len(data)), ModTime: modTime})
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+	for _, table := range manifest.Tables {
+		if err != nil {
+			break
+		}
+		file := spools[table.Name].file
+		var info os.FileInfo
+		info, err = file.Stat()
+		if err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			err = tw.WriteHeader(&tar.Header{Name: table.File, Mode: 0o644, Size: info.Size(), ModTime: modTime})
+		}
+		if err == nil {
+			_, err = io.Copy(tw, file)
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// ReadArchive restores the library of an archive read from r through connector into an empty database. Every table
+// is checked against the manifest, nothing is restored unless all of them match.
+func ReadArchive(r io.Reader, connector Connector) (*RestoreResult, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, invalidArchive("%v", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	header, err := tr.Next()
+	if err != nil {
+		return nil, invalidArchive("%v", err)
+	}
+	if header.Name != archiveManifest || header.Size > maxManifestSize {
+		return nil, invalidArchive("%s is not the first file", archiveManifest)
+	}
+	var manifest ArchiveManifest
+	err = json.NewDecoder(io.LimitReader(tr, maxManifestSize)).Decode(&manifest)
+	if err != nil {
+		return nil, invalidArchive("%s: %v", archiveManifest, err)
+	}
+	err = manifest.check()
+	if err != nil {
+		return nil, err
+	}
+
+	return connector.Import(manifest.SchemaVersion, func(load func(table string, row map[string]json.RawMessage) error) error {
+		// the files follow the manifest, one per table
+		for i := range manifest.Tables {
+			table := &manifest.Tables[i]
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return invalidArchive("missing file %s", table.File)
+			}
+			if err != nil {
+				return invalidArchive("%v", err)
+			}
+			if header.Name != table.File || header.Typeflag != tar.TypeReg {
+				return invalidArchive("unexpected file %s", header.Name)
+			}
+			// the tar reader reads no more than the size of the header
+			if header.Size > CurrentArchivePolicy().MaxTable {
+				return WrapError(ErrValidation, fmt.Errorf("%w: %s has %d bytes", ErrArchiveTableTooLarge, table.Name, header.Size))
+			}
+
+			sum := sha256.New()
+			lines := bufio.NewReader(io.TeeReader(tr, sum))
+			rows := 0
+			for {
+				line, err := lines.ReadBytes('\n')
+				if err != nil && !errors.Is(err, io.EOF) {
+					return invalidArchive("%v", err)
+				}
+				if len(bytes.TrimSpace(line)) != 0 {
+					var row map[string]json.RawMessage
+					if err := json.Unmarshal(line, &row); err != nil {
+						return invalidArchive("%s line %d: %v", header.Name, rows+1, err)
+					}
+					if err := load(table.Name, row); err != nil {
+						return err
+					}
+					rows++
+				}
+				if errors.Is(err, io.EOF) {
+					break
+				}
+			}
+			if rows != table.Rows || hex.EncodeToString(sum.Sum(nil)) != table.SHA256 {
+				return WrapError(ErrValidation, fmt.Errorf("%w: %s", ErrArchiveChecksum, table.Name))
+			}
+		}
+		header, err := tr.Next()
+		if err == nil {
+			return invalidArchive("unexpected file %s", header.Name)
+		}
+		if !errors.Is(err, io.EOF) {
+			return invalidArchive("%v", err)
+		}
+		return nil
+	})
+}
+
+func (c *DatabaseConnector) Export(write func(table string, row map[string]any) error) (int, error) {
+	tables, err := archivedTables()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// nothing is written, the transaction only gives the reads a single snapshot
+	defer tx.Rollback()
+	if snapshotSQL := c.Dialect().SnapshotSQL(); snapshotSQL != "" {
+		_, err = tx.Exec(snapshotSQL)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	applied, err := c.appliedMigrations(tx)
+	if err != nil {
+		return 0, err
+	}
+	schemaVersion := 0
+	for version := range applied {
+		if version > schemaVersion {
+			schemaVersion = version
+		}
+	}
+
+	for _, table := range tables {
+		actual, err := c.Dialect().DescribeTable(tx, table.schema.Name)
+		if err != nil {
+			return 0, err
+		}
+		if actual == nil {
+			// a table of a later migration, it has no rows yet
+			continue
+		}
+		names := make(map[string]bool)
+		for _, column := range actual.Columns {
+			names[column.Name] = true
+		}
+		columns := table.columns(names)
+
+		rows, err := tx.Query("SELECT " + strings.Join(table.columnNames(columns), ", ") + " FROM " + table.schema.Name +
+			" ORDER BY " + strings.Join(table.schema.PrimaryKey, ", "))
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			row := reflect.New(table.rowType).Elem()
+			dest, scanned := table.scanTargets(row, columns)
+			err = rows.Scan(dest...)
+			if err == nil {
+				scanned()
+				err = write(table.schema.Name, table.encode(row, columns))
+			}
+			if err != nil {
+				rows.Close()
+				return 0, err
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return schemaVersion, nil
+}
+
+func (c *DatabaseConnector) Import(schemaVersion int, read func(load func(table string, row map[string]json.RawMessage) error) error) (*RestoreResult, error) {
+	tables, err := archivedTables()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.importTables(tx, tables, schemaVersion, read)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+func (c *DatabaseConnector) importTables(tx *Tx, tables []*archivedTable, schemaVersion int,
+	read func(load func(table string, row map[string]json.RawMessage) error) error) (*RestoreResult, error) {
+	result := RestoreResult{SchemaVersion: schemaVersion, Rows: make(map[string]int), Skipped: make([]string, 0)}
+	byName := make(map[string]*archivedTable)
+	insertSQL := make(map[string]string)
+	skipped := make(map[string]bool)
+	for _, table := range tables {
+		name := table.schema.Name
+		var count int
+		err := queryRow(tx, "SELECT COUNT(*) FROM "+name).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count != 0 && !keptOnReset[name] {
+			return nil, WrapError(ErrConflict, fmt.Errorf("%w: %s has rows", ErrDatabaseNotEmpty, name))
+		}
+		if count != 0 {
+			skipped[name] = true
+			result.Skipped = append(result.Skipped, name)
+		}
+
+		columns := table.columnNames(table.columns(nil))
+		byName[name] = table
+		insertSQL[name] = "INSERT INTO " + name + " (" + strings.Join(columns, ", ") + ") VALUES (?" +
+			strings.Repeat(", ?", len(columns)-1) + ")"
+	}
+
+	err := read(func(name string, values map[string]json.RawMessage) error {
+		table, ok := byName[name]
+		if !ok {
+			return invalidArchive("unknown table %q", name)
+		}
+		if skipped[name] {
+			return nil
+		}
+		row, err := table.decode(values)
+		if err != nil {
+			return invalidArchive("%v", err)
+		}
+		_, err = tx.Exec(insertSQL[name], table.args(row, table.columns(nil))...)
+		if err != nil {
+			var failure *Error
+			if errors.As(c.Dialect().ConstraintError(err), &failure) {
+				return invalidArchive("row of %s: %v", name, err)
+			}
+			return err
+		}
+		result.Rows[name]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the migrations the archive predates fill what they added from the rows restored
+	for _, migration := range Migrations {
+		if migration.Version <= schemaVersion || migration.Backfill == nil {
+			continue
+		}
+		statements, err := migration.Backfill(c.Dialect(), tx)
+		if err != nil {
+			return nil, err
+		}
+		for _, statement := range statements {
+			_, err = tx.Exec(statement)
+			if err != nil {
+				return nil, fmt.Errorf("migration %d: %w", migration.Version, err)
+			}
+		}
+	}
+	for _, table := range tables {
+		column := table.autoIncrement()
+		if column == "" || skipped[table.schema.Name] {
+			continue
+		}
+		if syncSQL := c.Dialect().SyncSequenceSQL(table.schema.Name, column); syncSQL != "" {
+			_, err = tx.Exec(syncSQL)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = c.writeAudit(tx, "restore", AuditDatabase, "", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,234 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SystemActor is the actor of the changes made without an AuditContext, by the commands and the background sweeps
+const SystemActor = "system"
+
+// The entity types of the audit log
+const (
+	AuditBook     = "book"
+	AuditCopy     = "copy"
+	AuditCard     = "card"
+	AuditBorrow   = "borrow"
+	AuditFine     = "fine"
+	AuditHold     = "hold"
+	AuditUser     = "user"
+	AuditDatabase = "database"
+)
+
+// AuditContext tells who makes the changes through a connector returned by WithAudit, and in which request
+type AuditContext struct {
+	Actor     string
+	RequestID string
+}
+
+// AuditEntry is a change of an entity, kept in the append-only audit log with the entity as it was before and
+// after it. Entities created have no Before, removed ones no After.
+type AuditEntry struct {
+	AuditID    int             `json:"audit_id" sql:"not null;autoIncrement;primaryKey"`
+	CreatedAt  int64           `json:"created_at" sql:"not null;index"`
+	Actor      string          `json:"actor" sql:"not null;size:64;index"`
+	RequestID  string          `json:"request_id" sql:"not null;size:64;default:''"`
+	Action     string          `json:"action" sql:"not null;size:32"`
+	EntityType string          `json:"entity_type" sql:"not null;size:32;index:idx_audit_entity"`
+	EntityID   string          `json:"entity_id" sql:"not null;size:64;index:idx_audit_entity"`
+	OldState   json.RawMessage `json:"before" sql:"not null;json"`
+	NewState   json.RawMessage `json:"after" sql:"not null;json"`
+}
+
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+// AuditQuery selects the entries of the audit log with all the fields it sets, Until is excluded
+type AuditQuery struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	RequestID  string
+	Since      int64
+	Until      int64
+	Page       *Page
+}
+
+type AuditList struct {
+	// Count is the number of matching entries, Entries only holds a page of them, latest first
+	Count   int          `json:"count"`
+	Entries []AuditEntry `json:"entries"`
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// auditSort is the order of the audit log, latest first
+const auditSort = "audit_id DESC"
+
+// borrowEntityID is the id of a borrow record in the audit log
+func borrowEntityID(borrow *Borrow) string {
+	return fmt.Sprintf("%d/%d/%d", borrow.CardID, borrow.BookID, borrow.BorrowTime)
+}
+
+// entry returns the entry of a change made in the context, before and after are the entity, nil if it has none
+func (a *AuditContext) entry(action string, entityType string, entityId any, before any, after any) (*AuditEntry, error) {
+	entry := AuditEntry{
+		CreatedAt:  time.Now().Unix(),
+		Actor:      SystemActor,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityId),
+	}
+	if a != nil && a.Actor != "" {
+		entry.Actor, entry.RequestID = a.Actor, a.RequestID
+	}
+
+	var err error
+	entry.OldState, err = json.Marshal(before)
+	if err != nil {
+		return nil, err
+	}
+	entry.NewState, err = json.Marshal(after)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (q *AuditQuery) check() error {
+	if q == nil {
+		return ErrNilAuditQuery
+	}
+	if q.Since < 0 || q.Until < 0 || (q.Until > 0 && q.Until <= q.Since) {
+		return ErrInvalidTimeRange
+	}
+	_, err := q.Page.check()
+	return err
+}
+
+func (q *AuditQuery) matches(entry *AuditEntry) bool {
+	return (q.Actor == "" || entry.Actor == q.Actor) &&
+		(q.Action == "" || entry.Action == q.Action) &&
+		(q.EntityType == "" || entry.EntityType == q.EntityType) &&
+		(q.EntityID == "" || entry.EntityID == q.EntityID) &&
+		(q.RequestID == "" || entry.RequestID == q.RequestID) &&
+		entry.CreatedAt >= q.Since &&
+		(q.Until == 0 || entry.CreatedAt < q.Until)
+}
+
+// whereSQL returns the WHERE clause selecting the entries matching the query, empty if all entries match
+func (q *AuditQuery) whereSQL() (string, []any) {
+	var (
+		conditions []string
+		args       []any
+	)
+	for _, field := range []struct {
+		column string
+		value  string
+	}{
+		{"actor", q.Actor},
+		{"action", q.Action},
+		{"entity_type", q.EntityType},
+		{"entity_id", q.EntityID},
+		{"request_id", q.RequestID},
+	} {
+		if field.value != "" {
+			conditions = append(conditions, field.column+" = ?")
+			args = append(args, field.value)
+		}
+	}
+	if q.Since > 0 {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, q.Since)
+	}
+	if q.Until > 0 {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, q.Until)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// paginate keeps the first limit entries read, and sets the cursor of the next page if more were read
+func (l *AuditList) paginate(limit int) {
+	if len(l.Entries) <= limit {
+		return
+	}
+	l.Entries = l.Entries[:limit]
+	l.NextCursor = encodeCursor(auditSort, nil, l.Entries[limit-1].AuditID)
+}
+
+// WithAudit returns a connector to the same database recording the changes it makes as made in the context
+func (c *DatabaseConnector) WithAudit(audit *AuditContext) Connector {
+	scoped := *c
+	scoped.audit = audit
+	return &scoped
+}
+
+// writeAudit adds the entry of a change to the audit log, in the transaction of the change
+func (c *DatabaseConnector) writeAudit(executor SQLExecutor, action string, entityType string, entityId any, before any, after any) error {
+	entry, err := c.audit.entry(action, entityType, entityId, before, after)
+	if err != nil {
+		return err
+	}
+	_, err = executor.Exec("INSERT INTO audit_log (created_at, actor, request_id, action, entity_type, entity_id, old_state, new_state) "+
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)", entry.CreatedAt, entry.Actor, entry.RequestID, entry.Action, entry.EntityType, entry.EntityID,
+		string(entry.OldState), string(entry.NewState))
+	return err
+}
+
+func (c *DatabaseConnector) ShowAudit(query *AuditQuery) (*AuditList, error) {
+	err := query.check()
+	if err != nil {
+		return nil, err
+	}
+	limit, _ := query.Page.check()
+	after, err := query.Page.after(auditSort)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, args := query.whereSQL()
+	var count int
+	err = queryRow(c.DB, "SELECT COUNT(*) FROM audit_log"+whereSQL, args...).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+
+	if after != nil {
+		if whereSQL == "" {
+			whereSQL = " WHERE audit_id < ?"
+		} else {
+			whereSQL += " AND audit_id < ?"
+		}
+		args = append(args, after.ID)
+	}
+	rows, err := c.DB.Query("SELECT * FROM audit_log"+whereSQL+" ORDER BY audit_id DESC"+limitSQL(limit, query.Page.offset()), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := AuditList{Count: count, Entries: make([]AuditEntry, 0)}
+	for rows.Next() {
+		var (
+			entry              AuditEntry
+			oldState, newState string
+		)
+		err := rows.Scan(&entry.AuditID, &entry.CreatedAt, &entry.Actor, &entry.RequestID, &entry.Action, &entry.EntityType,
+			&entry.EntityID, &oldState, &newState)
+		if err != nil {
+			return nil, err
+		}
+		entry.OldState, entry.NewState = json.RawMessage(oldState), json.RawMessage(newState)
+		list.Entries = append(list.Entries, entry)
+	}
+	list.paginate(limit)
+	return &list, rows.Err()
+}
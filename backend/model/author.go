@@ -0,0 +1,373 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type AuthorRole string
+
+const (
+	RoleAuthor      AuthorRole = "author"
+	RoleEditor      AuthorRole = "editor"
+	RoleTranslator  AuthorRole = "translator"
+	RoleIllustrator AuthorRole = "illustrator"
+)
+
+type Author struct {
+	AuthorID int    `json:"author_id" sql:"not null;autoIncrement;primaryKey"`
+	Name     string `json:"name" sql:"not null;size:63;unique"`
+	// Works is the number of books of the author, only set by ShowAuthors and ShowAuthorWorks
+	Works int `json:"works" sql:"-"`
+}
+
+// BookAuthor links a book to one of its contributors, Position orders the contributors of the book from 1
+type BookAuthor struct {
+	BookID   int        `sql:"not null;primaryKey;constraint:Book.BookID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	AuthorID int        `sql:"not null;primaryKey;index;constraint:Author.AuthorID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	Role     AuthorRole `sql:"not null;primaryKey;enum:author,editor,translator,illustrator"`
+	Position int        `sql:"not null"`
+}
+
+// Contributor is a contributor of a book, given by AuthorID or by Name when the book is stored
+type Contributor struct {
+	AuthorID int        `json:"author_id"`
+	Name     string     `json:"name"`
+	Role     AuthorRole `json:"role"`
+	Position int        `json:"position"`
+}
+
+type AuthorList struct {
+	Count   int      `json:"count"`
+	Authors []Author `json:"authors"`
+}
+
+type AuthorWork struct {
+	Book     Book       `json:"book"`
+	Role     AuthorRole `json:"role"`
+	Position int        `json:"position"`
+}
+
+type AuthorWorks struct {
+	Author Author       `json:"author"`
+	Count  int          `json:"count"`
+	Works  []AuthorWork `json:"works"`
+}
+
+var authorSeparators = strings.NewReplacer(";", ",", "&", ",", "/", ",", "、", ",", "，", ",", " and ", ",", " AND ", ",", " And ", ",")
+
+// splitAuthors splits an Author string into the names it lists, e.g. "A. Smith & B. Jones" or "Smith, Jones"
+func splitAuthors(author string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(authorSeparators.Replace(author), ",") {
+		name = strings.Join(strings.Fields(name), " ")
+		if name == "" {
+			continue
+		}
+		duplicate := false
+		for _, seen := range names {
+			if strings.EqualFold(seen, name) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// replaceAuthors sets the Author of the book and replaces its authors by the names it lists, the editors, translators
+// and illustrators being kept after them
+func (b *Book) replaceAuthors(author string) {
+	contributors := make([]Contributor, 0, len(b.Contributors))
+	for _, name := range splitAuthors(author) {
+		contributors = append(contributors, Contributor{Name: name, Role: RoleAuthor})
+	}
+	for _, contributor := range b.Contributors {
+		if contributor.Role != RoleAuthor {
+			contributors = append(contributors, contributor)
+		}
+	}
+	b.Author, b.Contributors = author, contributors
+}
+
+// resolveContributors checks the contributors of a book, or takes them from Book.Author if there are none, and
+// numbers them in order. A book given contributors only gets its Author from the names of its authors.
+func (b *Book) resolveContributors() error {
+	if len(b.Contributors) == 0 {
+		for _, name := range splitAuthors(b.Author) {
+			b.Contributors = append(b.Contributors, Contributor{Name: name, Role: RoleAuthor})
+		}
+	}
+
+	var names []string
+	for i := range b.Contributors {
+		contributor := &b.Contributors[i]
+		contributor.Name = strings.Join(strings.Fields(contributor.Name), " ")
+		if contributor.Role == "" {
+			contributor.Role = RoleAuthor
+		}
+		switch contributor.Role {
+		case RoleAuthor, RoleEditor, RoleTranslator, RoleIllustrator:
+		default:
+			return ErrInvalidAuthorRole
+		}
+		if contributor.AuthorID == 0 && contributor.Name == "" {
+			return ErrMissingAuthorName
+		}
+		if utf8.RuneCountInString(contributor.Name) > 63 {
+			return ErrAuthorNameTooLong
+		}
+		contributor.Position = i + 1
+		if contributor.Role == RoleAuthor {
+			names = append(names, contributor.Name)
+		}
+	}
+	if b.Author == "" {
+		b.Author = strings.Join(names, ", ")
+	}
+	// book.author holds 63 characters, the names of many authors may not fit in it
+	if utf8.RuneCountInString(b.Author) > 63 {
+		return ErrAuthorTooLong
+	}
+	return nil
+}
+
+// checkContributors returns an error if a contributor is listed twice in the same role, the contributors found in
+// the registry must have their id and the new ones none
+func checkContributors(contributors []Contributor) error {
+	seen := make(map[string]bool)
+	for _, contributor := range contributors {
+		key := fmt.Sprintf("%d/%s/%s", contributor.AuthorID, contributor.Role, strings.ToLower(contributor.Name))
+		if contributor.AuthorID != 0 {
+			key = fmt.Sprintf("%d/%s", contributor.AuthorID, contributor.Role)
+		}
+		if seen[key] {
+			return ErrDuplicateContributor
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// linkAuthors replaces the contributors of a book, the contributors given by name are found in the registry or
+// added to it
+func linkAuthors(executor SQLExecutor, dialect Dialect, bookId int, contributors []Contributor) error {
+	_, err := executor.Exec("DELETE FROM book_author WHERE book_id = ?", bookId)
+	if err != nil {
+		return err
+	}
+
+	for i := range contributors {
+		contributor := &contributors[i]
+		if contributor.AuthorID != 0 {
+			err = queryRow(executor, "SELECT name FROM author WHERE author_id = ?", contributor.AuthorID).Scan(&contributor.Name)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrAuthorNotFound
+				}
+				return err
+			}
+			continue
+		}
+
+		err = queryRow(executor, "SELECT author_id, name FROM author WHERE LOWER(name) = LOWER(?)", contributor.Name).
+			Scan(&contributor.AuthorID, &contributor.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			insertedID, err := dialect.InsertReturningID(executor, "INSERT INTO author (name) VALUES (?)", "author_id", contributor.Name)
+			if err != nil {
+				return err
+			}
+			contributor.AuthorID = int(insertedID)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	err = checkContributors(contributors)
+	if err != nil {
+		return err
+	}
+	for _, contributor := range contributors {
+		_, err = executor.Exec("INSERT INTO book_author (book_id, author_id, role, position) VALUES (?, ?, ?, ?)",
+			bookId, contributor.AuthorID, contributor.Role, contributor.Position)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryContributors returns the contributors of the books selected by bookIdsSQL, by book
+func queryContributors(executor SQLExecutor, bookIdsSQL string, args ...any) (map[int][]Contributor, error) {
+	rows, err := executor.Query("SELECT ba.book_id, a.author_id, a.name, ba.role, ba.position FROM book_author ba "+
+		"JOIN author a ON a.author_id = ba.author_id WHERE ba.book_id IN ("+bookIdsSQL+") ORDER BY ba.book_id, ba.position", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contributors := make(map[int][]Contributor)
+	for rows.Next() {
+		var (
+			bookId      int
+			contributor Contributor
+		)
+		err = rows.Scan(&bookId, &contributor.AuthorID, &contributor.Name, &contributor.Role, &contributor.Position)
+		if err != nil {
+			return nil, err
+		}
+		contributors[bookId] = append(contributors[bookId], contributor)
+	}
+	return contributors, rows.Err()
+}
+
+// quoteLiteral returns s as a string literal of the dialect
+func quoteLiteral(dialect Dialect, s string) string {
+	s = strings.ReplaceAll(s, "'", "''")
+	if dialect.Name() == DriverMySQL {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	return "'" + s + "'"
+}
+
+// backfillAuthors fills the authors registry from the Author strings of the books stored before it existed
+func backfillAuthors(dialect Dialect, executor SQLExecutor) ([]string, error) {
+	statements := make([]string, 0)
+	books, err := dialect.DescribeTable(executor, "book")
+	if err != nil {
+		return nil, err
+	}
+	registry, err := dialect.DescribeTable(executor, "author")
+	if err != nil {
+		return nil, err
+	}
+	if books == nil {
+		// no books to read
+		return statements, nil
+	}
+	if registry != nil {
+		var authors int
+		err = queryRow(executor, "SELECT COUNT(*) FROM author").Scan(&authors)
+		if err != nil {
+			return nil, err
+		}
+		if authors != 0 {
+			// a registry filled with the books
+			return statements, nil
+		}
+	}
+
+	rows, err := executor.Query("SELECT book_id, author FROM book ORDER BY book_id")
+	if err != nil {
+		return nil, err
+	}
+	authors := make(map[int]string)
+	var bookIds []int
+	for rows.Next() {
+		var (
+			bookId int
+			author string
+		)
+		err = rows.Scan(&bookId, &author)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bookIds = append(bookIds, bookId)
+		authors[bookId] = author
+	}
+	rows.Close()
+
+	registered := make(map[string]string)
+	for _, bookId := range bookIds {
+		for i, name := range splitAuthors(authors[bookId]) {
+			if utf8.RuneCountInString(name) > 63 {
+				name = string([]rune(name)[:63])
+			}
+			key := strings.ToLower(name)
+			if _, ok := registered[key]; !ok {
+				registered[key] = name
+				statements = append(statements, "INSERT INTO author (name) VALUES ("+quoteLiteral(dialect, name)+")")
+			}
+			statements = append(statements, fmt.Sprintf(
+				"INSERT INTO book_author (book_id, author_id, role, position) SELECT %d, author_id, '%s', %d FROM author WHERE name = %s",
+				bookId, RoleAuthor, i+1, quoteLiteral(dialect, registered[key])))
+		}
+	}
+	return statements, nil
+}
+
+func (c *DatabaseConnector) ShowAuthors(name string) (*AuthorList, error) {
+	querySQL := "SELECT a.author_id, a.name, COUNT(DISTINCT ba.book_id) FROM author a LEFT JOIN book_author ba ON ba.author_id = a.author_id"
+	var args []any
+	if name != "" {
+		querySQL += " WHERE " + likeSQL(c.Dialect(), "a.name")
+		args = append(args, "%"+escapeLike(name)+"%")
+	}
+	querySQL += " GROUP BY a.author_id, a.name ORDER BY a.name, a.author_id"
+
+	rows, err := c.DB.Query(querySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authors := AuthorList{Authors: make([]Author, 0)}
+	for rows.Next() {
+		var author Author
+		err = rows.Scan(&author.AuthorID, &author.Name, &author.Works)
+		if err != nil {
+			return nil, err
+		}
+		authors.Authors = append(authors.Authors, author)
+	}
+	authors.Count = len(authors.Authors)
+	return &authors, rows.Err()
+}
+
+func (c *DatabaseConnector) ShowAuthorWorks(authorId int) (*AuthorWorks, error) {
+	works := AuthorWorks{Works: make([]AuthorWork, 0)}
+	err := queryRow(c.DB, "SELECT author_id, name FROM author WHERE author_id = ?", authorId).
+		Scan(&works.Author.AuthorID, &works.Author.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAuthorNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := c.DB.Query("SELECT b.*, ba.role, ba.position FROM book_author ba JOIN book b ON b.book_id = ba.book_id "+
+		"WHERE ba.author_id = ? ORDER BY b.publish_year, b.book_id, ba.position", authorId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var work AuthorWork
+		book := &work.Book
+		err = rows.Scan(&book.BookID, &book.Category, &book.Title, &book.Press, &book.PublishYear, &book.Author, &book.Price,
+			&book.Stock, &book.ISBN, &book.Version, &book.UpdatedAt, &work.Role, &work.Position)
+		if err != nil {
+			return nil, err
+		}
+		works.Works = append(works.Works, work)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	bookIds := make(map[int]bool)
+	for _, work := range works.Works {
+		bookIds[work.Book.BookID] = true
+	}
+	works.Author.Works = len(bookIds)
+	works.Count = len(works.Works)
+	return &works, nil
+}
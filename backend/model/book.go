@@ -1,8 +1,12 @@
 package model
 
 import (
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 type myFloat float32
@@ -11,6 +15,11 @@ func (f myFloat) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf("%0.2f", f)), nil
 }
 
+// SetPrice sets the price of the book, for the callers outside the package that cannot name its type
+func (b *Book) SetPrice(price float32) {
+	b.Price = myFloat(price)
+}
+
 type Book struct {
 	BookID      int     `json:"book_id" sql:"not null;autoIncrement;primaryKey"`
 	Category    string  `json:"category" sql:"not null;size:63;unique:book_unique"`
@@ -20,6 +29,14 @@ type Book struct {
 	Author      string  `json:"author" sql:"not null;size:63;unique:book_unique"`
 	Price       myFloat `json:"price" sql:"not null;decimal:7,2;default:0.00"`
 	Stock       int     `json:"stock" sql:"not null;default:0"`
+	// ISBN is an ISBN-13, books without one have a nil ISBN
+	ISBN *string `json:"isbn,omitempty" sql:"size:13;index:idx_book_isbn,unique"`
+	// Version counts the changes of the information of the book, UpdatedAt is the time of the last one. Stock
+	// changes leave both as they are.
+	Version   int   `json:"version" sql:"not null;default:1"`
+	UpdatedAt int64 `json:"updated_at" sql:"not null;default:0"`
+	// Contributors are the authors, editors, translators and illustrators of the book in order
+	Contributors []Contributor `json:"contributors,omitempty" sql:"-"`
 }
 
 type BookCreateRequest struct {
@@ -30,6 +47,91 @@ type BookCreateRequest struct {
 	Author      *string  `json:"author,omitempty"`
 	Price       *myFloat `json:"price,omitempty"`
 	Stock       *int     `json:"stock,omitempty"`
+	ISBN        *string  `json:"isbn,omitempty"`
+	// Contributors replace Author, which is then set to the names of the authors
+	Contributors []Contributor `json:"contributors,omitempty"`
+}
+
+// BookPatch changes the fields of a book it sets, an empty ISBN removes the ISBN of the book. Contributors replace
+// those of the book and its Author as in BookCreateRequest, a new Author without them replaces its authors only.
+type BookPatch struct {
+	Category     *string       `json:"category,omitempty"`
+	Title        *string       `json:"title,omitempty"`
+	Press        *string       `json:"press,omitempty"`
+	PublishYear  *int          `json:"publish_year,omitempty"`
+	Author       *string       `json:"author,omitempty"`
+	Price        *float32      `json:"price,omitempty"`
+	ISBN         *string       `json:"isbn,omitempty"`
+	Contributors []Contributor `json:"contributors,omitempty"`
+	// Version is the version of the book the patch was made from, the patch fails with ErrStaleBook if the book
+	// changed since. A patch without it always applies.
+	Version *int `json:"version,omitempty"`
+}
+
+// patch returns the patch setting the fields an update of the whole book carries, Version included if the book has
+// one. Its ISBN and contributors are left as they are, only a patch changes them.
+func (b *Book) patch() *BookPatch {
+	price := float32(b.Price)
+	patch := BookPatch{
+		Category:    &b.Category,
+		Title:       &b.Title,
+		Press:       &b.Press,
+		PublishYear: &b.PublishYear,
+		Author:      &b.Author,
+		Price:       &price,
+	}
+	if b.Version != 0 {
+		patch.Version = &b.Version
+	}
+	return &patch
+}
+
+// apply checks the version of the book and changes it by the patch, the book must have its contributors
+func (p *BookPatch) apply(book *Book) error {
+	if p.Version != nil && *p.Version != book.Version {
+		return ErrStaleBook
+	}
+	if p.Category != nil {
+		book.Category = *p.Category
+	}
+	if p.Title != nil {
+		book.Title = *p.Title
+	}
+	if p.Press != nil {
+		book.Press = *p.Press
+	}
+	if p.PublishYear != nil {
+		book.PublishYear = *p.PublishYear
+	}
+	if p.Price != nil {
+		book.Price = myFloat(*p.Price)
+	}
+	if p.ISBN != nil {
+		isbn := *p.ISBN
+		book.ISBN = &isbn
+	}
+	switch {
+	case p.Contributors != nil:
+		book.Contributors = append([]Contributor(nil), p.Contributors...)
+		book.Author = ""
+		if p.Author != nil {
+			book.Author = *p.Author
+		}
+	case p.Author != nil && *p.Author != book.Author:
+		book.replaceAuthors(*p.Author)
+	}
+
+	err := book.normalizeISBN()
+	if err != nil {
+		return err
+	}
+	err = book.resolveContributors()
+	if err != nil {
+		return err
+	}
+	book.Version++
+	book.UpdatedAt = time.Now().Unix()
+	return nil
 }
 
 type IncStockOption string
@@ -66,16 +168,31 @@ const (
 )
 
 type BookQueryConditions struct {
-	Category       *string     `json:"category,omitempty"`
-	Title          *string     `json:"title,omitempty"`
-	Press          *string     `json:"press,omitempty"`
-	MinPublishYear *int        `json:"min_publish_year,omitempty"`
-	MaxPublishYear *int        `json:"max_publish_year,omitempty"`
-	Author         *string     `json:"author,omitempty"`
-	MinPrice       *float32    `json:"min_price,omitempty"`
-	MaxPrice       *float32    `json:"max_price,omitempty"`
-	SortBy         *BookColumn `json:"sort_by,omitempty"`
-	SortOrder      *SortOrder  `json:"sort_order,omitempty"`
+	Category       *string  `json:"category,omitempty"`
+	Title          *string  `json:"title,omitempty"`
+	Press          *string  `json:"press,omitempty"`
+	MinPublishYear *int     `json:"min_publish_year,omitempty"`
+	MaxPublishYear *int     `json:"max_publish_year,omitempty"`
+	Author         *string  `json:"author,omitempty"`
+	MinPrice       *float32 `json:"min_price,omitempty"`
+	MaxPrice       *float32 `json:"max_price,omitempty"`
+	ISBN           *string  `json:"isbn,omitempty"`
+	AuthorID       *int     `json:"author_id,omitempty"`
+	// AuthorName matches the whole name of a contributor, unlike Author which matches part of Book.Author
+	AuthorName *string `json:"author_name,omitempty"`
+	// BookIDs restricts the results to the given books when it is not empty
+	BookIDs []int `json:"book_ids,omitempty"`
+	// Filter is a filter expression the books must also match
+	Filter *BookFilter `json:"filter,omitempty"`
+	// AvailableOnly only keeps the books in stock
+	AvailableOnly bool        `json:"available_only,omitempty"`
+	SortBy        *BookColumn `json:"sort_by,omitempty"`
+	SortOrder     *SortOrder  `json:"sort_order,omitempty"`
+	// Sort sorts the books on several columns, it replaces SortBy and SortOrder
+	Sort []SortKey `json:"sort,omitempty"`
+	// Facets asks for the facets of the matching books along with them
+	Facets *BookFacetOptions `json:"facets,omitempty"`
+	Page
 }
 
 func NewBookQueryConditions() *BookQueryConditions {
@@ -122,6 +239,26 @@ func (c *BookQueryConditions) WithMaxPrice(maxPrice float32) *BookQueryCondition
 	return c
 }
 
+func (c *BookQueryConditions) WithISBN(isbn string) *BookQueryConditions {
+	c.ISBN = &isbn
+	return c
+}
+
+func (c *BookQueryConditions) WithAuthorID(authorId int) *BookQueryConditions {
+	c.AuthorID = &authorId
+	return c
+}
+
+func (c *BookQueryConditions) WithAuthorName(authorName string) *BookQueryConditions {
+	c.AuthorName = &authorName
+	return c
+}
+
+func (c *BookQueryConditions) WithBookIDs(bookIds ...int) *BookQueryConditions {
+	c.BookIDs = bookIds
+	return c
+}
+
 func (c *BookQueryConditions) WithSortBy(sortBy BookColumn) *BookQueryConditions {
 	c.SortBy = &sortBy
 	return c
@@ -132,85 +269,304 @@ func (c *BookQueryConditions) WithSortOrder(sortOrder SortOrder) *BookQueryCondi
 	return c
 }
 
+func (c *BookQueryConditions) WithFilter(filter *BookFilter) *BookQueryConditions {
+	c.Filter = filter
+	return c
+}
+
+func (c *BookQueryConditions) WithAvailableOnly() *BookQueryConditions {
+	c.AvailableOnly = true
+	return c
+}
+
+func (c *BookQueryConditions) WithSort(keys ...SortKey) *BookQueryConditions {
+	c.Sort = keys
+	return c
+}
+
+func (c *BookQueryConditions) WithFacets(options BookFacetOptions) *BookQueryConditions {
+	c.Facets = &options
+	return c
+}
+
+func (c *BookQueryConditions) WithPage(page *Page) *BookQueryConditions {
+	c.Page = *page
+	return c
+}
+
 func (c *BookQueryConditions) Build() *BookQueryConditions {
 	return c
 }
 
+// sort returns the keys the books are sorted on, by book id unless Sort or SortBy is set, ending with book id to
+// break ties
+func (c *BookQueryConditions) sort() ([]SortKey, error) {
+	var keys []SortKey
+	if c != nil && c.SortBy != nil {
+		if len(c.Sort) > 0 {
+			return nil, ErrSortWithSortBy
+		}
+		key := SortKey{Column: *c.SortBy}
+		if c.SortOrder != nil {
+			key.Order = *c.SortOrder
+		}
+		keys = append(keys, key)
+	} else if c != nil {
+		keys = append(keys, c.Sort...)
+	}
+
+	seen := make(map[BookColumn]bool)
+	for i := range keys {
+		if _, ok := bookColumnKinds[keys[i].Column]; !ok {
+			return nil, ErrInvalidSortColumn
+		}
+		if seen[keys[i].Column] {
+			return nil, ErrDuplicateSortColumn
+		}
+		seen[keys[i].Column] = true
+		switch keys[i].Order {
+		case "":
+			keys[i].Order = Ascending
+		case Ascending, Descending:
+		default:
+			return nil, ErrInvalidSortOrder
+		}
+		if keys[i].Column == BookColumnBookID {
+			return keys[:i+1], nil
+		}
+	}
+	return append(keys, SortKey{Column: BookColumnBookID, Order: Ascending}), nil
+}
+
+func bookSort(keys []SortKey) string {
+	sorts := make([]string, len(keys))
+	for i, key := range keys {
+		sorts[i] = string(key.Column) + " " + string(key.Order)
+	}
+	return strings.Join(sorts, ", ")
+}
+
+// filter checks the filter expression of the conditions, if any
+func (c *BookQueryConditions) filter() (*BookFilter, error) {
+	if c == nil || c.Filter == nil {
+		return nil, nil
+	}
+	return c.Filter, c.Filter.check(0)
+}
+
+// column returns the value of a column of the book
+func (b *Book) column(column BookColumn) any {
+	switch column {
+	case BookColumnCategory:
+		return b.Category
+	case BookColumnTitle:
+		return b.Title
+	case BookColumnPress:
+		return b.Press
+	case BookColumnPublishYear:
+		return b.PublishYear
+	case BookColumnAuthor:
+		return b.Author
+	case BookColumnPrice:
+		return b.Price
+	case BookColumnStock:
+		return b.Stock
+	}
+	return b.BookID
+}
+
+// setColumns sets the sort keys of the book to the values of a cursor
+func (b *Book) setColumns(keys []SortKey, values []byte) error {
+	var columns []json.RawMessage
+	err := json.Unmarshal(values, &columns)
+	if err != nil || len(columns) != len(keys) {
+		return ErrInvalidCursor
+	}
+	for i, key := range keys {
+		err = b.setColumn(key.Column, columns[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setColumn sets a column of the book to the value of a cursor
+func (b *Book) setColumn(column BookColumn, value []byte) error {
+	var err error
+	switch column {
+	case BookColumnBookID:
+		err = json.Unmarshal(value, &b.BookID)
+	case BookColumnCategory:
+		err = json.Unmarshal(value, &b.Category)
+	case BookColumnTitle:
+		err = json.Unmarshal(value, &b.Title)
+	case BookColumnPress:
+		err = json.Unmarshal(value, &b.Press)
+	case BookColumnPublishYear:
+		err = json.Unmarshal(value, &b.PublishYear)
+	case BookColumnAuthor:
+		err = json.Unmarshal(value, &b.Author)
+	case BookColumnPrice:
+		err = json.Unmarshal(value, &b.Price)
+	case BookColumnStock:
+		err = json.Unmarshal(value, &b.Stock)
+	}
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
 type BookQueryResult struct {
+	// Count is the number of books matching the conditions, Results only holds a page of them
 	Count   int    `json:"count"`
 	Results []Book `json:"results"`
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Facets are only counted when the conditions ask for them
+	Facets *BookFacets `json:"facets,omitempty"`
+}
+
+// paginate keeps the first limit books read, and sets the cursor of the next page if more were read
+func (r *BookQueryResult) paginate(limit int, keys []SortKey) {
+	if len(r.Results) <= limit {
+		return
+	}
+	r.Results = r.Results[:limit]
+	last := &r.Results[limit-1]
+	columns := make([]any, len(keys))
+	for i, key := range keys {
+		columns[i] = last.column(key.Column)
+	}
+	r.NextCursor = encodeCursor(bookSort(keys), columns, last.BookID)
 }
 
 func (c *DatabaseConnector) StoreBook(book *Book) error {
 	if book == nil {
-		return errors.New("book is nil")
+		return ErrNilBook
+	}
+	if book.Stock < 0 {
+		return ErrNegativeStock
+	}
+	err := book.normalizeISBN()
+	if err != nil {
+		return err
+	}
+	err = book.resolveContributors()
+	if err != nil {
+		return err
 	}
 
 	var (
 		insertSQL string
 		args      []any
 	)
-	insertSQL = "INSERT INTO book (category, title, press, publish_year, author, price, stock) VALUES (?, ?, ?, ?, ?, ?, ?)"
-	args = append(args, book.Category, book.Title, book.Press, book.PublishYear, book.Author, book.Price, book.Stock)
+	book.Version, book.UpdatedAt = 1, time.Now().Unix()
+	insertSQL = "INSERT INTO book (category, title, press, publish_year, author, price, stock, isbn, version, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	args = append(args, book.Category, book.Title, book.Press, book.PublishYear, book.Author, book.Price, book.Stock, book.ISBN, book.Version, book.UpdatedAt)
 
-	result, err := c.DB.Exec(insertSQL, args...)
+	tx, err := c.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	insertedID, err := result.LastInsertId()
+	insertedID, err := c.Dialect().InsertReturningID(tx, insertSQL, "book_id", args...)
 	if err != nil {
+		tx.Rollback()
+		err = c.Dialect().ConstraintError(err)
+		if errors.Is(err, ErrDuplicate) {
+			return ErrDuplicateBook
+		}
 		return err
 	}
 
+	// the stock of a new book is its number of copies
+	err = insertCopies(tx, c.Dialect(), int(insertedID), make([]BookCopy, book.Stock))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = linkAuthors(tx, c.Dialect(), int(insertedID), book.Contributors)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	created := *book
+	created.BookID = int(insertedID)
+	err = c.writeAudit(tx, "create", AuditBook, created.BookID, nil, &created)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
 	book.BookID = int(insertedID)
 	return nil
 }
 
-func (c *DatabaseConnector) IncBookStock(bookId int, deltaStock int) error {
-	var (
-		querySQL  string
-		updateSQL string
-		args      []any
-	)
-
-	querySQL = "SELECT stock FROM book WHERE book_id = ? FOR UPDATE"
-	args = append(args, bookId)
+// queryBookRow returns the row of a book, without its contributors
+func queryBookRow(executor SQLExecutor, bookId int, lock string) (*Book, error) {
+	var book Book
+	err := queryRow(executor, "SELECT * FROM book WHERE book_id = ?"+lock, bookId).Scan(&book.BookID, &book.Category, &book.Title,
+		&book.Press, &book.PublishYear, &book.Author, &book.Price, &book.Stock, &book.ISBN, &book.Version, &book.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
 
+func (c *DatabaseConnector) IncBookStock(bookId int, deltaStock int) error {
 	tx, err := c.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	rows, err := tx.Query(querySQL, args...)
+	before, err := queryBookRow(tx, bookId, c.Dialect().LockForUpdate())
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	var stock int
-	if rows.Next() {
-		err = rows.Scan(&stock)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	} else {
+	if before.Stock+deltaStock < 0 {
 		tx.Rollback()
-		return errors.New("book not found")
+		return ErrStockNotEnough
 	}
-	rows.Close()
 
-	if stock+deltaStock < 0 {
+	// adding stock adds copies, removing stock withdraws the newest available copies
+	if deltaStock > 0 {
+		err = insertCopies(tx, c.Dialect(), bookId, make([]BookCopy, deltaStock))
+	} else {
+		_, err = moveCopies(tx, bookId, CopyAvailable, CopyWithdrawn, -deltaStock, true)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = syncStock(tx, bookId)
+	if err != nil {
 		tx.Rollback()
-		return errors.New("stock not enough")
+		return err
 	}
 
-	args = args[:0]
-	updateSQL = "UPDATE book SET stock = ? WHERE book_id = ?"
-	args = append(args, stock+deltaStock, bookId)
+	if deltaStock > 0 {
+		err = promoteHolds(tx, bookId, time.Now().Unix())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 
-	_, err = tx.Exec(updateSQL, args...)
+	after, err := queryBookRow(tx, bookId, "")
+	if err == nil {
+		err = c.writeAudit(tx, "stock", AuditBook, bookId, before, after)
+	}
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -222,38 +578,71 @@ func (c *DatabaseConnector) IncBookStock(bookId int, deltaStock int) error {
 
 func (c *DatabaseConnector) StoreBooks(books []Book) error {
 	if books == nil {
-		return errors.New("books is nil")
+		return ErrNilBooks
 	}
 	if len(books) == 0 {
-		return errors.New("books is empty")
+		return ErrEmptyBooks
+	}
+	for i := range books {
+		if books[i].Stock < 0 {
+			return ErrNegativeStock
+		}
 	}
 
 	var (
 		insertSQL string
 		args      []any
 	)
-	insertSQL = "INSERT INTO book (category, title, press, publish_year, author, price, stock) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	insertSQL = "INSERT INTO book (category, title, press, publish_year, author, price, stock, isbn, version, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
-	tx, err := c.DB.Begin()
-	if err != nil {
-		return err
+	now := time.Now().Unix()
+	for i := range books {
+		err := books[i].normalizeISBN()
+		if err != nil {
+			return err
+		}
+		err = books[i].resolveContributors()
+		if err != nil {
+			return err
+		}
+		books[i].Version, books[i].UpdatedAt = 1, now
 	}
 
-	stmt, err := tx.Prepare(insertSQL)
+	tx, err := c.DB.Begin()
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	defer stmt.Close()
 
-	for _, book := range books {
+	for i, book := range books {
 		args = args[:0]
-		args = append(args, book.Category, book.Title, book.Press, book.PublishYear, book.Author, book.Price, book.Stock)
-		_, err = stmt.Exec(args...)
+		args = append(args, book.Category, book.Title, book.Press, book.PublishYear, book.Author, book.Price, book.Stock, book.ISBN, book.Version, book.UpdatedAt)
+		insertedID, err := c.Dialect().InsertReturningID(tx, insertSQL, "book_id", args...)
+		if err != nil {
+			tx.Rollback()
+			err = c.Dialect().ConstraintError(err)
+			if errors.Is(err, ErrDuplicate) {
+				return ErrDuplicateBook
+			}
+			return err
+		}
+
+		err = insertCopies(tx, c.Dialect(), int(insertedID), make([]BookCopy, book.Stock))
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
+		err = linkAuthors(tx, c.Dialect(), int(insertedID), book.Contributors)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		book.BookID = int(insertedID)
+		err = c.writeAudit(tx, "create", AuditBook, book.BookID, nil, &book)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		books[i].BookID = int(insertedID)
 	}
 
 	err = tx.Commit()
@@ -277,10 +666,20 @@ func (c *DatabaseConnector) RemoveBook(bookId int) error {
 	for _, borrow := range borrows {
 		if borrow.ReturnTime == 0 {
 			tx.Rollback()
-			return errors.New("book is borrowed")
+			return ErrBookBorrowed
 		}
 	}
 
+	// removing a book that does not exist changes nothing, and is not recorded
+	before, err := queryBookRow(tx, bookId, c.Dialect().LockForUpdate())
+	if err == nil {
+		err = c.writeAudit(tx, "remove", AuditBook, bookId, before, nil)
+	}
+	if err != nil && !errors.Is(err, ErrBookNotFound) {
+		tx.Rollback()
+		return err
+	}
+
 	_, err = tx.Exec(deleteSQL, bookId)
 	if err != nil {
 		tx.Rollback()
@@ -293,116 +692,323 @@ func (c *DatabaseConnector) RemoveBook(bookId int) error {
 
 func (c *DatabaseConnector) ModifyBookInfo(book *Book) error {
 	if book == nil {
-		return errors.New("book is nil")
+		return ErrNilBook
 	}
+	modified, err := c.PatchBook(book.BookID, book.patch())
+	if err != nil {
+		return err
+	}
+	*book = *modified
+	return nil
+}
 
-	var (
-		updateSQL string
-		args      []any
-	)
-	updateSQL = "UPDATE book SET category = ?, title = ?, press = ?, publish_year = ?, author = ?, price = ? WHERE book_id = ?"
-	args = append(args, book.Category, book.Title, book.Press, book.PublishYear, book.Author, book.Price, book.BookID)
+func (c *DatabaseConnector) PatchBook(bookId int, patch *BookPatch) (*Book, error) {
+	if patch == nil {
+		return nil, ErrNilPatch
+	}
 
-	_, err := c.DB.Exec(updateSQL, args...)
-	return err
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := queryBookRow(tx, bookId, c.Dialect().LockForUpdate())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	contributors, err := queryContributors(tx, "?", bookId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	existing.Contributors = contributors[bookId]
+
+	book := *existing
+	book.Contributors = append([]Contributor(nil), existing.Contributors...)
+	version := book.Version
+	err = patch.apply(&book)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// the version is checked again for the databases that do not lock the row
+	updateSQL := "UPDATE book SET category = ?, title = ?, press = ?, publish_year = ?, author = ?, price = ?, isbn = ?, version = ?, updated_at = ? " +
+		"WHERE book_id = ? AND version = ?"
+	result, err := tx.Exec(updateSQL, book.Category, book.Title, book.Press, book.PublishYear, book.Author, book.Price, book.ISBN,
+		book.Version, book.UpdatedAt, bookId, version)
+	if err != nil {
+		tx.Rollback()
+		err = c.Dialect().ConstraintError(err)
+		if errors.Is(err, ErrDuplicate) {
+			return nil, ErrDuplicateBook
+		}
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		err = ErrStaleBook
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = linkAuthors(tx, c.Dialect(), bookId, book.Contributors)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = c.writeAudit(tx, "update", AuditBook, bookId, existing, &book)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &book, nil
 }
 
-func (c *DatabaseConnector) QueryBook(condition *BookQueryConditions) (*BookQueryResult, error) {
+// bookWhereSQL returns the WHERE clause selecting the books matching the conditions, empty if all books match
+func (c *DatabaseConnector) bookWhereSQL(condition *BookQueryConditions) (string, []any, error) {
 	var (
-		querySQL string
+		whereSQL string
 		args     []any
 	)
-	querySQL = "SELECT * FROM book"
+	like := likeSQL(c.Dialect(), "")
 	if condition != nil {
 		if condition.Category != nil {
-			querySQL += " WHERE category = ?"
+			whereSQL += " WHERE category = ?"
 			args = append(args, *condition.Category)
 		}
 		if condition.Title != nil {
 			if len(args) == 0 {
-				querySQL += " WHERE title LIKE ?"
+				whereSQL += " WHERE title" + like
 			} else {
-				querySQL += " AND title LIKE ?"
+				whereSQL += " AND title" + like
 			}
-			args = append(args, "%"+*condition.Title+"%")
+			args = append(args, "%"+escapeLike(*condition.Title)+"%")
 		}
 		if condition.Press != nil {
 			if len(args) == 0 {
-				querySQL += " WHERE press LIKE ?"
+				whereSQL += " WHERE press" + like
 			} else {
-				querySQL += " AND press LIKE ?"
+				whereSQL += " AND press" + like
 			}
-			args = append(args, "%"+*condition.Press+"%")
+			args = append(args, "%"+escapeLike(*condition.Press)+"%")
 		}
 		if condition.MinPublishYear != nil {
 			if len(args) == 0 {
-				querySQL += " WHERE publish_year >= ?"
+				whereSQL += " WHERE publish_year >= ?"
 			} else {
-				querySQL += " AND publish_year >= ?"
+				whereSQL += " AND publish_year >= ?"
 			}
 			args = append(args, *condition.MinPublishYear)
 		}
 		if condition.MaxPublishYear != nil {
 			if len(args) == 0 {
-				querySQL += " WHERE publish_year <= ?"
+				whereSQL += " WHERE publish_year <= ?"
 			} else {
-				querySQL += " AND publish_year <= ?"
+				whereSQL += " AND publish_year <= ?"
 			}
 			args = append(args, *condition.MaxPublishYear)
 		}
 		if condition.Author != nil {
 			if len(args) == 0 {
-				querySQL += " WHERE author LIKE ?"
+				whereSQL += " WHERE author" + like
 			} else {
-				querySQL += " AND author LIKE ?"
+				whereSQL += " AND author" + like
 			}
-			args = append(args, "%"+*condition.Author+"%")
+			args = append(args, "%"+escapeLike(*condition.Author)+"%")
 		}
 		if condition.MinPrice != nil {
 			if len(args) == 0 {
-				querySQL += " WHERE price >= ?"
+				whereSQL += " WHERE price >= ?"
 			} else {
-				querySQL += " AND price >= ?"
+				whereSQL += " AND price >= ?"
 			}
 			args = append(args, *condition.MinPrice)
 		}
 		if condition.MaxPrice != nil {
 			if len(args) == 0 {
-				querySQL += " WHERE price <= ?"
+				whereSQL += " WHERE price <= ?"
 			} else {
-				querySQL += " AND price <= ?"
+				whereSQL += " AND price <= ?"
 			}
 			args = append(args, *condition.MaxPrice)
 		}
-		if condition.SortBy != nil {
-			querySQL += " ORDER BY " + string(*condition.SortBy)
-			if condition.SortOrder != nil {
-				if *condition.SortOrder != Ascending && *condition.SortOrder != Descending {
-					return nil, errors.New("invalid sort order")
-				}
-				querySQL += " " + string(*condition.SortOrder)
+		if condition.ISBN != nil {
+			isbn, err := NormalizeISBN(*condition.ISBN)
+			if err != nil {
+				return "", nil, err
+			}
+			if len(args) == 0 {
+				whereSQL += " WHERE isbn = ?"
+			} else {
+				whereSQL += " AND isbn = ?"
+			}
+			args = append(args, isbn)
+		}
+		if condition.AuthorID != nil {
+			if len(args) == 0 {
+				whereSQL += " WHERE book_id IN (SELECT book_id FROM book_author WHERE author_id = ?)"
+			} else {
+				whereSQL += " AND book_id IN (SELECT book_id FROM book_author WHERE author_id = ?)"
+			}
+			args = append(args, *condition.AuthorID)
+		}
+		if condition.AuthorName != nil {
+			authorSQL := "book_id IN (SELECT ba.book_id FROM book_author ba JOIN author a ON a.author_id = ba.author_id WHERE LOWER(a.name) = LOWER(?))"
+			if len(args) == 0 {
+				whereSQL += " WHERE " + authorSQL
+			} else {
+				whereSQL += " AND " + authorSQL
+			}
+			args = append(args, strings.Join(strings.Fields(*condition.AuthorName), " "))
+		}
+		if len(condition.BookIDs) > 0 {
+			bookIdsSQL := "book_id IN (?" + strings.Repeat(", ?", len(condition.BookIDs)-1) + ")"
+			if len(args) == 0 {
+				whereSQL += " WHERE " + bookIdsSQL
+			} else {
+				whereSQL += " AND " + bookIdsSQL
+			}
+			for _, bookId := range condition.BookIDs {
+				args = append(args, bookId)
+			}
+		}
+		filter, err := condition.filter()
+		if err != nil {
+			return "", nil, err
+		}
+		if filter != nil {
+			filterSQL, filterArgs := filter.sql(c.Dialect())
+			if len(args) == 0 {
+				whereSQL += " WHERE " + filterSQL
+			} else {
+				whereSQL += " AND " + filterSQL
+			}
+			args = append(args, filterArgs...)
+		}
+		if condition.AvailableOnly {
+			if len(args) == 0 {
+				whereSQL += " WHERE stock > ?"
+			} else {
+				whereSQL += " AND stock > ?"
+			}
+			args = append(args, 0)
+		}
+	}
+	return whereSQL, args, nil
+}
+
+func (c *DatabaseConnector) QueryBook(condition *BookQueryConditions) (*BookQueryResult, error) {
+	whereSQL, args, err := c.bookWhereSQL(condition)
+	if err != nil {
+		return nil, err
+	}
+	querySQL := "SELECT * FROM book" + whereSQL
+
+	var page *Page
+	if condition != nil {
+		page = &condition.Page
+	}
+	limit, err := page.check()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := condition.sort()
+	if err != nil {
+		return nil, err
+	}
+	after, err := page.after(bookSort(keys))
+	if err != nil {
+		return nil, err
+	}
+
+	var result BookQueryResult
+	err = queryRow(c.DB, "SELECT COUNT(*) FROM book"+whereSQL, args...).Scan(&result.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	// the page starts after the last book of the cursor, in the order of the books
+	if after != nil {
+		var last Book
+		err = last.setColumns(keys, after.Key)
+		if err != nil {
+			return nil, err
+		}
+		// a book is after the last one if it has the same first keys and comes after it on the next one
+		var (
+			afterSQL  []string
+			equalSQL  string
+			equalArgs []any
+		)
+		where := len(args) == 0
+		for _, key := range keys {
+			compare := " > ?"
+			if key.Order == Descending {
+				compare = " < ?"
 			}
-			querySQL += ", book_id ASC"
+			value := sqlValue(bookColumnKinds[key.Column], bookValue(&last, key.Column))
+			afterSQL = append(afterSQL, "("+equalSQL+string(key.Column)+compare+")")
+			args = append(args, equalArgs...)
+			args = append(args, value)
+			equalSQL += string(key.Column) + " = ? AND "
+			equalArgs = append(equalArgs, value)
+		}
+		if where {
+			querySQL += " WHERE (" + strings.Join(afterSQL, " OR ") + ")"
 		} else {
-			querySQL += " ORDER BY book_id ASC"
+			querySQL += " AND (" + strings.Join(afterSQL, " OR ") + ")"
 		}
 	}
+	querySQL += " ORDER BY " + bookSort(keys) + limitSQL(limit, page.offset())
 
 	rows, err := c.DB.Query(querySQL, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var result BookQueryResult
 	for rows.Next() {
 		var book Book
-		err = rows.Scan(&book.BookID, &book.Category, &book.Title, &book.Press, &book.PublishYear, &book.Author, &book.Price, &book.Stock)
+		err = rows.Scan(&book.BookID, &book.Category, &book.Title, &book.Press, &book.PublishYear, &book.Author, &book.Price, &book.Stock, &book.ISBN,
+			&book.Version, &book.UpdatedAt)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
 		result.Results = append(result.Results, book)
 	}
-	result.Count = len(result.Results)
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	result.paginate(limit, keys)
+	if condition != nil && condition.Facets != nil {
+		result.Facets, err = c.bookFacets(condition)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(result.Results) == 0 {
+		return &result, nil
+	}
+
+	bookIds := make([]any, len(result.Results))
+	for i := range result.Results {
+		bookIds[i] = result.Results[i].BookID
+	}
+	contributors, err := queryContributors(c.DB, "?"+strings.Repeat(", ?", len(bookIds)-1), bookIds...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result.Results {
+		result.Results[i].Contributors = contributors[result.Results[i].BookID]
+	}
 	return &result, nil
 }
@@ -1,7 +1,7 @@
 package model
 
 import (
-	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -13,13 +13,19 @@ type Borrow struct {
 	BookID     int   `sql:"not null;primaryKey;constraint:Book.BookID,OnDelete:CASCADE,OnUpdate:CASCADE"`
 	BorrowTime int64 `sql:"not null;primaryKey"`
 	ReturnTime int64 `sql:"not null;default:0"`
+	DueTime    int64 `sql:"not null;default:0"`
+	Renewals   int   `sql:"not null;default:0"`
+	// Barcode is the copy lent, loans made before copies were tracked have none
+	Barcode string `sql:"not null;size:32;default:''"`
 }
 
 type BorrowRequest struct {
-	CardID     *int   `json:"card_id,omitempty"`
-	BookID     *int   `json:"book_id,omitempty"`
-	BorrowTime *int64 `json:"borrow_time,omitempty"`
-	ReturnTime *int64 `json:"return_time,omitempty"`
+	CardID     *int    `json:"card_id,omitempty"`
+	BookID     *int    `json:"book_id,omitempty"`
+	BorrowTime *int64  `json:"borrow_time,omitempty"`
+	ReturnTime *int64  `json:"return_time,omitempty"`
+	DueTime    *int64  `json:"due_time,omitempty"`
+	Barcode    *string `json:"barcode,omitempty"`
 }
 
 type Item struct {
@@ -33,11 +39,41 @@ type Item struct {
 	Price       myFloat `json:"price"`
 	BorrowTime  int64   `json:"borrow_time"`
 	ReturnTime  int64   `json:"return_time"`
+	DueTime     int64   `json:"due_time"`
+	Overdue     bool    `json:"overdue"`
+	Renewals    int     `json:"renewals"`
+	Barcode     string  `json:"barcode"`
+}
+
+// Overdue reports whether the book was, or is still, kept after its due time. Loans made before due times existed have none.
+func (b *Borrow) Overdue(now int64) bool {
+	if b.DueTime == 0 {
+		return false
+	}
+	if b.ReturnTime != 0 {
+		return b.ReturnTime > b.DueTime
+	}
+	return now > b.DueTime
 }
 
 type BorrowHistories struct {
+	// Count is the number of borrow records of the card, Items only holds a page of them
 	Count int    `json:"count"`
 	Items []Item `json:"items"`
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// borrowSort is the order of a borrow history, latest first
+const borrowSort = "borrow_time DESC"
+
+// paginateBorrows keeps the first limit borrow records read, and returns the cursor of the next page if more were read
+func paginateBorrows(borrows []Borrow, limit int) ([]Borrow, string) {
+	if len(borrows) <= limit {
+		return borrows, ""
+	}
+	last := borrows[limit-1]
+	return borrows[:limit], encodeCursor(borrowSort, last.BorrowTime, last.BookID)
 }
 
 func queryBookBorrow(executor SQLExecutor, bookId int) ([]Borrow, error) {
@@ -52,7 +88,7 @@ func queryBookBorrow(executor SQLExecutor, bookId int) ([]Borrow, error) {
 	var borrows []Borrow
 	for rows.Next() {
 		var borrow Borrow
-		err = rows.Scan(&borrow.CardID, &borrow.BookID, &borrow.BorrowTime, &borrow.ReturnTime)
+		err = rows.Scan(&borrow.CardID, &borrow.BookID, &borrow.BorrowTime, &borrow.ReturnTime, &borrow.DueTime, &borrow.Renewals, &borrow.Barcode)
 		if err != nil {
 			return nil, err
 		}
@@ -65,24 +101,72 @@ func (c *DatabaseConnector) QueryBookBorrow(bookId int) ([]Borrow, error) {
 	return queryBookBorrow(c.DB, bookId)
 }
 
-func QueryBookBorrowInTx(tx *sql.Tx, bookId int) ([]Borrow, error) {
+func QueryBookBorrowInTx(tx *Tx, bookId int) ([]Borrow, error) {
 	return queryBookBorrow(tx, bookId)
 }
 
+// openLoans returns how many books of each category the card has not returned
+func openLoans(executor SQLExecutor, cardId int) (map[string]int, error) {
+	rows, err := executor.Query("SELECT book.category, COUNT(*) FROM borrow JOIN book ON borrow.book_id = book.book_id "+
+		"WHERE borrow.card_id = ? AND borrow.return_time = 0 GROUP BY book.category", cardId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := make(map[string]int)
+	for rows.Next() {
+		var (
+			category string
+			count    int
+		)
+		err = rows.Scan(&category, &count)
+		if err != nil {
+			return nil, err
+		}
+		loans[category] = count
+	}
+	return loans, rows.Err()
+}
+
 func (c *DatabaseConnector) BorrowBook(borrow *Borrow) error {
 	var (
 		queryBookSQL   string
 		queryBorrowSQL string
-		updateSQL      string
+		queryCardSQL   string
 		insertSQL      string
 		args           []any
 	)
 
-	queryBookSQL = "SELECT stock FROM book WHERE book_id = ? FOR UPDATE"
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// a borrow of a copy may omit the book
+	var lent *BookCopy
+	if borrow.Barcode != "" {
+		lent, err = queryCopy(tx, borrow.Barcode, c.Dialect().LockForUpdate())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if borrow.BookID == 0 {
+			borrow.BookID = lent.BookID
+		}
+		if borrow.BookID != lent.BookID {
+			tx.Rollback()
+			return ErrCopyOfOtherBook
+		}
+	}
+
+	queryBookSQL = "SELECT stock, category FROM book WHERE book_id = ?" + c.Dialect().LockForUpdate()
 	args = append(args, borrow.BookID)
 
-	tx, err := c.DB.Begin()
+	borrowTime := time.Now().Unix()
+	_, err = expireBookHolds(tx, borrow.BookID, borrowTime)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
@@ -92,18 +176,35 @@ func (c *DatabaseConnector) BorrowBook(borrow *Borrow) error {
 		return err
 	}
 
-	var stock int
+	var (
+		stock    int
+		category string
+	)
 	if rows.Next() {
-		rows.Scan(&stock)
+		rows.Scan(&stock, &category)
 	} else {
 		tx.Rollback()
-		return errors.New("book not found")
+		return ErrBookNotFound
 	}
 	rows.Close()
 
-	if stock <= 0 {
+	// a copy set aside for a hold of the card is no longer in stock
+	var ready int
+	err = queryRow(tx, "SELECT COUNT(*) FROM hold WHERE book_id = ? AND card_id = ? AND status = ?",
+		borrow.BookID, borrow.CardID, HoldReady).Scan(&ready)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if lent != nil {
+		if lent.Status != CopyAvailable && (lent.Status != CopyOnHold || ready == 0) {
+			tx.Rollback()
+			return ErrCopyNotAvailable
+		}
+	} else if stock <= 0 && ready == 0 {
 		tx.Rollback()
-		return errors.New("stock not enough")
+		return ErrStockNotEnough
 	}
 
 	args = args[:0]
@@ -118,45 +219,119 @@ func (c *DatabaseConnector) BorrowBook(borrow *Borrow) error {
 
 	if rows.Next() {
 		tx.Rollback()
-		return errors.New("book already borrowed")
+		return ErrAlreadyBorrowed
 	}
 	rows.Close()
 
-	args = args[:0]
-	updateSQL = "UPDATE book SET stock = ? WHERE book_id = ?"
-	args = append(args, stock-1, borrow.BookID)
+	// lock the card so that concurrent loans of the card count each other against its limits
+	queryCardSQL = "SELECT type FROM card WHERE card_id = ?" + c.Dialect().LockForUpdate()
+
+	rows, err = tx.Query(queryCardSQL, borrow.CardID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var cardType string
+	if rows.Next() {
+		rows.Scan(&cardType)
+	} else {
+		tx.Rollback()
+		return ErrCardNotFound
+	}
+	rows.Close()
+
+	balance, err := fineBalance(tx, borrow.CardID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if CurrentFinePolicy().Blocks(balance) {
+		tx.Rollback()
+		return ErrFinesOutstanding
+	}
+
+	loans, err := openLoans(tx, borrow.CardID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = CurrentLoanPolicy().CheckLimits(cardType, category, loans)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	due, err := dueTime(borrow, borrowTime, cardType, category)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	_, err = tx.Exec(updateSQL, args...)
+	barcode, err := lendCopy(tx, borrow.BookID, lent, ready > 0)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE hold SET status = ? WHERE book_id = ? AND card_id = ? AND status IN (?, ?)",
+		HoldFulfilled, borrow.BookID, borrow.CardID, HoldWaiting, HoldReady)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	args = args[:0]
-	insertSQL = "INSERT INTO borrow (card_id, book_id, borrow_time, return_time) VALUES (?, ?, ?, ?)"
-	args = append(args, borrow.CardID, borrow.BookID, time.Now().Unix(), 0)
+	insertSQL = "INSERT INTO borrow (card_id, book_id, borrow_time, return_time, due_time, barcode) VALUES (?, ?, ?, ?, ?, ?)"
+	args = append(args, borrow.CardID, borrow.BookID, borrowTime, 0, due, barcode)
 
 	_, err = tx.Exec(insertSQL, args...)
+	if err != nil {
+		tx.Rollback()
+		err = c.Dialect().ConstraintError(err)
+		if errors.Is(err, ErrDuplicate) {
+			return ErrDuplicateBorrow
+		}
+		if errors.Is(err, ErrNotFound) {
+			return ErrCardNotFound
+		}
+		return err
+	}
+	loan := Borrow{CardID: borrow.CardID, BookID: borrow.BookID, BorrowTime: borrowTime, DueTime: due, Barcode: barcode}
+	err = c.writeAudit(tx, "borrow", AuditBorrow, borrowEntityID(&loan), nil, &loan)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
 	err = tx.Commit()
-	return err
+	if err != nil {
+		return err
+	}
+	borrow.Barcode = barcode
+	return nil
 }
 
+// ReturnBook closes the open loan of borrow.Barcode, or of borrow.BookID by borrow.CardID
 func (c *DatabaseConnector) ReturnBook(borrow *Borrow) error {
 	var (
 		querySQL        string
 		updateBorrowSQL string
-		updateBookSQL   string
 		args            []any
 	)
 
-	querySQL =
-		"SELECT * FROM borrow WHERE book_id = ? AND card_id = ? AND return_time = 0 LOCK IN SHARE MODE"
-	args = append(args, borrow.BookID, borrow.CardID)
+	if borrow.Barcode != "" {
+		querySQL = "SELECT * FROM borrow WHERE barcode = ? AND return_time = 0"
+		args = append(args, borrow.Barcode)
+		if borrow.CardID != 0 {
+			querySQL += " AND card_id = ?"
+			args = append(args, borrow.CardID)
+		}
+	} else {
+		querySQL = "SELECT * FROM borrow WHERE book_id = ? AND card_id = ? AND return_time = 0"
+		args = append(args, borrow.BookID, borrow.CardID)
+	}
+	querySQL += c.Dialect().LockForShare()
 	logrus.Info(querySQL, args)
 
 	tx, err := c.DB.Begin()
@@ -172,14 +347,23 @@ func (c *DatabaseConnector) ReturnBook(borrow *Borrow) error {
 
 	if !rows.Next() {
 		tx.Rollback()
-		return errors.New("book not borrowed")
+		return ErrBookNotBorrowed
+	}
+	var loan Borrow
+	err = rows.Scan(&loan.CardID, &loan.BookID, &loan.BorrowTime, &loan.ReturnTime, &loan.DueTime, &loan.Renewals, &loan.Barcode)
+	if err != nil {
+		rows.Close()
+		tx.Rollback()
+		return err
 	}
 	rows.Close()
+	before := loan
+	loan.ReturnTime = time.Now().Unix()
 
 	args = args[:0]
 	updateBorrowSQL =
-		"UPDATE borrow SET return_time = ? WHERE book_id = ? AND card_id = ? AND return_time = 0"
-	args = append(args, time.Now().Unix(), borrow.BookID, borrow.CardID)
+		"UPDATE borrow SET return_time = ? WHERE card_id = ? AND book_id = ? AND borrow_time = ?"
+	args = append(args, loan.ReturnTime, loan.CardID, loan.BookID, loan.BorrowTime)
 
 	_, err = tx.Exec(updateBorrowSQL, args...)
 	if err != nil {
@@ -187,11 +371,32 @@ func (c *DatabaseConnector) ReturnBook(borrow *Borrow) error {
 		return err
 	}
 
-	args = args[:0]
-	updateBookSQL = "UPDATE book SET stock = stock + 1 WHERE book_id = ?"
-	args = append(args, borrow.BookID)
+	err = returnCopy(tx, c.Dialect(), &loan)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = promoteHolds(tx, loan.BookID, loan.ReturnTime)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	_, err = tx.Exec(updateBookSQL, args...)
+	var cardType string
+	err = queryRow(tx, "SELECT type FROM card WHERE card_id = ?", loan.CardID).Scan(&cardType)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if charge := overdueCharge(&loan, cardType); charge != nil {
+		err = insertFine(tx, c.Dialect(), charge)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = c.writeAudit(tx, "return", AuditBorrow, borrowEntityID(&loan), &before, &loan)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -201,13 +406,110 @@ func (c *DatabaseConnector) ReturnBook(borrow *Borrow) error {
 	return err
 }
 
-func (c *DatabaseConnector) ShowBorrowHistory(cardId int) (*BorrowHistories, error) {
+// RenewBook extends the open loan of borrow.BookID by borrow.CardID, borrow is set to the renewed loan
+func (c *DatabaseConnector) RenewBook(borrow *Borrow) error {
+	querySQL := "SELECT * FROM borrow WHERE book_id = ? AND card_id = ? AND return_time = 0" + c.Dialect().LockForUpdate()
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(querySQL, borrow.BookID, borrow.CardID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if !rows.Next() {
+		rows.Close()
+		tx.Rollback()
+		return ErrBookNotBorrowed
+	}
+	var loan Borrow
+	err = rows.Scan(&loan.CardID, &loan.BookID, &loan.BorrowTime, &loan.ReturnTime, &loan.DueTime, &loan.Renewals, &loan.Barcode)
+	if err != nil {
+		rows.Close()
+		tx.Rollback()
+		return err
+	}
+	rows.Close()
+
+	var (
+		cardType string
+		category string
+	)
+	err = queryRow(tx, "SELECT type FROM card WHERE card_id = ?", borrow.CardID).Scan(&cardType)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = queryRow(tx, "SELECT category FROM book WHERE book_id = ?", borrow.BookID).Scan(&category)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	due, err := CurrentLoanPolicy().Renew(&loan, time.Now().Unix(), cardType, category)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var holds int
+	err = queryRow(tx, "SELECT COUNT(*) FROM hold WHERE book_id = ? AND card_id <> ? AND status IN (?, ?)",
+		borrow.BookID, borrow.CardID, HoldWaiting, HoldReady).Scan(&holds)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if holds > 0 {
+		tx.Rollback()
+		return ErrBookOnHold
+	}
+
+	before := loan
+	loan.DueTime = due
+	loan.Renewals++
+	_, err = tx.Exec("UPDATE borrow SET due_time = ?, renewals = ? WHERE card_id = ? AND book_id = ? AND borrow_time = ?",
+		loan.DueTime, loan.Renewals, loan.CardID, loan.BookID, loan.BorrowTime)
+	if err == nil {
+		err = c.writeAudit(tx, "renew", AuditBorrow, borrowEntityID(&loan), &before, &loan)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	*borrow = loan
+	return nil
+}
+
+func (c *DatabaseConnector) ShowBorrowHistory(cardId int, page *Page) (*BorrowHistories, error) {
 	var (
 		queryCardSQL   string
 		queryBorrowSQL string
 		queryBookSQL   string
+		args           []any
 	)
 
+	limit, err := page.check()
+	if err != nil {
+		return nil, err
+	}
+	after, err := page.after(borrowSort)
+	if err != nil {
+		return nil, err
+	}
+	var borrowTime int64
+	if after != nil && json.Unmarshal(after.Key, &borrowTime) != nil {
+		return nil, ErrInvalidCursor
+	}
+
 	queryCardSQL = "SELECT * FROM card WHERE card_id = ?"
 
 	tx, err := c.DB.Begin()
@@ -223,13 +525,26 @@ func (c *DatabaseConnector) ShowBorrowHistory(cardId int) (*BorrowHistories, err
 
 	if !rows.Next() {
 		tx.Rollback()
-		return nil, errors.New("card not found")
+		return nil, ErrCardNotFound
 	}
 	rows.Close()
 
-	queryBorrowSQL = "SELECT * FROM borrow WHERE card_id = ? ORDER BY borrow_time DESC, book_id ASC"
+	var count int
+	err = queryRow(tx, "SELECT COUNT(*) FROM borrow WHERE card_id = ?", cardId).Scan(&count)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 
-	rows, err = tx.Query(queryBorrowSQL, cardId)
+	queryBorrowSQL = "SELECT * FROM borrow WHERE card_id = ?"
+	args = append(args, cardId)
+	if after != nil {
+		queryBorrowSQL += " AND (borrow_time < ? OR (borrow_time = ? AND book_id > ?))"
+		args = append(args, borrowTime, borrowTime, after.ID)
+	}
+	queryBorrowSQL += " ORDER BY borrow_time DESC, book_id ASC" + limitSQL(limit, page.offset())
+
+	rows, err = tx.Query(queryBorrowSQL, args...)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
@@ -239,7 +554,7 @@ func (c *DatabaseConnector) ShowBorrowHistory(cardId int) (*BorrowHistories, err
 
 	for rows.Next() {
 		var borrow Borrow
-		err = rows.Scan(&borrow.CardID, &borrow.BookID, &borrow.BorrowTime, &borrow.ReturnTime)
+		err = rows.Scan(&borrow.CardID, &borrow.BookID, &borrow.BorrowTime, &borrow.ReturnTime, &borrow.DueTime, &borrow.Renewals, &borrow.Barcode)
 		if err != nil {
 			tx.Rollback()
 			return nil, err
@@ -248,11 +563,13 @@ func (c *DatabaseConnector) ShowBorrowHistory(cardId int) (*BorrowHistories, err
 	}
 	rows.Close()
 
+	now := time.Now().Unix()
 	histories := BorrowHistories{
-		Count: 0,
+		Count: count,
 		Items: make([]Item, 0),
 	}
-	queryBookSQL = "SELECT * FROM book WHERE book_id = ? LOCK IN SHARE MODE"
+	borrows, histories.NextCursor = paginateBorrows(borrows, limit)
+	queryBookSQL = "SELECT * FROM book WHERE book_id = ?" + c.Dialect().LockForShare()
 	stmt, err := tx.Prepare(queryBookSQL)
 	if err != nil {
 		tx.Rollback()
@@ -278,6 +595,9 @@ func (c *DatabaseConnector) ShowBorrowHistory(cardId int) (*BorrowHistories, err
 				&book.Author,
 				&book.Price,
 				&book.Stock,
+				&book.ISBN,
+				&book.Version,
+				&book.UpdatedAt,
 			)
 			if err != nil {
 				tx.Rollback()
@@ -285,11 +605,10 @@ func (c *DatabaseConnector) ShowBorrowHistory(cardId int) (*BorrowHistories, err
 			}
 		} else {
 			tx.Rollback()
-			return nil, errors.New("book not found")
+			return nil, ErrBookNotFound
 		}
 		bookRows.Close()
 
-		histories.Count++
 		histories.Items = append(histories.Items, Item{
 			CardID:      cardId,
 			BookID:      book.BookID,
@@ -301,6 +620,10 @@ func (c *DatabaseConnector) ShowBorrowHistory(cardId int) (*BorrowHistories, err
 			Price:       book.Price,
 			BorrowTime:  borrow.BorrowTime,
 			ReturnTime:  borrow.ReturnTime,
+			DueTime:     borrow.DueTime,
+			Overdue:     borrow.Overdue(now),
+			Renewals:    borrow.Renewals,
+			Barcode:     borrow.Barcode,
 		})
 	}
 
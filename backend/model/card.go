@@ -1,17 +1,50 @@
 package model
 
-import "errors"
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
 
 type Card struct {
 	CardID     int    `json:"card_id" sql:"not null;autoIncrement;primaryKey"`
 	Name       string `json:"name" sql:"not null;size:63;unique:card_unique"`
 	Department string `json:"department" sql:"not null;size:63;unique:card_unique"`
 	Type       string `json:"type" sql:"not null;char:1;unique:card_unique;check:type in ('T', 'S')"`
+	// Version counts the changes of the card, UpdatedAt is the time of the last one
+	Version   int   `json:"version" sql:"not null;default:1"`
+	UpdatedAt int64 `json:"updated_at" sql:"not null;default:0"`
+	// Loans and Quotas are only set by QueryCard
+	Loans  *int        `json:"loans,omitempty" sql:"-"`
+	Quotas []CardQuota `json:"quotas,omitempty" sql:"-"`
+}
+
+// CardQuota is how many more books a card may borrow, of Category or of any category if it is empty
+type CardQuota struct {
+	Category  string `json:"category,omitempty"`
+	Limit     int    `json:"limit"`
+	Loans     int    `json:"loans"`
+	Remaining int    `json:"remaining"`
 }
 
 type CardList struct {
+	// Count is the number of cards, Cards only holds a page of them
 	Count int    `json:"count"`
 	Cards []Card `json:"cards"`
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// cardSort is the order of the card list
+const cardSort = "card_id ASC"
+
+// paginate keeps the first limit cards read, and sets the cursor of the next page if more were read
+func (l *CardList) paginate(limit int) {
+	if len(l.Cards) <= limit {
+		return
+	}
+	l.Cards = l.Cards[:limit]
+	l.NextCursor = encodeCursor(cardSort, nil, l.Cards[limit-1].CardID)
 }
 
 type CardCreateRequest struct {
@@ -20,9 +53,47 @@ type CardCreateRequest struct {
 	Type       *string `json:"type,omitempty"`
 }
 
+// CardPatch changes the fields of a card it sets
+type CardPatch struct {
+	Name       *string `json:"name,omitempty"`
+	Department *string `json:"department,omitempty"`
+	Type       *string `json:"type,omitempty"`
+	// Version is the version of the card the patch was made from, the patch fails with ErrStaleCard if the card
+	// changed since. A patch without it always applies.
+	Version *int `json:"version,omitempty"`
+}
+
+// apply checks the version of the card and changes it by the patch
+func (p *CardPatch) apply(card *Card) error {
+	if p.Version != nil && *p.Version != card.Version {
+		return ErrStaleCard
+	}
+	if p.Name != nil {
+		card.Name = *p.Name
+	}
+	if p.Department != nil {
+		card.Department = *p.Department
+	}
+	if p.Type != nil {
+		card.Type = *p.Type
+	}
+	card.Version++
+	card.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+func (card *Card) setQuotas(loans map[string]int) {
+	total := 0
+	for _, count := range loans {
+		total += count
+	}
+	card.Loans = &total
+	card.Quotas = CurrentLoanPolicy().Quotas(card.Type, loans)
+}
+
 func (c *DatabaseConnector) RegisterCard(card *Card) error {
 	if card == nil {
-		return errors.New("card is nil")
+		return ErrNilCard
 	}
 
 	var (
@@ -30,23 +101,114 @@ func (c *DatabaseConnector) RegisterCard(card *Card) error {
 		args      []any
 	)
 
-	insertSQL = "INSERT INTO card (name, department, type) VALUES (?, ?, ?)"
-	args = append(args, card.Name, card.Department, card.Type)
+	card.Version, card.UpdatedAt = 1, time.Now().Unix()
+	insertSQL = "INSERT INTO card (name, department, type, version, updated_at) VALUES (?, ?, ?, ?, ?)"
+	args = append(args, card.Name, card.Department, card.Type, card.Version, card.UpdatedAt)
 
-	result, err := c.DB.Exec(insertSQL, args...)
+	tx, err := c.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	insertedID, err := result.LastInsertId()
+	insertedID, err := c.Dialect().InsertReturningID(tx, insertSQL, "card_id", args...)
 	if err != nil {
+		tx.Rollback()
+		err = c.Dialect().ConstraintError(err)
+		if errors.Is(err, ErrDuplicate) {
+			return ErrDuplicateCard
+		}
+		if errors.Is(err, ErrValidation) {
+			return ErrInvalidCardType
+		}
+		return err
+	}
+	created := *card
+	created.CardID = int(insertedID)
+	err = c.writeAudit(tx, "create", AuditCard, created.CardID, nil, &created)
+	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
 	card.CardID = int(insertedID)
 	return nil
 }
 
+// queryCardRow returns the row of a card
+func queryCardRow(executor SQLExecutor, cardId int, lock string) (*Card, error) {
+	var card Card
+	err := queryRow(executor, "SELECT * FROM card WHERE card_id = ?"+lock, cardId).
+		Scan(&card.CardID, &card.Name, &card.Department, &card.Type, &card.Version, &card.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+func (c *DatabaseConnector) PatchCard(cardId int, patch *CardPatch) (*Card, error) {
+	if patch == nil {
+		return nil, ErrNilPatch
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := queryCardRow(tx, cardId, c.Dialect().LockForUpdate())
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	card := *existing
+	version := card.Version
+	err = patch.apply(&card)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// the version is checked again for the databases that do not lock the row
+	result, err := tx.Exec("UPDATE card SET name = ?, department = ?, type = ?, version = ?, updated_at = ? WHERE card_id = ? AND version = ?",
+		card.Name, card.Department, card.Type, card.Version, card.UpdatedAt, cardId, version)
+	if err != nil {
+		tx.Rollback()
+		err = c.Dialect().ConstraintError(err)
+		if errors.Is(err, ErrDuplicate) {
+			return nil, ErrDuplicateCard
+		}
+		if errors.Is(err, ErrValidation) {
+			return nil, ErrInvalidCardType
+		}
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err == nil && affected == 0 {
+		err = ErrStaleCard
+	}
+	if err == nil {
+		err = c.writeAudit(tx, "update", AuditCard, cardId, existing, &card)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
 func (c *DatabaseConnector) RemoveCard(cardId int) error {
 	var (
 		querySQL  string
@@ -68,10 +230,52 @@ func (c *DatabaseConnector) RemoveCard(cardId int) error {
 
 	if rows.Next() {
 		tx.Rollback()
-		return errors.New("card has not returned all books")
+		return ErrCardHasBorrows
+	}
+	rows.Close()
+
+	balance, err := fineBalance(tx, cardId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if balance > 0 {
+		tx.Rollback()
+		return ErrCardHasFines
+	}
+
+	rows, err = tx.Query("SELECT DISTINCT book_id FROM hold WHERE card_id = ? AND status IN (?, ?)", cardId, HoldWaiting, HoldReady)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var heldBooks []int
+	for rows.Next() {
+		var bookId int
+		rows.Scan(&bookId)
+		heldBooks = append(heldBooks, bookId)
 	}
 	rows.Close()
 
+	now := time.Now().Unix()
+	for _, bookId := range heldBooks {
+		_, err = releaseHolds(tx, bookId, HoldCancelled, now, "card_id = ?", cardId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// removing a card that does not exist changes nothing, and is not recorded
+	before, err := queryCardRow(tx, cardId, c.Dialect().LockForUpdate())
+	if err == nil {
+		err = c.writeAudit(tx, "remove", AuditCard, cardId, before, nil)
+	}
+	if err != nil && !errors.Is(err, ErrCardNotFound) {
+		tx.Rollback()
+		return err
+	}
+
 	deleteSQL = "DELETE FROM card WHERE card_id = ?"
 
 	_, err = tx.Exec(deleteSQL, cardId)
@@ -84,28 +288,52 @@ func (c *DatabaseConnector) RemoveCard(cardId int) error {
 	return err
 }
 
-func (c *DatabaseConnector) ShowCards() (*CardList, error) {
-	querySQL := "SELECT * FROM card ORDER BY card_id"
+func (c *DatabaseConnector) ShowCards(page *Page) (*CardList, error) {
+	limit, err := page.check()
+	if err != nil {
+		return nil, err
+	}
+	after, err := page.after(cardSort)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = queryRow(c.DB, "SELECT COUNT(*) FROM card").Scan(&count)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := c.DB.Query(querySQL)
+	querySQL := "SELECT * FROM card"
+	var args []any
+	if after != nil {
+		querySQL += " WHERE card_id > ?"
+		args = append(args, after.ID)
+	}
+	querySQL += " ORDER BY card_id" + limitSQL(limit, page.offset())
+
+	rows, err := c.DB.Query(querySQL, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var cards []Card
 	for rows.Next() {
 		var card Card
-		err := rows.Scan(&card.CardID, &card.Name, &card.Department, &card.Type)
+		err := rows.Scan(&card.CardID, &card.Name, &card.Department, &card.Type, &card.Version, &card.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
 		cards = append(cards, card)
 	}
 
-	return &CardList{
-		Count: len(cards),
+	list := CardList{
+		Count: count,
 		Cards: cards,
-	}, nil
+	}
+	list.paginate(limit)
+	return &list, rows.Err()
 }
 
 func (c *DatabaseConnector) QueryCard(cardId int) (*Card, error) {
@@ -114,10 +342,19 @@ func (c *DatabaseConnector) QueryCard(cardId int) (*Card, error) {
 	row := c.DB.QueryRow(querySQL, cardId)
 
 	var card Card
-	err := row.Scan(&card.CardID, &card.Name, &card.Department, &card.Type)
+	err := row.Scan(&card.CardID, &card.Name, &card.Department, &card.Type, &card.Version, &card.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	loans, err := openLoans(c.DB, cardId)
 	if err != nil {
 		return nil, err
 	}
+	card.setQuotas(loans)
 
 	return &card, nil
 }
@@ -1,30 +1,116 @@
 package model
 
 import (
-	"LibManSys/utils"
 	"database/sql"
-	"errors"
+	"encoding/json"
 	"fmt"
-	"reflect"
-	"strconv"
-	"strings"
-	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+type Connector interface {
+	Connect() error
+	Close() error
+	AutoMigrate(args ...any) error
+	Migrate() error
+	// ResetDatabase clears the tables and the ones referencing them, or recreates all the tables not kept on reset
+	// if none is given
+	ResetDatabase(tables ...string) error
+
+	StoreBook(book *Book) error
+	IncBookStock(bookId int, deltaStock int) error
+	StoreBooks(books []Book) error
+	RemoveBook(bookId int) error
+	ModifyBookInfo(book *Book) error
+	PatchBook(bookId int, patch *BookPatch) (*Book, error)
+	QueryBook(condition *BookQueryConditions) (*BookQueryResult, error)
+	ShowCopies(bookId int) (*CopyList, error)
+	AddCopies(bookId int, copies []BookCopy) error
+	WithdrawCopies(barcodes []string) error
+	UpdateCopy(bookCopy *BookCopy) error
+	ShowAuthors(name string) (*AuthorList, error)
+	ShowAuthorWorks(authorId int) (*AuthorWorks, error)
+	BorrowBook(borrow *Borrow) error
+	ReturnBook(borrow *Borrow) error
+	RenewBook(borrow *Borrow) error
+	ShowBorrowHistory(cardId int, page *Page) (*BorrowHistories, error)
+	RegisterCard(card *Card) error
+	QueryCard(cardId int) (*Card, error)
+	PatchCard(cardId int, patch *CardPatch) (*Card, error)
+	RemoveCard(cardId int) error
+	ShowCards(page *Page) (*CardList, error)
+	ShowFines(cardId int) (*FineLedger, error)
+	SettleFine(entry *FineEntry) error
+	PlaceHold(hold *Hold) error
+	CancelHold(holdId int) error
+	ShowCardHolds(cardId int) (*HoldList, error)
+	ShowBookHolds(bookId int) (*HoldList, error)
+	ExpireHolds() (int, error)
+
+	CreateUser(user *User) error
+	// BootstrapUser creates the user only if there are no users yet, failing with ErrUsersExist otherwise
+	BootstrapUser(user *User) error
+	QueryUser(userId int) (*User, error)
+	QueryUserByName(username string) (*User, error)
+	ShowUsers() (*UserList, error)
+	SetUserRole(userId int, role UserRole) error
+	SetUserPassword(userId int, passwordHash string) error
+	RemoveUser(userId int) error
+	CreateSession(session *UserSession) error
+	// QuerySession returns the user of the unexpired session with the token hash
+	QuerySession(tokenHash string) (*User, error)
+	RemoveSession(tokenHash string) error
+
+	// SetCardPIN sets the PIN of the patron of a card and ends the sessions of the card
+	SetCardPIN(cardId int, pinHash string) error
+	QueryCardCredential(cardId int) (*CardCredential, error)
+	CreatePatronSession(session *PatronSession) error
+	// QueryPatronSession returns the card of the unexpired patron session with the token hash
+	QueryPatronSession(tokenHash string) (int, error)
+	RemovePatronSession(tokenHash string) error
+
+	// WithAudit returns a connector to the same storage recording the changes it makes as made in the context
+	WithAudit(audit *AuditContext) Connector
+	ShowAudit(query *AuditQuery) (*AuditList, error)
+
+	// Export passes the rows of the archived tables to write table by table in the order of Tables, all read at the
+	// same point in time, and returns the schema version of the rows
+	Export(write func(table string, row map[string]any) error) (int, error)
+	// Import loads the rows read into the empty storage and migrates the ones of an older schema version forward, in
+	// a single transaction failing as a whole if read or a row fails
+	Import(schemaVersion int, read func(load func(table string, row map[string]json.RawMessage) error) error) (*RestoreResult, error)
+}
+
 type ConnectConfig struct {
+	Driver   string
 	User     string
 	Password string
 	Host     string
 	Port     int
 	DBName   string
+	SSLMode  string
+	Path     string
 }
 
 type DatabaseConnector struct {
 	Config ConnectConfig
-	DB     *sql.DB
+	DB     *DB
+	// audit is who makes the changes through the connector, see WithAudit
+	audit *AuditContext
+}
+
+func NewConnector(config *ConnectConfig) Connector {
+	if config.Driver == DriverMemory {
+		return NewMemoryConnector(config.Path)
+	}
+	return &DatabaseConnector{Config: *config}
 }
 
 type SQLExecutor interface {
@@ -33,22 +119,44 @@ type SQLExecutor interface {
 }
 
 func (c *DatabaseConnector) Connect() error {
-	var err error
-	c.DB, err = sql.Open("mysql", c.Config.databaseLoginInfo())
+	dialect, err := DialectOf(c.Config.Driver)
+	if err != nil {
+		return err
+	}
+	db, err := dialect.Open(&c.Config)
 	if err != nil {
 		logrus.Panic(err)
 	}
-	c.DB.SetMaxOpenConns(2000)
-	c.DB.SetMaxIdleConns(1000)
-	c.DB.SetConnMaxLifetime(time.Minute * 60)
+	c.DB = &DB{DB: db, dialect: dialect}
 	return nil
 }
 
+func (c *DatabaseConnector) Dialect() Dialect {
+	return c.DB.dialect
+}
+
 func (c *DatabaseConnector) Close() error {
 	return c.DB.Close()
 }
 
-func (c *DatabaseConnector) ResetDatabase() error {
+// keptOnReset are the tables ResetDatabase leaves as they are, so that a reset neither locks the staff out nor erases
+// the audit log
+var keptOnReset = map[string]bool{
+	TableName(User{}):          true,
+	TableName(UserSession{}):   true,
+	TableName(UserBootstrap{}): true,
+	TableName(AuditEntry{}):    true,
+}
+
+func (c *DatabaseConnector) ResetDatabase(tables ...string) error {
+	if len(tables) != 0 {
+		return c.clearTables(tables)
+	}
+	resolved, err := ResolveResetTables(nil)
+	if err != nil {
+		return err
+	}
+
 	tx, err := c.DB.Begin()
 	if err != nil {
 		return err
@@ -56,7 +164,13 @@ func (c *DatabaseConnector) ResetDatabase() error {
 
 	dropSQL := "DROP TABLE IF EXISTS %s"
 
-	dbNames := []string{"borrow", "book", "card"}
+	dbNames := []string{TableName(SchemaMigration{})}
+	for i := len(Tables) - 1; i >= 0; i-- {
+		if keptOnReset[TableName(Tables[i])] {
+			continue
+		}
+		dbNames = append(dbNames, TableName(Tables[i]))
+	}
 	for _, dbName := range dbNames {
 		_, err := tx.Exec(fmt.Sprintf(dropSQL, dbName))
 		if err != nil {
@@ -65,12 +179,19 @@ func (c *DatabaseConnector) ResetDatabase() error {
 		}
 	}
 
-	err = AutoMigrateInTx(
-		tx,
-		Book{},
-		Card{},
-		Borrow{},
-	)
+	err = c.ensureMigrationTable(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, migration := range Migrations {
+		_, err = c.applyMigration(tx, migration, true, false)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = c.writeAudit(tx, "reset", AuditDatabase, "", nil, &ResetOptions{Tables: resolved})
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -80,266 +201,28 @@ func (c *DatabaseConnector) ResetDatabase() error {
 	return err
 }
 
-func (config *ConnectConfig) databaseLoginInfo() string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-		config.User,
-		config.Password,
-		config.Host,
-		config.Port,
-		config.DBName,
-	)
-}
-
-func autoMigrate(executor SQLExecutor, args ...any) error {
+func autoMigrate(dialect Dialect, executor SQLExecutor, args ...any) error {
 	for _, table := range args {
-		createTableSQL := "CREATE TABLE IF NOT EXISTS "
-		uniqueFields := make(map[string][]string)
-		primaryFields := make([]string, 0)
-		checkConstraints := make([]string, 0)
-		foreignKeys := make([]string, 0)
-		createTableSQL += utils.ToSnake(reflect.ValueOf(table).Type().Name()) + " ("
-		for i := 0; i < reflect.ValueOf(table).NumField(); i++ {
-			field := reflect.ValueOf(table).Type().Field(i)
-			createTableSQL += utils.ToSnake(field.Name) + " " + getType(field)
-			tag := field.Tag.Get("sql")
-			tags := strings.Split(tag, ";")
-			for _, t := range tags {
-				if t == "not null" {
-					createTableSQL += " NOT NULL"
-				}
-				if t == "autoIncrement" {
-					createTableSQL += " AUTO_INCREMENT"
-				}
-				if t == "unique" {
-					createTableSQL += " UNIQUE"
-				}
-				if strings.HasPrefix(t, "default:") {
-					createTableSQL += " DEFAULT " + strings.TrimPrefix(t, "default:")
-				}
-				if strings.HasPrefix(t, "check:") {
-					checkConstraint := "CHECK(" + strings.TrimPrefix(t, "check:") + ")"
-					checkConstraints = append(checkConstraints, checkConstraint)
-				}
-				if strings.HasPrefix(t, "constraint:") {
-					foreignKey := ""
-					constraints := strings.Split(strings.TrimPrefix(t, "constraint:"), ",")
-					if len(constraints) == 0 {
-						logrus.WithField("field", reflect.ValueOf(table).Type().Name()+"."+field.Name).
-							Fatal("empty constraint")
-						return errors.New("empty constraint")
-					}
-					references := strings.Split(constraints[0], ".")
-					if len(references) != 2 {
-						logrus.WithField("reference", references).Fatal("invalid reference")
-						return errors.New("invalid reference")
-					}
-					foreignKey = "FOREIGN KEY (" + utils.ToSnake(field.Name) + ") REFERENCES " + utils.ToSnake(references[0]) + "(" + utils.ToSnake(references[1]) + ")"
-					for _, val := range constraints {
-						if strings.HasPrefix(val, "OnUpdate:") {
-							foreignKey += " ON UPDATE " + strings.TrimPrefix(val, "OnUpdate:")
-						}
-						if strings.HasPrefix(val, "OnDelete:") {
-							foreignKey += " ON DELETE " + strings.TrimPrefix(val, "OnDelete:")
-						}
-					}
-					foreignKeys = append(foreignKeys, foreignKey)
-				}
-				if strings.HasPrefix(t, "unique:") {
-					name := strings.TrimPrefix(t, "unique:")
-					if _, ok := uniqueFields[name]; !ok {
-						uniqueFields[name] = make([]string, 0)
-					}
-					uniqueFields[name] = append(uniqueFields[name], utils.ToSnake(field.Name))
-				}
-				if t == "primaryKey" {
-					primaryFields = append(primaryFields, utils.ToSnake(field.Name))
-				}
-			}
-			if i != reflect.ValueOf(table).NumField()-1 {
-				createTableSQL += ", "
-			}
-		}
-		for name, fields := range uniqueFields {
-			createTableSQL += ", CONSTRAINT " + name + " UNIQUE("
-			for i, field := range fields {
-				createTableSQL += field
-				if i != len(fields)-1 {
-					createTableSQL += ", "
-				}
-			}
-			createTableSQL += ")"
-		}
-		if len(primaryFields) != 0 {
-			createTableSQL += ", PRIMARY KEY("
-			for i, field := range primaryFields {
-				createTableSQL += field
-				if i != len(primaryFields)-1 {
-					createTableSQL += ", "
-				}
-			}
-			createTableSQL += ")"
-		}
-		if len(checkConstraints) != 0 {
-			for _, constraint := range checkConstraints {
-				createTableSQL += ", " + constraint
-			}
-		}
-		if len(foreignKeys) != 0 {
-			for _, foreignKey := range foreignKeys {
-				createTableSQL += ", " + foreignKey
-			}
-		}
-		createTableSQL += ") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;"
-		_, err := executor.Exec(createTableSQL)
+		schema, err := ParseTable(table)
 		if err != nil {
 			logrus.Error(err)
 			return err
 		}
+		for _, statement := range schema.CreateSQL(dialect) {
+			_, err = executor.Exec(statement)
+			if err != nil {
+				logrus.Error(err)
+				return err
+			}
+		}
 	}
 	return nil
 }
 
 func (c *DatabaseConnector) AutoMigrate(args ...any) error {
-	return autoMigrate(c.DB, args...)
+	return autoMigrate(c.Dialect(), c.DB, args...)
 }
 
-func AutoMigrateInTx(tx *sql.Tx, args ...any) error {
-	return autoMigrate(tx, args...)
-}
-
-func getType(field reflect.StructField) string {
-	switch field.Type.Name() {
-	case "string":
-		tag := field.Tag.Get("sql")
-		tags := strings.Split(tag, ";")
-		for _, t := range tags {
-			if strings.HasPrefix(t, "size:") {
-				size, err := strconv.Atoi(strings.TrimPrefix(t, "size:"))
-				if err != nil {
-					logrus.Fatal(err)
-					return ""
-				}
-				if size <= 0 {
-					logrus.Fatal("size must be greater than 0")
-					return ""
-				} else if size > 0 && size <= 255 {
-					return "VARCHAR(" + strconv.Itoa(size) + ")"
-				} else if size > 255 && size <= 65535 {
-					return "TEXT"
-				} else if size > 65535 && size <= 16777215 {
-					return "MEDIUMTEXT"
-				} else if size > 16777215 && size <= 4294967295 {
-					return "LONGTEXT"
-				} else {
-					logrus.Fatal("size must be less than 4294967295")
-					return ""
-				}
-			}
-			if strings.HasPrefix(t, "char:") {
-				size, err := strconv.Atoi(strings.TrimPrefix(t, "char:"))
-				if err != nil {
-					logrus.Fatal(err)
-					return ""
-				}
-				if size <= 0 {
-					logrus.Fatal("size must be greater than 0")
-					return ""
-				} else if size > 0 && size <= 255 {
-					return "CHAR(" + strconv.Itoa(size) + ")"
-				} else {
-					logrus.Fatal("size must be less than 255")
-					return ""
-				}
-			}
-		}
-	case "int8":
-		return "TINYINT"
-	case "uint8":
-		return "CHAR(1)"
-	case "int16":
-		return "SMALLINT"
-	case "uint16":
-		return "SMALLINT UNSIGNED"
-	case "int":
-		return "INT"
-	case "int32":
-		return "INT"
-	case "uint":
-		return "INT UNSIGNED"
-	case "uint32":
-		return "INT UNSIGNED"
-	case "int64":
-		return "BIGINT"
-	case "uint64":
-		return "BIGINT UNSIGNED"
-	case "float32", "myFloat":
-		tag := field.Tag.Get("sql")
-		tags := strings.Split(tag, ";")
-		for _, t := range tags {
-			if strings.HasPrefix(t, "decimal:") {
-				nums := strings.Split(strings.TrimPrefix(t, "decimal:"), ",")
-				if len(nums) != 2 {
-					logrus.WithField("field", field.Name).Fatal("invalid decimal")
-					return ""
-				}
-				precision, err := strconv.Atoi(nums[0])
-				if err != nil {
-					logrus.WithField("field", field.Name).Fatal(err)
-					return ""
-				}
-				scale, err := strconv.Atoi(nums[1])
-				if err != nil {
-					logrus.WithField("field", field.Name).Fatal(err)
-					return ""
-				}
-				if precision <= 0 {
-					logrus.WithField("field", field.Name).Fatal("precision must be greater than 0")
-					return ""
-				}
-				if scale <= 0 {
-					logrus.WithField("field", field.Name).Fatal("scale must be greater than 0")
-					return ""
-				}
-				return "DECIMAL(" + strconv.Itoa(precision) + "," + strconv.Itoa(scale) + ")"
-			}
-		}
-		return "FLOAT"
-	case "float64":
-		tag := field.Tag.Get("sql")
-		tags := strings.Split(tag, ";")
-		for _, t := range tags {
-			if strings.HasPrefix(t, "decimal:") {
-				nums := strings.Split(strings.TrimPrefix(t, "decimal:"), ",")
-				if len(nums) != 2 {
-					logrus.WithField("field", field.Name).Fatal("invalid decimal")
-					return ""
-				}
-				precision, err := strconv.Atoi(nums[0])
-				if err != nil {
-					logrus.WithField("field", field.Name).Fatal(err)
-					return ""
-				}
-				scale, err := strconv.Atoi(nums[1])
-				if err != nil {
-					logrus.WithField("field", field.Name).Fatal(err)
-					return ""
-				}
-				if precision <= 0 {
-					logrus.WithField("field", field.Name).Fatal("precision must be greater than 0")
-					return ""
-				}
-				if scale <= 0 {
-					logrus.WithField("field", field.Name).Fatal("scale must be greater than 0")
-					return ""
-				}
-				return "DECIMAL(" + strconv.Itoa(precision) + "," + strconv.Itoa(scale) + ")"
-			}
-		}
-		return "DOUBLE"
-	case "time.Time":
-		return "DATETIME"
-	default:
-		return "VARCHAR(255)"
-	}
-	return "VARCHAR(255)"
+func (c *DatabaseConnector) AutoMigrateInTx(tx *Tx, args ...any) error {
+	return autoMigrate(c.Dialect(), tx, args...)
 }
@@ -0,0 +1,500 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type CopyStatus string
+
+const (
+	CopyAvailable CopyStatus = "available"
+	CopyOnLoan    CopyStatus = "on_loan"
+	// CopyOnHold is a copy set aside for a ready hold
+	CopyOnHold    CopyStatus = "on_hold"
+	CopyLost      CopyStatus = "lost"
+	CopyDamaged   CopyStatus = "damaged"
+	CopyInRepair  CopyStatus = "in_repair"
+	CopyWithdrawn CopyStatus = "withdrawn"
+)
+
+// BookCopy is a physical item of a book, Book.Stock counts the available copies of the book
+type BookCopy struct {
+	CopyID   int        `json:"copy_id" sql:"not null;autoIncrement;primaryKey"`
+	BookID   int        `json:"book_id" sql:"not null;index;constraint:Book.BookID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	Barcode  string     `json:"barcode" sql:"not null;size:32;unique"`
+	Status   CopyStatus `json:"status" sql:"not null;enum:available,on_loan,on_hold,lost,damaged,in_repair,withdrawn"`
+	Location string     `json:"location" sql:"not null;size:63;default:''"`
+}
+
+type CopyRequest struct {
+	Barcode  *string     `json:"barcode,omitempty"`
+	Status   *CopyStatus `json:"status,omitempty"`
+	Location *string     `json:"location,omitempty"`
+}
+
+type CopyAddRequest struct {
+	BookID *int          `json:"book_id,omitempty"`
+	Count  *int          `json:"count,omitempty"`
+	Copies []CopyRequest `json:"copies,omitempty"`
+}
+
+type CopyWithdrawRequest struct {
+	Barcodes []string `json:"barcodes,omitempty"`
+}
+
+type CopyList struct {
+	Count  int        `json:"count"`
+	Copies []BookCopy `json:"copies"`
+}
+
+// copyBarcode returns the barcode given to the seq-th copy of a book added without one
+func copyBarcode(bookId int, seq int) string {
+	return fmt.Sprintf("%06d-%03d", bookId, seq)
+}
+
+func validateBarcode(barcode string) error {
+	if barcode == "" || len(barcode) > 32 || strings.TrimSpace(barcode) != barcode {
+		return ErrInvalidBarcode
+	}
+	return nil
+}
+
+// validateCopyUpdate checks a copy in status from may be set to status to by a librarian, the other statuses
+// are set by loans, holds and withdrawals
+func validateCopyUpdate(from CopyStatus, to CopyStatus) error {
+	switch to {
+	case CopyAvailable, CopyLost, CopyDamaged, CopyInRepair:
+	default:
+		return ErrInvalidCopyStatus
+	}
+	if from != to && (from == CopyOnLoan || from == CopyOnHold || from == CopyWithdrawn) {
+		return ErrCopyNotAvailable
+	}
+	return nil
+}
+
+func scanCopies(rows *sql.Rows) ([]BookCopy, error) {
+	defer rows.Close()
+
+	copies := make([]BookCopy, 0)
+	for rows.Next() {
+		var bookCopy BookCopy
+		err := rows.Scan(&bookCopy.CopyID, &bookCopy.BookID, &bookCopy.Barcode, &bookCopy.Status, &bookCopy.Location)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, bookCopy)
+	}
+	return copies, rows.Err()
+}
+
+// insertCopies adds copies to a book, the copies without a barcode get a generated one
+func insertCopies(executor SQLExecutor, dialect Dialect, bookId int, copies []BookCopy) error {
+	var seq int
+	err := queryRow(executor, "SELECT COUNT(*) FROM book_copy WHERE book_id = ?", bookId).Scan(&seq)
+	if err != nil {
+		return err
+	}
+
+	insertSQL := "INSERT INTO book_copy (book_id, barcode, status, location) VALUES (?, ?, ?, ?)"
+	for i := range copies {
+		copies[i].BookID = bookId
+		if copies[i].Status == "" {
+			copies[i].Status = CopyAvailable
+		}
+		if copies[i].Barcode == "" {
+			seq++
+			copies[i].Barcode = copyBarcode(bookId, seq)
+		}
+		err = validateBarcode(copies[i].Barcode)
+		if err != nil {
+			return err
+		}
+
+		insertedID, err := dialect.InsertReturningID(executor, insertSQL, "copy_id",
+			copies[i].BookID, copies[i].Barcode, copies[i].Status, copies[i].Location)
+		if err != nil {
+			err = dialect.ConstraintError(err)
+			if errors.Is(err, ErrDuplicate) {
+				return ErrDuplicateCopy
+			}
+			return err
+		}
+		copies[i].CopyID = int(insertedID)
+	}
+	return nil
+}
+
+// moveCopies sets n copies of the book in status from to status to, the oldest copies first or the newest ones
+// first, and returns their barcodes
+func moveCopies(executor SQLExecutor, bookId int, from CopyStatus, to CopyStatus, n int, newestFirst bool) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	order := " ORDER BY copy_id"
+	if newestFirst {
+		order += " DESC"
+	}
+	rows, err := executor.Query("SELECT * FROM book_copy WHERE book_id = ? AND status = ?"+order, bookId, from)
+	if err != nil {
+		return nil, err
+	}
+	copies, err := scanCopies(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(copies) < n {
+		return nil, ErrStockNotEnough
+	}
+
+	barcodes := make([]string, 0, n)
+	for _, bookCopy := range copies[:n] {
+		_, err = executor.Exec("UPDATE book_copy SET status = ? WHERE copy_id = ?", to, bookCopy.CopyID)
+		if err != nil {
+			return nil, err
+		}
+		barcodes = append(barcodes, bookCopy.Barcode)
+	}
+	return barcodes, nil
+}
+
+// syncStock sets the stock of the book to the number of its available copies
+func syncStock(executor SQLExecutor, bookId int) error {
+	_, err := executor.Exec("UPDATE book SET stock = (SELECT COUNT(*) FROM book_copy WHERE book_id = ? AND status = ?) WHERE book_id = ?",
+		bookId, CopyAvailable, bookId)
+	return err
+}
+
+// lendCopy puts a copy of the book on loan and returns its barcode: lent if not nil, otherwise the first available
+// copy, or the first copy set aside if the borrower has a ready hold. A ready hold lending an available copy
+// gives back the copy set aside for it.
+func lendCopy(executor SQLExecutor, bookId int, lent *BookCopy, held bool) (string, error) {
+	var barcode string
+	if lent == nil {
+		from := CopyAvailable
+		if held {
+			from = CopyOnHold
+		}
+		barcodes, err := moveCopies(executor, bookId, from, CopyOnLoan, 1, false)
+		if err != nil {
+			return "", err
+		}
+		barcode = barcodes[0]
+	} else {
+		_, err := executor.Exec("UPDATE book_copy SET status = ? WHERE copy_id = ?", CopyOnLoan, lent.CopyID)
+		if err != nil {
+			return "", err
+		}
+		if held && lent.Status == CopyAvailable {
+			_, err = moveCopies(executor, bookId, CopyOnHold, CopyAvailable, 1, false)
+			if err != nil {
+				return "", err
+			}
+		}
+		barcode = lent.Barcode
+	}
+	return barcode, syncStock(executor, bookId)
+}
+
+// returnCopy makes the copy of a closed loan available again, a loan made before copies were tracked brings
+// a new copy
+func returnCopy(executor SQLExecutor, dialect Dialect, loan *Borrow) error {
+	if loan.Barcode == "" {
+		err := insertCopies(executor, dialect, loan.BookID, make([]BookCopy, 1))
+		if err != nil {
+			return err
+		}
+	} else {
+		_, err := executor.Exec("UPDATE book_copy SET status = ? WHERE barcode = ?", CopyAvailable, loan.Barcode)
+		if err != nil {
+			return err
+		}
+	}
+	return syncStock(executor, loan.BookID)
+}
+
+func queryCopy(executor SQLExecutor, barcode string, lock string) (*BookCopy, error) {
+	rows, err := executor.Query("SELECT * FROM book_copy WHERE barcode = ?"+lock, barcode)
+	if err != nil {
+		return nil, err
+	}
+	copies, err := scanCopies(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(copies) == 0 {
+		return nil, ErrCopyNotFound
+	}
+	return &copies[0], nil
+}
+
+// backfillCopies creates the copies of the books stored before copies were tracked: one per book in stock, on
+// loan or set aside for a hold
+func backfillCopies(dialect Dialect, executor SQLExecutor) ([]string, error) {
+	statements := make([]string, 0)
+	for _, table := range []string{"book", "borrow", "hold"} {
+		schema, err := dialect.DescribeTable(executor, table)
+		if err != nil {
+			return nil, err
+		}
+		if schema == nil {
+			// nothing to backfill in a database that does not have the tables yet
+			return statements, nil
+		}
+	}
+
+	rows, err := executor.Query("SELECT book_id, stock FROM book ORDER BY book_id")
+	if err != nil {
+		return nil, err
+	}
+	stocks := make(map[int]int)
+	var bookIds []int
+	for rows.Next() {
+		var bookId, stock int
+		err = rows.Scan(&bookId, &stock)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bookIds = append(bookIds, bookId)
+		stocks[bookId] = stock
+	}
+	rows.Close()
+
+	insertSQL := "INSERT INTO book_copy (book_id, barcode, status, location) VALUES (%d, '%s', '%s', '')"
+	for _, bookId := range bookIds {
+		seq := 0
+		add := func(status CopyStatus) string {
+			seq++
+			barcode := copyBarcode(bookId, seq)
+			statements = append(statements, fmt.Sprintf(insertSQL, bookId, barcode, status))
+			return barcode
+		}
+
+		for i := 0; i < stocks[bookId]; i++ {
+			add(CopyAvailable)
+		}
+
+		rows, err = executor.Query("SELECT card_id, borrow_time FROM borrow WHERE book_id = ? AND return_time = 0 ORDER BY borrow_time", bookId)
+		if err != nil {
+			return nil, err
+		}
+		var loans []Borrow
+		for rows.Next() {
+			var loan Borrow
+			err = rows.Scan(&loan.CardID, &loan.BorrowTime)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			loans = append(loans, loan)
+		}
+		rows.Close()
+		for _, loan := range loans {
+			barcode := add(CopyOnLoan)
+			statements = append(statements, fmt.Sprintf("UPDATE borrow SET barcode = '%s' WHERE card_id = %d AND book_id = %d AND borrow_time = %d",
+				barcode, loan.CardID, bookId, loan.BorrowTime))
+		}
+
+		var ready int
+		err = queryRow(executor, "SELECT COUNT(*) FROM hold WHERE book_id = ? AND status = ?", bookId, HoldReady).Scan(&ready)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < ready; i++ {
+			add(CopyOnHold)
+		}
+	}
+	return statements, nil
+}
+
+func (c *DatabaseConnector) ShowCopies(bookId int) (*CopyList, error) {
+	var count int
+	err := queryRow(c.DB, "SELECT COUNT(*) FROM book WHERE book_id = ?", bookId).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrBookNotFound
+	}
+
+	rows, err := c.DB.Query("SELECT * FROM book_copy WHERE book_id = ? ORDER BY copy_id", bookId)
+	if err != nil {
+		return nil, err
+	}
+	copies, err := scanCopies(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &CopyList{Count: len(copies), Copies: copies}, nil
+}
+
+// AddCopies adds copies to a book, the barcodes and locations of copies are read and their ids are set
+func (c *DatabaseConnector) AddCopies(bookId int, copies []BookCopy) error {
+	if len(copies) == 0 {
+		return ErrEmptyCopies
+	}
+	for i := range copies {
+		copies[i].Status = CopyAvailable
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT book_id FROM book WHERE book_id = ?"+c.Dialect().LockForUpdate(), bookId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !rows.Next() {
+		rows.Close()
+		tx.Rollback()
+		return ErrBookNotFound
+	}
+	rows.Close()
+
+	err = insertCopies(tx, c.Dialect(), bookId, copies)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i := range copies {
+		err = c.writeAudit(tx, "create", AuditCopy, copies[i].Barcode, nil, &copies[i])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = syncStock(tx, bookId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = promoteHolds(tx, bookId, time.Now().Unix())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// WithdrawCopies takes copies out of the collection, copies on loan or set aside for a hold cannot be withdrawn
+func (c *DatabaseConnector) WithdrawCopies(barcodes []string) error {
+	if len(barcodes) == 0 {
+		return ErrEmptyCopies
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	books := make(map[int]bool)
+	for _, barcode := range barcodes {
+		bookCopy, err := queryCopy(tx, barcode, c.Dialect().LockForUpdate())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if bookCopy.Status == CopyOnLoan || bookCopy.Status == CopyOnHold || bookCopy.Status == CopyWithdrawn {
+			tx.Rollback()
+			return ErrCopyNotAvailable
+		}
+
+		_, err = tx.Exec("UPDATE book_copy SET status = ? WHERE copy_id = ?", CopyWithdrawn, bookCopy.CopyID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		withdrawn := *bookCopy
+		withdrawn.Status = CopyWithdrawn
+		err = c.writeAudit(tx, "withdraw", AuditCopy, bookCopy.Barcode, bookCopy, &withdrawn)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		books[bookCopy.BookID] = true
+	}
+
+	for bookId := range books {
+		err = syncStock(tx, bookId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// UpdateCopy sets the status and location of the copy with bookCopy.Barcode, bookCopy is set to the updated copy
+func (c *DatabaseConnector) UpdateCopy(bookCopy *BookCopy) error {
+	if bookCopy == nil {
+		return ErrNilCopy
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	stored, err := queryCopy(tx, bookCopy.Barcode, c.Dialect().LockForUpdate())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if bookCopy.Status == "" {
+		bookCopy.Status = stored.Status
+	}
+	if bookCopy.Location == "" {
+		bookCopy.Location = stored.Location
+	}
+	err = validateCopyUpdate(stored.Status, bookCopy.Status)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE book_copy SET status = ?, location = ? WHERE copy_id = ?", bookCopy.Status, bookCopy.Location, stored.CopyID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	updated := *stored
+	updated.Status, updated.Location = bookCopy.Status, bookCopy.Location
+	err = c.writeAudit(tx, "update", AuditCopy, stored.Barcode, stored, &updated)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = syncStock(tx, stored.BookID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if bookCopy.Status == CopyAvailable && stored.Status != CopyAvailable {
+		err = promoteHolds(tx, stored.BookID, time.Now().Unix())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	bookCopy.CopyID = stored.CopyID
+	bookCopy.BookID = stored.BookID
+	return nil
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// DB wraps sql.DB and rewrites the ? placeholders of every query to the style of its dialect
+type DB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+// Tx wraps sql.Tx the same way DB does
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(rebind(db.dialect, query), args...)
+}
+
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(rebind(db.dialect, query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(rebind(db.dialect, query), args...)
+}
+
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.Prepare(rebind(db.dialect, query))
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.dialect}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(rebind(tx.dialect, query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(rebind(tx.dialect, query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(rebind(tx.dialect, query), args...)
+}
+
+func (tx *Tx) Prepare(query string) (*sql.Stmt, error) {
+	return tx.Tx.Prepare(rebind(tx.dialect, query))
+}
+
+// rebind replaces the ? placeholders outside of quoted strings with the placeholders of the dialect
+func rebind(dialect Dialect, query string) string {
+	if dialect == nil || dialect.Placeholder(1) == "?" || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var (
+		builder strings.Builder
+		quote   rune
+		n       int
+	)
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '?':
+			n++
+			builder.WriteString(dialect.Placeholder(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Dialect hides the differences of the SQL databases behind DatabaseConnector.
+// Schemas declare MySQL flavored types, every dialect translates them into its own.
+type Dialect interface {
+	Name() string
+	// Open opens the connection pool described by config
+	Open(config *ConnectConfig) (*sql.DB, error)
+	// Placeholder returns the n-th (from 1) bind parameter of a statement
+	Placeholder(n int) string
+
+	ColumnType(table *TableSchema, column *ColumnSchema) string
+	DefaultValue(column *ColumnSchema) *string
+	ColumnSQL(table *TableSchema, column *ColumnSchema) string
+	CreateTableSQL(table *TableSchema) []string
+	// AddColumnSQL returns "" if the column cannot be added to an existing table
+	AddColumnSQL(table *TableSchema, column *ColumnSchema) string
+	// ModifyColumnSQL returns nil if the column cannot be altered in place
+	ModifyColumnSQL(table *TableSchema, column *ColumnSchema) []string
+	// AddConstraintSQL returns "" if the constraint cannot be added to an existing table
+	AddConstraintSQL(table string, definition string) string
+	CreateIndexSQL(table string, index IndexSchema) string
+	DropIndexSQL(table string, index IndexSchema) string
+	Fulltext() bool
+	// DescribeTable reads the current schema of a table from the database, it returns nil if the table does not exist
+	DescribeTable(executor SQLExecutor, name string) (*TableSchema, error)
+
+	// LockForUpdate returns the row-locking clause for exclusive reads inside a transaction
+	LockForUpdate() string
+	// LockForShare returns the row-locking clause for shared reads inside a transaction
+	LockForShare() string
+	// Like returns the case-insensitive LIKE operator
+	Like() string
+	// UpsertSQL returns an insert statement updating the other columns when a row with the same keys exists
+	UpsertSQL(table string, columns []string, keys []string) string
+	// ConstraintError wraps a constraint violation reported by the database into the kind of failure it means
+	ConstraintError(err error) error
+	// InsertReturningID executes an insert statement and returns the generated value of idColumn
+	InsertReturningID(executor SQLExecutor, query string, idColumn string, args ...any) (int64, error)
+	// SnapshotSQL returns the statement making the reads of a transaction see a single snapshot, "" if they do already
+	SnapshotSQL() string
+	// SyncSequenceSQL returns the statement moving the sequence of an auto increment column past the values inserted
+	// into it explicitly, "" if the database does it itself
+	SyncSequenceSQL(table string, column string) string
+}
+
+var dialects = map[string]Dialect{
+	DriverMySQL:    mysqlDialect{},
+	DriverSQLite:   sqliteDialect{},
+	DriverPostgres: postgresDialect{},
+}
+
+// DialectOf returns the dialect of a storage driver, an empty driver means MySQL
+func DialectOf(driver string) (Dialect, error) {
+	if driver == "" {
+		driver = DriverMySQL
+	}
+	dialect, ok := dialects[driver]
+	if !ok {
+		return nil, errors.New("unsupported database driver: " + driver)
+	}
+	return dialect, nil
+}
+
+// columnSQL renders a column definition with the type and default of the dialect, autoIncrement is appended after NOT NULL
+func columnSQL(dialect Dialect, table *TableSchema, column *ColumnSchema, autoIncrement string) string {
+	columnSQL := column.Name + " " + dialect.ColumnType(table, column)
+	if column.NotNull {
+		columnSQL += " NOT NULL"
+	}
+	if column.AutoIncrement && autoIncrement != "" {
+		columnSQL += " " + autoIncrement
+	}
+	if column.Unique {
+		columnSQL += " UNIQUE"
+	}
+	if value := dialect.DefaultValue(column); value != nil {
+		columnSQL += " DEFAULT " + *value
+	}
+	return columnSQL
+}
+
+// enumSQL emulates an ENUM column by a VARCHAR wide enough for its values and a CHECK constraint
+func enumSQL(column *ColumnSchema) (string, string) {
+	size := 1
+	for _, value := range column.Enum {
+		if len(value) > size {
+			size = len(value)
+		}
+	}
+	return "VARCHAR(" + strconv.Itoa(size) + ")", "CHECK(" + column.Name + " IN ('" + strings.Join(column.Enum, "', '") + "'))"
+}
+
+// createTableSQL renders CREATE TABLE, indexes the dialect cannot declare inline are returned as separate statements
+func createTableSQL(dialect Dialect, t *TableSchema, inlineIndexes bool, inlinePrimaryKey bool, options string) []string {
+	definitions := make([]string, 0)
+	for i := range t.Columns {
+		definitions = append(definitions, dialect.ColumnSQL(t, &t.Columns[i]))
+	}
+	indexStatements := make([]string, 0)
+	for _, index := range t.Indexes {
+		columns := strings.Join(index.Columns, ", ")
+		switch {
+		case index.Unique:
+			definitions = append(definitions, "CONSTRAINT "+index.Name+" UNIQUE("+columns+")")
+		case !inlineIndexes:
+			indexStatements = append(indexStatements, dialect.CreateIndexSQL(t.Name, index)+";")
+		case index.Fulltext:
+			definitions = append(definitions, "FULLTEXT INDEX "+index.Name+" ("+columns+")")
+		default:
+			definitions = append(definitions, "INDEX "+index.Name+" ("+columns+")")
+		}
+	}
+	if len(t.PrimaryKey) != 0 && !inlinePrimaryKey {
+		definitions = append(definitions, "PRIMARY KEY("+strings.Join(t.PrimaryKey, ", ")+")")
+	}
+	for _, check := range t.Checks {
+		definitions = append(definitions, "CONSTRAINT "+check.Name+" CHECK("+check.Clause+")")
+	}
+	for _, foreignKey := range t.ForeignKeys {
+		definitions = append(definitions, foreignKey.sql())
+	}
+
+	createTableSQL := "CREATE TABLE IF NOT EXISTS " + t.Name + " (" + strings.Join(definitions, ", ") + ")"
+	if options != "" {
+		createTableSQL += " " + options
+	}
+	return append([]string{createTableSQL + ";"}, indexStatements...)
+}
+
+func upsertSQL(table string, columns []string, keys []string, conflict string, value func(column string) string) string {
+	placeholders := make([]string, len(columns))
+	updates := make([]string, 0, len(columns))
+	for i, column := range columns {
+		placeholders[i] = "?"
+		isKey := false
+		for _, key := range keys {
+			isKey = isKey || key == column
+		}
+		if !isKey {
+			updates = append(updates, column+" = "+value(column))
+		}
+	}
+	return "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ") " +
+		conflict + " " + strings.Join(updates, ", ")
+}
+
+func lastInsertID(executor SQLExecutor, query string, args ...any) (int64, error) {
+	result, err := executor.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
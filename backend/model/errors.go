@@ -0,0 +1,150 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of failures, every error returned by a Connector for a reason other than a broken database
+// wraps one of them, tell them apart with errors.Is
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrConflict          = errors.New("conflict")
+	ErrDuplicate         = errors.New("duplicate")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrLimitReached      = errors.New("limit reached")
+	ErrForbidden         = errors.New("forbidden")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrTooManyAttempts   = errors.New("too many attempts")
+	ErrValidation        = errors.New("validation failed")
+)
+
+var (
+	ErrBookNotFound         = NewError(ErrNotFound, "book not found")
+	ErrCardNotFound         = NewError(ErrNotFound, "card not found")
+	ErrDuplicateBook        = NewError(ErrDuplicate, "duplicate book")
+	ErrDuplicateCard        = NewError(ErrDuplicate, "duplicate card")
+	ErrDuplicateBorrow      = NewError(ErrDuplicate, "duplicate borrow record")
+	ErrStockNotEnough       = NewError(ErrInsufficientStock, "stock not enough")
+	ErrBookBorrowed         = NewError(ErrConflict, "book is borrowed")
+	ErrBookNotBorrowed      = NewError(ErrConflict, "book not borrowed")
+	ErrAlreadyBorrowed      = NewError(ErrConflict, "book already borrowed")
+	ErrCardHasBorrows       = NewError(ErrConflict, "card has not returned all books")
+	ErrNilBook              = NewError(ErrValidation, "book is nil")
+	ErrNilBooks             = NewError(ErrValidation, "books is nil")
+	ErrEmptyBooks           = NewError(ErrValidation, "books is empty")
+	ErrNegativeStock        = NewError(ErrValidation, "stock must not be negative")
+	ErrNilCard              = NewError(ErrValidation, "card is nil")
+	ErrInvalidCardType      = NewError(ErrValidation, "invalid card type")
+	ErrInvalidSortOrder     = NewError(ErrValidation, "invalid sort order")
+	ErrInvalidSortColumn    = NewError(ErrValidation, "invalid sort column")
+	ErrDuplicateSortColumn  = NewError(ErrValidation, "column sorted on twice")
+	ErrSortWithSortBy       = NewError(ErrValidation, "sort and sort_by cannot be used together")
+	ErrInvalidDueTime       = NewError(ErrValidation, "due time is before borrow time")
+	ErrFinesOutstanding     = NewError(ErrConflict, "outstanding fines exceed the limit")
+	ErrCardHasFines         = NewError(ErrConflict, "card has outstanding fines")
+	ErrNilFine              = NewError(ErrValidation, "fine is nil")
+	ErrInvalidFineKind      = NewError(ErrValidation, "invalid fine kind")
+	ErrInvalidFineAmount    = NewError(ErrValidation, "amount must be greater than 0")
+	ErrMissingFineReason    = NewError(ErrValidation, "missing reason")
+	ErrFineExceedsBalance   = NewError(ErrValidation, "amount exceeds the outstanding balance")
+	ErrHoldNotFound         = NewError(ErrNotFound, "hold not found")
+	ErrDuplicateHold        = NewError(ErrDuplicate, "card already holds the book")
+	ErrBookAvailable        = NewError(ErrConflict, "book is in stock, borrow it instead")
+	ErrHoldNotActive        = NewError(ErrConflict, "hold is no longer active")
+	ErrNilHold              = NewError(ErrValidation, "hold is nil")
+	ErrRenewalLimit         = NewError(ErrConflict, "loan renewed too many times")
+	ErrLoanOverdue          = NewError(ErrConflict, "loan is too overdue to be renewed")
+	ErrBookOnHold           = NewError(ErrConflict, "book has pending holds")
+	ErrLoanLimit            = NewError(ErrLimitReached, "card has reached its loan limit")
+	ErrCategoryLoanLimit    = NewError(ErrLimitReached, "card has reached its loan limit for the category")
+	ErrCopyNotFound         = NewError(ErrNotFound, "copy not found")
+	ErrDuplicateCopy        = NewError(ErrDuplicate, "duplicate barcode")
+	ErrCopyNotAvailable     = NewError(ErrConflict, "copy is not available")
+	ErrCopyOfOtherBook      = NewError(ErrValidation, "copy is not a copy of the book")
+	ErrInvalidBarcode       = NewError(ErrValidation, "invalid barcode")
+	ErrInvalidCopyStatus    = NewError(ErrValidation, "invalid copy status")
+	ErrNilCopy              = NewError(ErrValidation, "copy is nil")
+	ErrEmptyCopies          = NewError(ErrValidation, "copies is empty")
+	ErrInvalidISBN          = NewError(ErrValidation, "invalid ISBN")
+	ErrAuthorNotFound       = NewError(ErrNotFound, "author not found")
+	ErrInvalidAuthorRole    = NewError(ErrValidation, "invalid contributor role")
+	ErrMissingAuthorName    = NewError(ErrValidation, "contributor has neither an author id nor a name")
+	ErrAuthorNameTooLong    = NewError(ErrValidation, "author name is longer than 63 characters")
+	ErrAuthorTooLong        = NewError(ErrValidation, "author of the book, the names of its authors joined, is longer than 63 characters")
+	ErrDuplicateContributor = NewError(ErrValidation, "contributor listed twice in the same role")
+	ErrEmptySearchQuery     = NewError(ErrValidation, "search query has no words")
+	ErrInvalidSearchLimit   = NewError(ErrValidation, "invalid search limit")
+	ErrInvalidPageSize      = NewError(ErrValidation, fmt.Sprintf("limit must be between 1 and %d", MaxPageSize))
+	ErrInvalidOffset        = NewError(ErrValidation, "offset must not be negative")
+	ErrOffsetWithCursor     = NewError(ErrValidation, "offset and cursor cannot be used together")
+	ErrInvalidCursor        = NewError(ErrValidation, "invalid cursor")
+	ErrNilPatch             = NewError(ErrValidation, "patch is nil")
+	ErrStaleBook            = NewError(ErrConflict, "book was changed since the version given")
+	ErrStaleCard            = NewError(ErrConflict, "card was changed since the version given")
+	ErrInvalidFacetTop      = NewError(ErrValidation, fmt.Sprintf("facet top must be between 1 and %d", MaxFacetTop))
+	ErrInvalidBucketWidth   = NewError(ErrValidation, "bucket width must be positive")
+	ErrUserNotFound         = NewError(ErrNotFound, "user not found")
+	ErrDuplicateUser        = NewError(ErrDuplicate, "duplicate username")
+	ErrNilUser              = NewError(ErrValidation, "user is nil")
+	ErrInvalidUsername      = NewError(ErrValidation, "username must be 1 to 63 letters, digits, dots, dashes or underscores")
+	ErrInvalidUserRole      = NewError(ErrValidation, "invalid user role")
+	ErrMissingPassword      = NewError(ErrValidation, "missing password")
+	ErrPasswordTooShort     = NewError(ErrValidation, "password is too short")
+	ErrPasswordTooLong      = NewError(ErrValidation, fmt.Sprintf("password is longer than %d bytes", maxPasswordLength))
+	ErrLastAdmin            = NewError(ErrConflict, "cannot remove or demote the last admin")
+	ErrUsersExist           = NewError(ErrForbidden, "the first admin was already created")
+	ErrNilSession           = NewError(ErrValidation, "session is nil")
+	ErrInvalidCredentials   = NewError(ErrUnauthorized, "invalid username or password")
+	ErrUserLocked           = NewError(ErrTooManyAttempts, "too many failed logins, try again later")
+	ErrInvalidToken         = NewError(ErrUnauthorized, "invalid or expired token")
+	ErrMissingToken         = NewError(ErrUnauthorized, "missing bearer token")
+	ErrPermissionDenied     = NewError(ErrForbidden, "permission denied")
+	ErrCredentialNotFound   = NewError(ErrNotFound, "card has no PIN")
+	ErrMissingPIN           = NewError(ErrValidation, "missing PIN")
+	ErrPINTooShort          = NewError(ErrValidation, "PIN is too short")
+	ErrPINTooLong           = NewError(ErrValidation, fmt.Sprintf("PIN is longer than %d bytes", maxPasswordLength))
+	ErrInvalidPIN           = NewError(ErrUnauthorized, "invalid card number or PIN")
+	ErrCardLocked           = NewError(ErrTooManyAttempts, "too many failed logins, try again later")
+	ErrNilAuditQuery        = NewError(ErrValidation, "audit query is nil")
+	ErrInvalidTimeRange     = NewError(ErrValidation, "invalid time range")
+	ErrResetDisabled        = NewError(ErrForbidden, "the database cannot be reset in this environment")
+	ErrInvalidConfirmation  = NewError(ErrForbidden, "invalid or expired confirmation token")
+	ErrUnknownTable         = NewError(ErrValidation, "unknown table or table kept on reset")
+	ErrFixtureNotFound      = NewError(ErrNotFound, "fixture not found")
+	ErrInvalidFixtureName   = NewError(ErrValidation, "fixture name must be 1 to 64 letters, digits, dashes or underscores")
+	ErrNilFixture           = NewError(ErrValidation, "fixture is nil")
+	ErrFixtureTableKept     = NewError(ErrValidation, "fixture loads rows into a table the reset keeps")
+	ErrInvalidArchive       = NewError(ErrValidation, "invalid archive")
+	ErrArchiveTooNew        = NewError(ErrValidation, "archive is of a schema version newer than the database")
+	ErrArchiveChecksum      = NewError(ErrValidation, "archive table does not match the manifest")
+	ErrArchiveTableTooLarge = NewError(ErrValidation, "archive table is larger than the archive policy allows")
+	ErrDatabaseNotEmpty     = NewError(ErrConflict, "archives are restored into an empty database only")
+)
+
+// Error is a failure of a known kind, its message is meant to be shown to the user
+type Error struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+func NewError(kind error, msg string) *Error {
+	return &Error{Kind: kind, Msg: msg}
+}
+
+// WrapError marks err as a failure of kind keeping its message
+func WrapError(kind error, err error) *Error {
+	return &Error{Kind: kind, Msg: err.Error(), Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
@@ -0,0 +1,380 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultFacetTop         = 10
+	MaxFacetTop             = 100
+	DefaultYearBucketWidth  = 10
+	DefaultPriceBucketWidth = 10
+)
+
+// BookFacetOptions asks QueryBook for the facets of the books matching the conditions
+type BookFacetOptions struct {
+	// Top is the number of presses and authors counted, the ones with the most books
+	Top        int     `json:"top,omitempty"`
+	YearWidth  int     `json:"year_width,omitempty"`
+	PriceWidth float32 `json:"price_width,omitempty"`
+}
+
+// FacetCount is the number of books with a value, AuthorID is set for the authors
+type FacetCount struct {
+	Value    string `json:"value"`
+	AuthorID int    `json:"author_id,omitempty"`
+	Count    int    `json:"count"`
+}
+
+// FacetBucket is the number of books with a value from From, included, to To, excluded
+type FacetBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+// BookFacets counts the books matching the conditions of a query by value of a column. Each facet ignores the
+// conditions on its own column, so that it tells how many books choosing another value would find. Those of the
+// filter expression are ignored when the whole filter or one of its And groups requires them, the conditions under
+// Or and Not still apply.
+type BookFacets struct {
+	Categories []FacetCount  `json:"categories"`
+	Presses    []FacetCount  `json:"presses"`
+	Authors    []FacetCount  `json:"authors"`
+	Years      []FacetBucket `json:"years"`
+	Prices     []FacetBucket `json:"prices"`
+}
+
+// facetDimension is a facet and the columns of the conditions it ignores
+type facetDimension int
+
+const (
+	facetCategory facetDimension = iota
+	facetPress
+	facetAuthor
+	facetYear
+	facetPrice
+)
+
+// facetFields are the fields of the filter conditions each facet ignores
+var facetFields = map[facetDimension]BookColumn{
+	facetCategory: BookColumnCategory,
+	facetPress:    BookColumnPress,
+	facetAuthor:   BookColumnAuthor,
+	facetYear:     BookColumnPublishYear,
+	facetPrice:    BookColumnPrice,
+}
+
+// check returns the options with their defaults set
+func (o *BookFacetOptions) check() (BookFacetOptions, error) {
+	options := *o
+	if options.Top == 0 {
+		options.Top = DefaultFacetTop
+	}
+	if options.YearWidth == 0 {
+		options.YearWidth = DefaultYearBucketWidth
+	}
+	if options.PriceWidth == 0 {
+		options.PriceWidth = DefaultPriceBucketWidth
+	}
+	if options.Top < 0 || options.Top > MaxFacetTop {
+		return options, ErrInvalidFacetTop
+	}
+	if options.YearWidth < 0 {
+		return options, ErrInvalidBucketWidth
+	}
+	if priceCents(float64(options.PriceWidth)) <= 0 {
+		return options, ErrInvalidBucketWidth
+	}
+	return options, nil
+}
+
+// without returns a copy of the conditions without those on the column of the facet, nor the sort and page
+func (c *BookQueryConditions) without(dimension facetDimension) *BookQueryConditions {
+	condition := BookQueryConditions{}
+	if c != nil {
+		condition = *c
+	}
+	condition.Facets = nil
+	condition.SortBy, condition.SortOrder, condition.Sort = nil, nil, nil
+	condition.Page = Page{}
+	switch dimension {
+	case facetCategory:
+		condition.Category = nil
+	case facetPress:
+		condition.Press = nil
+	case facetAuthor:
+		condition.Author, condition.AuthorID, condition.AuthorName = nil, nil, nil
+	case facetYear:
+		condition.MinPublishYear, condition.MaxPublishYear = nil, nil
+	case facetPrice:
+		condition.MinPrice, condition.MaxPrice = nil, nil
+	}
+	if condition.Filter != nil {
+		condition.Filter = condition.Filter.without(facetFields[dimension])
+	}
+	return &condition
+}
+
+// without returns the filter without the conditions on field it requires, the filter itself or those of its And
+// groups, nil if none are left. The conditions under Or and Not are kept since removing them would not widen the
+// books matched.
+func (f *BookFilter) without(field BookColumn) *BookFilter {
+	switch {
+	case f.Field != "":
+		if f.Field == field {
+			return nil
+		}
+	case len(f.And) != 0:
+		kept := make([]BookFilter, 0, len(f.And))
+		for i := range f.And {
+			if filter := f.And[i].without(field); filter != nil {
+				kept = append(kept, *filter)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return &BookFilter{And: kept}
+	}
+	return f
+}
+
+func priceCents(price float64) int64 {
+	return int64(math.Round(price * 100))
+}
+
+// facetCounts returns the counts of the values, the most frequent first, at most top of them unless top is 0
+func facetCounts(counts map[string]int, top int) []FacetCount {
+	facets := make([]FacetCount, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, FacetCount{Value: value, Count: count})
+	}
+	sortFacetCounts(facets)
+	if top > 0 && len(facets) > top {
+		facets = facets[:top]
+	}
+	return facets
+}
+
+func sortFacetCounts(facets []FacetCount) {
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		if !strings.EqualFold(facets[i].Value, facets[j].Value) {
+			return strings.ToLower(facets[i].Value) < strings.ToLower(facets[j].Value)
+		}
+		return facets[i].AuthorID < facets[j].AuthorID
+	})
+}
+
+// facetBuckets groups the counts of integer values in buckets of width, scale being the unit of the values
+func facetBuckets(counts map[int64]int, width int64, scale float64) []FacetBucket {
+	buckets := map[int64]int{}
+	for value, count := range counts {
+		// rounds down for negative values too
+		bucket := value / width
+		if value%width < 0 {
+			bucket--
+		}
+		buckets[bucket] += count
+	}
+	facets := make([]FacetBucket, 0, len(buckets))
+	for bucket, count := range buckets {
+		facets = append(facets, FacetBucket{
+			From:  float64(bucket*width) / scale,
+			To:    float64((bucket+1)*width) / scale,
+			Count: count,
+		})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		return facets[i].From < facets[j].From
+	})
+	return facets
+}
+
+func (c *DatabaseConnector) bookFacets(condition *BookQueryConditions) (*BookFacets, error) {
+	options, err := condition.Facets.check()
+	if err != nil {
+		return nil, err
+	}
+
+	facets := BookFacets{}
+	groupSQL := func(dimension facetDimension, column string) (string, []any, error) {
+		whereSQL, args, err := c.bookWhereSQL(condition.without(dimension))
+		if err != nil {
+			return "", nil, err
+		}
+		return "SELECT " + column + ", COUNT(*) FROM book" + whereSQL + " GROUP BY " + column, args, nil
+	}
+	// the categories and presses are sorted and cut in Go to order their ties the same way in all databases
+	for _, facet := range []struct {
+		dimension facetDimension
+		column    string
+		top       int
+		counts    *[]FacetCount
+	}{
+		{facetCategory, "category", 0, &facets.Categories},
+		{facetPress, "press", options.Top, &facets.Presses},
+	} {
+		querySQL, args, err := groupSQL(facet.dimension, facet.column)
+		if err != nil {
+			return nil, err
+		}
+		counts := map[string]int{}
+		err = queryGroups(c.DB, querySQL, args, func(scan func(dest ...any) error) error {
+			var (
+				value string
+				count int
+			)
+			err := scan(&value, &count)
+			counts[value] += count
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		*facet.counts = facetCounts(counts, facet.top)
+	}
+
+	whereSQL, args, err := c.bookWhereSQL(condition.without(facetAuthor))
+	if err != nil {
+		return nil, err
+	}
+	querySQL := "SELECT a.author_id, a.name, COUNT(DISTINCT ba.book_id) FROM book_author ba JOIN author a ON a.author_id = ba.author_id" +
+		" WHERE ba.book_id IN (SELECT book_id FROM book" + whereSQL + ") GROUP BY a.author_id, a.name"
+	facets.Authors = []FacetCount{}
+	err = queryGroups(c.DB, querySQL, args, func(scan func(dest ...any) error) error {
+		var author FacetCount
+		err := scan(&author.AuthorID, &author.Value, &author.Count)
+		facets.Authors = append(facets.Authors, author)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortFacetCounts(facets.Authors)
+	if len(facets.Authors) > options.Top {
+		facets.Authors = facets.Authors[:options.Top]
+	}
+
+	querySQL, args, err = groupSQL(facetYear, "publish_year")
+	if err != nil {
+		return nil, err
+	}
+	years := map[int64]int{}
+	err = queryGroups(c.DB, querySQL, args, func(scan func(dest ...any) error) error {
+		var (
+			year  int64
+			count int
+		)
+		err := scan(&year, &count)
+		years[year] += count
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	facets.Years = facetBuckets(years, int64(options.YearWidth), 1)
+
+	querySQL, args, err = groupSQL(facetPrice, "price")
+	if err != nil {
+		return nil, err
+	}
+	prices := map[int64]int{}
+	err = queryGroups(c.DB, querySQL, args, func(scan func(dest ...any) error) error {
+		var (
+			price myFloat
+			count int
+		)
+		err := scan(&price, &count)
+		prices[priceCents(float64(price))] += count
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	facets.Prices = facetBuckets(prices, priceCents(float64(options.PriceWidth)), 100)
+	return &facets, nil
+}
+
+// queryGroups runs a query and calls row with the scan of each of its rows
+func queryGroups(executor SQLExecutor, querySQL string, args []any, row func(scan func(dest ...any) error) error) error {
+	rows, err := executor.Query(querySQL, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = row(rows.Scan)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// bookFacets counts the books matching the conditions, it must be called with c.mu held
+func (c *MemoryConnector) bookFacets(condition *BookQueryConditions) (*BookFacets, error) {
+	options, err := condition.Facets.check()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches [5]func(book *Book) bool
+	for dimension := range matches {
+		matches[dimension], err = c.bookMatcher(condition.without(facetDimension(dimension)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	categories, presses := map[string]int{}, map[string]int{}
+	authors := map[int]*FacetCount{}
+	years, prices := map[int64]int{}, map[int64]int{}
+	for _, book := range c.sortedBooks() {
+		if matches[facetCategory](&book) {
+			categories[book.Category]++
+		}
+		if matches[facetPress](&book) {
+			presses[book.Press]++
+		}
+		if matches[facetAuthor](&book) {
+			counted := map[int]bool{}
+			for _, contributor := range c.bookContributors(book.BookID) {
+				if counted[contributor.AuthorID] {
+					continue
+				}
+				counted[contributor.AuthorID] = true
+				if authors[contributor.AuthorID] == nil {
+					authors[contributor.AuthorID] = &FacetCount{Value: contributor.Name, AuthorID: contributor.AuthorID}
+				}
+				authors[contributor.AuthorID].Count++
+			}
+		}
+		if matches[facetYear](&book) {
+			years[int64(book.PublishYear)]++
+		}
+		if matches[facetPrice](&book) {
+			prices[priceCents(float64(book.Price))]++
+		}
+	}
+
+	facets := BookFacets{
+		Categories: facetCounts(categories, 0),
+		Presses:    facetCounts(presses, options.Top),
+		Authors:    make([]FacetCount, 0, len(authors)),
+		Years:      facetBuckets(years, int64(options.YearWidth), 1),
+		Prices:     facetBuckets(prices, priceCents(float64(options.PriceWidth)), 100),
+	}
+	for _, author := range authors {
+		facets.Authors = append(facets.Authors, *author)
+	}
+	sortFacetCounts(facets.Authors)
+	if len(facets.Authors) > options.Top {
+		facets.Authors = facets.Authors[:options.Top]
+	}
+	return &facets, nil
+}
@@ -0,0 +1,576 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type FilterOp string
+
+const (
+	FilterEq       FilterOp = "eq"
+	FilterIn       FilterOp = "in"
+	FilterContains FilterOp = "contains"
+	FilterPrefix   FilterOp = "prefix"
+	FilterLt       FilterOp = "lt"
+	FilterLte      FilterOp = "lte"
+	FilterGt       FilterOp = "gt"
+	FilterGte      FilterOp = "gte"
+)
+
+const (
+	maxFilterDepth  = 8
+	maxFilterValues = 100
+)
+
+type columnKind int
+
+const (
+	textColumn columnKind = iota
+	integerColumn
+	decimalColumn
+)
+
+// bookColumnKinds are the columns of a book that can be filtered and sorted on
+var bookColumnKinds = map[BookColumn]columnKind{
+	BookColumnBookID:      integerColumn,
+	BookColumnCategory:    textColumn,
+	BookColumnTitle:       textColumn,
+	BookColumnPress:       textColumn,
+	BookColumnPublishYear: integerColumn,
+	BookColumnAuthor:      textColumn,
+	BookColumnPrice:       decimalColumn,
+	BookColumnStock:       integerColumn,
+}
+
+// BookFilter is a filter expression on the books: a group of filters in And or Or, a negated filter in Not, or a
+// condition comparing Field to Value, or to Values for the in operator. Text is compared ignoring case.
+type BookFilter struct {
+	And    []BookFilter `json:"and,omitempty"`
+	Or     []BookFilter `json:"or,omitempty"`
+	Not    *BookFilter  `json:"not,omitempty"`
+	Field  BookColumn   `json:"field,omitempty"`
+	Op     FilterOp     `json:"op,omitempty"`
+	Value  any          `json:"value,omitempty"`
+	Values []any        `json:"values,omitempty"`
+	// values are the values of the condition as strings for text columns and float64 for the others, set by check
+	values []any
+}
+
+// SortKey is a column the books are sorted on, ascending unless Order is DESC
+type SortKey struct {
+	Column BookColumn `json:"column"`
+	Order  SortOrder  `json:"order,omitempty"`
+}
+
+func filterError(format string, args ...any) error {
+	return NewError(ErrValidation, "invalid filter: "+fmt.Sprintf(format, args...))
+}
+
+// check validates the filter and converts the values of its conditions to the types of their columns
+func (f *BookFilter) check(depth int) error {
+	if depth > maxFilterDepth {
+		return filterError("nested more than %d levels", maxFilterDepth)
+	}
+	set := 0
+	for _, isSet := range []bool{f.And != nil, f.Or != nil, f.Not != nil, f.Field != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return filterError("a filter has exactly one of and, or, not and field")
+	}
+
+	switch {
+	case f.Not != nil:
+		return f.Not.check(depth + 1)
+	case f.Field == "":
+		group := f.And
+		if f.Or != nil {
+			group = f.Or
+		}
+		if len(group) == 0 {
+			return filterError("empty group")
+		}
+		for i := range group {
+			if err := group[i].check(depth + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	kind, ok := bookColumnKinds[f.Field]
+	if !ok {
+		return filterError("unknown field %q", f.Field)
+	}
+	switch f.Op {
+	case FilterEq, FilterIn:
+	case FilterContains, FilterPrefix:
+		if kind != textColumn {
+			return filterError("%s only applies to text fields", f.Op)
+		}
+	case FilterLt, FilterLte, FilterGt, FilterGte:
+		if kind == textColumn {
+			return filterError("%s only applies to number fields", f.Op)
+		}
+	default:
+		return filterError("unknown operator %q", f.Op)
+	}
+
+	values := []any{f.Value}
+	if f.Op == FilterIn {
+		if len(f.Values) == 0 || len(f.Values) > maxFilterValues {
+			return filterError("in takes 1 to %d values", maxFilterValues)
+		}
+		values = f.Values
+	}
+	f.values = make([]any, len(values))
+	for i, value := range values {
+		converted, err := columnValue(kind, value)
+		if err != nil {
+			return filterError("%v for field %s", err, f.Field)
+		}
+		f.values[i] = converted
+	}
+	return nil
+}
+
+// columnValue converts a value given as JSON or as text to the type of a column
+func columnValue(kind columnKind, value any) (any, error) {
+	switch v := value.(type) {
+	case string:
+		if kind == textColumn {
+			return v, nil
+		}
+		number, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+		return columnValue(kind, number)
+	case float64:
+		if kind == textColumn {
+			return nil, fmt.Errorf("%v is not text", v)
+		}
+		if kind == integerColumn && v != math.Trunc(v) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return v, nil
+	case int:
+		return columnValue(kind, float64(v))
+	}
+	return nil, fmt.Errorf("missing or invalid value")
+}
+
+// sqlValue returns a value checked for a column as the argument of a query, decimals as text to compare exactly
+func sqlValue(kind columnKind, value any) any {
+	switch kind {
+	case integerColumn:
+		return int64(value.(float64))
+	case decimalColumn:
+		return fmt.Sprintf("%.2f", value.(float64))
+	}
+	return value
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with \
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// likeSQL returns the condition matching column to a pattern escaped by escapeLike
+func likeSQL(dialect Dialect, column string) string {
+	return column + " " + dialect.Like() + " ? ESCAPE " + quoteLiteral(dialect, `\`)
+}
+
+// sql returns the filter as a condition of a query on the book table and its arguments, the filter must be checked
+func (f *BookFilter) sql(dialect Dialect) (string, []any) {
+	switch {
+	case f.Not != nil:
+		condition, args := f.Not.sql(dialect)
+		return "NOT " + condition, args
+	case f.Field == "":
+		group, operator := f.And, " AND "
+		if f.Or != nil {
+			group, operator = f.Or, " OR "
+		}
+		conditions := make([]string, len(group))
+		var args []any
+		for i := range group {
+			condition, groupArgs := group[i].sql(dialect)
+			conditions[i] = condition
+			args = append(args, groupArgs...)
+		}
+		return "(" + strings.Join(conditions, operator) + ")", args
+	}
+
+	kind := bookColumnKinds[f.Field]
+	column := string(f.Field)
+	args := make([]any, len(f.values))
+	for i, value := range f.values {
+		args[i] = sqlValue(kind, value)
+	}
+	switch f.Op {
+	case FilterContains:
+		return likeSQL(dialect, column), []any{"%" + escapeLike(args[0].(string)) + "%"}
+	case FilterPrefix:
+		return likeSQL(dialect, column), []any{escapeLike(args[0].(string)) + "%"}
+	case FilterLt:
+		return column + " < ?", args
+	case FilterLte:
+		return column + " <= ?", args
+	case FilterGt:
+		return column + " > ?", args
+	case FilterGte:
+		return column + " >= ?", args
+	}
+
+	placeholder := "?"
+	if kind == textColumn {
+		column, placeholder = "LOWER("+column+")", "LOWER(?)"
+	}
+	if f.Op == FilterEq {
+		return column + " = " + placeholder, args
+	}
+	return column + " IN (" + placeholder + strings.Repeat(", "+placeholder, len(args)-1) + ")", args
+}
+
+// match reports whether a book matches the filter, the filter must be checked
+func (f *BookFilter) match(book *Book) bool {
+	switch {
+	case f.Not != nil:
+		return !f.Not.match(book)
+	case f.And != nil:
+		for i := range f.And {
+			if !f.And[i].match(book) {
+				return false
+			}
+		}
+		return true
+	case f.Or != nil:
+		for i := range f.Or {
+			if f.Or[i].match(book) {
+				return true
+			}
+		}
+		return false
+	}
+
+	kind := bookColumnKinds[f.Field]
+	for _, value := range f.values {
+		var compare int
+		if kind == textColumn {
+			text, value := strings.ToLower(book.column(f.Field).(string)), strings.ToLower(value.(string))
+			switch f.Op {
+			case FilterContains:
+				return strings.Contains(text, value)
+			case FilterPrefix:
+				return strings.HasPrefix(text, value)
+			}
+			compare = strings.Compare(text, value)
+		} else {
+			compare = compareNumbers(kind, bookNumber(book, f.Field), value.(float64))
+		}
+
+		switch f.Op {
+		case FilterEq, FilterIn:
+			if compare == 0 {
+				return true
+			}
+		case FilterLt:
+			return compare < 0
+		case FilterLte:
+			return compare <= 0
+		case FilterGt:
+			return compare > 0
+		case FilterGte:
+			return compare >= 0
+		}
+	}
+	return false
+}
+
+// bookValue returns a column of the book as a value of a filter
+func bookValue(book *Book, column BookColumn) any {
+	if bookColumnKinds[column] == textColumn {
+		return book.column(column)
+	}
+	return bookNumber(book, column)
+}
+
+func bookNumber(book *Book, column BookColumn) float64 {
+	switch value := book.column(column).(type) {
+	case int:
+		return float64(value)
+	case myFloat:
+		return float64(value)
+	}
+	return 0
+}
+
+// compareNumbers compares two numbers of a column, decimals to the cent
+func compareNumbers(kind columnKind, a float64, b float64) int {
+	if kind == decimalColumn {
+		a, b = math.Round(a*100), math.Round(b*100)
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ParseBookFilter parses the compact syntax of a filter, e.g.
+//
+//	category=[cs,math] AND (title~"deep learning" OR author~knuth) AND NOT price>=100
+//
+// = is eq, [a,b] after = is in, ~ is contains, ^ is prefix and <, <=, >, >= compare numbers. Conditions next to each
+// other are joined by AND, which binds tighter than OR. Values with spaces or symbols are quoted, \ escaping " and \.
+func ParseBookFilter(s string) (*BookFilter, error) {
+	tokens, err := filterTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	parser := filterParser{tokens: tokens}
+	filter, err := parser.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if !parser.done() {
+		return nil, filterError("unexpected %q", parser.peek().text)
+	}
+	return filter, nil
+}
+
+type filterToken struct {
+	text string
+	// quoted tokens are always values, never keywords or symbols
+	quoted bool
+}
+
+func filterTokens(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			var text strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, filterError("unclosed quote")
+			}
+			i++
+			tokens = append(tokens, filterToken{text.String(), true})
+		case r == '<' || r == '>':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, filterToken{string(runes[i : i+2]), false})
+				i += 2
+			} else {
+				tokens = append(tokens, filterToken{string(r), false})
+				i++
+			}
+		case strings.ContainsRune("()[],=~^", r):
+			tokens = append(tokens, filterToken{string(r), false})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`"()[],=~^<>`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{string(runes[start:i]), false})
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	next   int
+}
+
+var filterOps = map[string]FilterOp{
+	"=":  FilterEq,
+	"~":  FilterContains,
+	"^":  FilterPrefix,
+	"<":  FilterLt,
+	"<=": FilterLte,
+	">":  FilterGt,
+	">=": FilterGte,
+}
+
+func (p *filterParser) done() bool {
+	return p.next >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.done() {
+		return filterToken{}
+	}
+	return p.tokens[p.next]
+}
+
+// is reports whether the next token is the symbol or keyword text
+func (p *filterParser) is(text string) bool {
+	return !p.done() && !p.peek().quoted && strings.EqualFold(p.peek().text, text)
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.is(text) {
+		if p.done() {
+			return filterError("expected %q at the end", text)
+		}
+		return filterError("expected %q instead of %q", text, p.peek().text)
+	}
+	p.next++
+	return nil
+}
+
+func (p *filterParser) or(depth int) (*BookFilter, error) {
+	filter, err := p.and(depth)
+	if err != nil {
+		return nil, err
+	}
+	group := []BookFilter{*filter}
+	for p.is("OR") {
+		p.next++
+		filter, err = p.and(depth)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, *filter)
+	}
+	if len(group) == 1 {
+		return &group[0], nil
+	}
+	return &BookFilter{Or: group}, nil
+}
+
+func (p *filterParser) and(depth int) (*BookFilter, error) {
+	filter, err := p.unary(depth)
+	if err != nil {
+		return nil, err
+	}
+	group := []BookFilter{*filter}
+	for !p.done() && !p.is("OR") && !p.is(")") {
+		if p.is("AND") {
+			p.next++
+		}
+		filter, err = p.unary(depth)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, *filter)
+	}
+	if len(group) == 1 {
+		return &group[0], nil
+	}
+	return &BookFilter{And: group}, nil
+}
+
+func (p *filterParser) unary(depth int) (*BookFilter, error) {
+	if depth > maxFilterDepth {
+		return nil, filterError("nested more than %d levels", maxFilterDepth)
+	}
+	switch {
+	case p.is("NOT"):
+		p.next++
+		filter, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &BookFilter{Not: filter}, nil
+	case p.is("("):
+		p.next++
+		filter, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return filter, p.expect(")")
+	}
+	return p.condition()
+}
+
+func (p *filterParser) condition() (*BookFilter, error) {
+	if p.done() {
+		return nil, filterError("expected a condition at the end")
+	}
+	field := p.peek()
+	if field.quoted || strings.ContainsAny(field.text, "()[],") {
+		return nil, filterError("expected a field instead of %q", field.text)
+	}
+	p.next++
+	op, ok := filterOps[p.peek().text]
+	if !ok || p.peek().quoted {
+		return nil, filterError("expected an operator after %q", field.text)
+	}
+	p.next++
+	filter := BookFilter{Field: BookColumn(field.text), Op: op}
+
+	if op == FilterEq && p.is("[") {
+		p.next++
+		filter.Op = FilterIn
+		for {
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			filter.Values = append(filter.Values, value)
+			if !p.is(",") {
+				break
+			}
+			p.next++
+		}
+		return &filter, p.expect("]")
+	}
+
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	filter.Value = value
+	return &filter, nil
+}
+
+func (p *filterParser) value() (string, error) {
+	token := p.peek()
+	if p.done() || !token.quoted && strings.ContainsAny(token.text, "()[],=~^<>") {
+		return "", filterError("expected a value")
+	}
+	p.next++
+	return token.text, nil
+}
+
+// ParseBookSort parses a comma separated list of columns, each descending if it starts with -, e.g. -price,title
+func ParseBookSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, column := range strings.Split(s, ",") {
+		column = strings.TrimSpace(column)
+		key := SortKey{Order: Ascending}
+		if strings.HasPrefix(column, "-") {
+			column, key.Order = column[1:], Descending
+		} else {
+			column = strings.TrimPrefix(column, "+")
+		}
+		if column == "" {
+			return nil, ErrInvalidSortColumn
+		}
+		key.Column = BookColumn(column)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
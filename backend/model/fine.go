@@ -0,0 +1,209 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+type FineKind string
+
+const (
+	FineCharge  FineKind = "charge"
+	FinePayment FineKind = "payment"
+	FineWaiver  FineKind = "waiver"
+)
+
+// FineEntry is a line of the fines ledger, Amount is positive for charges and negative for payments and waivers
+type FineEntry struct {
+	FineID     int      `json:"fine_id" sql:"not null;autoIncrement;primaryKey"`
+	CardID     int      `json:"card_id" sql:"not null;index;constraint:Card.CardID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	BookID     int      `json:"book_id" sql:"not null;default:0"`
+	BorrowTime int64    `json:"borrow_time" sql:"not null;default:0"`
+	Kind       FineKind `json:"kind" sql:"not null;enum:charge,payment,waiver"`
+	Amount     myFloat  `json:"amount" sql:"not null;decimal:9,2"`
+	Reason     string   `json:"reason" sql:"not null;size:255"`
+	CreatedAt  int64    `json:"created_at" sql:"not null"`
+}
+
+type FineSettleRequest struct {
+	CardID *int     `json:"card_id,omitempty"`
+	Amount *myFloat `json:"amount,omitempty"`
+	Reason *string  `json:"reason,omitempty"`
+}
+
+type FineLedger struct {
+	CardID  int         `json:"card_id"`
+	Balance myFloat     `json:"balance"`
+	Count   int         `json:"count"`
+	Entries []FineEntry `json:"entries"`
+}
+
+// NewSettlement returns a payment or a waiver of amount for the card, to be recorded by SettleFine
+func NewSettlement(cardId int, kind FineKind, amount float64, reason string) *FineEntry {
+	return &FineEntry{CardID: cardId, Kind: kind, Amount: myFloat(amount), Reason: reason}
+}
+
+func validateSettlement(entry *FineEntry, balance float64) error {
+	if entry == nil {
+		return ErrNilFine
+	}
+	if entry.Kind != FinePayment && entry.Kind != FineWaiver {
+		return ErrInvalidFineKind
+	}
+	if entry.Amount <= 0 {
+		return ErrInvalidFineAmount
+	}
+	if entry.Reason == "" {
+		return ErrMissingFineReason
+	}
+	if math.Round(float64(entry.Amount)*100) > math.Round(balance*100) {
+		return ErrFineExceedsBalance
+	}
+	return nil
+}
+
+// overdueCharge returns the charge of a loan returned late, or nil if the policy charges nothing
+func overdueCharge(borrow *Borrow, cardType string) *FineEntry {
+	amount, lateDays := CurrentFinePolicy().Fine(cardType, borrow.DueTime, borrow.ReturnTime)
+	if amount <= 0 {
+		return nil
+	}
+	return &FineEntry{
+		CardID:     borrow.CardID,
+		BookID:     borrow.BookID,
+		BorrowTime: borrow.BorrowTime,
+		Kind:       FineCharge,
+		Amount:     myFloat(amount),
+		Reason:     fmt.Sprintf("returned %d days late", lateDays),
+		CreatedAt:  borrow.ReturnTime,
+	}
+}
+
+func fineBalance(executor SQLExecutor, cardId int) (float64, error) {
+	var balance float64
+	err := queryRow(executor, "SELECT COALESCE(SUM(amount), 0) FROM fine_entry WHERE card_id = ?", cardId).Scan(&balance)
+	return balance, err
+}
+
+func insertFine(executor SQLExecutor, dialect Dialect, entry *FineEntry) error {
+	insertSQL := "INSERT INTO fine_entry (card_id, book_id, borrow_time, kind, amount, reason, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+
+	insertedID, err := dialect.InsertReturningID(executor, insertSQL, "fine_id",
+		entry.CardID, entry.BookID, entry.BorrowTime, entry.Kind, entry.Amount, entry.Reason, entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	entry.FineID = int(insertedID)
+	return nil
+}
+
+func (c *DatabaseConnector) ShowFines(cardId int) (*FineLedger, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT card_id FROM card WHERE card_id = ?", cardId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if !rows.Next() {
+		rows.Close()
+		tx.Rollback()
+		return nil, ErrCardNotFound
+	}
+	rows.Close()
+
+	rows, err = tx.Query("SELECT * FROM fine_entry WHERE card_id = ? ORDER BY created_at DESC, fine_id DESC", cardId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	ledger := FineLedger{
+		CardID:  cardId,
+		Entries: make([]FineEntry, 0),
+	}
+	var balance float64
+	for rows.Next() {
+		var entry FineEntry
+		err = rows.Scan(&entry.FineID, &entry.CardID, &entry.BookID, &entry.BorrowTime, &entry.Kind, &entry.Amount, &entry.Reason, &entry.CreatedAt)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+		balance += float64(entry.Amount)
+		ledger.Entries = append(ledger.Entries, entry)
+	}
+	rows.Close()
+
+	ledger.Count = len(ledger.Entries)
+	ledger.Balance = myFloat(math.Round(balance*100) / 100)
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &ledger, nil
+}
+
+// SettleFine records a payment or a waiver of at most the outstanding balance of the card, entry.Amount is positive
+func (c *DatabaseConnector) SettleFine(entry *FineEntry) error {
+	if entry == nil {
+		return ErrNilFine
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT card_id FROM card WHERE card_id = ?"+c.Dialect().LockForUpdate(), entry.CardID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !rows.Next() {
+		rows.Close()
+		tx.Rollback()
+		return ErrCardNotFound
+	}
+	rows.Close()
+
+	balance, err := fineBalance(tx, entry.CardID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = validateSettlement(entry, balance)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	settlement := *entry
+	settlement.Amount = -entry.Amount
+	settlement.BookID = 0
+	settlement.BorrowTime = 0
+	settlement.CreatedAt = time.Now().Unix()
+	err = insertFine(tx, c.Dialect(), &settlement)
+	if err == nil {
+		err = c.writeAudit(tx, string(settlement.Kind), AuditFine, settlement.FineID, nil, &settlement)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	entry.FineID = settlement.FineID
+	entry.CreatedAt = settlement.CreatedAt
+	return nil
+}
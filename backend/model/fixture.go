@@ -0,0 +1,201 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+var fixtureNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// fixtureExtensions are the files a fixture set is looked for in, in order
+var fixtureExtensions = []string{".yaml", ".yml", ".json"}
+
+// Fixture is a set of books, cards and loans loaded after a reset. Loans refer to the books and cards by their Key.
+type Fixture struct {
+	Books []FixtureBook `json:"books" yaml:"books"`
+	Cards []FixtureCard `json:"cards" yaml:"cards"`
+	Loans []FixtureLoan `json:"loans" yaml:"loans"`
+}
+
+type FixtureBook struct {
+	Key         string  `json:"key" yaml:"key"`
+	Category    string  `json:"category" yaml:"category"`
+	Title       string  `json:"title" yaml:"title"`
+	Press       string  `json:"press" yaml:"press"`
+	PublishYear int     `json:"publish_year" yaml:"publish_year"`
+	Author      string  `json:"author" yaml:"author"`
+	Price       float32 `json:"price" yaml:"price"`
+	Stock       int     `json:"stock" yaml:"stock"`
+	ISBN        string  `json:"isbn" yaml:"isbn"`
+}
+
+type FixtureCard struct {
+	Key        string `json:"key" yaml:"key"`
+	Name       string `json:"name" yaml:"name"`
+	Department string `json:"department" yaml:"department"`
+	Type       string `json:"type" yaml:"type"`
+}
+
+// FixtureLoan lends the book to the card, and returns it at once if Returned is set
+type FixtureLoan struct {
+	Card     string `json:"card" yaml:"card"`
+	Book     string `json:"book" yaml:"book"`
+	Returned bool   `json:"returned" yaml:"returned"`
+}
+
+// LoadFixture reads the fixture set name from its YAML or JSON file in dir
+func LoadFixture(dir string, name string) (*Fixture, error) {
+	if !fixtureNamePattern.MatchString(name) {
+		return nil, ErrInvalidFixtureName
+	}
+
+	for _, extension := range fixtureExtensions {
+		data, err := os.ReadFile(filepath.Join(dir, name+extension))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var fixture Fixture
+		if extension == ".json" {
+			err = json.Unmarshal(data, &fixture)
+		} else {
+			err = yaml.Unmarshal(data, &fixture)
+		}
+		if err == nil {
+			err = fixture.check()
+		}
+		if err != nil {
+			return nil, WrapError(ErrValidation, fmt.Errorf("fixture %s: %w", name, err))
+		}
+		return &fixture, nil
+	}
+	return nil, ErrFixtureNotFound
+}
+
+func (f *Fixture) check() error {
+	books := make(map[string]bool)
+	for _, book := range f.Books {
+		if book.Key == "" {
+			continue
+		}
+		if books[book.Key] {
+			return fmt.Errorf("duplicate book key %s", book.Key)
+		}
+		books[book.Key] = true
+	}
+	cards := make(map[string]bool)
+	for _, card := range f.Cards {
+		if card.Key == "" {
+			continue
+		}
+		if cards[card.Key] {
+			return fmt.Errorf("duplicate card key %s", card.Key)
+		}
+		cards[card.Key] = true
+	}
+
+	// a card borrows a book once, the borrow records of a card and book are told apart by the second they began
+	loans := make(map[FixtureLoan]bool)
+	for _, loan := range f.Loans {
+		if !cards[loan.Card] || !books[loan.Book] {
+			return fmt.Errorf("loan of book %q to card %q refers to no book or card", loan.Book, loan.Card)
+		}
+		pair := FixtureLoan{Card: loan.Card, Book: loan.Book}
+		if loans[pair] {
+			return fmt.Errorf("book %s lent to card %s twice", loan.Book, loan.Card)
+		}
+		loans[pair] = true
+	}
+	return nil
+}
+
+// Validate checks the fixture loads only into tables the reset clears and loads it into an empty memory database, so
+// that a fixture breaking a rule of the connectors, e.g. lending a book out of stock, is refused before the reset
+// rather than loaded halfway after it
+func (f *Fixture) Validate(tables []string) error {
+	if f == nil {
+		return ErrNilFixture
+	}
+	cleared := make(map[string]bool)
+	for _, table := range tables {
+		cleared[table] = true
+	}
+	for _, loaded := range []struct {
+		table string
+		rows  int
+	}{
+		{TableName(Book{}), len(f.Books)},
+		{TableName(Card{}), len(f.Cards)},
+		{TableName(Borrow{}), len(f.Loans)},
+	} {
+		if loaded.rows != 0 && !cleared[loaded.table] {
+			return WrapError(ErrValidation, fmt.Errorf("%w: %s", ErrFixtureTableKept, loaded.table))
+		}
+	}
+
+	err := f.Apply(NewMemoryConnector(""))
+	if err != nil {
+		return WrapError(ErrValidation, fmt.Errorf("fixture: %v", err))
+	}
+	return nil
+}
+
+// Apply stores the books and cards of the fixture and lends the books of its loans through connector
+func (f *Fixture) Apply(connector Connector) error {
+	if f == nil {
+		return ErrNilFixture
+	}
+
+	books := make(map[string]int)
+	for _, item := range f.Books {
+		book := Book{
+			Category:    item.Category,
+			Title:       item.Title,
+			Press:       item.Press,
+			PublishYear: item.PublishYear,
+			Author:      item.Author,
+			Price:       myFloat(item.Price),
+			Stock:       item.Stock,
+		}
+		if item.ISBN != "" {
+			isbn := item.ISBN
+			book.ISBN = &isbn
+		}
+		err := connector.StoreBook(&book)
+		if err != nil {
+			return fmt.Errorf("book %q: %w", item.Title, err)
+		}
+		books[item.Key] = book.BookID
+	}
+
+	cards := make(map[string]int)
+	for _, item := range f.Cards {
+		card := Card{Name: item.Name, Department: item.Department, Type: item.Type}
+		err := connector.RegisterCard(&card)
+		if err != nil {
+			return fmt.Errorf("card %q: %w", item.Name, err)
+		}
+		cards[item.Key] = card.CardID
+	}
+
+	for _, loan := range f.Loans {
+		borrow := Borrow{CardID: cards[loan.Card], BookID: books[loan.Book]}
+		err := connector.BorrowBook(&borrow)
+		if err == nil && loan.Returned {
+			err = connector.ReturnBook(&Borrow{CardID: borrow.CardID, BookID: borrow.BookID})
+		}
+		if err != nil {
+			return fmt.Errorf("loan of book %s to card %s: %w", loan.Book, loan.Card, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+type HoldStatus string
+
+const (
+	HoldWaiting   HoldStatus = "waiting"
+	HoldReady     HoldStatus = "ready"
+	HoldFulfilled HoldStatus = "fulfilled"
+	HoldCancelled HoldStatus = "cancelled"
+	HoldExpired   HoldStatus = "expired"
+)
+
+// Hold is a place in the queue of a book. A ready hold has a copy set aside, taken out of the stock of
+// the book, until ExpireTime.
+type Hold struct {
+	HoldID     int        `json:"hold_id" sql:"not null;autoIncrement;primaryKey"`
+	CardID     int        `json:"card_id" sql:"not null;index;constraint:Card.CardID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	BookID     int        `json:"book_id" sql:"not null;index:idx_hold_queue;constraint:Book.BookID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	Status     HoldStatus `json:"status" sql:"not null;index:idx_hold_queue;enum:waiting,ready,fulfilled,cancelled,expired"`
+	Priority   int        `json:"priority" sql:"not null;default:0"`
+	CreatedAt  int64      `json:"created_at" sql:"not null"`
+	ReadyAt    int64      `json:"ready_at" sql:"not null;default:0"`
+	ExpireTime int64      `json:"expire_time" sql:"not null;default:0"`
+	// Position is the place of a waiting hold in the queue of its book, starting at 1
+	Position int `json:"position,omitempty" sql:"-"`
+}
+
+type HoldRequest struct {
+	CardID *int `json:"card_id,omitempty"`
+	BookID *int `json:"book_id,omitempty"`
+}
+
+type HoldList struct {
+	Count int    `json:"count"`
+	Holds []Hold `json:"holds"`
+}
+
+func (h *Hold) Active() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
+}
+
+// servedBefore reports whether waiting hold a comes before waiting hold b in the queue of their book
+func (a *Hold) servedBefore(b *Hold) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt < b.CreatedAt
+	}
+	return a.HoldID < b.HoldID
+}
+
+const holdQueueOrder = " ORDER BY priority DESC, created_at, hold_id"
+
+func scanHolds(rows *sql.Rows) ([]Hold, error) {
+	defer rows.Close()
+
+	holds := make([]Hold, 0)
+	for rows.Next() {
+		var hold Hold
+		err := rows.Scan(&hold.HoldID, &hold.CardID, &hold.BookID, &hold.Status, &hold.Priority,
+			&hold.CreatedAt, &hold.ReadyAt, &hold.ExpireTime)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+func holdPosition(executor SQLExecutor, hold *Hold) (int, error) {
+	var position int
+	err := queryRow(executor,
+		"SELECT COUNT(*) FROM hold WHERE book_id = ? AND status = ? AND "+
+			"(priority > ? OR (priority = ? AND (created_at < ? OR (created_at = ? AND hold_id <= ?))))",
+		hold.BookID, HoldWaiting, hold.Priority, hold.Priority, hold.CreatedAt, hold.CreatedAt, hold.HoldID).Scan(&position)
+	return position, err
+}
+
+// promoteHolds sets the copies in stock aside for the first waiting holds of the book
+func promoteHolds(executor SQLExecutor, bookId int, now int64) error {
+	var stock int
+	err := queryRow(executor, "SELECT stock FROM book WHERE book_id = ?", bookId).Scan(&stock)
+	if err != nil {
+		return err
+	}
+	if stock <= 0 {
+		return nil
+	}
+
+	rows, err := executor.Query("SELECT * FROM hold WHERE book_id = ? AND status = ?"+holdQueueOrder, bookId, HoldWaiting)
+	if err != nil {
+		return err
+	}
+	waiting, err := scanHolds(rows)
+	if err != nil {
+		return err
+	}
+	if len(waiting) > stock {
+		waiting = waiting[:stock]
+	}
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	expireTime := CurrentHoldPolicy().ExpireTime(now)
+	for _, hold := range waiting {
+		_, err = executor.Exec("UPDATE hold SET status = ?, ready_at = ?, expire_time = ? WHERE hold_id = ?",
+			HoldReady, now, expireTime, hold.HoldID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = moveCopies(executor, bookId, CopyAvailable, CopyOnHold, len(waiting), false)
+	if err != nil {
+		return err
+	}
+	return syncStock(executor, bookId)
+}
+
+// releaseHolds closes the active holds of a book matching condition, puts the copies set aside for them
+// back in stock and passes them to the next holds
+func releaseHolds(executor SQLExecutor, bookId int, status HoldStatus, now int64, condition string, args ...any) (int, error) {
+	var ready int
+	err := queryRow(executor, "SELECT COUNT(*) FROM hold WHERE book_id = ? AND status = ? AND "+condition,
+		append([]any{bookId, HoldReady}, args...)...).Scan(&ready)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := executor.Exec("UPDATE hold SET status = ? WHERE book_id = ? AND status IN (?, ?) AND "+condition,
+		append([]any{status, bookId, HoldWaiting, HoldReady}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	released, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if ready > 0 {
+		_, err = moveCopies(executor, bookId, CopyOnHold, CopyAvailable, ready, false)
+		if err != nil {
+			return 0, err
+		}
+		err = syncStock(executor, bookId)
+		if err != nil {
+			return 0, err
+		}
+		err = promoteHolds(executor, bookId, now)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(released), nil
+}
+
+// expireBookHolds expires the ready holds of the book that were not claimed in time
+func expireBookHolds(executor SQLExecutor, bookId int, now int64) (int, error) {
+	return releaseHolds(executor, bookId, HoldExpired, now, "status = ? AND expire_time <= ?", HoldReady, now)
+}
+
+// PlaceHold queues the card for a book out of stock, hold.CardID and hold.BookID are read and the other
+// fields are set
+func (c *DatabaseConnector) PlaceHold(hold *Hold) error {
+	if hold == nil {
+		return ErrNilHold
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	_, err = expireBookHolds(tx, hold.BookID, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := tx.Query("SELECT stock FROM book WHERE book_id = ?"+c.Dialect().LockForUpdate(), hold.BookID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	var stock int
+	if rows.Next() {
+		rows.Scan(&stock)
+	} else {
+		rows.Close()
+		tx.Rollback()
+		return ErrBookNotFound
+	}
+	rows.Close()
+
+	var cardType string
+	err = queryRow(tx, "SELECT type FROM card WHERE card_id = ?", hold.CardID).Scan(&cardType)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrCardNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var count int
+	err = queryRow(tx, "SELECT COUNT(*) FROM borrow WHERE book_id = ? AND card_id = ? AND return_time = 0",
+		hold.BookID, hold.CardID).Scan(&count)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return ErrAlreadyBorrowed
+	}
+
+	err = queryRow(tx, "SELECT COUNT(*) FROM hold WHERE book_id = ? AND card_id = ? AND status IN (?, ?)",
+		hold.BookID, hold.CardID, HoldWaiting, HoldReady).Scan(&count)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return ErrDuplicateHold
+	}
+
+	if stock > 0 {
+		tx.Rollback()
+		return ErrBookAvailable
+	}
+
+	hold.Status = HoldWaiting
+	hold.Priority = CurrentHoldPolicy().Priority(cardType)
+	hold.CreatedAt = now
+	hold.ReadyAt = 0
+	hold.ExpireTime = 0
+
+	insertSQL := "INSERT INTO hold (card_id, book_id, status, priority, created_at, ready_at, expire_time) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	insertedID, err := c.Dialect().InsertReturningID(tx, insertSQL, "hold_id",
+		hold.CardID, hold.BookID, hold.Status, hold.Priority, hold.CreatedAt, hold.ReadyAt, hold.ExpireTime)
+	if err != nil {
+		tx.Rollback()
+		return c.Dialect().ConstraintError(err)
+	}
+	hold.HoldID = int(insertedID)
+
+	hold.Position, err = holdPosition(tx, hold)
+	if err == nil {
+		err = c.writeAudit(tx, "place", AuditHold, hold.HoldID, nil, hold)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// CancelHold withdraws an active hold, a copy set aside for it goes to the next hold
+func (c *DatabaseConnector) CancelHold(holdId int) error {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT * FROM hold WHERE hold_id = ?"+c.Dialect().LockForUpdate(), holdId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	holds, err := scanHolds(rows)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if len(holds) == 0 {
+		tx.Rollback()
+		return ErrHoldNotFound
+	}
+	if !holds[0].Active() {
+		tx.Rollback()
+		return ErrHoldNotActive
+	}
+
+	_, err = releaseHolds(tx, holds[0].BookID, HoldCancelled, time.Now().Unix(), "hold_id = ?", holdId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	cancelled := holds[0]
+	cancelled.Status = HoldCancelled
+	err = c.writeAudit(tx, "cancel", AuditHold, holdId, &holds[0], &cancelled)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// ShowCardHolds lists the holds of a card, newest first
+func (c *DatabaseConnector) ShowCardHolds(cardId int) (*HoldList, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = queryRow(tx, "SELECT COUNT(*) FROM card WHERE card_id = ?", cardId).Scan(&count)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if count == 0 {
+		tx.Rollback()
+		return nil, ErrCardNotFound
+	}
+
+	rows, err := tx.Query("SELECT * FROM hold WHERE card_id = ? ORDER BY created_at DESC, hold_id DESC", cardId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	holds, err := scanHolds(rows)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for i := range holds {
+		if holds[i].Status == HoldWaiting {
+			holds[i].Position, err = holdPosition(tx, &holds[i])
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &HoldList{Count: len(holds), Holds: holds}, nil
+}
+
+// ShowBookHolds lists the active holds of a book, the ready ones then the waiting ones in queue order
+func (c *DatabaseConnector) ShowBookHolds(bookId int) (*HoldList, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = queryRow(tx, "SELECT COUNT(*) FROM book WHERE book_id = ?", bookId).Scan(&count)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if count == 0 {
+		tx.Rollback()
+		return nil, ErrBookNotFound
+	}
+
+	rows, err := tx.Query("SELECT * FROM hold WHERE book_id = ? AND status = ? ORDER BY ready_at, hold_id", bookId, HoldReady)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	holds, err := scanHolds(rows)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	rows, err = tx.Query("SELECT * FROM hold WHERE book_id = ? AND status = ?"+holdQueueOrder, bookId, HoldWaiting)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	waiting, err := scanHolds(rows)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for i := range waiting {
+		waiting[i].Position = i + 1
+	}
+	holds = append(holds, waiting...)
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &HoldList{Count: len(holds), Holds: holds}, nil
+}
+
+// ExpireHolds expires the ready holds that were not claimed in time and returns how many
+func (c *DatabaseConnector) ExpireHolds() (int, error) {
+	now := time.Now().Unix()
+	rows, err := c.DB.Query("SELECT DISTINCT book_id FROM hold WHERE status = ? AND expire_time <= ?", HoldReady, now)
+	if err != nil {
+		return 0, err
+	}
+	var bookIds []int
+	for rows.Next() {
+		var bookId int
+		err = rows.Scan(&bookId)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		bookIds = append(bookIds, bookId)
+	}
+	rows.Close()
+
+	expired := 0
+	for _, bookId := range bookIds {
+		tx, err := c.DB.Begin()
+		if err != nil {
+			return expired, err
+		}
+		var stock int
+		err = queryRow(tx, "SELECT stock FROM book WHERE book_id = ?"+c.Dialect().LockForUpdate(), bookId).Scan(&stock)
+		if err != nil {
+			tx.Rollback()
+			return expired, err
+		}
+		rows, err := tx.Query("SELECT * FROM hold WHERE book_id = ? AND status = ? AND expire_time <= ?", bookId, HoldReady, now)
+		if err != nil {
+			tx.Rollback()
+			return expired, err
+		}
+		holds, err := scanHolds(rows)
+		if err != nil {
+			tx.Rollback()
+			return expired, err
+		}
+		n, err := expireBookHolds(tx, bookId, now)
+		if err != nil {
+			tx.Rollback()
+			return expired, err
+		}
+		for i := range holds {
+			expiredHold := holds[i]
+			expiredHold.Status = HoldExpired
+			err = c.writeAudit(tx, "expire", AuditHold, expiredHold.HoldID, &holds[i], &expiredHold)
+			if err != nil {
+				tx.Rollback()
+				return expired, err
+			}
+		}
+		err = tx.Commit()
+		if err != nil {
+			return expired, err
+		}
+		expired += n
+	}
+	return expired, nil
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var checkNamePattern = regexp.MustCompile(`(?i)CONSTRAINT\s+(\w+)\s+CHECK`)
+
+type rowScanner struct {
+	rows *sql.Rows
+	err  error
+}
+
+func (r *rowScanner) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	return r.rows.Scan(dest...)
+}
+
+func queryRow(executor SQLExecutor, query string, args ...any) *rowScanner {
+	rows, err := executor.Query(query, args...)
+	return &rowScanner{rows: rows, err: err}
+}
+
+var integerWidthPattern = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|integer|bigint)\(\d+\)`)
+
+// normalizeType makes declared and reported column types comparable, e.g. "INT" and "int(11)"
+func normalizeType(columnType string) string {
+	columnType = strings.ToLower(strings.TrimSpace(columnType))
+	columnType = integerWidthPattern.ReplaceAllString(columnType, "$1")
+	return strings.Join(strings.Fields(columnType), " ")
+}
+
+var defaultCastPattern = regexp.MustCompile(`(::[\w ]+)+$`)
+
+// normalizeDefault strips the quotes, and the casts PostgreSQL adds, from a default value
+func normalizeDefault(value *string) string {
+	if value == nil {
+		return "NULL"
+	}
+	return strings.Trim(defaultCastPattern.ReplaceAllString(strings.TrimSpace(*value), ""), "'\"")
+}
+
+func sameColumns(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameColumnSet(a []string, b []string) bool {
+	x := append([]string(nil), a...)
+	y := append([]string(nil), b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	return sameColumns(x, y)
+}
@@ -0,0 +1,120 @@
+package model
+
+import "strings"
+
+// NormalizeISBN checks the checksum of an ISBN-10 or ISBN-13, hyphens and spaces ignored, and returns it as
+// an ISBN-13
+func NormalizeISBN(isbn string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		if r == 'x' {
+			return 'X'
+		}
+		return r
+	}, isbn)
+
+	switch len(digits) {
+	case 10:
+		if !validISBN10(digits) {
+			return "", ErrInvalidISBN
+		}
+		isbn13 := "978" + digits[:9]
+		return isbn13 + string(isbn13CheckDigit(isbn13)), nil
+	case 13:
+		if !allDigits(digits) || !strings.HasPrefix(digits, "978") && !strings.HasPrefix(digits, "979") {
+			return "", ErrInvalidISBN
+		}
+		if isbn13CheckDigit(digits[:12]) != digits[12] {
+			return "", ErrInvalidISBN
+		}
+		return digits, nil
+	}
+	return "", ErrInvalidISBN
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validISBN10 checks the weighted sum of an ISBN-10 is a multiple of 11, its check digit may be X for 10
+func validISBN10(digits string) bool {
+	if !allDigits(digits[:9]) {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(digits[i]-'0') * (10 - i)
+	}
+	switch check := digits[9]; {
+	case check == 'X':
+		sum += 10
+	case check >= '0' && check <= '9':
+		sum += int(check - '0')
+	default:
+		return false
+	}
+	return sum%11 == 0
+}
+
+func isbn13CheckDigit(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(first12[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// normalizeISBN stores the ISBN of the book as an ISBN-13, an empty ISBN means the book has none
+func (b *Book) normalizeISBN() error {
+	if b.ISBN == nil {
+		return nil
+	}
+	if *b.ISBN == "" {
+		b.ISBN = nil
+		return nil
+	}
+	isbn, err := NormalizeISBN(*b.ISBN)
+	if err != nil {
+		return err
+	}
+	b.ISBN = &isbn
+	return nil
+}
+
+// sameISBN reports whether two books have the same ISBN, books without one never do
+func sameISBN(a *Book, b *Book) bool {
+	return a.ISBN != nil && b.ISBN != nil && *a.ISBN == *b.ISBN
+}
+
+// DedupeBooksByISBN merges the books sharing an ISBN into the first of them, adding up their stock
+func DedupeBooksByISBN(books []Book) ([]Book, error) {
+	deduped := make([]Book, 0, len(books))
+	for _, book := range books {
+		if err := book.normalizeISBN(); err != nil {
+			return nil, err
+		}
+		merged := false
+		for i := range deduped {
+			if sameISBN(&deduped[i], &book) {
+				deduped[i].Stock += book.Stock
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			deduped = append(deduped, book)
+		}
+	}
+	return deduped, nil
+}
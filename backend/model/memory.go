@@ -0,0 +1,2539 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MemoryConnector struct {
+	*memoryStore
+	// audit is who makes the changes through the connector, see WithAudit
+	audit *AuditContext
+}
+
+// memoryStore is the data of a MemoryConnector, shared by the connectors WithAudit returns
+type memoryStore struct {
+	Path string
+
+	mu           sync.RWMutex
+	books        map[int]*Book
+	cards        map[int]*Card
+	borrows      []Borrow
+	fines        []FineEntry
+	holds        []Hold
+	copies       []BookCopy
+	authors      map[int]*Author
+	links        []BookAuthor
+	credentials  map[int]*CardCredential
+	patrons      map[string]*PatronSession
+	users        map[int]*User
+	sessions     map[string]*UserSession
+	nextBookID   int
+	nextCardID   int
+	nextFineID   int
+	nextHoldID   int
+	nextCopyID   int
+	nextAuthorID int
+	nextUserID   int
+	audits       []AuditEntry
+	nextAuditID  int
+}
+
+// snapshotUser keeps the password hash of a user in a snapshot, it is left out of the JSON of a User
+type snapshotUser struct {
+	User
+	PasswordHash string `json:"password_hash"`
+}
+
+type memorySnapshot struct {
+	NextBookID     int              `json:"next_book_id"`
+	NextCardID     int              `json:"next_card_id"`
+	NextFineID     int              `json:"next_fine_id"`
+	NextHoldID     int              `json:"next_hold_id"`
+	NextCopyID     int              `json:"next_copy_id"`
+	NextAuthorID   int              `json:"next_author_id"`
+	NextUserID     int              `json:"next_user_id"`
+	NextAuditID    int              `json:"next_audit_id"`
+	Books          []Book           `json:"books"`
+	Cards          []Card           `json:"cards"`
+	Borrows        []Borrow         `json:"borrows"`
+	Fines          []FineEntry      `json:"fines"`
+	Holds          []Hold           `json:"holds"`
+	Copies         []BookCopy       `json:"copies"`
+	Authors        []Author         `json:"authors"`
+	BookAuthors    []BookAuthor     `json:"book_authors"`
+	Credentials    []CardCredential `json:"card_credentials"`
+	PatronSessions []PatronSession  `json:"patron_sessions"`
+	Users          []snapshotUser   `json:"users"`
+	Sessions       []UserSession    `json:"sessions"`
+	Audits         []AuditEntry     `json:"audit_log"`
+}
+
+func NewMemoryConnector(path string) *MemoryConnector {
+	c := &MemoryConnector{memoryStore: &memoryStore{Path: path}}
+	c.reset()
+	c.resetUsers()
+	c.resetAudits()
+	return c
+}
+
+// reset clears the library, the users and their sessions are kept like ResetDatabase of a database does
+func (c *MemoryConnector) reset() {
+	c.books = make(map[int]*Book)
+	c.cards = make(map[int]*Card)
+	c.borrows = make([]Borrow, 0)
+	c.fines = make([]FineEntry, 0)
+	c.holds = make([]Hold, 0)
+	c.copies = make([]BookCopy, 0)
+	c.authors = make(map[int]*Author)
+	c.links = make([]BookAuthor, 0)
+	c.credentials = make(map[int]*CardCredential)
+	c.patrons = make(map[string]*PatronSession)
+	c.nextBookID = 1
+	c.nextCardID = 1
+	c.nextFineID = 1
+	c.nextHoldID = 1
+	c.nextCopyID = 1
+	c.nextAuthorID = 1
+}
+
+func (c *MemoryConnector) resetUsers() {
+	c.users = make(map[int]*User)
+	c.sessions = make(map[string]*UserSession)
+	c.nextUserID = 1
+}
+
+func (c *MemoryConnector) resetAudits() {
+	c.audits = make([]AuditEntry, 0)
+	c.nextAuditID = 1
+}
+
+func (c *MemoryConnector) Connect() error {
+	if c.Path == "" {
+		return nil
+	}
+	if _, err := os.Stat(c.Path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return c.LoadSnapshot(c.Path)
+}
+
+func (c *MemoryConnector) Close() error {
+	if c.Path == "" {
+		return nil
+	}
+	return c.SaveSnapshot(c.Path)
+}
+
+func (c *MemoryConnector) AutoMigrate(args ...any) error {
+	return nil
+}
+
+func (c *MemoryConnector) Migrate() error {
+	return nil
+}
+
+func (c *MemoryConnector) ResetDatabase(tables ...string) error {
+	resolved, err := ResolveResetTables(tables)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(tables) == 0 {
+		c.reset()
+	} else {
+		c.clearTables(resolved)
+	}
+	return c.writeAudit("reset", AuditDatabase, "", nil, &ResetOptions{Tables: resolved})
+}
+
+// clearTables clears the tables like DELETE would, the ids go on from where they were, and frees the copies of the
+// loans and holds cleared like resetCopies
+func (c *MemoryConnector) clearTables(tables []string) {
+	clearers := map[string]func(){
+		TableName(Book{}):           func() { c.books = make(map[int]*Book) },
+		TableName(Card{}):           func() { c.cards = make(map[int]*Card) },
+		TableName(Borrow{}):         func() { c.borrows = make([]Borrow, 0) },
+		TableName(FineEntry{}):      func() { c.fines = make([]FineEntry, 0) },
+		TableName(Hold{}):           func() { c.holds = make([]Hold, 0) },
+		TableName(BookCopy{}):       func() { c.copies = make([]BookCopy, 0) },
+		TableName(Author{}):         func() { c.authors = make(map[int]*Author) },
+		TableName(BookAuthor{}):     func() { c.links = make([]BookAuthor, 0) },
+		TableName(CardCredential{}): func() { c.credentials = make(map[int]*CardCredential) },
+		TableName(PatronSession{}):  func() { c.patrons = make(map[string]*PatronSession) },
+	}
+	cleared := make(map[string]bool)
+	for _, table := range tables {
+		clearers[table]()
+		cleared[table] = true
+	}
+
+	if !cleared[TableName(BookCopy{})] {
+		for i := range c.copies {
+			bookCopy := &c.copies[i]
+			if (bookCopy.Status == CopyOnLoan && cleared[TableName(Borrow{})]) ||
+				(bookCopy.Status == CopyOnHold && cleared[TableName(Hold{})]) {
+				bookCopy.Status = CopyAvailable
+			}
+		}
+	}
+	for bookId := range c.books {
+		c.syncStock(bookId)
+	}
+}
+
+func (c *MemoryConnector) SaveSnapshot(path string) error {
+	c.mu.RLock()
+	snapshot := memorySnapshot{
+		NextBookID:   c.nextBookID,
+		NextCardID:   c.nextCardID,
+		NextFineID:   c.nextFineID,
+		NextHoldID:   c.nextHoldID,
+		NextCopyID:   c.nextCopyID,
+		NextAuthorID: c.nextAuthorID,
+		NextUserID:   c.nextUserID,
+		NextAuditID:  c.nextAuditID,
+		Books:        c.sortedBooks(),
+		Cards:        c.sortedCards(),
+		Borrows:      append([]Borrow(nil), c.borrows...),
+		Fines:        append([]FineEntry(nil), c.fines...),
+		Holds:        append([]Hold(nil), c.holds...),
+		Copies:       append([]BookCopy(nil), c.copies...),
+		Authors:      c.sortedAuthors(),
+		BookAuthors:  append([]BookAuthor(nil), c.links...),
+		Audits:       append([]AuditEntry(nil), c.audits...),
+	}
+	for _, card := range snapshot.Cards {
+		if credential, ok := c.credentials[card.CardID]; ok {
+			snapshot.Credentials = append(snapshot.Credentials, *credential)
+		}
+	}
+	for _, session := range c.patrons {
+		snapshot.PatronSessions = append(snapshot.PatronSessions, *session)
+	}
+	sort.Slice(snapshot.PatronSessions, func(i, j int) bool {
+		return snapshot.PatronSessions[i].TokenHash < snapshot.PatronSessions[j].TokenHash
+	})
+	for _, user := range c.sortedUsers() {
+		snapshot.Users = append(snapshot.Users, snapshotUser{User: user, PasswordHash: user.PasswordHash})
+	}
+	for _, session := range c.sessions {
+		snapshot.Sessions = append(snapshot.Sessions, *session)
+	}
+	sort.Slice(snapshot.Sessions, func(i, j int) bool {
+		return snapshot.Sessions[i].TokenHash < snapshot.Sessions[j].TokenHash
+	})
+	c.mu.RUnlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated snapshot
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (c *MemoryConnector) LoadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var snapshot memorySnapshot
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reset()
+	c.resetUsers()
+	c.resetAudits()
+	for i := range snapshot.Books {
+		book := snapshot.Books[i]
+		// snapshots taken before books had versions
+		if book.Version == 0 {
+			book.Version = 1
+		}
+		c.books[book.BookID] = &book
+		if book.BookID >= c.nextBookID {
+			c.nextBookID = book.BookID + 1
+		}
+	}
+	for i := range snapshot.Cards {
+		card := snapshot.Cards[i]
+		if card.Version == 0 {
+			card.Version = 1
+		}
+		c.cards[card.CardID] = &card
+		if card.CardID >= c.nextCardID {
+			c.nextCardID = card.CardID + 1
+		}
+	}
+	if snapshot.Borrows != nil {
+		c.borrows = snapshot.Borrows
+	}
+	for _, fine := range snapshot.Fines {
+		c.fines = append(c.fines, fine)
+		if fine.FineID >= c.nextFineID {
+			c.nextFineID = fine.FineID + 1
+		}
+	}
+	for _, hold := range snapshot.Holds {
+		c.holds = append(c.holds, hold)
+		if hold.HoldID >= c.nextHoldID {
+			c.nextHoldID = hold.HoldID + 1
+		}
+	}
+	if snapshot.NextBookID > c.nextBookID {
+		c.nextBookID = snapshot.NextBookID
+	}
+	if snapshot.NextCardID > c.nextCardID {
+		c.nextCardID = snapshot.NextCardID
+	}
+	if snapshot.NextFineID > c.nextFineID {
+		c.nextFineID = snapshot.NextFineID
+	}
+	for _, bookCopy := range snapshot.Copies {
+		c.copies = append(c.copies, bookCopy)
+		if bookCopy.CopyID >= c.nextCopyID {
+			c.nextCopyID = bookCopy.CopyID + 1
+		}
+	}
+	if snapshot.NextHoldID > c.nextHoldID {
+		c.nextHoldID = snapshot.NextHoldID
+	}
+	if snapshot.NextCopyID > c.nextCopyID {
+		c.nextCopyID = snapshot.NextCopyID
+	}
+	for i := range snapshot.Authors {
+		author := snapshot.Authors[i]
+		c.authors[author.AuthorID] = &author
+		if author.AuthorID >= c.nextAuthorID {
+			c.nextAuthorID = author.AuthorID + 1
+		}
+	}
+	if snapshot.BookAuthors != nil {
+		c.links = snapshot.BookAuthors
+	}
+	if snapshot.NextAuthorID > c.nextAuthorID {
+		c.nextAuthorID = snapshot.NextAuthorID
+	}
+	if snapshot.Copies == nil {
+		c.backfillCopies()
+	}
+	if snapshot.Authors == nil {
+		c.backfillAuthors()
+	}
+	for i := range snapshot.Credentials {
+		credential := snapshot.Credentials[i]
+		c.credentials[credential.CardID] = &credential
+	}
+	for i := range snapshot.PatronSessions {
+		session := snapshot.PatronSessions[i]
+		c.patrons[session.TokenHash] = &session
+	}
+	for _, stored := range snapshot.Users {
+		user := stored.User
+		user.PasswordHash = stored.PasswordHash
+		c.users[user.UserID] = &user
+		if user.UserID >= c.nextUserID {
+			c.nextUserID = user.UserID + 1
+		}
+	}
+	if snapshot.NextUserID > c.nextUserID {
+		c.nextUserID = snapshot.NextUserID
+	}
+	for i := range snapshot.Sessions {
+		session := snapshot.Sessions[i]
+		c.sessions[session.TokenHash] = &session
+	}
+	for _, entry := range snapshot.Audits {
+		c.audits = append(c.audits, entry)
+		if entry.AuditID >= c.nextAuditID {
+			c.nextAuditID = entry.AuditID + 1
+		}
+	}
+	if snapshot.NextAuditID > c.nextAuditID {
+		c.nextAuditID = snapshot.NextAuditID
+	}
+	return nil
+}
+
+// backfillAuthors fills the authors registry of a snapshot saved before it existed from the Author strings
+func (c *MemoryConnector) backfillAuthors() {
+	for _, book := range c.sortedBooks() {
+		contributors := make([]Contributor, 0)
+		for _, name := range splitAuthors(book.Author) {
+			if utf8.RuneCountInString(name) > 63 {
+				name = string([]rune(name)[:63])
+			}
+			contributors = append(contributors, Contributor{Name: name, Role: RoleAuthor, Position: len(contributors) + 1})
+		}
+		c.resolveAuthors(contributors)
+		c.linkAuthors(book.BookID, contributors)
+	}
+}
+
+// backfillCopies creates the copies of a snapshot saved before copies were tracked: one per book in stock, on
+// loan or set aside for a hold
+func (c *MemoryConnector) backfillCopies() {
+	for _, book := range c.sortedBooks() {
+		// the stock of a book may have been left below 0 before copies were tracked, it gets none then
+		if book.Stock > 0 {
+			c.insertCopies(book.BookID, make([]BookCopy, book.Stock))
+		}
+		for i := range c.borrows {
+			loan := &c.borrows[i]
+			if loan.BookID == book.BookID && loan.ReturnTime == 0 {
+				added := []BookCopy{{Status: CopyOnLoan}}
+				c.insertCopies(book.BookID, added)
+				loan.Barcode = added[0].Barcode
+			}
+		}
+		for _, hold := range c.holds {
+			if hold.BookID == book.BookID && hold.Status == HoldReady {
+				c.insertCopies(book.BookID, []BookCopy{{Status: CopyOnHold}})
+			}
+		}
+	}
+}
+
+func (c *MemoryConnector) sortedBooks() []Book {
+	books := make([]Book, 0, len(c.books))
+	for _, book := range c.books {
+		books = append(books, *book)
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].BookID < books[j].BookID
+	})
+	return books
+}
+
+func (c *MemoryConnector) sortedAuthors() []Author {
+	authors := make([]Author, 0, len(c.authors))
+	for _, author := range c.authors {
+		authors = append(authors, *author)
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		return authors[i].AuthorID < authors[j].AuthorID
+	})
+	return authors
+}
+
+func (c *MemoryConnector) sortedCards() []Card {
+	cards := make([]Card, 0, len(c.cards))
+	for _, card := range c.cards {
+		cards = append(cards, *card)
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		return cards[i].CardID < cards[j].CardID
+	})
+	return cards
+}
+
+func (c *MemoryConnector) sortedUsers() []User {
+	users := make([]User, 0, len(c.users))
+	for _, user := range c.users {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserID < users[j].UserID
+	})
+	return users
+}
+
+func sameBook(a *Book, b *Book) bool {
+	return strings.EqualFold(a.Category, b.Category) &&
+		strings.EqualFold(a.Title, b.Title) &&
+		strings.EqualFold(a.Press, b.Press) &&
+		a.PublishYear == b.PublishYear &&
+		strings.EqualFold(a.Author, b.Author)
+}
+
+func (c *MemoryConnector) findDuplicateBook(book *Book) bool {
+	for _, existing := range c.books {
+		if existing.BookID != book.BookID && (sameBook(existing, book) || sameISBN(existing, book)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MemoryConnector) hasOpenBorrow(match func(borrow *Borrow) bool) bool {
+	for i := range c.borrows {
+		if c.borrows[i].ReturnTime == 0 && match(&c.borrows[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MemoryConnector) removeBorrows(match func(borrow *Borrow) bool) {
+	kept := c.borrows[:0]
+	for _, borrow := range c.borrows {
+		if !match(&borrow) {
+			kept = append(kept, borrow)
+		}
+	}
+	c.borrows = kept
+}
+
+func (c *MemoryConnector) StoreBook(book *Book) error {
+	if book == nil {
+		return ErrNilBook
+	}
+	if book.Stock < 0 {
+		return ErrNegativeStock
+	}
+
+	err := book.normalizeISBN()
+	if err != nil {
+		return err
+	}
+	err = book.resolveContributors()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	book.BookID = 0
+	if c.findDuplicateBook(book) {
+		return ErrDuplicateBook
+	}
+	err = c.resolveAuthors(book.Contributors)
+	if err != nil {
+		return err
+	}
+
+	book.BookID = c.nextBookID
+	c.nextBookID++
+	book.Version, book.UpdatedAt = 1, time.Now().Unix()
+	stored := *book
+	stored.Contributors = nil
+	c.books[stored.BookID] = &stored
+	c.insertCopies(stored.BookID, make([]BookCopy, book.Stock))
+	c.linkAuthors(stored.BookID, book.Contributors)
+	return c.writeAudit("create", AuditBook, book.BookID, nil, book)
+}
+
+func (c *MemoryConnector) IncBookStock(bookId int, deltaStock int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	book, ok := c.books[bookId]
+	if !ok {
+		return ErrBookNotFound
+	}
+
+	if book.Stock+deltaStock < 0 {
+		return ErrStockNotEnough
+	}
+
+	before := *book
+	// adding stock adds copies, removing stock withdraws the newest available copies
+	if deltaStock > 0 {
+		c.insertCopies(bookId, make([]BookCopy, deltaStock))
+		c.promoteHolds(bookId, time.Now().Unix())
+	} else {
+		c.moveCopies(bookId, CopyAvailable, CopyWithdrawn, -deltaStock, true)
+	}
+	return c.writeAudit("stock", AuditBook, bookId, &before, book)
+}
+
+func (c *MemoryConnector) StoreBooks(books []Book) error {
+	if books == nil {
+		return ErrNilBooks
+	}
+	if len(books) == 0 {
+		return ErrEmptyBooks
+	}
+	for i := range books {
+		if books[i].Stock < 0 {
+			return ErrNegativeStock
+		}
+	}
+
+	for i := range books {
+		err := books[i].normalizeISBN()
+		if err != nil {
+			return err
+		}
+		err = books[i].resolveContributors()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range books {
+		book := books[i]
+		book.BookID = 0
+		if c.findDuplicateBook(&book) {
+			return ErrDuplicateBook
+		}
+		if err := c.resolveAuthors(book.Contributors); err != nil {
+			return err
+		}
+		for j := 0; j < i; j++ {
+			if sameBook(&books[j], &book) || sameISBN(&books[j], &book) {
+				return ErrDuplicateBook
+			}
+		}
+	}
+
+	now := time.Now().Unix()
+	for i, book := range books {
+		book.BookID = c.nextBookID
+		c.nextBookID++
+		book.Version, book.UpdatedAt = 1, now
+		books[i].BookID = book.BookID
+		books[i].Version, books[i].UpdatedAt = book.Version, book.UpdatedAt
+		stored := book
+		stored.Contributors = nil
+		c.books[stored.BookID] = &stored
+		c.insertCopies(stored.BookID, make([]BookCopy, book.Stock))
+		c.linkAuthors(stored.BookID, book.Contributors)
+		err := c.writeAudit("create", AuditBook, book.BookID, nil, &book)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MemoryConnector) RemoveBook(bookId int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hasOpenBorrow(func(borrow *Borrow) bool { return borrow.BookID == bookId }) {
+		return ErrBookBorrowed
+	}
+
+	// removing a book that does not exist changes nothing, and is not recorded
+	if book, ok := c.books[bookId]; ok {
+		err := c.writeAudit("remove", AuditBook, bookId, book, nil)
+		if err != nil {
+			return err
+		}
+	}
+	delete(c.books, bookId)
+	c.removeBorrows(func(borrow *Borrow) bool { return borrow.BookID == bookId })
+	c.removeHolds(func(hold *Hold) bool { return hold.BookID == bookId })
+	c.removeLinks(func(link *BookAuthor) bool { return link.BookID == bookId })
+	copies := c.copies[:0]
+	for _, bookCopy := range c.copies {
+		if bookCopy.BookID != bookId {
+			copies = append(copies, bookCopy)
+		}
+	}
+	c.copies = copies
+	return nil
+}
+
+func (c *MemoryConnector) ModifyBookInfo(book *Book) error {
+	if book == nil {
+		return ErrNilBook
+	}
+	modified, err := c.PatchBook(book.BookID, book.patch())
+	if err != nil {
+		return err
+	}
+	*book = *modified
+	return nil
+}
+
+func (c *MemoryConnector) PatchBook(bookId int, patch *BookPatch) (*Book, error) {
+	if patch == nil {
+		return nil, ErrNilPatch
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	existing, ok := c.books[bookId]
+	if !ok {
+		return nil, ErrBookNotFound
+	}
+	before := *existing
+	before.Contributors = c.bookContributors(bookId)
+	book := before
+	book.Contributors = append([]Contributor(nil), before.Contributors...)
+	err := patch.apply(&book)
+	if err != nil {
+		return nil, err
+	}
+	if c.findDuplicateBook(&book) {
+		return nil, ErrDuplicateBook
+	}
+	err = c.resolveAuthors(book.Contributors)
+	if err != nil {
+		return nil, err
+	}
+	c.linkAuthors(bookId, book.Contributors)
+
+	stored := book
+	stored.Contributors = nil
+	*existing = stored
+	return &book, c.writeAudit("update", AuditBook, bookId, &before, &book)
+}
+
+func containsFold(s string, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func bookColumnLess(column BookColumn, a *Book, b *Book) (less bool, equal bool, err error) {
+	switch column {
+	case BookColumnBookID:
+		return a.BookID < b.BookID, a.BookID == b.BookID, nil
+	case BookColumnCategory:
+		return strings.ToLower(a.Category) < strings.ToLower(b.Category), strings.EqualFold(a.Category, b.Category), nil
+	case BookColumnTitle:
+		return strings.ToLower(a.Title) < strings.ToLower(b.Title), strings.EqualFold(a.Title, b.Title), nil
+	case BookColumnPress:
+		return strings.ToLower(a.Press) < strings.ToLower(b.Press), strings.EqualFold(a.Press, b.Press), nil
+	case BookColumnPublishYear:
+		return a.PublishYear < b.PublishYear, a.PublishYear == b.PublishYear, nil
+	case BookColumnAuthor:
+		return strings.ToLower(a.Author) < strings.ToLower(b.Author), strings.EqualFold(a.Author, b.Author), nil
+	case BookColumnPrice:
+		return a.Price < b.Price, a.Price == b.Price, nil
+	case BookColumnStock:
+		return a.Stock < b.Stock, a.Stock == b.Stock, nil
+	default:
+		return false, false, ErrInvalidSortColumn
+	}
+}
+
+// bookMatcher returns a function telling if a book matches the conditions, it must be called with c.mu held
+func (c *MemoryConnector) bookMatcher(condition *BookQueryConditions) (func(book *Book) bool, error) {
+	filter, err := condition.filter()
+	if err != nil {
+		return nil, err
+	}
+	var isbn string
+	if condition != nil && condition.ISBN != nil {
+		isbn, err = NormalizeISBN(*condition.ISBN)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return func(book *Book) bool {
+		if condition == nil {
+			return true
+		}
+		if condition.Category != nil && !strings.EqualFold(book.Category, *condition.Category) {
+			return false
+		}
+		if condition.ISBN != nil && (book.ISBN == nil || *book.ISBN != isbn) {
+			return false
+		}
+		if condition.AuthorID != nil && !c.hasContributor(book.BookID, func(author *Author) bool {
+			return author.AuthorID == *condition.AuthorID
+		}) {
+			return false
+		}
+		if condition.AuthorName != nil && !c.hasContributor(book.BookID, func(author *Author) bool {
+			return strings.EqualFold(author.Name, strings.Join(strings.Fields(*condition.AuthorName), " "))
+		}) {
+			return false
+		}
+		if len(condition.BookIDs) > 0 && !containsInt(condition.BookIDs, book.BookID) {
+			return false
+		}
+		if condition.Title != nil && !containsFold(book.Title, *condition.Title) {
+			return false
+		}
+		if condition.Press != nil && !containsFold(book.Press, *condition.Press) {
+			return false
+		}
+		if condition.MinPublishYear != nil && book.PublishYear < *condition.MinPublishYear {
+			return false
+		}
+		if condition.MaxPublishYear != nil && book.PublishYear > *condition.MaxPublishYear {
+			return false
+		}
+		if condition.Author != nil && !containsFold(book.Author, *condition.Author) {
+			return false
+		}
+		if condition.MinPrice != nil && float32(book.Price) < *condition.MinPrice {
+			return false
+		}
+		if condition.MaxPrice != nil && float32(book.Price) > *condition.MaxPrice {
+			return false
+		}
+		if filter != nil && !filter.match(book) {
+			return false
+		}
+		if condition.AvailableOnly && book.Stock <= 0 {
+			return false
+		}
+		return true
+	}, nil
+}
+
+func (c *MemoryConnector) QueryBook(condition *BookQueryConditions) (*BookQueryResult, error) {
+	var page *Page
+	if condition != nil {
+		page = &condition.Page
+	}
+	limit, err := page.check()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := condition.sort()
+	if err != nil {
+		return nil, err
+	}
+	after, err := page.after(bookSort(keys))
+	if err != nil {
+		return nil, err
+	}
+	var last Book
+	if after != nil {
+		err = last.setColumns(keys, after.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	match, err := c.bookMatcher(condition)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var result BookQueryResult
+	for _, book := range c.sortedBooks() {
+		if !match(&book) {
+			continue
+		}
+		result.Results = append(result.Results, book)
+	}
+
+	before := func(a *Book, b *Book) bool {
+		for _, key := range keys {
+			less, equal, _ := bookColumnLess(key.Column, a, b)
+			if equal {
+				continue
+			}
+			if key.Order == Descending {
+				return !less
+			}
+			return less
+		}
+		return false
+	}
+	sort.SliceStable(result.Results, func(i, j int) bool {
+		return before(&result.Results[i], &result.Results[j])
+	})
+	result.Count = len(result.Results)
+
+	// the page starts after the last book of the cursor, in the order of the books
+	books := result.Results
+	if after != nil {
+		books = books[sort.Search(len(books), func(i int) bool {
+			return before(&last, &books[i])
+		}):]
+	}
+	if offset := page.offset(); offset < len(books) {
+		books = books[offset:]
+	} else {
+		books = nil
+	}
+	if len(books) > limit+1 {
+		books = books[:limit+1]
+	}
+	result.Results = books
+	result.paginate(limit, keys)
+	if condition != nil && condition.Facets != nil {
+		result.Facets, err = c.bookFacets(condition)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range result.Results {
+		result.Results[i].Contributors = c.bookContributors(result.Results[i].BookID)
+	}
+	return &result, nil
+}
+
+func (c *MemoryConnector) BorrowBook(borrow *Borrow) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// a borrow of a copy may omit the book
+	var lent *BookCopy
+	if borrow.Barcode != "" {
+		lent = c.findCopy(borrow.Barcode)
+		if lent == nil {
+			return ErrCopyNotFound
+		}
+		if borrow.BookID == 0 {
+			borrow.BookID = lent.BookID
+		}
+		if borrow.BookID != lent.BookID {
+			return ErrCopyOfOtherBook
+		}
+	}
+
+	borrowTime := time.Now().Unix()
+	c.expireBookHolds(borrow.BookID, borrowTime)
+
+	book, ok := c.books[borrow.BookID]
+	if !ok {
+		return ErrBookNotFound
+	}
+
+	// a copy set aside for a hold of the card is no longer in stock
+	ready := c.findHold(func(hold *Hold) bool {
+		return hold.BookID == borrow.BookID && hold.CardID == borrow.CardID && hold.Status == HoldReady
+	}) != nil
+
+	if lent != nil {
+		if lent.Status != CopyAvailable && (lent.Status != CopyOnHold || !ready) {
+			return ErrCopyNotAvailable
+		}
+	} else if book.Stock <= 0 && !ready {
+		return ErrStockNotEnough
+	}
+
+	if c.hasOpenBorrow(func(b *Borrow) bool { return b.BookID == borrow.BookID && b.CardID == borrow.CardID }) {
+		return ErrAlreadyBorrowed
+	}
+
+	card, ok := c.cards[borrow.CardID]
+	if !ok {
+		return ErrCardNotFound
+	}
+
+	if CurrentFinePolicy().Blocks(c.fineBalance(borrow.CardID)) {
+		return ErrFinesOutstanding
+	}
+
+	err := CurrentLoanPolicy().CheckLimits(card.Type, book.Category, c.openLoans(borrow.CardID))
+	if err != nil {
+		return err
+	}
+
+	due, err := dueTime(borrow, borrowTime, card.Type, book.Category)
+	if err != nil {
+		return err
+	}
+	for _, b := range c.borrows {
+		// (card_id, book_id, borrow_time) is the primary key of a borrow record
+		if b.BookID == borrow.BookID && b.CardID == borrow.CardID && b.BorrowTime == borrowTime {
+			return ErrDuplicateBorrow
+		}
+	}
+
+	barcode := c.lendCopy(borrow.BookID, lent, ready)
+	for i := range c.holds {
+		hold := &c.holds[i]
+		if hold.BookID == borrow.BookID && hold.CardID == borrow.CardID && hold.Active() {
+			hold.Status = HoldFulfilled
+		}
+	}
+	loan := Borrow{
+		CardID:     borrow.CardID,
+		BookID:     borrow.BookID,
+		BorrowTime: borrowTime,
+		ReturnTime: 0,
+		DueTime:    due,
+		Barcode:    barcode,
+	}
+	c.borrows = append(c.borrows, loan)
+	borrow.Barcode = barcode
+	return c.writeAudit("borrow", AuditBorrow, borrowEntityID(&loan), nil, &loan)
+}
+
+func (c *MemoryConnector) ReturnBook(borrow *Borrow) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.borrows {
+		b := &c.borrows[i]
+		if b.ReturnTime != 0 {
+			continue
+		}
+		if borrow.Barcode != "" {
+			if b.Barcode != borrow.Barcode || (borrow.CardID != 0 && b.CardID != borrow.CardID) {
+				continue
+			}
+		} else if b.BookID != borrow.BookID || b.CardID != borrow.CardID {
+			continue
+		}
+
+		before := *b
+		b.ReturnTime = time.Now().Unix()
+		if _, ok := c.books[b.BookID]; ok {
+			c.returnCopy(b)
+			c.promoteHolds(b.BookID, b.ReturnTime)
+		}
+		if card, ok := c.cards[b.CardID]; ok {
+			if charge := overdueCharge(b, card.Type); charge != nil {
+				c.addFine(*charge)
+			}
+		}
+		return c.writeAudit("return", AuditBorrow, borrowEntityID(b), &before, b)
+	}
+	return ErrBookNotBorrowed
+}
+
+func (c *MemoryConnector) RenewBook(borrow *Borrow) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.borrows {
+		loan := &c.borrows[i]
+		if loan.BookID != borrow.BookID || loan.CardID != borrow.CardID || loan.ReturnTime != 0 {
+			continue
+		}
+
+		var cardType, category string
+		if card, ok := c.cards[loan.CardID]; ok {
+			cardType = card.Type
+		}
+		if book, ok := c.books[loan.BookID]; ok {
+			category = book.Category
+		}
+		due, err := CurrentLoanPolicy().Renew(loan, time.Now().Unix(), cardType, category)
+		if err != nil {
+			return err
+		}
+		if c.findHold(func(hold *Hold) bool {
+			return hold.BookID == loan.BookID && hold.CardID != loan.CardID && hold.Active()
+		}) != nil {
+			return ErrBookOnHold
+		}
+
+		before := *loan
+		loan.DueTime = due
+		loan.Renewals++
+		*borrow = *loan
+		return c.writeAudit("renew", AuditBorrow, borrowEntityID(loan), &before, loan)
+	}
+	return ErrBookNotBorrowed
+}
+
+func (c *MemoryConnector) ShowBorrowHistory(cardId int, page *Page) (*BorrowHistories, error) {
+	limit, err := page.check()
+	if err != nil {
+		return nil, err
+	}
+	after, err := page.after(borrowSort)
+	if err != nil {
+		return nil, err
+	}
+	var borrowTime int64
+	if after != nil && json.Unmarshal(after.Key, &borrowTime) != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.cards[cardId]; !ok {
+		return nil, ErrCardNotFound
+	}
+
+	borrows := make([]Borrow, 0)
+	for _, borrow := range c.borrows {
+		if borrow.CardID == cardId {
+			borrows = append(borrows, borrow)
+		}
+	}
+	sort.SliceStable(borrows, func(i, j int) bool {
+		if borrows[i].BorrowTime != borrows[j].BorrowTime {
+			return borrows[i].BorrowTime > borrows[j].BorrowTime
+		}
+		return borrows[i].BookID < borrows[j].BookID
+	})
+
+	now := time.Now().Unix()
+	histories := BorrowHistories{
+		Count: len(borrows),
+		Items: make([]Item, 0),
+	}
+	// the page starts after the borrow record of the cursor, latest first
+	if after != nil {
+		borrows = borrows[sort.Search(len(borrows), func(i int) bool {
+			return borrows[i].BorrowTime < borrowTime || borrows[i].BorrowTime == borrowTime && borrows[i].BookID > after.ID
+		}):]
+	}
+	if offset := page.offset(); offset < len(borrows) {
+		borrows = borrows[offset:]
+	} else {
+		borrows = nil
+	}
+	borrows, histories.NextCursor = paginateBorrows(borrows, limit)
+	for _, borrow := range borrows {
+		book, ok := c.books[borrow.BookID]
+		if !ok {
+			return nil, ErrBookNotFound
+		}
+
+		histories.Items = append(histories.Items, Item{
+			CardID:      cardId,
+			BookID:      book.BookID,
+			Category:    book.Category,
+			Title:       book.Title,
+			Press:       book.Press,
+			PublishYear: book.PublishYear,
+			Author:      book.Author,
+			Price:       book.Price,
+			BorrowTime:  borrow.BorrowTime,
+			ReturnTime:  borrow.ReturnTime,
+			DueTime:     borrow.DueTime,
+			Overdue:     borrow.Overdue(now),
+			Renewals:    borrow.Renewals,
+			Barcode:     borrow.Barcode,
+		})
+	}
+	return &histories, nil
+}
+
+func (c *MemoryConnector) RegisterCard(card *Card) error {
+	if card == nil {
+		return ErrNilCard
+	}
+	if card.Type != "T" && card.Type != "S" {
+		return ErrInvalidCardType
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, existing := range c.cards {
+		if strings.EqualFold(existing.Name, card.Name) &&
+			strings.EqualFold(existing.Department, card.Department) &&
+			strings.EqualFold(existing.Type, card.Type) {
+			return ErrDuplicateCard
+		}
+	}
+
+	card.CardID = c.nextCardID
+	c.nextCardID++
+	card.Version, card.UpdatedAt = 1, time.Now().Unix()
+	stored := *card
+	c.cards[stored.CardID] = &stored
+	return c.writeAudit("create", AuditCard, card.CardID, nil, card)
+}
+
+func (c *MemoryConnector) PatchCard(cardId int, patch *CardPatch) (*Card, error) {
+	if patch == nil {
+		return nil, ErrNilPatch
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	existing, ok := c.cards[cardId]
+	if !ok {
+		return nil, ErrCardNotFound
+	}
+	card := *existing
+	err := patch.apply(&card)
+	if err != nil {
+		return nil, err
+	}
+	if card.Type != "T" && card.Type != "S" {
+		return nil, ErrInvalidCardType
+	}
+	for _, other := range c.cards {
+		if other.CardID != cardId &&
+			strings.EqualFold(other.Name, card.Name) &&
+			strings.EqualFold(other.Department, card.Department) &&
+			strings.EqualFold(other.Type, card.Type) {
+			return nil, ErrDuplicateCard
+		}
+	}
+
+	before := *existing
+	*existing = card
+	return &card, c.writeAudit("update", AuditCard, cardId, &before, &card)
+}
+
+func (c *MemoryConnector) RemoveCard(cardId int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hasOpenBorrow(func(borrow *Borrow) bool { return borrow.CardID == cardId }) {
+		return ErrCardHasBorrows
+	}
+	if c.fineBalance(cardId) > 0 {
+		return ErrCardHasFines
+	}
+
+	// removing a card that does not exist changes nothing, and is not recorded
+	if card, ok := c.cards[cardId]; ok {
+		err := c.writeAudit("remove", AuditCard, cardId, card, nil)
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now().Unix()
+	for bookId := range c.books {
+		c.releaseHolds(bookId, HoldCancelled, now, func(hold *Hold) bool { return hold.CardID == cardId })
+	}
+
+	delete(c.cards, cardId)
+	delete(c.credentials, cardId)
+	c.removePatronSessions(cardId)
+	c.removeBorrows(func(borrow *Borrow) bool { return borrow.CardID == cardId })
+	c.removeHolds(func(hold *Hold) bool { return hold.CardID == cardId })
+	fines := c.fines[:0]
+	for _, fine := range c.fines {
+		if fine.CardID != cardId {
+			fines = append(fines, fine)
+		}
+	}
+	c.fines = fines
+	return nil
+}
+
+func (c *MemoryConnector) ShowCards(page *Page) (*CardList, error) {
+	limit, err := page.check()
+	if err != nil {
+		return nil, err
+	}
+	after, err := page.after(cardSort)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var cards []Card
+	if len(c.cards) != 0 {
+		cards = c.sortedCards()
+	}
+	count := len(cards)
+
+	if after != nil {
+		cards = cards[sort.Search(len(cards), func(i int) bool {
+			return cards[i].CardID > after.ID
+		}):]
+	}
+	if offset := page.offset(); offset < len(cards) {
+		cards = cards[offset:]
+	} else {
+		cards = nil
+	}
+
+	list := CardList{
+		Count: count,
+		Cards: cards,
+	}
+	list.paginate(limit)
+	return &list, nil
+}
+
+func (c *MemoryConnector) QueryCard(cardId int) (*Card, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	card, ok := c.cards[cardId]
+	if !ok {
+		return nil, ErrCardNotFound
+	}
+
+	result := *card
+	result.setQuotas(c.openLoans(cardId))
+	return &result, nil
+}
+
+func (c *MemoryConnector) openLoans(cardId int) map[string]int {
+	loans := make(map[string]int)
+	for _, borrow := range c.borrows {
+		if borrow.CardID == cardId && borrow.ReturnTime == 0 {
+			if book, ok := c.books[borrow.BookID]; ok {
+				loans[book.Category]++
+			}
+		}
+	}
+	return loans
+}
+
+func (c *MemoryConnector) fineBalance(cardId int) float64 {
+	var balance float64
+	for _, fine := range c.fines {
+		if fine.CardID == cardId {
+			balance += float64(fine.Amount)
+		}
+	}
+	return math.Round(balance*100) / 100
+}
+
+func (c *MemoryConnector) addFine(entry FineEntry) FineEntry {
+	entry.FineID = c.nextFineID
+	c.nextFineID++
+	c.fines = append(c.fines, entry)
+	return entry
+}
+
+func (c *MemoryConnector) ShowFines(cardId int) (*FineLedger, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.cards[cardId]; !ok {
+		return nil, ErrCardNotFound
+	}
+
+	ledger := FineLedger{
+		CardID:  cardId,
+		Balance: myFloat(c.fineBalance(cardId)),
+		Entries: make([]FineEntry, 0),
+	}
+	for _, fine := range c.fines {
+		if fine.CardID == cardId {
+			ledger.Entries = append(ledger.Entries, fine)
+		}
+	}
+	sort.SliceStable(ledger.Entries, func(i, j int) bool {
+		if ledger.Entries[i].CreatedAt != ledger.Entries[j].CreatedAt {
+			return ledger.Entries[i].CreatedAt > ledger.Entries[j].CreatedAt
+		}
+		return ledger.Entries[i].FineID > ledger.Entries[j].FineID
+	})
+	ledger.Count = len(ledger.Entries)
+	return &ledger, nil
+}
+
+func (c *MemoryConnector) SettleFine(entry *FineEntry) error {
+	if entry == nil {
+		return ErrNilFine
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.cards[entry.CardID]; !ok {
+		return ErrCardNotFound
+	}
+	if err := validateSettlement(entry, c.fineBalance(entry.CardID)); err != nil {
+		return err
+	}
+
+	settlement := *entry
+	settlement.Amount = -entry.Amount
+	settlement.BookID = 0
+	settlement.BorrowTime = 0
+	settlement.CreatedAt = time.Now().Unix()
+	settlement = c.addFine(settlement)
+
+	entry.FineID = settlement.FineID
+	entry.CreatedAt = settlement.CreatedAt
+	return c.writeAudit(string(settlement.Kind), AuditFine, settlement.FineID, nil, &settlement)
+}
+
+func (c *MemoryConnector) findHold(match func(hold *Hold) bool) *Hold {
+	for i := range c.holds {
+		if match(&c.holds[i]) {
+			return &c.holds[i]
+		}
+	}
+	return nil
+}
+
+func (c *MemoryConnector) removeHolds(match func(hold *Hold) bool) {
+	holds := c.holds[:0]
+	for _, hold := range c.holds {
+		if !match(&hold) {
+			holds = append(holds, hold)
+		}
+	}
+	c.holds = holds
+}
+
+// waitingHolds returns the waiting holds of the book in queue order
+func (c *MemoryConnector) waitingHolds(bookId int) []*Hold {
+	waiting := make([]*Hold, 0)
+	for i := range c.holds {
+		if c.holds[i].BookID == bookId && c.holds[i].Status == HoldWaiting {
+			waiting = append(waiting, &c.holds[i])
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].servedBefore(waiting[j]) })
+	return waiting
+}
+
+func (c *MemoryConnector) holdPosition(hold *Hold) int {
+	for i, waiting := range c.waitingHolds(hold.BookID) {
+		if waiting.HoldID == hold.HoldID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (c *MemoryConnector) promoteHolds(bookId int, now int64) {
+	book, ok := c.books[bookId]
+	if !ok {
+		return
+	}
+	expireTime := CurrentHoldPolicy().ExpireTime(now)
+	for _, hold := range c.waitingHolds(bookId) {
+		if book.Stock <= 0 {
+			return
+		}
+		hold.Status = HoldReady
+		hold.ReadyAt = now
+		hold.ExpireTime = expireTime
+		c.moveCopies(bookId, CopyAvailable, CopyOnHold, 1, false)
+	}
+}
+
+func (c *MemoryConnector) releaseHolds(bookId int, status HoldStatus, now int64, match func(hold *Hold) bool) int {
+	released, ready := 0, 0
+	for i := range c.holds {
+		hold := &c.holds[i]
+		if hold.BookID != bookId || !hold.Active() || !match(hold) {
+			continue
+		}
+		if hold.Status == HoldReady {
+			ready++
+		}
+		hold.Status = status
+		released++
+	}
+	if _, ok := c.books[bookId]; ok && ready > 0 {
+		c.moveCopies(bookId, CopyOnHold, CopyAvailable, ready, false)
+		c.promoteHolds(bookId, now)
+	}
+	return released
+}
+
+func (c *MemoryConnector) expireBookHolds(bookId int, now int64) int {
+	return c.releaseHolds(bookId, HoldExpired, now, func(hold *Hold) bool {
+		return hold.Status == HoldReady && hold.ExpireTime <= now
+	})
+}
+
+func (c *MemoryConnector) PlaceHold(hold *Hold) error {
+	if hold == nil {
+		return ErrNilHold
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().Unix()
+	c.expireBookHolds(hold.BookID, now)
+
+	book, ok := c.books[hold.BookID]
+	if !ok {
+		return ErrBookNotFound
+	}
+	card, ok := c.cards[hold.CardID]
+	if !ok {
+		return ErrCardNotFound
+	}
+	if c.hasOpenBorrow(func(b *Borrow) bool { return b.BookID == hold.BookID && b.CardID == hold.CardID }) {
+		return ErrAlreadyBorrowed
+	}
+	if c.findHold(func(h *Hold) bool { return h.BookID == hold.BookID && h.CardID == hold.CardID && h.Active() }) != nil {
+		return ErrDuplicateHold
+	}
+	if book.Stock > 0 {
+		return ErrBookAvailable
+	}
+
+	hold.HoldID = c.nextHoldID
+	c.nextHoldID++
+	hold.Status = HoldWaiting
+	hold.Priority = CurrentHoldPolicy().Priority(card.Type)
+	hold.CreatedAt = now
+	hold.ReadyAt = 0
+	hold.ExpireTime = 0
+	hold.Position = 0
+	c.holds = append(c.holds, *hold)
+
+	hold.Position = c.holdPosition(hold)
+	return c.writeAudit("place", AuditHold, hold.HoldID, nil, hold)
+}
+
+func (c *MemoryConnector) CancelHold(holdId int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hold := c.findHold(func(h *Hold) bool { return h.HoldID == holdId })
+	if hold == nil {
+		return ErrHoldNotFound
+	}
+	if !hold.Active() {
+		return ErrHoldNotActive
+	}
+
+	before := *hold
+	c.releaseHolds(hold.BookID, HoldCancelled, time.Now().Unix(), func(h *Hold) bool { return h.HoldID == holdId })
+	return c.writeAudit("cancel", AuditHold, holdId, &before, hold)
+}
+
+func (c *MemoryConnector) ShowCardHolds(cardId int) (*HoldList, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.cards[cardId]; !ok {
+		return nil, ErrCardNotFound
+	}
+
+	holds := make([]Hold, 0)
+	for _, hold := range c.holds {
+		if hold.CardID == cardId {
+			if hold.Status == HoldWaiting {
+				hold.Position = c.holdPosition(&hold)
+			}
+			holds = append(holds, hold)
+		}
+	}
+	sort.SliceStable(holds, func(i, j int) bool {
+		if holds[i].CreatedAt != holds[j].CreatedAt {
+			return holds[i].CreatedAt > holds[j].CreatedAt
+		}
+		return holds[i].HoldID > holds[j].HoldID
+	})
+	return &HoldList{Count: len(holds), Holds: holds}, nil
+}
+
+func (c *MemoryConnector) ShowBookHolds(bookId int) (*HoldList, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.books[bookId]; !ok {
+		return nil, ErrBookNotFound
+	}
+
+	holds := make([]Hold, 0)
+	for _, hold := range c.holds {
+		if hold.BookID == bookId && hold.Status == HoldReady {
+			holds = append(holds, hold)
+		}
+	}
+	sort.SliceStable(holds, func(i, j int) bool {
+		if holds[i].ReadyAt != holds[j].ReadyAt {
+			return holds[i].ReadyAt < holds[j].ReadyAt
+		}
+		return holds[i].HoldID < holds[j].HoldID
+	})
+	for i, hold := range c.waitingHolds(bookId) {
+		waiting := *hold
+		waiting.Position = i + 1
+		holds = append(holds, waiting)
+	}
+	return &HoldList{Count: len(holds), Holds: holds}, nil
+}
+
+func (c *MemoryConnector) ExpireHolds() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().Unix()
+	due := make([]Hold, 0)
+	for _, hold := range c.holds {
+		if _, ok := c.books[hold.BookID]; ok && hold.Status == HoldReady && hold.ExpireTime <= now {
+			due = append(due, hold)
+		}
+	}
+	expired := 0
+	for bookId := range c.books {
+		expired += c.expireBookHolds(bookId, now)
+	}
+	for i := range due {
+		expiredHold := due[i]
+		expiredHold.Status = HoldExpired
+		err := c.writeAudit("expire", AuditHold, expiredHold.HoldID, &due[i], &expiredHold)
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+func (c *MemoryConnector) findCopy(barcode string) *BookCopy {
+	for i := range c.copies {
+		if c.copies[i].Barcode == barcode {
+			return &c.copies[i]
+		}
+	}
+	return nil
+}
+
+// checkNewCopies returns an error if the copies cannot be added, their barcodes are taken or invalid
+func (c *MemoryConnector) checkNewCopies(copies []BookCopy) error {
+	for i := range copies {
+		if copies[i].Barcode == "" {
+			continue
+		}
+		if err := validateBarcode(copies[i].Barcode); err != nil {
+			return err
+		}
+		if c.findCopy(copies[i].Barcode) != nil {
+			return ErrDuplicateCopy
+		}
+		for j := 0; j < i; j++ {
+			if copies[j].Barcode == copies[i].Barcode {
+				return ErrDuplicateCopy
+			}
+		}
+	}
+	return nil
+}
+
+// insertCopies adds copies checked by checkNewCopies to a book, the copies without a barcode get a generated one
+func (c *MemoryConnector) insertCopies(bookId int, copies []BookCopy) {
+	seq := 0
+	for _, bookCopy := range c.copies {
+		if bookCopy.BookID == bookId {
+			seq++
+		}
+	}
+	for i := range copies {
+		copies[i].CopyID = c.nextCopyID
+		c.nextCopyID++
+		copies[i].BookID = bookId
+		if copies[i].Status == "" {
+			copies[i].Status = CopyAvailable
+		}
+		if copies[i].Barcode == "" {
+			seq++
+			copies[i].Barcode = copyBarcode(bookId, seq)
+		}
+		c.copies = append(c.copies, copies[i])
+	}
+	c.syncStock(bookId)
+}
+
+// moveCopies sets at most n copies of the book in status from to status to, the oldest copies first or the
+// newest ones first
+func (c *MemoryConnector) moveCopies(bookId int, from CopyStatus, to CopyStatus, n int, newestFirst bool) {
+	for i := range c.copies {
+		if n <= 0 {
+			break
+		}
+		j := i
+		if newestFirst {
+			j = len(c.copies) - 1 - i
+		}
+		if c.copies[j].BookID == bookId && c.copies[j].Status == from {
+			c.copies[j].Status = to
+			n--
+		}
+	}
+	c.syncStock(bookId)
+}
+
+func (c *MemoryConnector) syncStock(bookId int) {
+	book, ok := c.books[bookId]
+	if !ok {
+		return
+	}
+	book.Stock = 0
+	for _, bookCopy := range c.copies {
+		if bookCopy.BookID == bookId && bookCopy.Status == CopyAvailable {
+			book.Stock++
+		}
+	}
+}
+
+func (c *MemoryConnector) lendCopy(bookId int, lent *BookCopy, held bool) string {
+	if lent == nil {
+		from := CopyAvailable
+		if held {
+			from = CopyOnHold
+		}
+		for i := range c.copies {
+			if c.copies[i].BookID == bookId && c.copies[i].Status == from {
+				lent = &c.copies[i]
+				break
+			}
+		}
+	} else if held && lent.Status == CopyAvailable {
+		c.moveCopies(bookId, CopyOnHold, CopyAvailable, 1, false)
+	}
+	lent.Status = CopyOnLoan
+	c.syncStock(bookId)
+	return lent.Barcode
+}
+
+func (c *MemoryConnector) returnCopy(loan *Borrow) {
+	// a loan made before copies were tracked brings a new copy
+	bookCopy := c.findCopy(loan.Barcode)
+	if loan.Barcode == "" || bookCopy == nil {
+		c.insertCopies(loan.BookID, make([]BookCopy, 1))
+		return
+	}
+	bookCopy.Status = CopyAvailable
+	c.syncStock(loan.BookID)
+}
+
+func (c *MemoryConnector) ShowCopies(bookId int) (*CopyList, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.books[bookId]; !ok {
+		return nil, ErrBookNotFound
+	}
+
+	copies := make([]BookCopy, 0)
+	for _, bookCopy := range c.copies {
+		if bookCopy.BookID == bookId {
+			copies = append(copies, bookCopy)
+		}
+	}
+	return &CopyList{Count: len(copies), Copies: copies}, nil
+}
+
+func (c *MemoryConnector) AddCopies(bookId int, copies []BookCopy) error {
+	if len(copies) == 0 {
+		return ErrEmptyCopies
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.books[bookId]; !ok {
+		return ErrBookNotFound
+	}
+	for i := range copies {
+		copies[i].Status = CopyAvailable
+	}
+	err := c.checkNewCopies(copies)
+	if err != nil {
+		return err
+	}
+
+	c.insertCopies(bookId, copies)
+	c.promoteHolds(bookId, time.Now().Unix())
+	for i := range copies {
+		err := c.writeAudit("create", AuditCopy, copies[i].Barcode, nil, &copies[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MemoryConnector) WithdrawCopies(barcodes []string) error {
+	if len(barcodes) == 0 {
+		return ErrEmptyCopies
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	withdrawn := make([]*BookCopy, 0, len(barcodes))
+	for _, barcode := range barcodes {
+		bookCopy := c.findCopy(barcode)
+		if bookCopy == nil {
+			return ErrCopyNotFound
+		}
+		if bookCopy.Status == CopyOnLoan || bookCopy.Status == CopyOnHold || bookCopy.Status == CopyWithdrawn {
+			return ErrCopyNotAvailable
+		}
+		for _, w := range withdrawn {
+			if w == bookCopy {
+				return ErrCopyNotAvailable
+			}
+		}
+		withdrawn = append(withdrawn, bookCopy)
+	}
+
+	for _, bookCopy := range withdrawn {
+		before := *bookCopy
+		bookCopy.Status = CopyWithdrawn
+		c.syncStock(bookCopy.BookID)
+		err := c.writeAudit("withdraw", AuditCopy, bookCopy.Barcode, &before, bookCopy)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *MemoryConnector) UpdateCopy(bookCopy *BookCopy) error {
+	if bookCopy == nil {
+		return ErrNilCopy
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored := c.findCopy(bookCopy.Barcode)
+	if stored == nil {
+		return ErrCopyNotFound
+	}
+	if bookCopy.Status == "" {
+		bookCopy.Status = stored.Status
+	}
+	if bookCopy.Location == "" {
+		bookCopy.Location = stored.Location
+	}
+	err := validateCopyUpdate(stored.Status, bookCopy.Status)
+	if err != nil {
+		return err
+	}
+
+	before := *stored
+	from := stored.Status
+	stored.Status = bookCopy.Status
+	stored.Location = bookCopy.Location
+	c.syncStock(stored.BookID)
+	if bookCopy.Status == CopyAvailable && from != CopyAvailable {
+		c.promoteHolds(stored.BookID, time.Now().Unix())
+	}
+	bookCopy.CopyID = stored.CopyID
+	bookCopy.BookID = stored.BookID
+	return c.writeAudit("update", AuditCopy, stored.Barcode, &before, stored)
+}
+
+func (c *MemoryConnector) findAuthor(name string) *Author {
+	for _, author := range c.authors {
+		if strings.EqualFold(author.Name, name) {
+			return author
+		}
+	}
+	return nil
+}
+
+// resolveAuthors sets the ids and names of the contributors found in the registry and checks them, the new
+// contributors keep no id until linkAuthors adds them
+func (c *MemoryConnector) resolveAuthors(contributors []Contributor) error {
+	for i := range contributors {
+		contributor := &contributors[i]
+		if contributor.AuthorID != 0 {
+			author, ok := c.authors[contributor.AuthorID]
+			if !ok {
+				return ErrAuthorNotFound
+			}
+			contributor.Name = author.Name
+		} else if author := c.findAuthor(contributor.Name); author != nil {
+			contributor.AuthorID = author.AuthorID
+			contributor.Name = author.Name
+		}
+	}
+	return checkContributors(contributors)
+}
+
+// linkAuthors replaces the contributors of a book with contributors checked by resolveAuthors
+func (c *MemoryConnector) linkAuthors(bookId int, contributors []Contributor) {
+	c.removeLinks(func(link *BookAuthor) bool { return link.BookID == bookId })
+	for i := range contributors {
+		contributor := &contributors[i]
+		if contributor.AuthorID == 0 {
+			if author := c.findAuthor(contributor.Name); author != nil {
+				contributor.AuthorID = author.AuthorID
+			} else {
+				contributor.AuthorID = c.nextAuthorID
+				c.nextAuthorID++
+				c.authors[contributor.AuthorID] = &Author{AuthorID: contributor.AuthorID, Name: contributor.Name}
+			}
+		}
+		c.links = append(c.links, BookAuthor{
+			BookID:   bookId,
+			AuthorID: contributor.AuthorID,
+			Role:     contributor.Role,
+			Position: contributor.Position,
+		})
+	}
+}
+
+func (c *MemoryConnector) removeLinks(match func(link *BookAuthor) bool) {
+	links := c.links[:0]
+	for _, link := range c.links {
+		if !match(&link) {
+			links = append(links, link)
+		}
+	}
+	c.links = links
+}
+
+func (c *MemoryConnector) bookContributors(bookId int) []Contributor {
+	var contributors []Contributor
+	for _, link := range c.links {
+		if link.BookID == bookId {
+			contributors = append(contributors, Contributor{
+				AuthorID: link.AuthorID,
+				Name:     c.authors[link.AuthorID].Name,
+				Role:     link.Role,
+				Position: link.Position,
+			})
+		}
+	}
+	sort.Slice(contributors, func(i, j int) bool { return contributors[i].Position < contributors[j].Position })
+	return contributors
+}
+
+func (c *MemoryConnector) ShowAuthors(name string) (*AuthorList, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	authors := AuthorList{Authors: make([]Author, 0)}
+	for _, author := range c.sortedAuthors() {
+		if name != "" && !containsFold(author.Name, name) {
+			continue
+		}
+		author.Works = c.authorWorks(author.AuthorID)
+		authors.Authors = append(authors.Authors, author)
+	}
+	sort.SliceStable(authors.Authors, func(i, j int) bool { return authors.Authors[i].Name < authors.Authors[j].Name })
+	authors.Count = len(authors.Authors)
+	return &authors, nil
+}
+
+func (c *MemoryConnector) authorWorks(authorId int) int {
+	books := make(map[int]bool)
+	for _, link := range c.links {
+		if link.AuthorID == authorId {
+			books[link.BookID] = true
+		}
+	}
+	return len(books)
+}
+
+func (c *MemoryConnector) ShowAuthorWorks(authorId int) (*AuthorWorks, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	author, ok := c.authors[authorId]
+	if !ok {
+		return nil, ErrAuthorNotFound
+	}
+
+	works := AuthorWorks{Author: *author, Works: make([]AuthorWork, 0)}
+	works.Author.Works = c.authorWorks(authorId)
+	for _, link := range c.links {
+		if link.AuthorID != authorId {
+			continue
+		}
+		if book, ok := c.books[link.BookID]; ok {
+			works.Works = append(works.Works, AuthorWork{Book: *book, Role: link.Role, Position: link.Position})
+		}
+	}
+	sort.SliceStable(works.Works, func(i, j int) bool {
+		a, b := works.Works[i], works.Works[j]
+		if a.Book.PublishYear != b.Book.PublishYear {
+			return a.Book.PublishYear < b.Book.PublishYear
+		}
+		if a.Book.BookID != b.Book.BookID {
+			return a.Book.BookID < b.Book.BookID
+		}
+		return a.Position < b.Position
+	})
+	works.Count = len(works.Works)
+	return &works, nil
+}
+
+func (c *MemoryConnector) hasContributor(bookId int, match func(author *Author) bool) bool {
+	for _, link := range c.links {
+		if link.BookID == bookId && match(c.authors[link.AuthorID]) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertUser stores a checked user, it must be called with c.mu held
+func (c *MemoryConnector) insertUser(user *User) error {
+	for _, existing := range c.users {
+		if existing.Username == user.Username {
+			return ErrDuplicateUser
+		}
+	}
+	user.UserID = c.nextUserID
+	c.nextUserID++
+	user.CreatedAt = time.Now().Unix()
+	stored := *user
+	c.users[stored.UserID] = &stored
+	return c.writeAudit("create", AuditUser, user.UserID, nil, user)
+}
+
+func (c *MemoryConnector) CreateUser(user *User) error {
+	err := user.check()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.insertUser(user)
+}
+
+func (c *MemoryConnector) BootstrapUser(user *User) error {
+	err := user.check()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.users) > 0 {
+		return ErrUsersExist
+	}
+	return c.insertUser(user)
+}
+
+func (c *MemoryConnector) QueryUser(userId int) (*User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	user, ok := c.users[userId]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	result := *user
+	return &result, nil
+}
+
+func (c *MemoryConnector) QueryUserByName(username string) (*User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, user := range c.users {
+		if user.Username == username {
+			result := *user
+			return &result, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (c *MemoryConnector) ShowUsers() (*UserList, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	users := c.sortedUsers()
+	return &UserList{Count: len(users), Users: users}, nil
+}
+
+// lastAdmin reports whether the user is the only admin, it must be called with c.mu held
+func (c *MemoryConnector) lastAdmin(userId int) bool {
+	for _, user := range c.users {
+		if user.Role == UserAdmin && user.UserID != userId {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *MemoryConnector) removeSessions(userId int) {
+	for tokenHash, session := range c.sessions {
+		if session.UserID == userId {
+			delete(c.sessions, tokenHash)
+		}
+	}
+}
+
+func (c *MemoryConnector) SetUserRole(userId int, role UserRole) error {
+	if !role.Valid() {
+		return ErrInvalidUserRole
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	user, ok := c.users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.Role == UserAdmin && role != UserAdmin && c.lastAdmin(userId) {
+		return ErrLastAdmin
+	}
+	before := *user
+	user.Role = role
+	return c.writeAudit("role", AuditUser, userId, &before, user)
+}
+
+func (c *MemoryConnector) SetUserPassword(userId int, passwordHash string) error {
+	if passwordHash == "" {
+		return ErrMissingPassword
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	user, ok := c.users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = passwordHash
+	c.removeSessions(userId)
+	return c.writeAudit("password", AuditUser, userId, nil, nil)
+}
+
+func (c *MemoryConnector) RemoveUser(userId int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	user, ok := c.users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if user.Role == UserAdmin && c.lastAdmin(userId) {
+		return ErrLastAdmin
+	}
+	c.removeSessions(userId)
+	delete(c.users, userId)
+	return c.writeAudit("remove", AuditUser, userId, user, nil)
+}
+
+func (c *MemoryConnector) CreateSession(session *UserSession) error {
+	if session == nil {
+		return ErrNilSession
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.users[session.UserID]; !ok {
+		return ErrUserNotFound
+	}
+	for tokenHash, existing := range c.sessions {
+		if existing.ExpireTime <= session.CreatedAt {
+			delete(c.sessions, tokenHash)
+		}
+	}
+	stored := *session
+	c.sessions[stored.TokenHash] = &stored
+	return nil
+}
+
+func (c *MemoryConnector) QuerySession(tokenHash string) (*User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	session, ok := c.sessions[tokenHash]
+	if !ok || session.ExpireTime <= time.Now().Unix() {
+		return nil, ErrInvalidToken
+	}
+	user, ok := c.users[session.UserID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	result := *user
+	return &result, nil
+}
+
+func (c *MemoryConnector) RemoveSession(tokenHash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.sessions, tokenHash)
+	return nil
+}
+
+func (c *MemoryConnector) removePatronSessions(cardId int) {
+	for tokenHash, session := range c.patrons {
+		if session.CardID == cardId {
+			delete(c.patrons, tokenHash)
+		}
+	}
+}
+
+func (c *MemoryConnector) SetCardPIN(cardId int, pinHash string) error {
+	if pinHash == "" {
+		return ErrMissingPIN
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.cards[cardId]; !ok {
+		return ErrCardNotFound
+	}
+	c.credentials[cardId] = &CardCredential{CardID: cardId, PINHash: pinHash, UpdatedAt: time.Now().Unix()}
+	c.removePatronSessions(cardId)
+	return c.writeAudit("pin", AuditCard, cardId, nil, nil)
+}
+
+func (c *MemoryConnector) QueryCardCredential(cardId int) (*CardCredential, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	credential, ok := c.credentials[cardId]
+	if !ok {
+		return nil, ErrCredentialNotFound
+	}
+	result := *credential
+	return &result, nil
+}
+
+func (c *MemoryConnector) CreatePatronSession(session *PatronSession) error {
+	if session == nil {
+		return ErrNilSession
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.cards[session.CardID]; !ok {
+		return ErrCardNotFound
+	}
+	for tokenHash, existing := range c.patrons {
+		if existing.ExpireTime <= session.CreatedAt {
+			delete(c.patrons, tokenHash)
+		}
+	}
+	stored := *session
+	c.patrons[stored.TokenHash] = &stored
+	return nil
+}
+
+func (c *MemoryConnector) QueryPatronSession(tokenHash string) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	session, ok := c.patrons[tokenHash]
+	if !ok || session.ExpireTime <= time.Now().Unix() {
+		return 0, ErrInvalidToken
+	}
+	return session.CardID, nil
+}
+
+func (c *MemoryConnector) RemovePatronSession(tokenHash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.patrons, tokenHash)
+	return nil
+}
+
+func (c *MemoryConnector) WithAudit(audit *AuditContext) Connector {
+	return &MemoryConnector{memoryStore: c.memoryStore, audit: audit}
+}
+
+// writeAudit adds the entry of a change to the audit log, it must be called with c.mu held
+func (c *MemoryConnector) writeAudit(action string, entityType string, entityId any, before any, after any) error {
+	entry, err := c.audit.entry(action, entityType, entityId, before, after)
+	if err != nil {
+		return err
+	}
+	entry.AuditID = c.nextAuditID
+	c.nextAuditID++
+	c.audits = append(c.audits, *entry)
+	return nil
+}
+
+func (c *MemoryConnector) ShowAudit(query *AuditQuery) (*AuditList, error) {
+	err := query.check()
+	if err != nil {
+		return nil, err
+	}
+	limit, _ := query.Page.check()
+	after, err := query.Page.after(auditSort)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make([]AuditEntry, 0)
+	for i := len(c.audits) - 1; i >= 0; i-- {
+		if query.matches(&c.audits[i]) {
+			entries = append(entries, c.audits[i])
+		}
+	}
+	count := len(entries)
+
+	if after != nil {
+		entries = entries[sort.Search(len(entries), func(i int) bool {
+			return entries[i].AuditID < after.ID
+		}):]
+	}
+	if offset := query.Page.offset(); offset < len(entries) {
+		entries = entries[offset:]
+	} else {
+		entries = make([]AuditEntry, 0)
+	}
+
+	list := AuditList{Count: count, Entries: entries}
+	list.paginate(limit)
+	return &list, nil
+}
+
+// archiveRows returns the rows of an archived table in the order of their keys, it must be called with c.mu held
+func (c *MemoryConnector) archiveRows(table string) []any {
+	rows := make([]any, 0)
+	switch table {
+	case TableName(Book{}):
+		for _, book := range c.sortedBooks() {
+			rows = append(rows, book)
+		}
+	case TableName(Card{}):
+		for _, card := range c.sortedCards() {
+			rows = append(rows, card)
+		}
+	case TableName(Borrow{}):
+		for _, borrow := range c.borrows {
+			rows = append(rows, borrow)
+		}
+	case TableName(FineEntry{}):
+		for _, entry := range c.fines {
+			rows = append(rows, entry)
+		}
+	case TableName(Hold{}):
+		for _, hold := range c.holds {
+			rows = append(rows, hold)
+		}
+	case TableName(BookCopy{}):
+		for _, bookCopy := range c.copies {
+			rows = append(rows, bookCopy)
+		}
+	case TableName(Author{}):
+		for _, author := range c.sortedAuthors() {
+			rows = append(rows, author)
+		}
+	case TableName(BookAuthor{}):
+		for _, link := range c.links {
+			rows = append(rows, link)
+		}
+	case TableName(User{}):
+		for _, user := range c.sortedUsers() {
+			rows = append(rows, user)
+		}
+	case TableName(CardCredential{}):
+		cardIds := make([]int, 0, len(c.credentials))
+		for cardId := range c.credentials {
+			cardIds = append(cardIds, cardId)
+		}
+		sort.Ints(cardIds)
+		for _, cardId := range cardIds {
+			rows = append(rows, *c.credentials[cardId])
+		}
+	case TableName(AuditEntry{}):
+		for _, entry := range c.audits {
+			rows = append(rows, entry)
+		}
+	}
+	return rows
+}
+
+func (c *MemoryConnector) Export(write func(table string, row map[string]any) error) (int, error) {
+	tables, err := archivedTables()
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, table := range tables {
+		columns := table.columns(nil)
+		for _, row := range c.archiveRows(table.schema.Name) {
+			err = write(table.schema.Name, table.encode(reflect.ValueOf(row), columns))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	return LatestSchemaVersion(), nil
+}
+
+// Import loads the rows into a store of its own, which replaces the empty tables of c once they are all loaded
+func (c *MemoryConnector) Import(schemaVersion int, read func(load func(table string, row map[string]json.RawMessage) error) error) (*RestoreResult, error) {
+	tables, err := archivedTables()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := RestoreResult{SchemaVersion: schemaVersion, Rows: make(map[string]int), Skipped: make([]string, 0)}
+	byName := make(map[string]*archivedTable)
+	skipped := make(map[string]bool)
+	for _, table := range tables {
+		name := table.schema.Name
+		byName[name] = table
+		if len(c.archiveRows(name)) == 0 {
+			continue
+		}
+		if !keptOnReset[name] {
+			return nil, WrapError(ErrConflict, fmt.Errorf("%w: %s has rows", ErrDatabaseNotEmpty, name))
+		}
+		skipped[name] = true
+		result.Skipped = append(result.Skipped, name)
+	}
+
+	staged := NewMemoryConnector("")
+	next := func(id int, next *int) {
+		if id >= *next {
+			*next = id + 1
+		}
+	}
+	duplicate := func(exists bool, table string, key int) error {
+		if exists {
+			return invalidArchive("duplicate key %d in %s", key, table)
+		}
+		return nil
+	}
+	loaders := map[string]func(row any) error{
+		TableName(Book{}): func(row any) error {
+			book := row.(Book)
+			_, exists := staged.books[book.BookID]
+			staged.books[book.BookID] = &book
+			next(book.BookID, &staged.nextBookID)
+			return duplicate(exists, TableName(Book{}), book.BookID)
+		},
+		TableName(Card{}): func(row any) error {
+			card := row.(Card)
+			_, exists := staged.cards[card.CardID]
+			staged.cards[card.CardID] = &card
+			next(card.CardID, &staged.nextCardID)
+			return duplicate(exists, TableName(Card{}), card.CardID)
+		},
+		TableName(Borrow{}): func(row any) error {
+			staged.borrows = append(staged.borrows, row.(Borrow))
+			return nil
+		},
+		TableName(FineEntry{}): func(row any) error {
+			entry := row.(FineEntry)
+			staged.fines = append(staged.fines, entry)
+			next(entry.FineID, &staged.nextFineID)
+			return nil
+		},
+		TableName(Hold{}): func(row any) error {
+			hold := row.(Hold)
+			staged.holds = append(staged.holds, hold)
+			next(hold.HoldID, &staged.nextHoldID)
+			return nil
+		},
+		TableName(BookCopy{}): func(row any) error {
+			bookCopy := row.(BookCopy)
+			staged.copies = append(staged.copies, bookCopy)
+			next(bookCopy.CopyID, &staged.nextCopyID)
+			return nil
+		},
+		TableName(Author{}): func(row any) error {
+			author := row.(Author)
+			_, exists := staged.authors[author.AuthorID]
+			staged.authors[author.AuthorID] = &author
+			next(author.AuthorID, &staged.nextAuthorID)
+			return duplicate(exists, TableName(Author{}), author.AuthorID)
+		},
+		TableName(BookAuthor{}): func(row any) error {
+			staged.links = append(staged.links, row.(BookAuthor))
+			return nil
+		},
+		TableName(User{}): func(row any) error {
+			user := row.(User)
+			_, exists := staged.users[user.UserID]
+			staged.users[user.UserID] = &user
+			next(user.UserID, &staged.nextUserID)
+			return duplicate(exists, TableName(User{}), user.UserID)
+		},
+		TableName(CardCredential{}): func(row any) error {
+			credential := row.(CardCredential)
+			_, exists := staged.credentials[credential.CardID]
+			staged.credentials[credential.CardID] = &credential
+			return duplicate(exists, TableName(CardCredential{}), credential.CardID)
+		},
+		TableName(AuditEntry{}): func(row any) error {
+			entry := row.(AuditEntry)
+			staged.audits = append(staged.audits, entry)
+			next(entry.AuditID, &staged.nextAuditID)
+			return nil
+		},
+	}
+
+	err = read(func(name string, values map[string]json.RawMessage) error {
+		table, ok := byName[name]
+		if !ok {
+			return invalidArchive("unknown table %q", name)
+		}
+		if skipped[name] {
+			return nil
+		}
+		row, err := table.decode(values)
+		if err != nil {
+			return invalidArchive("%v", err)
+		}
+		err = loaders[name](row.Interface())
+		if err != nil {
+			return err
+		}
+		result.Rows[name]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// what the Backfill of the migrations 6 and 8 fill in a database, for the archives older than them
+	if schemaVersion < 6 {
+		staged.backfillCopies()
+	}
+	if schemaVersion < 8 {
+		staged.backfillAuthors()
+	}
+
+	users, sessions, nextUserID := c.users, c.sessions, c.nextUserID
+	audits, nextAuditID := c.audits, c.nextAuditID
+	c.memoryStore.restore(staged.memoryStore)
+	if skipped[TableName(User{})] {
+		c.users, c.sessions, c.nextUserID = users, sessions, nextUserID
+	}
+	if skipped[TableName(AuditEntry{})] {
+		c.audits, c.nextAuditID = audits, nextAuditID
+	}
+	err = c.writeAudit("restore", AuditDatabase, "", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// restore replaces the rows of s with the ones of staged, the path and the lock of s are kept
+func (s *memoryStore) restore(staged *memoryStore) {
+	s.books, s.cards, s.borrows, s.fines, s.holds = staged.books, staged.cards, staged.borrows, staged.fines, staged.holds
+	s.copies, s.authors, s.links, s.credentials = staged.copies, staged.authors, staged.links, staged.credentials
+	s.patrons, s.users, s.sessions, s.audits = staged.patrons, staged.users, staged.sessions, staged.audits
+	s.nextBookID, s.nextCardID, s.nextFineID, s.nextHoldID = staged.nextBookID, staged.nextCardID, staged.nextFineID, staged.nextHoldID
+	s.nextCopyID, s.nextAuthorID, s.nextUserID, s.nextAuditID = staged.nextCopyID, staged.nextAuthorID, staged.nextUserID, staged.nextAuditID
+}
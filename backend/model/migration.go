@@ -0,0 +1,650 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type SchemaMigration struct {
+	Version   int    `json:"version" sql:"not null;primaryKey"`
+	Name      string `json:"name" sql:"not null;size:255"`
+	AppliedAt int64  `json:"applied_at" sql:"not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type MigrationFunc func(dialect Dialect, executor SQLExecutor) ([]string, error)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      MigrationFunc
+	Down    MigrationFunc
+	// Backfill fills what Up adds from the rows already there, it runs after Up and again on the rows restored from
+	// an archive older than the migration
+	Backfill MigrationFunc
+}
+
+type MigrationStatus struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	Applied   bool   `json:"applied"`
+	AppliedAt int64  `json:"applied_at"`
+}
+
+// bookV1, cardV1 and borrowV1 are the tables as migration 1 creates them, the columns added to Book, Card and
+// Borrow since are added by the later migrations
+type bookV1 struct {
+	BookID      int     `sql:"not null;autoIncrement;primaryKey"`
+	Category    string  `sql:"not null;size:63;unique:book_unique"`
+	Title       string  `sql:"not null;size:63;unique:book_unique"`
+	Press       string  `sql:"not null;size:63;unique:book_unique"`
+	PublishYear int     `sql:"not null;unique:book_unique"`
+	Author      string  `sql:"not null;size:63;unique:book_unique"`
+	Price       myFloat `sql:"not null;decimal:7,2;default:0.00"`
+	Stock       int     `sql:"not null;default:0"`
+}
+
+func (bookV1) TableName() string {
+	return "book"
+}
+
+type cardV1 struct {
+	CardID     int    `sql:"not null;autoIncrement;primaryKey"`
+	Name       string `sql:"not null;size:63;unique:card_unique"`
+	Department string `sql:"not null;size:63;unique:card_unique"`
+	Type       string `sql:"not null;char:1;unique:card_unique;check:type in ('T', 'S')"`
+}
+
+func (cardV1) TableName() string {
+	return "card"
+}
+
+type borrowV1 struct {
+	CardID     int   `sql:"not null;primaryKey;constraint:Card.CardID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	BookID     int   `sql:"not null;primaryKey;constraint:Book.BookID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	BorrowTime int64 `sql:"not null;primaryKey"`
+	ReturnTime int64 `sql:"not null;default:0"`
+}
+
+func (borrowV1) TableName() string {
+	return "borrow"
+}
+
+// Migrations are applied in ascending version order, append new ones at the end and never edit an applied one
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create book, card and borrow tables",
+		Up:      createTables(bookV1{}, cardV1{}, borrowV1{}),
+		Down:    dropTables(borrowV1{}, cardV1{}, bookV1{}),
+	},
+	{
+		Version: 2,
+		Name:    "add due time to borrow",
+		Up:      addColumns(Borrow{}, "due_time"),
+		Down:    dropColumns(Borrow{}, "due_time"),
+	},
+	{
+		Version: 3,
+		Name:    "create fine ledger",
+		Up:      createTables(FineEntry{}),
+		Down:    dropTables(FineEntry{}),
+	},
+	{
+		Version: 4,
+		Name:    "create hold queue",
+		Up:      createTables(Hold{}),
+		Down:    dropTables(Hold{}),
+	},
+	{
+		Version: 5,
+		Name:    "add renewal count to borrow",
+		Up:      addColumns(Borrow{}, "renewals"),
+		Down:    dropColumns(Borrow{}, "renewals"),
+	},
+	{
+		Version:  6,
+		Name:     "track physical copies",
+		Up:       steps(createTables(BookCopy{}), addColumns(Borrow{}, "barcode")),
+		Down:     steps(dropColumns(Borrow{}, "barcode"), dropTables(BookCopy{})),
+		Backfill: backfillCopies,
+	},
+	{
+		Version: 7,
+		Name:    "add isbn to book",
+		Up:      steps(addColumns(Book{}, "isbn"), addIndexes(Book{}, "idx_book_isbn")),
+		Down:    steps(dropIndexes(Book{}, "idx_book_isbn"), dropColumns(Book{}, "isbn")),
+	},
+	{
+		Version:  8,
+		Name:     "create authors registry",
+		Up:       createTables(Author{}, BookAuthor{}),
+		Down:     dropTables(BookAuthor{}, Author{}),
+		Backfill: backfillAuthors,
+	},
+	{
+		Version: 9,
+		Name:    "add version and update time to book and card",
+		Up:      steps(addColumns(Book{}, "version", "updated_at"), addColumns(Card{}, "version", "updated_at")),
+		Down:    steps(dropColumns(Card{}, "version", "updated_at"), dropColumns(Book{}, "version", "updated_at")),
+	},
+	{
+		Version: 10,
+		Name:    "create users and sessions",
+		Up:      createTables(User{}, UserSession{}, UserBootstrap{}),
+		Down:    dropTables(UserBootstrap{}, UserSession{}, User{}),
+	},
+	{
+		Version: 11,
+		Name:    "create patron credentials and sessions",
+		Up:      createTables(CardCredential{}, PatronSession{}),
+		Down:    dropTables(PatronSession{}, CardCredential{}),
+	},
+	{
+		Version: 12,
+		Name:    "create audit log",
+		Up:      createTables(AuditEntry{}),
+		Down:    dropTables(AuditEntry{}),
+	},
+}
+
+// createTables creates the tables that do not exist yet, so a migration also works on a database created before it
+func createTables(tables ...any) MigrationFunc {
+	return func(dialect Dialect, executor SQLExecutor) ([]string, error) {
+		statements := make([]string, 0)
+		for _, table := range tables {
+			schema, err := ParseTable(table)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, schema.CreateSQL(dialect)...)
+		}
+		return statements, nil
+	}
+}
+
+// steps runs migration functions one after the other, they are all planned before any statement is executed
+func steps(funcs ...MigrationFunc) MigrationFunc {
+	return func(dialect Dialect, executor SQLExecutor) ([]string, error) {
+		statements := make([]string, 0)
+		for _, f := range funcs {
+			planned, err := f(dialect, executor)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, planned...)
+		}
+		return statements, nil
+	}
+}
+
+func dropTables(tables ...any) MigrationFunc {
+	return func(dialect Dialect, executor SQLExecutor) ([]string, error) {
+		statements := make([]string, 0)
+		for _, table := range tables {
+			statements = append(statements, "DROP TABLE IF EXISTS "+TableName(table))
+		}
+		return statements, nil
+	}
+}
+
+// addColumns adds the columns of the given fields that the table does not have yet
+func addColumns(table any, fields ...string) MigrationFunc {
+	return func(dialect Dialect, executor SQLExecutor) ([]string, error) {
+		expected, err := ParseTable(table)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := dialect.DescribeTable(executor, expected.Name)
+		if err != nil {
+			return nil, err
+		}
+		if actual == nil {
+			return expected.CreateSQL(dialect), nil
+		}
+
+		statements := make([]string, 0)
+		for _, field := range fields {
+			column := expected.Column(field)
+			if column == nil {
+				return nil, errors.New("unknown column " + expected.Name + "." + field)
+			}
+			if actual.Column(field) != nil {
+				continue
+			}
+			addColumnSQL := dialect.AddColumnSQL(expected, column)
+			if addColumnSQL == "" {
+				return nil, errors.New("cannot add column " + expected.Name + "." + field + " to an existing " + dialect.Name() + " table")
+			}
+			statements = append(statements, addColumnSQL)
+		}
+		return statements, nil
+	}
+}
+
+// addIndexes creates the given indexes of a table that does not have them yet, a missing table is created with
+// its indexes by an earlier step
+func addIndexes(table any, names ...string) MigrationFunc {
+	return func(dialect Dialect, executor SQLExecutor) ([]string, error) {
+		expected, err := ParseTable(table)
+		if err != nil {
+			return nil, err
+		}
+		actual, err := dialect.DescribeTable(executor, expected.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		statements := make([]string, 0)
+		if actual == nil {
+			return statements, nil
+		}
+		for _, name := range names {
+			index := expected.index(name)
+			if index == nil {
+				return nil, errors.New("unknown index " + expected.Name + "." + name)
+			}
+			if actual.index(name) == nil {
+				statements = append(statements, dialect.CreateIndexSQL(expected.Name, *index))
+			}
+		}
+		return statements, nil
+	}
+}
+
+func dropIndexes(table any, names ...string) MigrationFunc {
+	return func(dialect Dialect, executor SQLExecutor) ([]string, error) {
+		name := TableName(table)
+		actual, err := dialect.DescribeTable(executor, name)
+		if err != nil {
+			return nil, err
+		}
+
+		statements := make([]string, 0)
+		for _, index := range names {
+			if actual != nil && actual.index(index) != nil {
+				statements = append(statements, dialect.DropIndexSQL(name, *actual.index(index)))
+			}
+		}
+		return statements, nil
+	}
+}
+
+func dropColumns(table any, fields ...string) MigrationFunc {
+	return func(dialect Dialect, executor SQLExecutor) ([]string, error) {
+		name := TableName(table)
+		actual, err := dialect.DescribeTable(executor, name)
+		if err != nil {
+			return nil, err
+		}
+
+		statements := make([]string, 0)
+		for _, field := range fields {
+			if actual != nil && actual.Column(field) != nil {
+				statements = append(statements, "ALTER TABLE "+name+" DROP COLUMN "+field)
+			}
+		}
+		return statements, nil
+	}
+}
+
+func (c *DatabaseConnector) ensureMigrationTable(executor SQLExecutor) error {
+	schema, err := ParseTable(SchemaMigration{})
+	if err != nil {
+		return err
+	}
+	for _, statement := range schema.CreateSQL(c.Dialect()) {
+		_, err = executor.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *DatabaseConnector) appliedMigrations(executor SQLExecutor) (map[int]SchemaMigration, error) {
+	applied := make(map[int]SchemaMigration)
+
+	table, err := c.Dialect().DescribeTable(executor, TableName(SchemaMigration{}))
+	if err != nil {
+		return nil, err
+	}
+	if table == nil {
+		return applied, nil
+	}
+
+	rows, err := executor.Query("SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var migration SchemaMigration
+		err = rows.Scan(&migration.Version, &migration.Name, &migration.AppliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[migration.Version] = migration
+	}
+	return applied, nil
+}
+
+func (c *DatabaseConnector) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := c.appliedMigrations(c.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(Migrations))
+	for _, migration := range Migrations {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+func (c *DatabaseConnector) applyMigration(executor SQLExecutor, migration Migration, up bool, dryRun bool) ([]string, error) {
+	step := migration.Up
+	if !up {
+		step = migration.Down
+	}
+	if step == nil {
+		return nil, fmt.Errorf("migration %d is irreversible", migration.Version)
+	}
+
+	statements, err := step(c.Dialect(), executor)
+	if err != nil {
+		return nil, err
+	}
+	if up && migration.Backfill != nil {
+		// planned with Up, before any of its statements is executed
+		backfill, err := migration.Backfill(c.Dialect(), executor)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, backfill...)
+	}
+	if dryRun {
+		return statements, nil
+	}
+
+	for _, statement := range statements {
+		_, err = executor.Exec(statement)
+		if err != nil {
+			return nil, fmt.Errorf("migration %d: %w", migration.Version, err)
+		}
+	}
+
+	if up {
+		upsertSQL := c.Dialect().UpsertSQL(TableName(SchemaMigration{}), []string{"version", "name", "applied_at"}, []string{"version"})
+		_, err = executor.Exec(upsertSQL, migration.Version, migration.Name, time.Now().Unix())
+	} else {
+		_, err = executor.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return statements, nil
+}
+
+func (c *DatabaseConnector) migrateInTx(migration Migration, up bool, dryRun bool) ([]string, error) {
+	if dryRun {
+		return c.applyMigration(c.DB, migration, up, dryRun)
+	}
+
+	// MySQL commits DDL implicitly, the transaction only keeps the version row consistent on SQLite and PostgreSQL
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	statements, err := c.applyMigration(tx, migration, up, dryRun)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	return statements, err
+}
+
+// MigrateUp applies the pending migrations up to target version, or all of them if target is 0
+func (c *DatabaseConnector) MigrateUp(target int, dryRun bool) ([]string, error) {
+	if !dryRun {
+		if err := c.ensureMigrationTable(c.DB); err != nil {
+			return nil, err
+		}
+	}
+
+	applied, err := c.appliedMigrations(c.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make([]string, 0)
+	for _, migration := range Migrations {
+		if target != 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		executed, err := c.migrateInTx(migration, true, dryRun)
+		if err != nil {
+			return statements, err
+		}
+		statements = append(statements, fmt.Sprintf("-- migration %d up: %s", migration.Version, migration.Name))
+		statements = append(statements, executed...)
+		if !dryRun {
+			logrus.WithField("version", migration.Version).Info("Migration applied: ", migration.Name)
+		}
+	}
+	return statements, nil
+}
+
+// MigrateDown reverts the last steps applied migrations
+func (c *DatabaseConnector) MigrateDown(steps int, dryRun bool) ([]string, error) {
+	applied, err := c.appliedMigrations(c.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make([]string, 0)
+	for i := len(Migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := Migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		executed, err := c.migrateInTx(migration, false, dryRun)
+		if err != nil {
+			return statements, err
+		}
+		statements = append(statements, fmt.Sprintf("-- migration %d down: %s", migration.Version, migration.Name))
+		statements = append(statements, executed...)
+		if !dryRun {
+			logrus.WithField("version", migration.Version).Info("Migration reverted: ", migration.Name)
+		}
+		steps--
+	}
+	return statements, nil
+}
+
+// SchemaDiff compares the schema declared by the struct tags of Tables with the database
+// and returns the statements bringing the database up to date, plus what it cannot fix
+func (c *DatabaseConnector) SchemaDiff() (statements []string, warnings []string, err error) {
+	return schemaDiff(c.Dialect(), c.DB, Tables...)
+}
+
+func schemaDiff(dialect Dialect, executor SQLExecutor, tables ...any) (statements []string, warnings []string, err error) {
+	statements = make([]string, 0)
+	warnings = make([]string, 0)
+	for _, table := range tables {
+		expected, err := ParseTable(table)
+		if err != nil {
+			return nil, nil, err
+		}
+		actual, err := dialect.DescribeTable(executor, expected.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		tableStatements, tableWarnings := diffTable(dialect, expected, actual)
+		statements = append(statements, tableStatements...)
+		warnings = append(warnings, tableWarnings...)
+	}
+	return statements, warnings, nil
+}
+
+// SyncSchema applies the statements of SchemaDiff, it never drops columns or indexes the models do not declare
+func (c *DatabaseConnector) SyncSchema(dryRun bool) ([]string, error) {
+	statements, warnings, err := c.SchemaDiff()
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		logrus.Warn(warning)
+	}
+	if dryRun {
+		return statements, nil
+	}
+
+	for _, statement := range statements {
+		_, err = c.DB.Exec(statement)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", statement, err)
+		}
+		logrus.Info("Schema synchronized: ", statement)
+	}
+	return statements, nil
+}
+
+// Migrate applies pending migrations and then synchronizes the remaining differences of the struct-tag schema
+func (c *DatabaseConnector) Migrate() error {
+	_, err := c.MigrateUp(0, false)
+	if err != nil {
+		return err
+	}
+	_, err = c.SyncSchema(false)
+	return err
+}
+
+func diffTable(dialect Dialect, expected *TableSchema, actual *TableSchema) (statements []string, warnings []string) {
+	if actual == nil {
+		return expected.CreateSQL(dialect), nil
+	}
+
+	for i := range expected.Columns {
+		column := &expected.Columns[i]
+		current := actual.Column(column.Name)
+		if current == nil {
+			addColumnSQL := dialect.AddColumnSQL(expected, column)
+			if addColumnSQL == "" {
+				warnings = append(warnings, "cannot add column "+expected.Name+"."+column.Name+" to an existing "+dialect.Name()+" table")
+				continue
+			}
+			statements = append(statements, addColumnSQL)
+			continue
+		}
+
+		sameType := normalizeType(dialect.ColumnType(expected, column)) == normalizeType(current.Type)
+		sameDefault := normalizeDefault(dialect.DefaultValue(column)) == normalizeDefault(current.Default)
+		if sameType && sameDefault && column.NotNull == current.NotNull {
+			continue
+		}
+		modifySQL := dialect.ModifyColumnSQL(expected, column)
+		if modifySQL == nil {
+			warnings = append(warnings, "column "+expected.Name+"."+column.Name+" differs from the model, "+dialect.Name()+" cannot alter it in place")
+			continue
+		}
+		statements = append(statements, modifySQL...)
+	}
+	for _, column := range actual.Columns {
+		if expected.Column(column.Name) == nil {
+			warnings = append(warnings, "column "+expected.Name+"."+column.Name+" is not declared by the model, keeping it")
+		}
+	}
+
+	if !sameColumns(expected.PrimaryKey, actual.PrimaryKey) {
+		warnings = append(warnings, "primary key of "+expected.Name+" differs from the model ("+
+			strings.Join(actual.PrimaryKey, ", ")+" instead of "+strings.Join(expected.PrimaryKey, ", ")+")")
+	}
+
+	for _, index := range expected.Indexes {
+		current := actual.index(index.Name)
+		if current == nil {
+			// SQLite names the indexes of inline UNIQUE constraints itself
+			for i := range actual.Indexes {
+				if actual.Indexes[i].Unique == index.Unique && sameColumnSet(actual.Indexes[i].Columns, index.Columns) {
+					current = &actual.Indexes[i]
+					break
+				}
+			}
+		}
+		if current != nil && current.Unique == index.Unique && sameColumns(current.Columns, index.Columns) &&
+			(current.Fulltext == index.Fulltext || !dialect.Fulltext()) {
+			continue
+		}
+		if current != nil {
+			if strings.HasPrefix(current.Name, "sqlite_autoindex_") {
+				warnings = append(warnings, "index "+expected.Name+"."+index.Name+" differs from the model, SQLite cannot alter it in place")
+				continue
+			}
+			statements = append(statements, dialect.DropIndexSQL(expected.Name, *current))
+		}
+		statements = append(statements, dialect.CreateIndexSQL(expected.Name, index))
+	}
+
+	for _, foreignKey := range expected.ForeignKeys {
+		found := false
+		for _, current := range actual.ForeignKeys {
+			if current.Column == foreignKey.Column && current.RefTable == foreignKey.RefTable && current.RefColumn == foreignKey.RefColumn {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		addConstraintSQL := dialect.AddConstraintSQL(expected.Name, foreignKey.sql())
+		if addConstraintSQL == "" {
+			warnings = append(warnings, "cannot add foreign key on "+expected.Name+"."+foreignKey.Column+" to an existing "+dialect.Name()+" table")
+			continue
+		}
+		statements = append(statements, addConstraintSQL)
+	}
+
+	for _, check := range expected.Checks {
+		found := false
+		for _, current := range actual.Checks {
+			if strings.EqualFold(current.Name, check.Name) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		addConstraintSQL := dialect.AddConstraintSQL(expected.Name, "CONSTRAINT "+check.Name+" CHECK("+check.Clause+")")
+		if addConstraintSQL == "" {
+			warnings = append(warnings, "cannot add check "+check.Name+" to an existing "+dialect.Name()+" table")
+			continue
+		}
+		statements = append(statements, addConstraintSQL)
+	}
+
+	return statements, warnings
+}
@@ -0,0 +1,251 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return DriverMySQL
+}
+
+func (mysqlDialect) Open(config *ConnectConfig) (*sql.DB, error) {
+	db, err := sql.Open("mysql", config.databaseLoginInfo())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(2000)
+	db.SetMaxIdleConns(1000)
+	db.SetConnMaxLifetime(time.Minute * 60)
+	return db, nil
+}
+
+func (config *ConnectConfig) databaseLoginInfo() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
+		config.User,
+		config.Password,
+		config.Host,
+		config.Port,
+		config.DBName,
+	)
+}
+
+func (mysqlDialect) Placeholder(int) string {
+	return "?"
+}
+
+func (mysqlDialect) ColumnType(_ *TableSchema, column *ColumnSchema) string {
+	return column.Type
+}
+
+func (mysqlDialect) DefaultValue(column *ColumnSchema) *string {
+	return column.Default
+}
+
+func (d mysqlDialect) ColumnSQL(table *TableSchema, column *ColumnSchema) string {
+	return columnSQL(d, table, column, "AUTO_INCREMENT")
+}
+
+func (d mysqlDialect) CreateTableSQL(table *TableSchema) []string {
+	return createTableSQL(d, table, true, false, "ENGINE=InnoDB DEFAULT CHARSET=utf8mb4")
+}
+
+func (d mysqlDialect) AddColumnSQL(table *TableSchema, column *ColumnSchema) string {
+	return "ALTER TABLE " + table.Name + " ADD COLUMN " + d.ColumnSQL(table, column)
+}
+
+func (d mysqlDialect) ModifyColumnSQL(table *TableSchema, column *ColumnSchema) []string {
+	return []string{"ALTER TABLE " + table.Name + " MODIFY COLUMN " + d.ColumnSQL(table, column)}
+}
+
+func (mysqlDialect) AddConstraintSQL(table string, definition string) string {
+	return "ALTER TABLE " + table + " ADD " + definition
+}
+
+func (mysqlDialect) CreateIndexSQL(table string, index IndexSchema) string {
+	columns := strings.Join(index.Columns, ", ")
+	switch {
+	case index.Unique:
+		return "ALTER TABLE " + table + " ADD CONSTRAINT " + index.Name + " UNIQUE(" + columns + ")"
+	case index.Fulltext:
+		return "CREATE FULLTEXT INDEX " + index.Name + " ON " + table + " (" + columns + ")"
+	default:
+		return "CREATE INDEX " + index.Name + " ON " + table + " (" + columns + ")"
+	}
+}
+
+func (mysqlDialect) DropIndexSQL(table string, index IndexSchema) string {
+	return "ALTER TABLE " + table + " DROP INDEX " + index.Name
+}
+
+func (mysqlDialect) Fulltext() bool {
+	return true
+}
+
+func (mysqlDialect) DescribeTable(executor SQLExecutor, name string) (*TableSchema, error) {
+	return describeMySQLTable(executor, name)
+}
+
+func (mysqlDialect) LockForUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (mysqlDialect) LockForShare() string {
+	return " LOCK IN SHARE MODE"
+}
+
+func (mysqlDialect) Like() string {
+	return "LIKE"
+}
+
+func (mysqlDialect) UpsertSQL(table string, columns []string, keys []string) string {
+	return upsertSQL(table, columns, keys, "ON DUPLICATE KEY UPDATE", func(column string) string {
+		return "VALUES(" + column + ")"
+	})
+}
+
+func (mysqlDialect) ConstraintError(err error) error {
+	var mysqlError *mysql.MySQLError
+	if !errors.As(err, &mysqlError) {
+		return err
+	}
+	switch mysqlError.Number {
+	case 1062:
+		return WrapError(ErrDuplicate, err)
+	case 1451:
+		return WrapError(ErrConflict, err)
+	case 1452:
+		return WrapError(ErrNotFound, err)
+	case 3819:
+		return WrapError(ErrValidation, err)
+	}
+	return err
+}
+
+func (mysqlDialect) InsertReturningID(executor SQLExecutor, query string, _ string, args ...any) (int64, error) {
+	return lastInsertID(executor, query, args...)
+}
+
+// SnapshotSQL is empty, InnoDB transactions read a snapshot taken at their first read
+func (mysqlDialect) SnapshotSQL() string {
+	return ""
+}
+
+func (mysqlDialect) SyncSequenceSQL(string, string) string {
+	return ""
+}
+
+func describeMySQLTable(executor SQLExecutor, name string) (*TableSchema, error) {
+	querySQL := "SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT, EXTRA, COLUMN_KEY " +
+		"FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION"
+
+	rows, err := executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := TableSchema{Name: name}
+	for rows.Next() {
+		var (
+			column               ColumnSchema
+			nullable, extra, key string
+			defaultValue         sql.NullString
+		)
+		err = rows.Scan(&column.Name, &column.Type, &nullable, &defaultValue, &extra, &key)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		column.NotNull = nullable == "NO"
+		column.AutoIncrement = strings.Contains(strings.ToLower(extra), "auto_increment")
+		if defaultValue.Valid {
+			column.Default = &defaultValue.String
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+	rows.Close()
+	if len(schema.Columns) == 0 {
+		return nil, nil
+	}
+
+	querySQL = "SELECT INDEX_NAME, COLUMN_NAME, NON_UNIQUE, INDEX_TYPE FROM INFORMATION_SCHEMA.STATISTICS " +
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY INDEX_NAME, SEQ_IN_INDEX"
+
+	rows, err = executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			indexName, columnName, indexType string
+			nonUnique                        int
+		)
+		err = rows.Scan(&indexName, &columnName, &nonUnique, &indexType)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if indexName == "PRIMARY" {
+			schema.PrimaryKey = append(schema.PrimaryKey, columnName)
+			continue
+		}
+		index := schema.index(indexName)
+		if index == nil {
+			schema.Indexes = append(schema.Indexes, IndexSchema{
+				Name:     indexName,
+				Unique:   nonUnique == 0,
+				Fulltext: indexType == "FULLTEXT",
+			})
+			index = &schema.Indexes[len(schema.Indexes)-1]
+		}
+		index.Columns = append(index.Columns, columnName)
+	}
+	rows.Close()
+
+	querySQL = "SELECT k.COLUMN_NAME, k.REFERENCED_TABLE_NAME, k.REFERENCED_COLUMN_NAME, r.UPDATE_RULE, r.DELETE_RULE " +
+		"FROM INFORMATION_SCHEMA.KEY_COLUMN_USAGE k JOIN INFORMATION_SCHEMA.REFERENTIAL_CONSTRAINTS r " +
+		"ON k.CONSTRAINT_SCHEMA = r.CONSTRAINT_SCHEMA AND k.CONSTRAINT_NAME = r.CONSTRAINT_NAME " +
+		"WHERE k.TABLE_SCHEMA = DATABASE() AND k.TABLE_NAME = ? AND k.REFERENCED_TABLE_NAME IS NOT NULL"
+
+	rows, err = executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var foreignKey ForeignKeySchema
+		err = rows.Scan(&foreignKey.Column, &foreignKey.RefTable, &foreignKey.RefColumn, &foreignKey.OnUpdate, &foreignKey.OnDelete)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schema.ForeignKeys = append(schema.ForeignKeys, foreignKey)
+	}
+	rows.Close()
+
+	querySQL = "SELECT CONSTRAINT_NAME FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS " +
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_TYPE = 'CHECK'"
+
+	rows, err = executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var check CheckSchema
+		err = rows.Scan(&check.Name)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schema.Checks = append(schema.Checks, check)
+	}
+	rows.Close()
+
+	return &schema, nil
+}
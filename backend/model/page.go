@@ -0,0 +1,93 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// Page selects a page of a listing, either by Offset or by the Cursor returned with the previous page
+type Page struct {
+	Offset int    `json:"offset,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+func NewPage(offset int, limit int, cursor string) *Page {
+	return &Page{Offset: offset, Limit: limit, Cursor: cursor}
+}
+
+// pageCursor is the position of the last row of a page in its listing, Sort tells the order the listing had
+type pageCursor struct {
+	Sort string          `json:"s,omitempty"`
+	Key  json.RawMessage `json:"k,omitempty"`
+	ID   int             `json:"id"`
+}
+
+// check returns the size of the page, DefaultPageSize if the page does not set it
+func (p *Page) check() (int, error) {
+	if p == nil || p.Limit == 0 {
+		return DefaultPageSize, p.checkOffset()
+	}
+	if p.Limit < 0 || p.Limit > MaxPageSize {
+		return 0, ErrInvalidPageSize
+	}
+	return p.Limit, p.checkOffset()
+}
+
+func (p *Page) checkOffset() error {
+	if p == nil {
+		return nil
+	}
+	if p.Offset < 0 {
+		return ErrInvalidOffset
+	}
+	if p.Offset > 0 && p.Cursor != "" {
+		return ErrOffsetWithCursor
+	}
+	return nil
+}
+
+// after returns the cursor of the page, nil for the first page, it must come from a listing in the same order
+func (p *Page) after(sort string) (*pageCursor, error) {
+	if p == nil || p.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor pageCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func (p *Page) offset() int {
+	if p == nil {
+		return 0
+	}
+	return p.Offset
+}
+
+// encodeCursor returns the opaque cursor of the page ending with the row of id and sort key
+func encodeCursor(sort string, key any, id int) string {
+	cursor := pageCursor{Sort: sort, ID: id}
+	if key != nil {
+		cursor.Key, _ = json.Marshal(key)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// limitSQL returns the clause selecting a page of limit rows, one more is read to tell if another page follows
+func limitSQL(limit int, offset int) string {
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", limit+1, offset)
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CardCredential is the PIN the patron of a card signs in to the portal with, only its hash is stored
+type CardCredential struct {
+	CardID    int    `json:"card_id" sql:"not null;primaryKey;constraint:Card.CardID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	PINHash   string `json:"pin_hash" sql:"not null;size:255"`
+	UpdatedAt int64  `json:"updated_at" sql:"not null"`
+}
+
+// PatronSession is a login of a patron to the portal, kept apart from the sessions of the staff
+type PatronSession struct {
+	TokenHash  string `json:"token_hash" sql:"not null;char:64;primaryKey"`
+	CardID     int    `json:"card_id" sql:"not null;index;constraint:Card.CardID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	CreatedAt  int64  `json:"created_at" sql:"not null"`
+	ExpireTime int64  `json:"expire_time" sql:"not null;index"`
+}
+
+type PatronLoginRequest struct {
+	CardID *int    `json:"card_id,omitempty"`
+	PIN    *string `json:"pin,omitempty"`
+}
+
+type PatronLoginResult struct {
+	Token      string `json:"token"`
+	ExpireTime int64  `json:"expire_time"`
+	CardID     int    `json:"card_id"`
+}
+
+type CardPINRequest struct {
+	CardID *int `json:"card_id,omitempty"`
+	// OldPIN is required when patrons change their own PIN
+	OldPIN *string `json:"old_pin,omitempty"`
+	PIN    *string `json:"pin,omitempty"`
+}
+
+// HashPIN checks the length of a PIN or password of a card against the auth policy and returns its bcrypt hash
+func HashPIN(pin string) (string, error) {
+	if len(pin) < CurrentAuthPolicy().MinPINLength {
+		return "", ErrPINTooShort
+	}
+	if len(pin) > maxPasswordLength {
+		return "", ErrPINTooLong
+	}
+	return hashSecret(pin)
+}
+
+// CheckPIN reports whether pin is the PIN of the card, a nil credential has none
+func (cc *CardCredential) CheckPIN(pin string) bool {
+	if cc == nil {
+		return checkHash("", pin)
+	}
+	return checkHash(cc.PINHash, pin)
+}
+
+// NewPatronSession returns a random token and the session of the card it opens, lasting as long as the auth policy says
+func NewPatronSession(cardId int) (string, *PatronSession, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	return token, &PatronSession{
+		TokenHash:  HashToken(token),
+		CardID:     cardId,
+		CreatedAt:  now.Unix(),
+		ExpireTime: now.Add(CurrentAuthPolicy().TokenTTL).Unix(),
+	}, nil
+}
+
+func (c *DatabaseConnector) SetCardPIN(cardId int, pinHash string) error {
+	if pinHash == "" {
+		return ErrMissingPIN
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var id int
+	err = queryRow(tx, "SELECT card_id FROM card WHERE card_id = ?"+c.Dialect().LockForUpdate(), cardId).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrCardNotFound
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM card_credential WHERE card_id = ?", cardId)
+	}
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO card_credential (card_id, pin_hash, updated_at) VALUES (?, ?, ?)",
+			cardId, pinHash, time.Now().Unix())
+	}
+	// a new PIN signs the patron out everywhere
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM patron_session WHERE card_id = ?", cardId)
+	}
+	if err == nil {
+		err = c.writeAudit(tx, "pin", AuditCard, cardId, nil, nil)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c *DatabaseConnector) QueryCardCredential(cardId int) (*CardCredential, error) {
+	var credential CardCredential
+	err := c.DB.QueryRow("SELECT * FROM card_credential WHERE card_id = ?", cardId).
+		Scan(&credential.CardID, &credential.PINHash, &credential.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func (c *DatabaseConnector) CreatePatronSession(session *PatronSession) error {
+	if session == nil {
+		return ErrNilSession
+	}
+	// the expired sessions are cleared as new ones open
+	_, err := c.DB.Exec("DELETE FROM patron_session WHERE expire_time <= ?", session.CreatedAt)
+	if err != nil {
+		return err
+	}
+	_, err = c.DB.Exec("INSERT INTO patron_session (token_hash, card_id, created_at, expire_time) VALUES (?, ?, ?, ?)",
+		session.TokenHash, session.CardID, session.CreatedAt, session.ExpireTime)
+	return err
+}
+
+func (c *DatabaseConnector) QueryPatronSession(tokenHash string) (int, error) {
+	var cardId int
+	err := c.DB.QueryRow("SELECT card_id FROM patron_session WHERE token_hash = ? AND expire_time > ?",
+		tokenHash, time.Now().Unix()).Scan(&cardId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidToken
+	}
+	return cardId, err
+}
+
+func (c *DatabaseConnector) RemovePatronSession(tokenHash string) error {
+	_, err := c.DB.Exec("DELETE FROM patron_session WHERE token_hash = ?", tokenHash)
+	return err
+}
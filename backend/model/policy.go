@@ -0,0 +1,460 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// LoanRule sets the loan period of the books of Category lent to the cards of CardType, an empty field matches any value
+type LoanRule struct {
+	CardType string `json:"card_type" mapstructure:"card_type"`
+	Category string `json:"category" mapstructure:"category"`
+	Days     int    `json:"days" mapstructure:"days"`
+}
+
+// LoanLimit caps the open loans of the cards of CardType, of the books of Category or of all books if it is empty.
+// An empty CardType matches any card.
+type LoanLimit struct {
+	CardType string `json:"card_type" mapstructure:"card_type"`
+	Category string `json:"category" mapstructure:"category"`
+	MaxLoans int    `json:"max_loans" mapstructure:"max_loans"`
+}
+
+type LoanPolicy struct {
+	DefaultDays int         `json:"default_days" mapstructure:"default_days"`
+	Rules       []LoanRule  `json:"rules" mapstructure:"rules"`
+	Limits      []LoanLimit `json:"limits" mapstructure:"limits"`
+	MaxRenewals int         `json:"max_renewals" mapstructure:"max_renewals"`
+	// RenewalOverdueDays is how many days past its due time a loan may still be renewed
+	RenewalOverdueDays int `json:"renewal_overdue_days" mapstructure:"renewal_overdue_days"`
+}
+
+func DefaultLoanPolicy() *LoanPolicy {
+	return &LoanPolicy{DefaultDays: 30, MaxRenewals: 2}
+}
+
+func (p *LoanPolicy) Validate() error {
+	var errs []error
+	if p.DefaultDays <= 0 {
+		errs = append(errs, errors.New("default_days must be greater than 0"))
+	}
+	if p.MaxRenewals < 0 || p.RenewalOverdueDays < 0 {
+		errs = append(errs, errors.New("max_renewals and renewal_overdue_days must not be negative"))
+	}
+	for i, rule := range p.Rules {
+		if rule.Days <= 0 {
+			errs = append(errs, fmt.Errorf("rule %d: days must be greater than 0", i))
+		}
+		if rule.CardType != "" && rule.CardType != "T" && rule.CardType != "S" {
+			errs = append(errs, fmt.Errorf("rule %d: invalid card type %q", i, rule.CardType))
+		}
+	}
+	for i, limit := range p.Limits {
+		if limit.MaxLoans < 0 {
+			errs = append(errs, fmt.Errorf("limit %d: max_loans must not be negative", i))
+		}
+		if limit.CardType != "" && limit.CardType != "T" && limit.CardType != "S" {
+			errs = append(errs, fmt.Errorf("limit %d: invalid card type %q", i, limit.CardType))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Quotas returns the quotas of a card with loans open loans per category, a limit of the card type wins over
+// a limit of the same category for any card
+func (p *LoanPolicy) Quotas(cardType string, loans map[string]int) []CardQuota {
+	quotas := make([]CardQuota, 0)
+	specific := make([]bool, 0)
+	for _, limit := range p.Limits {
+		if limit.CardType != "" && limit.CardType != cardType {
+			continue
+		}
+		i := 0
+		for i < len(quotas) && !strings.EqualFold(quotas[i].Category, limit.Category) {
+			i++
+		}
+		if i == len(quotas) {
+			quotas = append(quotas, CardQuota{Category: limit.Category})
+			specific = append(specific, false)
+		} else if specific[i] {
+			continue
+		}
+		quotas[i].Limit = limit.MaxLoans
+		specific[i] = limit.CardType != ""
+	}
+
+	for i := range quotas {
+		for category, count := range loans {
+			if quotas[i].Category == "" || strings.EqualFold(quotas[i].Category, category) {
+				quotas[i].Loans += count
+			}
+		}
+		if quotas[i].Loans < quotas[i].Limit {
+			quotas[i].Remaining = quotas[i].Limit - quotas[i].Loans
+		}
+	}
+	return quotas
+}
+
+// CheckLimits returns an error if a card with loans open loans per category may not borrow a book of category
+func (p *LoanPolicy) CheckLimits(cardType string, category string, loans map[string]int) error {
+	for _, quota := range p.Quotas(cardType, loans) {
+		if quota.Remaining > 0 {
+			continue
+		}
+		if quota.Category == "" {
+			return ErrLoanLimit
+		}
+		if strings.EqualFold(quota.Category, category) {
+			return ErrCategoryLoanLimit
+		}
+	}
+	return nil
+}
+
+// LoanDays returns the loan period in days, a rule matching both the card type and the category wins over
+// a rule matching the card type only, which wins over a rule matching the category only
+func (p *LoanPolicy) LoanDays(cardType string, category string) int {
+	days, best := p.DefaultDays, 0
+	for _, rule := range p.Rules {
+		if rule.CardType != "" && rule.CardType != cardType {
+			continue
+		}
+		if rule.Category != "" && !strings.EqualFold(rule.Category, category) {
+			continue
+		}
+		score := 1
+		if rule.CardType != "" {
+			score += 2
+		}
+		if rule.Category != "" {
+			score++
+		}
+		if score > best {
+			days, best = rule.Days, score
+		}
+	}
+	return days
+}
+
+// DueTime returns when a book borrowed at borrowTime must be returned
+func (p *LoanPolicy) DueTime(borrowTime int64, cardType string, category string) int64 {
+	return time.Unix(borrowTime, 0).AddDate(0, 0, p.LoanDays(cardType, category)).Unix()
+}
+
+// Renew checks a loan may be renewed at now and returns its new due time, a new loan period counted from
+// the current due time, or from now if the loan is overdue or has no due time
+func (p *LoanPolicy) Renew(loan *Borrow, now int64, cardType string, category string) (int64, error) {
+	if loan.Renewals >= p.MaxRenewals {
+		return 0, ErrRenewalLimit
+	}
+	if loan.DueTime != 0 && now > time.Unix(loan.DueTime, 0).AddDate(0, 0, p.RenewalOverdueDays).Unix() {
+		return 0, ErrLoanOverdue
+	}
+	from := loan.DueTime
+	if from < now {
+		from = now
+	}
+	return p.DueTime(from, cardType, category), nil
+}
+
+var loanPolicy atomic.Pointer[LoanPolicy]
+
+// SetLoanPolicy replaces the policy used by the connectors for the next loans
+func SetLoanPolicy(policy *LoanPolicy) {
+	loanPolicy.Store(policy)
+}
+
+func CurrentLoanPolicy() *LoanPolicy {
+	if policy := loanPolicy.Load(); policy != nil {
+		return policy
+	}
+	return DefaultLoanPolicy()
+}
+
+// dueTime returns the due time of a new loan, the due time requested by the borrow if any or the one of the policy
+func dueTime(borrow *Borrow, borrowTime int64, cardType string, category string) (int64, error) {
+	if borrow.DueTime == 0 {
+		return CurrentLoanPolicy().DueTime(borrowTime, cardType, category), nil
+	}
+	if borrow.DueTime <= borrowTime {
+		return 0, ErrInvalidDueTime
+	}
+	return borrow.DueTime, nil
+}
+
+// FineRule sets the fines of the cards of CardType, an empty CardType matches any card
+type FineRule struct {
+	CardType  string  `json:"card_type" mapstructure:"card_type"`
+	DailyRate float64 `json:"daily_rate" mapstructure:"daily_rate"`
+	GraceDays int     `json:"grace_days" mapstructure:"grace_days"`
+	MaxFine   float64 `json:"max_fine" mapstructure:"max_fine"`
+}
+
+type FinePolicy struct {
+	// BlockThreshold is the outstanding balance above which a card cannot borrow
+	BlockThreshold float64    `json:"block_threshold" mapstructure:"block_threshold"`
+	Rules          []FineRule `json:"rules" mapstructure:"rules"`
+}
+
+// DefaultFinePolicy charges nothing
+func DefaultFinePolicy() *FinePolicy {
+	return &FinePolicy{}
+}
+
+func (p *FinePolicy) Validate() error {
+	var errs []error
+	if p.BlockThreshold < 0 {
+		errs = append(errs, errors.New("block_threshold must not be negative"))
+	}
+	for i, rule := range p.Rules {
+		if rule.DailyRate < 0 || rule.GraceDays < 0 || rule.MaxFine < 0 {
+			errs = append(errs, fmt.Errorf("rule %d: daily_rate, grace_days and max_fine must not be negative", i))
+		}
+		if rule.CardType != "" && rule.CardType != "T" && rule.CardType != "S" {
+			errs = append(errs, fmt.Errorf("rule %d: invalid card type %q", i, rule.CardType))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *FinePolicy) rule(cardType string) FineRule {
+	var rule FineRule
+	for _, r := range p.Rules {
+		if r.CardType == cardType {
+			return r
+		}
+		if r.CardType == "" {
+			rule = r
+		}
+	}
+	return rule
+}
+
+// Fine returns the fine of a loan returned at returnTime and the days it was late. The days within the
+// grace period are free once the book is late beyond it too, and a MaxFine of 0 means no cap.
+func (p *FinePolicy) Fine(cardType string, dueTime int64, returnTime int64) (float64, int) {
+	if dueTime == 0 || returnTime <= dueTime {
+		return 0, 0
+	}
+	const day = int64(24 * time.Hour / time.Second)
+	lateDays := int((returnTime - dueTime + day - 1) / day)
+
+	rule := p.rule(cardType)
+	chargedDays := lateDays - rule.GraceDays
+	if chargedDays <= 0 {
+		return 0, lateDays
+	}
+	fine := float64(chargedDays) * rule.DailyRate
+	if rule.MaxFine > 0 && fine > rule.MaxFine {
+		fine = rule.MaxFine
+	}
+	return math.Round(fine*100) / 100, lateDays
+}
+
+// Blocks reports whether a card owing balance may not borrow
+func (p *FinePolicy) Blocks(balance float64) bool {
+	return math.Round(balance*100) > math.Round(p.BlockThreshold*100)
+}
+
+var finePolicy atomic.Pointer[FinePolicy]
+
+func SetFinePolicy(policy *FinePolicy) {
+	finePolicy.Store(policy)
+}
+
+func CurrentFinePolicy() *FinePolicy {
+	if policy := finePolicy.Load(); policy != nil {
+		return policy
+	}
+	return DefaultFinePolicy()
+}
+
+type HoldPolicy struct {
+	// PickupDays is how long a copy set aside for a hold waits for its patron
+	PickupDays         int  `json:"pickup_days" mapstructure:"pickup_days"`
+	PrioritizeTeachers bool `json:"prioritize_teachers" mapstructure:"prioritize_teachers"`
+	// SweepInterval is how often the unclaimed holds are expired
+	SweepInterval time.Duration `json:"sweep_interval" mapstructure:"sweep_interval"`
+}
+
+func DefaultHoldPolicy() *HoldPolicy {
+	return &HoldPolicy{PickupDays: 3, SweepInterval: time.Minute}
+}
+
+func (p *HoldPolicy) Validate() error {
+	var errs []error
+	if p.PickupDays <= 0 {
+		errs = append(errs, errors.New("pickup_days must be greater than 0"))
+	}
+	if p.SweepInterval <= 0 {
+		errs = append(errs, errors.New("sweep_interval must be greater than 0"))
+	}
+	return errors.Join(errs...)
+}
+
+// Priority returns the priority of a new hold of a card, holds of higher priority are served first
+func (p *HoldPolicy) Priority(cardType string) int {
+	if p.PrioritizeTeachers && cardType == "T" {
+		return 1
+	}
+	return 0
+}
+
+// ExpireTime returns until when a copy set aside at readyAt waits for its patron
+func (p *HoldPolicy) ExpireTime(readyAt int64) int64 {
+	return time.Unix(readyAt, 0).AddDate(0, 0, p.PickupDays).Unix()
+}
+
+var holdPolicy atomic.Pointer[HoldPolicy]
+
+func SetHoldPolicy(policy *HoldPolicy) {
+	holdPolicy.Store(policy)
+}
+
+func CurrentHoldPolicy() *HoldPolicy {
+	if policy := holdPolicy.Load(); policy != nil {
+		return policy
+	}
+	return DefaultHoldPolicy()
+}
+
+type AuthPolicy struct {
+	// TokenTTL is how long a login lasts
+	TokenTTL          time.Duration `json:"token_ttl" mapstructure:"token_ttl"`
+	MinPasswordLength int           `json:"min_password_length" mapstructure:"min_password_length"`
+	MinPINLength      int           `json:"min_pin_length" mapstructure:"min_pin_length"`
+	// MaxPINAttempts failed patron logins in a row lock the card out of the portal for PINLockout
+	MaxPINAttempts int           `json:"max_pin_attempts" mapstructure:"max_pin_attempts"`
+	PINLockout     time.Duration `json:"pin_lockout" mapstructure:"pin_lockout"`
+	// MaxLoginAttempts wrong passwords in a row lock the username out for LoginLockout
+	MaxLoginAttempts int           `json:"max_login_attempts" mapstructure:"max_login_attempts"`
+	LoginLockout     time.Duration `json:"login_lockout" mapstructure:"login_lockout"`
+}
+
+func DefaultAuthPolicy() *AuthPolicy {
+	return &AuthPolicy{TokenTTL: 12 * time.Hour, MinPasswordLength: 8, MinPINLength: 4, MaxPINAttempts: 5, PINLockout: 15 * time.Minute,
+		MaxLoginAttempts: 5, LoginLockout: 15 * time.Minute}
+}
+
+func (p *AuthPolicy) Validate() error {
+	var errs []error
+	if p.TokenTTL <= 0 {
+		errs = append(errs, errors.New("token_ttl must be greater than 0"))
+	}
+	if p.MinPasswordLength <= 0 || p.MinPasswordLength > maxPasswordLength {
+		errs = append(errs, fmt.Errorf("min_password_length must be between 1 and %d", maxPasswordLength))
+	}
+	if p.MinPINLength <= 0 || p.MinPINLength > maxPasswordLength {
+		errs = append(errs, fmt.Errorf("min_pin_length must be between 1 and %d", maxPasswordLength))
+	}
+	if p.MaxPINAttempts <= 0 || p.PINLockout <= 0 {
+		errs = append(errs, errors.New("max_pin_attempts and pin_lockout must be greater than 0"))
+	}
+	if p.MaxLoginAttempts <= 0 || p.LoginLockout <= 0 {
+		errs = append(errs, errors.New("max_login_attempts and login_lockout must be greater than 0"))
+	}
+	return errors.Join(errs...)
+}
+
+var authPolicy atomic.Pointer[AuthPolicy]
+
+func SetAuthPolicy(policy *AuthPolicy) {
+	authPolicy.Store(policy)
+}
+
+func CurrentAuthPolicy() *AuthPolicy {
+	if policy := authPolicy.Load(); policy != nil {
+		return policy
+	}
+	return DefaultAuthPolicy()
+}
+
+// The environments a server runs in, only the ones for development and tests allow resetting the database
+const (
+	EnvDev  = "dev"
+	EnvTest = "test"
+	EnvProd = "prod"
+)
+
+type ResetPolicy struct {
+	// Environment is dev, test or prod, the server runs in prod unless the configuration says otherwise
+	Environment string `json:"environment" mapstructure:"environment"`
+	// ConfirmTTL is how long the token confirming a reset lasts
+	ConfirmTTL time.Duration `json:"confirm_ttl" mapstructure:"confirm_ttl"`
+	// Fixtures is the directory of the fixture sets a reset may load
+	Fixtures string `json:"fixtures" mapstructure:"fixtures"`
+}
+
+func DefaultResetPolicy() *ResetPolicy {
+	return &ResetPolicy{Environment: EnvProd, ConfirmTTL: 5 * time.Minute, Fixtures: "fixtures"}
+}
+
+func (p *ResetPolicy) Validate() error {
+	var errs []error
+	if p.Environment != EnvDev && p.Environment != EnvTest && p.Environment != EnvProd {
+		errs = append(errs, errors.New("environment must be dev, test or prod"))
+	}
+	if p.ConfirmTTL <= 0 {
+		errs = append(errs, errors.New("confirm_ttl must be greater than 0"))
+	}
+	return errors.Join(errs...)
+}
+
+// Allowed reports whether the database may be reset through the API
+func (p *ResetPolicy) Allowed() bool {
+	return p.Environment == EnvDev || p.Environment == EnvTest
+}
+
+var resetPolicy atomic.Pointer[ResetPolicy]
+
+func SetResetPolicy(policy *ResetPolicy) {
+	resetPolicy.Store(policy)
+}
+
+func CurrentResetPolicy() *ResetPolicy {
+	if policy := resetPolicy.Load(); policy != nil {
+		return policy
+	}
+	return DefaultResetPolicy()
+}
+
+// ArchivePolicy limits the size of the archives restored
+type ArchivePolicy struct {
+	// MaxUpload is the largest archive, in bytes, POST /db/restore accepts
+	MaxUpload int64 `json:"max_upload" mapstructure:"max_upload"`
+	// MaxTable is the largest file of a table, in bytes once decompressed, a restore reads
+	MaxTable int64 `json:"max_table" mapstructure:"max_table"`
+}
+
+func DefaultArchivePolicy() *ArchivePolicy {
+	return &ArchivePolicy{MaxUpload: 256 << 20, MaxTable: 1 << 30}
+}
+
+func (p *ArchivePolicy) Validate() error {
+	var errs []error
+	if p.MaxUpload <= 0 {
+		errs = append(errs, errors.New("max_upload must be greater than 0"))
+	}
+	if p.MaxTable <= 0 {
+		errs = append(errs, errors.New("max_table must be greater than 0"))
+	}
+	return errors.Join(errs...)
+}
+
+var archivePolicy atomic.Pointer[ArchivePolicy]
+
+func SetArchivePolicy(policy *ArchivePolicy) {
+	archivePolicy.Store(policy)
+}
+
+func CurrentArchivePolicy() *ArchivePolicy {
+	if policy := archivePolicy.Load(); policy != nil {
+		return policy
+	}
+	return DefaultArchivePolicy()
+}
@@ -0,0 +1,393 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return DriverPostgres
+}
+
+func (postgresDialect) Open(config *ConnectConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", config.postgresDataSource())
+	if err != nil {
+		return nil, err
+	}
+	// PostgreSQL forks a backend per connection and allows 100 connections by default
+	db.SetMaxOpenConns(50)
+	db.SetMaxIdleConns(25)
+	db.SetConnMaxLifetime(time.Minute * 60)
+	return db, nil
+}
+
+func (config *ConnectConfig) postgresDataSource() string {
+	sslMode := config.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	dataSource := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(config.User, config.Password),
+		Host:     config.Host + ":" + strconv.Itoa(config.Port),
+		Path:     "/" + config.DBName,
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+	return dataSource.String()
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+var sizedTypePattern = regexp.MustCompile(`^([A-Z ]+?)\s*(\([\d, ]+\))?(\s+UNSIGNED)?$`)
+
+// ColumnType translates the MySQL flavored type of a column, types it does not know are kept as they are
+func (postgresDialect) ColumnType(table *TableSchema, column *ColumnSchema) string {
+	if len(column.Enum) != 0 {
+		columnType, _ := enumSQL(column)
+		return columnType
+	}
+	match := sizedTypePattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(column.Type)))
+	if match == nil {
+		return column.Type
+	}
+	name, size, unsigned := match[1], match[2], match[3] != ""
+	switch name {
+	case "TINYINT":
+		if size == "(1)" {
+			return "BOOLEAN"
+		}
+		return "SMALLINT"
+	case "SMALLINT":
+		if unsigned {
+			return "INTEGER"
+		}
+		return "SMALLINT"
+	case "MEDIUMINT", "INT", "INTEGER":
+		if unsigned {
+			return "BIGINT"
+		}
+		return "INTEGER"
+	case "BIGINT":
+		if unsigned {
+			return "NUMERIC(20,0)"
+		}
+		return "BIGINT"
+	case "DECIMAL", "NUMERIC":
+		return "NUMERIC" + strings.ReplaceAll(size, " ", "")
+	case "FLOAT":
+		return "REAL"
+	case "DOUBLE":
+		return "DOUBLE PRECISION"
+	case "TINYTEXT", "TEXT", "MEDIUMTEXT", "LONGTEXT":
+		return "TEXT"
+	case "DATETIME", "TIMESTAMP":
+		return "TIMESTAMP"
+	case "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY":
+		return "BYTEA"
+	case "JSON":
+		return "JSONB"
+	}
+	return column.Type
+}
+
+func (d postgresDialect) DefaultValue(column *ColumnSchema) *string {
+	if column.Default == nil || d.ColumnType(nil, column) != "BOOLEAN" {
+		return column.Default
+	}
+	value := "FALSE"
+	if b, err := strconv.ParseBool(strings.Trim(*column.Default, "'")); err == nil && b {
+		value = "TRUE"
+	}
+	return &value
+}
+
+func (d postgresDialect) ColumnSQL(table *TableSchema, column *ColumnSchema) string {
+	columnSQL := columnSQL(d, table, column, "GENERATED BY DEFAULT AS IDENTITY")
+	if len(column.Enum) != 0 {
+		_, check := enumSQL(column)
+		columnSQL += " " + check
+	}
+	return columnSQL
+}
+
+func (d postgresDialect) CreateTableSQL(table *TableSchema) []string {
+	return createTableSQL(d, table, false, false, "")
+}
+
+func (d postgresDialect) AddColumnSQL(table *TableSchema, column *ColumnSchema) string {
+	return "ALTER TABLE " + table.Name + " ADD COLUMN " + d.ColumnSQL(table, column)
+}
+
+func (d postgresDialect) ModifyColumnSQL(table *TableSchema, column *ColumnSchema) []string {
+	columnType := d.ColumnType(table, column)
+	alter := "ALTER TABLE " + table.Name + " ALTER COLUMN " + column.Name + " TYPE " + columnType +
+		" USING " + column.Name + "::" + columnType
+	if column.NotNull {
+		alter += ", ALTER COLUMN " + column.Name + " SET NOT NULL"
+	} else {
+		alter += ", ALTER COLUMN " + column.Name + " DROP NOT NULL"
+	}
+	if value := d.DefaultValue(column); value != nil {
+		alter += ", ALTER COLUMN " + column.Name + " SET DEFAULT " + *value
+	} else if !column.AutoIncrement {
+		alter += ", ALTER COLUMN " + column.Name + " DROP DEFAULT"
+	}
+	return []string{alter}
+}
+
+func (postgresDialect) AddConstraintSQL(table string, definition string) string {
+	return "ALTER TABLE " + table + " ADD " + definition
+}
+
+func (postgresDialect) CreateIndexSQL(table string, index IndexSchema) string {
+	switch {
+	case index.Unique:
+		return "ALTER TABLE " + table + " ADD CONSTRAINT " + index.Name + " UNIQUE(" + strings.Join(index.Columns, ", ") + ")"
+	case index.Fulltext:
+		documents := make([]string, len(index.Columns))
+		for i, column := range index.Columns {
+			documents[i] = "COALESCE(" + column + ", '')"
+		}
+		return "CREATE INDEX IF NOT EXISTS " + index.Name + " ON " + table +
+			" USING GIN (to_tsvector('simple', " + strings.Join(documents, " || ' ' || ") + "))"
+	default:
+		return "CREATE INDEX IF NOT EXISTS " + index.Name + " ON " + table + " (" + strings.Join(index.Columns, ", ") + ")"
+	}
+}
+
+func (postgresDialect) DropIndexSQL(table string, index IndexSchema) string {
+	if index.Unique {
+		return "ALTER TABLE " + table + " DROP CONSTRAINT " + index.Name
+	}
+	return "DROP INDEX " + index.Name
+}
+
+func (postgresDialect) Fulltext() bool {
+	return true
+}
+
+func (postgresDialect) DescribeTable(executor SQLExecutor, name string) (*TableSchema, error) {
+	return describePostgresTable(executor, name)
+}
+
+func (postgresDialect) LockForUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (postgresDialect) LockForShare() string {
+	return " FOR SHARE"
+}
+
+func (postgresDialect) Like() string {
+	return "ILIKE"
+}
+
+func (postgresDialect) UpsertSQL(table string, columns []string, keys []string) string {
+	return upsertSQL(table, columns, keys, "ON CONFLICT ("+strings.Join(keys, ", ")+") DO UPDATE SET", func(column string) string {
+		return "excluded." + column
+	})
+}
+
+func (postgresDialect) ConstraintError(err error) error {
+	var postgresError *pq.Error
+	if !errors.As(err, &postgresError) {
+		return err
+	}
+	switch postgresError.Code {
+	case "23505":
+		return WrapError(ErrDuplicate, err)
+	case "23503":
+		if strings.Contains(postgresError.Detail, "is still referenced") {
+			return WrapError(ErrConflict, err)
+		}
+		return WrapError(ErrNotFound, err)
+	case "23514":
+		return WrapError(ErrValidation, err)
+	}
+	return err
+}
+
+// InsertReturningID uses RETURNING, the PostgreSQL driver does not implement LastInsertId
+func (postgresDialect) InsertReturningID(executor SQLExecutor, query string, idColumn string, args ...any) (int64, error) {
+	var id int64
+	err := queryRow(executor, query+" RETURNING "+idColumn, args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) SnapshotSQL() string {
+	return "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"
+}
+
+// SyncSequenceSQL sets the identity sequence, which explicit values leave behind
+func (postgresDialect) SyncSequenceSQL(table string, column string) string {
+	return "SELECT setval(pg_get_serial_sequence('" + table + "', '" + column + "'), COALESCE(MAX(" + column + "), 0) + 1, false) " +
+		"FROM " + table
+}
+
+func describePostgresTable(executor SQLExecutor, name string) (*TableSchema, error) {
+	querySQL := "SELECT column_name, data_type, character_maximum_length, numeric_precision, numeric_scale, " +
+		"is_nullable, column_default, is_identity FROM information_schema.columns " +
+		"WHERE table_schema = current_schema() AND table_name = ? ORDER BY ordinal_position"
+
+	rows, err := executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := TableSchema{Name: name}
+	for rows.Next() {
+		var (
+			column                       ColumnSchema
+			dataType, nullable, identity string
+			length, precision, scale     sql.NullInt64
+			defaultValue                 sql.NullString
+		)
+		err = rows.Scan(&column.Name, &dataType, &length, &precision, &scale, &nullable, &defaultValue, &identity)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		column.Type = postgresColumnType(dataType, length, precision, scale)
+		column.NotNull = nullable == "NO"
+		column.AutoIncrement = identity == "YES"
+		if defaultValue.Valid {
+			column.Default = &defaultValue.String
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+	rows.Close()
+	if len(schema.Columns) == 0 {
+		return nil, nil
+	}
+
+	querySQL = "SELECT k.column_name FROM information_schema.table_constraints t " +
+		"JOIN information_schema.key_column_usage k ON k.constraint_schema = t.constraint_schema AND k.constraint_name = t.constraint_name " +
+		"WHERE t.table_schema = current_schema() AND t.table_name = ? AND t.constraint_type = 'PRIMARY KEY' ORDER BY k.ordinal_position"
+
+	rows, err = executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var columnName string
+		err = rows.Scan(&columnName)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schema.PrimaryKey = append(schema.PrimaryKey, columnName)
+	}
+	rows.Close()
+
+	querySQL = "SELECT i.indexname, i.indexdef FROM pg_indexes i WHERE i.schemaname = current_schema() AND i.tablename = ? " +
+		"AND NOT EXISTS (SELECT 1 FROM information_schema.table_constraints t WHERE t.table_schema = i.schemaname " +
+		"AND t.constraint_name = i.indexname AND t.constraint_type = 'PRIMARY KEY') ORDER BY i.indexname"
+
+	rows, err = executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			index      IndexSchema
+			definition string
+		)
+		err = rows.Scan(&index.Name, &definition)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index.Unique = strings.HasPrefix(definition, "CREATE UNIQUE")
+		index.Fulltext = strings.Contains(definition, "USING gin")
+		if index.Fulltext {
+			for _, match := range fulltextColumnPattern.FindAllStringSubmatch(definition, -1) {
+				index.Columns = append(index.Columns, match[1])
+			}
+		} else if match := indexColumnsPattern.FindStringSubmatch(definition); match != nil {
+			for _, column := range strings.Split(match[1], ",") {
+				index.Columns = append(index.Columns, strings.Trim(strings.TrimSpace(column), "\""))
+			}
+		}
+		schema.Indexes = append(schema.Indexes, index)
+	}
+	rows.Close()
+
+	querySQL = "SELECT k.column_name, u.table_name, u.column_name, r.update_rule, r.delete_rule " +
+		"FROM information_schema.referential_constraints r " +
+		"JOIN information_schema.key_column_usage k ON k.constraint_schema = r.constraint_schema AND k.constraint_name = r.constraint_name " +
+		"JOIN information_schema.constraint_column_usage u ON u.constraint_schema = r.unique_constraint_schema " +
+		"AND u.constraint_name = r.unique_constraint_name " +
+		"WHERE k.table_schema = current_schema() AND k.table_name = ?"
+
+	rows, err = executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var foreignKey ForeignKeySchema
+		err = rows.Scan(&foreignKey.Column, &foreignKey.RefTable, &foreignKey.RefColumn, &foreignKey.OnUpdate, &foreignKey.OnDelete)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schema.ForeignKeys = append(schema.ForeignKeys, foreignKey)
+	}
+	rows.Close()
+
+	querySQL = "SELECT constraint_name FROM information_schema.table_constraints " +
+		"WHERE table_schema = current_schema() AND table_name = ? AND constraint_type = 'CHECK'"
+
+	rows, err = executor.Query(querySQL, name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var check CheckSchema
+		err = rows.Scan(&check.Name)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schema.Checks = append(schema.Checks, check)
+	}
+	rows.Close()
+
+	return &schema, nil
+}
+
+var (
+	indexColumnsPattern   = regexp.MustCompile(`\(([^()]*)\)$`)
+	fulltextColumnPattern = regexp.MustCompile(`(?i)COALESCE\(\(?"?(\w+)"?`)
+)
+
+// postgresColumnType spells a type reported by information_schema the way ColumnType does
+func postgresColumnType(dataType string, length, precision, scale sql.NullInt64) string {
+	switch dataType {
+	case "character varying":
+		if length.Valid {
+			return "VARCHAR(" + strconv.FormatInt(length.Int64, 10) + ")"
+		}
+		return "VARCHAR"
+	case "character":
+		return "CHAR(" + strconv.FormatInt(length.Int64, 10) + ")"
+	case "numeric":
+		if precision.Valid {
+			return "NUMERIC(" + strconv.FormatInt(precision.Int64, 10) + "," + strconv.FormatInt(scale.Int64, 10) + ")"
+		}
+		return "NUMERIC"
+	case "timestamp without time zone":
+		return "TIMESTAMP"
+	}
+	return strings.ToUpper(dataType)
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResetOptions selects what a reset clears and the fixture set it loads after, all the tables but the ones kept on
+// reset if it selects none
+type ResetOptions struct {
+	Tables  []string `json:"tables"`
+	Fixture string   `json:"fixture,omitempty"`
+}
+
+type ResetRequest struct {
+	Tables  []string `json:"tables,omitempty"`
+	Fixture *string  `json:"fixture,omitempty"`
+	// Confirm is the token of the confirmation of the reset
+	Confirm *string `json:"confirm,omitempty"`
+}
+
+// ResetConfirmation is a reset an admin asked for, done only if they send its token back before ExpireTime
+type ResetConfirmation struct {
+	Token      string `json:"token"`
+	ExpireTime int64  `json:"expire_time"`
+	// Tables are all the tables the reset clears, the ones referencing the tables selected included
+	Tables  []string `json:"tables"`
+	Fixture string   `json:"fixture,omitempty"`
+}
+
+type ResetResult struct {
+	Tables  []string `json:"tables"`
+	Fixture string   `json:"fixture,omitempty"`
+	// Books, Cards and Loans are the numbers of rows the fixture loaded
+	Books int `json:"books"`
+	Cards int `json:"cards"`
+	Loans int `json:"loans"`
+}
+
+// ResolveResetTables returns the tables a reset of tables clears in the order of Tables, the tables referencing them
+// included since their rows would be left dangling. All the tables but the ones kept on reset if tables is empty.
+func ResolveResetTables(tables []string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, name := range tables {
+		known := false
+		for _, table := range Tables {
+			if TableName(table) == name && !keptOnReset[name] {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, WrapError(ErrValidation, fmt.Errorf("%w: %s", ErrUnknownTable, name))
+		}
+		selected[name] = true
+	}
+
+	resolved := make([]string, 0)
+	for _, table := range Tables {
+		schema, err := ParseTable(table)
+		if err != nil {
+			return nil, err
+		}
+		if keptOnReset[schema.Name] {
+			continue
+		}
+		// Tables lists the tables referenced before the ones referencing them, one pass finds them all
+		for _, foreignKey := range schema.ForeignKeys {
+			if selected[foreignKey.RefTable] {
+				selected[schema.Name] = true
+			}
+		}
+		if len(tables) == 0 || selected[schema.Name] {
+			resolved = append(resolved, schema.Name)
+		}
+	}
+	return resolved, nil
+}
+
+// NewResetConfirmation returns the confirmation of a reset clearing tables, lasting as long as the reset policy says
+func NewResetConfirmation(tables []string, fixture string) (*ResetConfirmation, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	return &ResetConfirmation{
+		Token:      token,
+		ExpireTime: time.Now().Add(CurrentResetPolicy().ConfirmTTL).Unix(),
+		Tables:     tables,
+		Fixture:    fixture,
+	}, nil
+}
+
+// resetCopies frees the copies of the loans and holds a partial reset cleared, and sets the stock of the books it kept
+// to the number of their available copies
+func resetCopies(executor SQLExecutor, cleared map[string]bool) error {
+	copies, books := TableName(BookCopy{}), TableName(Book{})
+	if !cleared[copies] {
+		released := map[string]CopyStatus{TableName(Borrow{}): CopyOnLoan, TableName(Hold{}): CopyOnHold}
+		for table, status := range released {
+			if !cleared[table] {
+				continue
+			}
+			_, err := executor.Exec("UPDATE book_copy SET status = ? WHERE status = ?", CopyAvailable, status)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if cleared[books] {
+		return nil
+	}
+	_, err := executor.Exec("UPDATE book SET stock = (SELECT COUNT(*) FROM book_copy WHERE book_copy.book_id = book.book_id AND status = ?)",
+		CopyAvailable)
+	return err
+}
+
+// clearTables deletes the rows of the tables and of the ones referencing them, the tables and their auto increments
+// are kept
+func (c *DatabaseConnector) clearTables(tables []string) error {
+	resolved, err := ResolveResetTables(tables)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	cleared := make(map[string]bool)
+	for i := len(resolved) - 1; i >= 0; i-- {
+		_, err = tx.Exec("DELETE FROM " + resolved[i])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		cleared[resolved[i]] = true
+	}
+	err = resetCopies(tx, cleared)
+	if err == nil {
+		err = c.writeAudit(tx, "reset", AuditDatabase, "", nil, &ResetOptions{Tables: resolved})
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
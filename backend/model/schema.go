@@ -0,0 +1,414 @@
+package model
+
+import (
+	"LibManSys/utils"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ColumnSchema struct {
+	Name          string
+	Type          string
+	NotNull       bool
+	AutoIncrement bool
+	Unique        bool
+	Default       *string
+	Enum          []string
+}
+
+type IndexSchema struct {
+	Name     string
+	Columns  []string
+	Unique   bool
+	Fulltext bool
+}
+
+type ForeignKeySchema struct {
+	Column    string
+	RefTable  string
+	RefColumn string
+	OnUpdate  string
+	OnDelete  string
+}
+
+type CheckSchema struct {
+	Name   string
+	Clause string
+}
+
+type TableSchema struct {
+	Name        string
+	Columns     []ColumnSchema
+	PrimaryKey  []string
+	Indexes     []IndexSchema
+	Checks      []CheckSchema
+	ForeignKeys []ForeignKeySchema
+}
+
+// Tables lists the models stored in the database, in the order their tables are created
+var Tables = []any{
+	Book{},
+	Card{},
+	Borrow{},
+	FineEntry{},
+	Hold{},
+	BookCopy{},
+	Author{},
+	BookAuthor{},
+	User{},
+	UserSession{},
+	UserBootstrap{},
+	CardCredential{},
+	PatronSession{},
+	AuditEntry{},
+}
+
+type tableNamer interface {
+	TableName() string
+}
+
+func TableName(table any) string {
+	if namer, ok := table.(tableNamer); ok {
+		return namer.TableName()
+	}
+	return utils.ToSnake(reflect.TypeOf(table).Name())
+}
+
+type tag struct {
+	key   string
+	value string
+}
+
+func parseTags(field reflect.StructField) []tag {
+	tags := make([]tag, 0)
+	for _, t := range strings.Split(field.Tag.Get("sql"), ";") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(t, ":")
+		tags = append(tags, tag{key: key, value: value})
+	}
+	return tags
+}
+
+// ParseTable builds the schema of a table from the sql tags of a struct. Fields tagged sql:"-" are skipped.
+// All invalid tags are reported together in the returned error.
+func ParseTable(table any) (*TableSchema, error) {
+	tableType := reflect.TypeOf(table)
+	if tableType.Kind() != reflect.Struct {
+		return nil, errors.New("table must be a struct")
+	}
+
+	schema := TableSchema{Name: TableName(table)}
+	var errs []error
+	fail := func(field reflect.StructField, format string, args ...any) {
+		errs = append(errs, fmt.Errorf(tableType.Name()+"."+field.Name+": "+format, args...))
+	}
+
+	for i := 0; i < tableType.NumField(); i++ {
+		field := tableType.Field(i)
+		if field.Tag.Get("sql") == "-" || !field.IsExported() {
+			continue
+		}
+
+		column := ColumnSchema{Name: utils.ToSnake(field.Name)}
+		tags := parseTags(field)
+
+		columnType, enum, err := getType(field, tags)
+		if err != nil {
+			fail(field, "%v", err)
+		}
+		column.Type = columnType
+		column.Enum = enum
+
+		for _, t := range tags {
+			switch t.key {
+			case "not null":
+				column.NotNull = true
+			case "autoIncrement":
+				column.AutoIncrement = true
+			case "primaryKey":
+				schema.PrimaryKey = append(schema.PrimaryKey, column.Name)
+			case "default":
+				value := t.value
+				column.Default = &value
+			case "check":
+				if t.value == "" {
+					fail(field, "empty check")
+					continue
+				}
+				schema.Checks = append(schema.Checks, CheckSchema{
+					// the name MySQL generates for an unnamed check constraint
+					Name:   schema.Name + "_chk_" + strconv.Itoa(len(schema.Checks)+1),
+					Clause: t.value,
+				})
+			case "unique":
+				if t.value == "" {
+					column.Unique = true
+					continue
+				}
+				if err := schema.addIndex(t.value, column.Name, true, false); err != nil {
+					fail(field, "%v", err)
+				}
+			case "index":
+				name, options, _ := strings.Cut(t.value, ",")
+				if name == "" {
+					name = "idx_" + schema.Name + "_" + column.Name
+				}
+				var unique, fulltext bool
+				for _, option := range strings.Split(options, ",") {
+					switch option {
+					case "":
+					case "unique":
+						unique = true
+					case "fulltext":
+						fulltext = true
+					default:
+						fail(field, "unknown index option %q", option)
+					}
+				}
+				if unique && fulltext {
+					fail(field, "index %s cannot be both unique and fulltext", name)
+					continue
+				}
+				if err := schema.addIndex(name, column.Name, unique, fulltext); err != nil {
+					fail(field, "%v", err)
+				}
+			case "constraint":
+				constraints := strings.Split(t.value, ",")
+				references := strings.Split(constraints[0], ".")
+				if len(references) != 2 || references[0] == "" || references[1] == "" {
+					fail(field, "invalid reference %q", constraints[0])
+					continue
+				}
+				foreignKey := ForeignKeySchema{
+					Column:    column.Name,
+					RefTable:  utils.ToSnake(references[0]),
+					RefColumn: utils.ToSnake(references[1]),
+				}
+				for _, val := range constraints[1:] {
+					if strings.HasPrefix(val, "OnUpdate:") {
+						foreignKey.OnUpdate = strings.TrimPrefix(val, "OnUpdate:")
+					} else if strings.HasPrefix(val, "OnDelete:") {
+						foreignKey.OnDelete = strings.TrimPrefix(val, "OnDelete:")
+					} else {
+						fail(field, "unknown constraint option %q", val)
+					}
+				}
+				schema.ForeignKeys = append(schema.ForeignKeys, foreignKey)
+			case "size", "char", "decimal", "type", "json", "enum":
+				// column type options, handled by getType
+			default:
+				fail(field, "unknown tag %q", t.key)
+			}
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &schema, nil
+}
+
+func (t *TableSchema) addIndex(name string, column string, unique bool, fulltext bool) error {
+	index := t.index(name)
+	if index == nil {
+		t.Indexes = append(t.Indexes, IndexSchema{Name: name, Unique: unique, Fulltext: fulltext})
+		index = &t.Indexes[len(t.Indexes)-1]
+	} else if index.Fulltext != fulltext || (fulltext && unique) {
+		return errors.New("index " + name + " is declared with different kinds")
+	}
+	index.Unique = index.Unique || unique
+	index.Columns = append(index.Columns, column)
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// getType maps a struct field to its column type, enum columns also return their values
+func getType(field reflect.StructField, tags []tag) (string, []string, error) {
+	options := make(map[string]string)
+	for _, t := range tags {
+		options[t.key] = t.value
+	}
+
+	if columnType, ok := options["type"]; ok {
+		if columnType == "" {
+			return "", nil, errors.New("empty type")
+		}
+		return strings.ToUpper(columnType), nil, nil
+	}
+
+	fieldType := field.Type
+	if fieldType.Kind() == reflect.Pointer {
+		fieldType = fieldType.Elem()
+	}
+
+	if _, ok := options["json"]; ok {
+		return "JSON", nil, nil
+	}
+
+	if fieldType == timeType {
+		return "DATETIME", nil, nil
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		if values, ok := options["enum"]; ok {
+			enum := strings.Split(values, ",")
+			for _, value := range enum {
+				if value == "" || strings.ContainsAny(value, "'\\") {
+					return "", nil, fmt.Errorf("invalid enum value %q", value)
+				}
+			}
+			return "ENUM('" + strings.Join(enum, "', '") + "')", enum, nil
+		}
+		if value, ok := options["char"]; ok {
+			size, err := strconv.Atoi(value)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid char size %q", value)
+			}
+			if size <= 0 || size > 255 {
+				return "", nil, errors.New("char size must be between 1 and 255")
+			}
+			return "CHAR(" + strconv.Itoa(size) + ")", nil, nil
+		}
+		if value, ok := options["size"]; ok {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid size %q", value)
+			}
+			switch {
+			case size <= 0:
+				return "", nil, errors.New("size must be greater than 0")
+			case size <= 255:
+				return "VARCHAR(" + strconv.FormatInt(size, 10) + ")", nil, nil
+			case size <= 65535:
+				return "TEXT", nil, nil
+			case size <= 16777215:
+				return "MEDIUMTEXT", nil, nil
+			case size <= 4294967295:
+				return "LONGTEXT", nil, nil
+			default:
+				return "", nil, errors.New("size must be less than 4294967295")
+			}
+		}
+		return "VARCHAR(255)", nil, nil
+	case reflect.Bool:
+		return "TINYINT(1)", nil, nil
+	case reflect.Int8:
+		return "TINYINT", nil, nil
+	case reflect.Uint8:
+		return "CHAR(1)", nil, nil
+	case reflect.Int16:
+		return "SMALLINT", nil, nil
+	case reflect.Uint16:
+		return "SMALLINT UNSIGNED", nil, nil
+	case reflect.Int, reflect.Int32:
+		return "INT", nil, nil
+	case reflect.Uint, reflect.Uint32:
+		return "INT UNSIGNED", nil, nil
+	case reflect.Int64:
+		return "BIGINT", nil, nil
+	case reflect.Uint64:
+		return "BIGINT UNSIGNED", nil, nil
+	case reflect.Float32, reflect.Float64:
+		if value, ok := options["decimal"]; ok {
+			nums := strings.Split(value, ",")
+			if len(nums) != 2 {
+				return "", nil, fmt.Errorf("invalid decimal %q", value)
+			}
+			precision, err := strconv.Atoi(nums[0])
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid decimal precision %q", nums[0])
+			}
+			scale, err := strconv.Atoi(nums[1])
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid decimal scale %q", nums[1])
+			}
+			if precision <= 0 || precision > 65 {
+				return "", nil, errors.New("precision must be between 1 and 65")
+			}
+			if scale < 0 || scale > precision {
+				return "", nil, errors.New("scale must be between 0 and the precision")
+			}
+			return "DECIMAL(" + strconv.Itoa(precision) + "," + strconv.Itoa(scale) + ")", nil, nil
+		}
+		if fieldType.Kind() == reflect.Float32 {
+			return "FLOAT", nil, nil
+		}
+		return "DOUBLE", nil, nil
+	case reflect.Slice:
+		if fieldType.Elem().Kind() != reflect.Uint8 {
+			break
+		}
+		value, ok := options["size"]
+		if !ok {
+			return "BLOB", nil, nil
+		}
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid size %q", value)
+		}
+		switch {
+		case size <= 0:
+			return "", nil, errors.New("size must be greater than 0")
+		case size <= 255:
+			return "VARBINARY(" + strconv.FormatInt(size, 10) + ")", nil, nil
+		case size <= 65535:
+			return "BLOB", nil, nil
+		case size <= 16777215:
+			return "MEDIUMBLOB", nil, nil
+		case size <= 4294967295:
+			return "LONGBLOB", nil, nil
+		default:
+			return "", nil, errors.New("size must be less than 4294967295")
+		}
+	}
+	return "", nil, fmt.Errorf("unsupported type %s, tag it with json or type:", field.Type)
+}
+
+func (t *TableSchema) Column(name string) *ColumnSchema {
+	for i := range t.Columns {
+		if t.Columns[i].Name == name {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+func (t *TableSchema) index(name string) *IndexSchema {
+	for i := range t.Indexes {
+		if t.Indexes[i].Name == name {
+			return &t.Indexes[i]
+		}
+	}
+	return nil
+}
+
+// rowID reports whether the column is the single auto increment primary key of the table
+func (t *TableSchema) rowID(column *ColumnSchema) bool {
+	return column.AutoIncrement && len(t.PrimaryKey) == 1 && t.PrimaryKey[0] == column.Name
+}
+
+func (k *ForeignKeySchema) sql() string {
+	foreignKey := "FOREIGN KEY (" + k.Column + ") REFERENCES " + k.RefTable + "(" + k.RefColumn + ")"
+	if k.OnUpdate != "" {
+		foreignKey += " ON UPDATE " + k.OnUpdate
+	}
+	if k.OnDelete != "" {
+		foreignKey += " ON DELETE " + k.OnDelete
+	}
+	return foreignKey
+}
+
+// CreateSQL returns the statements creating the table and its indexes if they do not exist
+func (t *TableSchema) CreateSQL(dialect Dialect) []string {
+	return dialect.CreateTableSQL(t)
+}
@@ -0,0 +1,454 @@
+package model
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+type SearchField string
+
+const (
+	SearchFieldTitle    SearchField = "title"
+	SearchFieldAuthor   SearchField = "author"
+	SearchFieldCategory SearchField = "category"
+	SearchFieldPress    SearchField = "press"
+)
+
+// searchFields are the fields of a book the index searches, a match in a field counts its weight times
+var searchFields = []struct {
+	name   SearchField
+	weight float64
+	text   func(book *Book) string
+}{
+	{SearchFieldTitle, 3, func(book *Book) string { return book.Title }},
+	{SearchFieldAuthor, 2, bookAuthorText},
+	{SearchFieldCategory, 1, func(book *Book) string { return book.Category }},
+	{SearchFieldPress, 1, func(book *Book) string { return book.Press }},
+}
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+
+	// bm25K1 and bm25B tune how fast repeated matches saturate and how much longer fields are penalized
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// searchFragmentSize is the number of runes of a field kept around its first match when highlighting it
+	searchFragmentSize = 80
+)
+
+type SearchHit struct {
+	Book  Book    `json:"book"`
+	Score float64 `json:"score"`
+	// Highlights are the matched fields, with the matched words wrapped in <em></em> and the rest HTML escaped
+	Highlights map[SearchField]string `json:"highlights"`
+}
+
+type SearchResult struct {
+	// Count is the number of matching books, Hits only holds the best ranked of them
+	Count int         `json:"count"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+// SearchMatch is a book matching a query, as ranked by the index
+type SearchMatch struct {
+	BookID     int
+	Score      float64
+	Highlights map[SearchField]string
+}
+
+// SearchClause is a word or a quoted phrase of a query, its last word matches any word it prefixes if Prefix is set
+type SearchClause struct {
+	Terms  []string
+	Prefix bool
+}
+
+// SearchQuery is a parsed query, a book matches it if it matches all of its clauses in any of its fields
+type SearchQuery struct {
+	Clauses []SearchClause
+}
+
+type searchToken struct {
+	term       string
+	start, end int
+}
+
+// searchTokens splits a text into lower case words of letters and digits, each CJK character is a word by itself
+func searchTokens(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text {
+		switch {
+		case isIdeograph(r):
+			if start >= 0 {
+				tokens = append(tokens, searchToken{strings.ToLower(text[start:i]), start, i})
+				start = -1
+			}
+			end := i + utf8.RuneLen(r)
+			tokens = append(tokens, searchToken{text[i:end], i, end})
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			if start >= 0 {
+				tokens = append(tokens, searchToken{strings.ToLower(text[start:i]), start, i})
+				start = -1
+			}
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// ParseSearchQuery parses a free text query: words match in any order, "quoted words" must match as a phrase and
+// a word or phrase ending with * matches the words its last word prefixes
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	var parsed SearchQuery
+	addClause := func(text string, prefix bool) {
+		tokens := searchTokens(text)
+		if len(tokens) == 0 {
+			return
+		}
+		clause := SearchClause{Prefix: prefix}
+		for _, token := range tokens {
+			clause.Terms = append(clause.Terms, token.term)
+		}
+		parsed.Clauses = append(parsed.Clauses, clause)
+	}
+
+	for rest := strings.TrimSpace(query); rest != ""; rest = strings.TrimSpace(rest) {
+		var text string
+		if strings.HasPrefix(rest, `"`) {
+			phrase, after, found := strings.Cut(rest[1:], `"`)
+			if !found {
+				// an unclosed quote quotes the rest of the query
+				phrase, after = rest[1:], ""
+			}
+			prefix := strings.HasSuffix(strings.TrimSpace(phrase), "*") || strings.HasPrefix(after, "*")
+			addClause(phrase, prefix)
+			rest = strings.TrimPrefix(after, "*")
+			continue
+		}
+		if i := strings.IndexAny(rest, " \t\r\n\"　"); i >= 0 {
+			text, rest = rest[:i], rest[i:]
+		} else {
+			text, rest = rest, ""
+		}
+		addClause(text, strings.HasSuffix(text, "*"))
+	}
+
+	if len(parsed.Clauses) == 0 {
+		return nil, ErrEmptySearchQuery
+	}
+	return &parsed, nil
+}
+
+type searchPosting struct {
+	field    SearchField
+	position int
+}
+
+type searchDocument struct {
+	fields  map[SearchField]string
+	lengths map[SearchField]int
+}
+
+// BookIndex is an inverted index of the searched fields of the books
+type BookIndex struct {
+	mu       sync.RWMutex
+	docs     map[int]*searchDocument
+	postings map[string]map[int][]searchPosting
+	// lengths are the numbers of words of each field over all the books
+	lengths map[SearchField]int
+}
+
+func NewBookIndex() *BookIndex {
+	return &BookIndex{
+		docs:     make(map[int]*searchDocument),
+		postings: make(map[string]map[int][]searchPosting),
+		lengths:  make(map[SearchField]int),
+	}
+}
+
+// bookAuthorText is the author of a book followed by the contributors it does not name, like its translators
+func bookAuthorText(book *Book) string {
+	text := book.Author
+	for _, contributor := range book.Contributors {
+		if !containsFold(text, contributor.Name) {
+			text += ", " + contributor.Name
+		}
+	}
+	return text
+}
+
+// Reset replaces the books of the index
+func (x *BookIndex) Reset(books []Book) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.docs = make(map[int]*searchDocument)
+	x.postings = make(map[string]map[int][]searchPosting)
+	x.lengths = make(map[SearchField]int)
+	for i := range books {
+		x.add(&books[i])
+	}
+}
+
+// Index adds the books to the index, replacing the ones already indexed
+func (x *BookIndex) Index(books ...Book) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for i := range books {
+		x.remove(books[i].BookID)
+		x.add(&books[i])
+	}
+}
+
+func (x *BookIndex) Remove(bookIds ...int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, bookId := range bookIds {
+		x.remove(bookId)
+	}
+}
+
+func (x *BookIndex) add(book *Book) {
+	doc := &searchDocument{fields: make(map[SearchField]string), lengths: make(map[SearchField]int)}
+	for _, field := range searchFields {
+		text := field.text(book)
+		tokens := searchTokens(text)
+		doc.fields[field.name] = text
+		doc.lengths[field.name] = len(tokens)
+		x.lengths[field.name] += len(tokens)
+		for position, token := range tokens {
+			if x.postings[token.term] == nil {
+				x.postings[token.term] = make(map[int][]searchPosting)
+			}
+			x.postings[token.term][book.BookID] = append(x.postings[token.term][book.BookID], searchPosting{field.name, position})
+		}
+	}
+	x.docs[book.BookID] = doc
+}
+
+func (x *BookIndex) remove(bookId int) {
+	doc, ok := x.docs[bookId]
+	if !ok {
+		return
+	}
+	for _, text := range doc.fields {
+		for _, token := range searchTokens(text) {
+			delete(x.postings[token.term], bookId)
+			if len(x.postings[token.term]) == 0 {
+				delete(x.postings, token.term)
+			}
+		}
+	}
+	for field, length := range doc.lengths {
+		x.lengths[field] -= length
+	}
+	delete(x.docs, bookId)
+}
+
+// clauseTerms returns the words of the index each word of a clause matches
+func (x *BookIndex) clauseTerms(clause SearchClause) [][]string {
+	terms := make([][]string, len(clause.Terms))
+	for i, term := range clause.Terms {
+		if !clause.Prefix || i < len(clause.Terms)-1 {
+			terms[i] = []string{term}
+			continue
+		}
+		for indexed := range x.postings {
+			if strings.HasPrefix(indexed, term) {
+				terms[i] = append(terms[i], indexed)
+			}
+		}
+	}
+	return terms
+}
+
+// clauseMatches returns the positions of the first word of each occurrence of a clause in a book, by field
+func (x *BookIndex) clauseMatches(terms [][]string, bookId int) map[SearchField][]int {
+	at := func(i int, field SearchField, position int) bool {
+		for _, term := range terms[i] {
+			for _, posting := range x.postings[term][bookId] {
+				if posting.field == field && posting.position == position {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	var matches map[SearchField][]int
+	for _, term := range terms[0] {
+		for _, posting := range x.postings[term][bookId] {
+			matched := true
+			for i := 1; i < len(terms) && matched; i++ {
+				matched = at(i, posting.field, posting.position+i)
+			}
+			if matched {
+				if matches == nil {
+					matches = make(map[SearchField][]int)
+				}
+				matches[posting.field] = append(matches[posting.field], posting.position)
+			}
+		}
+	}
+	return matches
+}
+
+// Search returns the books matching a query, best ranked first, at most limit of them and their count
+func (x *BookIndex) Search(query *SearchQuery, limit int) ([]SearchMatch, int) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	scores := make(map[int]float64)
+	spans := make(map[int]map[SearchField][][2]int)
+	for i, clause := range query.Clauses {
+		terms := x.clauseTerms(clause)
+		matched := make(map[int]map[SearchField][]int)
+		for _, term := range terms[0] {
+			for bookId := range x.postings[term] {
+				if matched[bookId] != nil {
+					continue
+				}
+				if matches := x.clauseMatches(terms, bookId); matches != nil {
+					matched[bookId] = matches
+				}
+			}
+		}
+		// a book missing a clause is out of the results
+		for bookId := range scores {
+			if matched[bookId] == nil {
+				delete(scores, bookId)
+			}
+		}
+
+		idf := math.Log(1 + (float64(len(x.docs))-float64(len(matched))+0.5)/(float64(len(matched))+0.5))
+		for bookId, matches := range matched {
+			if _, ok := scores[bookId]; !ok && i > 0 {
+				continue
+			}
+			doc := x.docs[bookId]
+			score := 0.0
+			for _, field := range searchFields {
+				frequency := float64(len(matches[field.name]))
+				if frequency == 0 {
+					continue
+				}
+				average := float64(x.lengths[field.name]) / float64(len(x.docs))
+				norm := 1 - bm25B + bm25B*float64(doc.lengths[field.name])/average
+				score += field.weight * idf * frequency * (bm25K1 + 1) / (frequency + bm25K1*norm)
+
+				if spans[bookId] == nil {
+					spans[bookId] = make(map[SearchField][][2]int)
+				}
+				for _, position := range matches[field.name] {
+					spans[bookId][field.name] = append(spans[bookId][field.name], [2]int{position, position + len(terms) - 1})
+				}
+			}
+			// the score stays positive to tell the books still matching from the others
+			scores[bookId] += math.Max(score, math.SmallestNonzeroFloat64)
+		}
+	}
+
+	matches := make([]SearchMatch, 0, len(scores))
+	for bookId, score := range scores {
+		matches = append(matches, SearchMatch{BookID: bookId, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].BookID < matches[j].BookID
+	})
+
+	count := len(matches)
+	if limit < len(matches) {
+		matches = matches[:limit]
+	}
+	for i := range matches {
+		match := &matches[i]
+		match.Highlights = make(map[SearchField]string)
+		for field, fieldSpans := range spans[match.BookID] {
+			match.Highlights[field] = highlight(x.docs[match.BookID].fields[field], fieldSpans)
+		}
+	}
+	return matches, count
+}
+
+// highlight wraps the words of a text between the positions of each span in <em></em>, keeping searchFragmentSize
+// runes around the first of them
+func highlight(text string, spans [][2]int) string {
+	tokens := searchTokens(text)
+	marked := make([]bool, len(tokens))
+	first := len(tokens)
+	for _, span := range spans {
+		for position := span[0]; position <= span[1] && position < len(tokens); position++ {
+			marked[position] = true
+		}
+		if span[0] < first {
+			first = span[0]
+		}
+	}
+
+	from, to := 0, len(text)
+	if utf8.RuneCountInString(text) > searchFragmentSize && first < len(tokens) {
+		from = tokens[first].start
+		for n := 0; n < searchFragmentSize/4 && from > 0; n++ {
+			_, size := utf8.DecodeLastRuneInString(text[:from])
+			from -= size
+		}
+		// the fragment starts at a word
+		for _, token := range tokens {
+			if token.start >= from {
+				from = token.start
+				break
+			}
+		}
+		to = from
+		for n := 0; n < searchFragmentSize && to < len(text); n++ {
+			_, size := utf8.DecodeRuneInString(text[to:])
+			to += size
+		}
+	}
+
+	var builder strings.Builder
+	if from > 0 {
+		builder.WriteString("…")
+	}
+	last := from
+	for i := 0; i < len(tokens); i++ {
+		if !marked[i] || tokens[i].start < from || tokens[i].end > to {
+			continue
+		}
+		// consecutive matched words are wrapped together
+		j := i
+		for j+1 < len(tokens) && marked[j+1] && tokens[j+1].end <= to {
+			j++
+		}
+		builder.WriteString(html.EscapeString(text[last:tokens[i].start]))
+		builder.WriteString("<em>" + html.EscapeString(text[tokens[i].start:tokens[j].end]) + "</em>")
+		last = tokens[j].end
+		i = j
+	}
+	builder.WriteString(html.EscapeString(text[last:to]))
+	if to < len(text) {
+		builder.WriteString("…")
+	}
+	return builder.String()
+}
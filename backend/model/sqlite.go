@@ -0,0 +1,268 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteDialect keeps the MySQL type names, SQLite maps them to its storage classes by affinity
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return DriverSQLite
+}
+
+func (sqliteDialect) Open(config *ConnectConfig) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", config.sqliteDataSource())
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, serialize every transaction on one connection
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func (config *ConnectConfig) sqliteDataSource() string {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_txlock", "immediate")
+	return "file:" + config.Path + "?" + params.Encode()
+}
+
+func (sqliteDialect) Placeholder(int) string {
+	return "?"
+}
+
+func (sqliteDialect) ColumnType(table *TableSchema, column *ColumnSchema) string {
+	if table.rowID(column) {
+		return "INTEGER"
+	}
+	if len(column.Enum) != 0 {
+		columnType, _ := enumSQL(column)
+		return columnType
+	}
+	return column.Type
+}
+
+func (sqliteDialect) DefaultValue(column *ColumnSchema) *string {
+	return column.Default
+}
+
+func (d sqliteDialect) ColumnSQL(table *TableSchema, column *ColumnSchema) string {
+	if table.rowID(column) {
+		// SQLite only auto increments a column declared as INTEGER PRIMARY KEY
+		return column.Name + " INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT"
+	}
+	columnSQL := columnSQL(d, table, column, "")
+	if len(column.Enum) != 0 {
+		_, check := enumSQL(column)
+		columnSQL += " " + check
+	}
+	return columnSQL
+}
+
+func (d sqliteDialect) CreateTableSQL(table *TableSchema) []string {
+	inlinePrimaryKey := false
+	for i := range table.Columns {
+		inlinePrimaryKey = inlinePrimaryKey || table.rowID(&table.Columns[i])
+	}
+	// SQLite cannot declare a plain index inside CREATE TABLE, and has no fulltext index
+	return createTableSQL(d, table, false, inlinePrimaryKey, "")
+}
+
+func (d sqliteDialect) AddColumnSQL(table *TableSchema, column *ColumnSchema) string {
+	if table.rowID(column) || column.Unique {
+		return ""
+	}
+	return "ALTER TABLE " + table.Name + " ADD COLUMN " + d.ColumnSQL(table, column)
+}
+
+func (sqliteDialect) ModifyColumnSQL(*TableSchema, *ColumnSchema) []string {
+	return nil
+}
+
+func (sqliteDialect) AddConstraintSQL(string, string) string {
+	return ""
+}
+
+func (sqliteDialect) CreateIndexSQL(table string, index IndexSchema) string {
+	columns := strings.Join(index.Columns, ", ")
+	if index.Unique {
+		return "CREATE UNIQUE INDEX IF NOT EXISTS " + index.Name + " ON " + table + " (" + columns + ")"
+	}
+	return "CREATE INDEX IF NOT EXISTS " + index.Name + " ON " + table + " (" + columns + ")"
+}
+
+func (sqliteDialect) DropIndexSQL(_ string, index IndexSchema) string {
+	return "DROP INDEX " + index.Name
+}
+
+func (sqliteDialect) Fulltext() bool {
+	return false
+}
+
+func (sqliteDialect) DescribeTable(executor SQLExecutor, name string) (*TableSchema, error) {
+	return describeSQLiteTable(executor, name)
+}
+
+// SQLite locks the whole database for the immediate transactions, there is no row-locking clause
+
+func (sqliteDialect) LockForUpdate() string {
+	return ""
+}
+
+func (sqliteDialect) LockForShare() string {
+	return ""
+}
+
+func (sqliteDialect) Like() string {
+	return "LIKE"
+}
+
+func (sqliteDialect) UpsertSQL(table string, columns []string, keys []string) string {
+	return upsertSQL(table, columns, keys, "ON CONFLICT ("+strings.Join(keys, ", ")+") DO UPDATE SET", func(column string) string {
+		return "excluded." + column
+	})
+}
+
+func (sqliteDialect) ConstraintError(err error) error {
+	var sqliteError *sqlite.Error
+	if !errors.As(err, &sqliteError) {
+		return err
+	}
+	switch sqliteError.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return WrapError(ErrDuplicate, err)
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		// the foreign keys cascade, only an insert or update referencing a missing row fails
+		return WrapError(ErrNotFound, err)
+	case sqlite3.SQLITE_CONSTRAINT_CHECK:
+		return WrapError(ErrValidation, err)
+	}
+	return err
+}
+
+func (sqliteDialect) InsertReturningID(executor SQLExecutor, query string, _ string, args ...any) (int64, error) {
+	return lastInsertID(executor, query, args...)
+}
+
+// SnapshotSQL is empty, a transaction keeps its read lock until it ends
+func (sqliteDialect) SnapshotSQL() string {
+	return ""
+}
+
+func (sqliteDialect) SyncSequenceSQL(string, string) string {
+	return ""
+}
+
+func describeSQLiteTable(executor SQLExecutor, name string) (*TableSchema, error) {
+	rows, err := executor.Query("SELECT name, type, \"notnull\", dflt_value, pk FROM pragma_table_info(?)", name)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := TableSchema{Name: name}
+	primaryKey := make(map[int]string)
+	for rows.Next() {
+		var (
+			column       ColumnSchema
+			notNull, pk  int
+			defaultValue sql.NullString
+		)
+		err = rows.Scan(&column.Name, &column.Type, &notNull, &defaultValue, &pk)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		column.NotNull = notNull != 0
+		if defaultValue.Valid {
+			column.Default = &defaultValue.String
+		}
+		if pk > 0 {
+			primaryKey[pk] = column.Name
+		}
+		schema.Columns = append(schema.Columns, column)
+	}
+	rows.Close()
+	if len(schema.Columns) == 0 {
+		return nil, nil
+	}
+	for i := 1; i <= len(primaryKey); i++ {
+		schema.PrimaryKey = append(schema.PrimaryKey, primaryKey[i])
+	}
+
+	rows, err = executor.Query("SELECT name, \"unique\", origin FROM pragma_index_list(?)", name)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []IndexSchema
+	for rows.Next() {
+		var (
+			index  IndexSchema
+			unique int
+			origin string
+		)
+		err = rows.Scan(&index.Name, &unique, &origin)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if origin == "pk" {
+			continue
+		}
+		index.Unique = unique != 0
+		indexes = append(indexes, index)
+	}
+	rows.Close()
+
+	for _, index := range indexes {
+		rows, err = executor.Query("SELECT name FROM pragma_index_info(?) ORDER BY seqno", index.Name)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var columnName string
+			err = rows.Scan(&columnName)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			index.Columns = append(index.Columns, columnName)
+		}
+		rows.Close()
+		schema.Indexes = append(schema.Indexes, index)
+	}
+
+	rows, err = executor.Query("SELECT \"from\", \"table\", \"to\", on_update, on_delete FROM pragma_foreign_key_list(?)", name)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var foreignKey ForeignKeySchema
+		err = rows.Scan(&foreignKey.Column, &foreignKey.RefTable, &foreignKey.RefColumn, &foreignKey.OnUpdate, &foreignKey.OnDelete)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		schema.ForeignKeys = append(schema.ForeignKeys, foreignKey)
+	}
+	rows.Close()
+
+	// SQLite keeps check constraints only in the CREATE TABLE statement
+	var createSQL string
+	err = queryRow(executor, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&createSQL)
+	if err != nil {
+		return nil, err
+	}
+	for _, match := range checkNamePattern.FindAllStringSubmatch(createSQL, -1) {
+		schema.Checks = append(schema.Checks, CheckSchema{Name: match[1]})
+	}
+
+	return &schema, nil
+}
@@ -0,0 +1,468 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UserRole string
+
+// The roles of the staff, each one may do everything the roles before it may
+const (
+	UserReadOnly    UserRole = "readonly"
+	UserCirculation UserRole = "circulation"
+	UserLibrarian   UserRole = "librarian"
+	UserAdmin       UserRole = "admin"
+)
+
+var userRoleRanks = map[UserRole]int{
+	UserReadOnly:    1,
+	UserCirculation: 2,
+	UserLibrarian:   3,
+	UserAdmin:       4,
+}
+
+func (r UserRole) Valid() bool {
+	_, ok := userRoleRanks[r]
+	return ok
+}
+
+// Allows reports whether a user of role r may do what needs role required
+func (r UserRole) Allows(required UserRole) bool {
+	rank, ok := userRoleRanks[r]
+	return ok && rank >= userRoleRanks[required]
+}
+
+// User is an account of the library staff, signing in to the API
+type User struct {
+	UserID       int      `json:"user_id" sql:"not null;autoIncrement;primaryKey"`
+	Username     string   `json:"username" sql:"not null;size:63;unique"`
+	PasswordHash string   `json:"-" sql:"not null;size:255"`
+	Role         UserRole `json:"role" sql:"not null;enum:readonly,circulation,librarian,admin"`
+	CreatedAt    int64    `json:"created_at" sql:"not null"`
+}
+
+// TableName is plural since user is a reserved word in Postgres
+func (User) TableName() string {
+	return "users"
+}
+
+// UserSession is a login of a user, only the hash of its token is stored
+type UserSession struct {
+	TokenHash  string `json:"token_hash" sql:"not null;char:64;primaryKey"`
+	UserID     int    `json:"user_id" sql:"not null;index;constraint:Users.UserID,OnDelete:CASCADE,OnUpdate:CASCADE"`
+	CreatedAt  int64  `json:"created_at" sql:"not null"`
+	ExpireTime int64  `json:"expire_time" sql:"not null;index"`
+}
+
+// UserBootstrap is the single row BootstrapUser inserts with the first admin, its constant key making a concurrent
+// bootstrap wait for the first one and fail where both would count no users
+type UserBootstrap struct {
+	BootstrapID int   `json:"bootstrap_id" sql:"not null;primaryKey"`
+	CreatedAt   int64 `json:"created_at" sql:"not null"`
+}
+
+// userBootstrapKey is the key of the UserBootstrap row
+const userBootstrapKey = 1
+
+type UserList struct {
+	Count int    `json:"count"`
+	Users []User `json:"users"`
+}
+
+type LoginRequest struct {
+	Username *string `json:"username,omitempty"`
+	Password *string `json:"password,omitempty"`
+}
+
+type LoginResult struct {
+	Token      string `json:"token"`
+	ExpireTime int64  `json:"expire_time"`
+	User       User   `json:"user"`
+}
+
+type UserCreateRequest struct {
+	Username *string `json:"username,omitempty"`
+	Password *string `json:"password,omitempty"`
+	Role     *string `json:"role,omitempty"`
+}
+
+type UserRoleRequest struct {
+	UserID *int    `json:"user_id,omitempty"`
+	Role   *string `json:"role,omitempty"`
+}
+
+type UserPasswordRequest struct {
+	UserID *int `json:"user_id,omitempty"`
+	// OldPassword is required when users change their own password
+	OldPassword *string `json:"old_password,omitempty"`
+	Password    *string `json:"password,omitempty"`
+}
+
+// maxPasswordLength is the most bytes of a password bcrypt reads
+const maxPasswordLength = 72
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9._-]{1,63}$`)
+
+// NormalizeUsername returns the form usernames are stored and looked up in
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func (u *User) check() error {
+	if u == nil {
+		return ErrNilUser
+	}
+	if !usernamePattern.MatchString(u.Username) {
+		return ErrInvalidUsername
+	}
+	if !u.Role.Valid() {
+		return ErrInvalidUserRole
+	}
+	if u.PasswordHash == "" {
+		return ErrMissingPassword
+	}
+	return nil
+}
+
+// HashPassword checks the length of a password against the auth policy and returns its bcrypt hash
+func HashPassword(password string) (string, error) {
+	if len(password) < CurrentAuthPolicy().MinPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	if len(password) > maxPasswordLength {
+		return "", ErrPasswordTooLong
+	}
+	return hashSecret(password)
+}
+
+func hashSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// checkHash reports whether secret has the bcrypt hash. It takes as long without a hash, so that the time of a
+// failed login does not tell whether the account exists.
+func checkHash(hash string, secret string) bool {
+	if hash == "" {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(secret))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+}
+
+// CheckPassword reports whether password is the password of the user, a nil user has none
+func (u *User) CheckPassword(password string) bool {
+	if u == nil {
+		return checkHash("", password)
+	}
+	return checkHash(u.PasswordHash, password)
+}
+
+// HashToken returns the hash a session token is stored under
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// NewSession returns a random token and the session of the user it opens, lasting as long as the auth policy says
+func NewSession(userId int) (string, *UserSession, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	return token, &UserSession{
+		TokenHash:  HashToken(token),
+		UserID:     userId,
+		CreatedAt:  now.Unix(),
+		ExpireTime: now.Add(CurrentAuthPolicy().TokenTTL).Unix(),
+	}, nil
+}
+
+func scanUser(scanner interface{ Scan(dest ...any) error }) (*User, error) {
+	var user User
+	err := scanner.Scan(&user.UserID, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *DatabaseConnector) insertUser(executor SQLExecutor, user *User) error {
+	user.CreatedAt = time.Now().Unix()
+	insertedID, err := c.Dialect().InsertReturningID(executor,
+		"INSERT INTO users (username, password_hash, role, created_at) VALUES (?, ?, ?, ?)", "user_id",
+		user.Username, user.PasswordHash, user.Role, user.CreatedAt)
+	if err != nil {
+		err = c.Dialect().ConstraintError(err)
+		if errors.Is(err, ErrDuplicate) {
+			return ErrDuplicateUser
+		}
+		if errors.Is(err, ErrValidation) {
+			return ErrInvalidUserRole
+		}
+		return err
+	}
+	user.UserID = int(insertedID)
+	return c.writeAudit(executor, "create", AuditUser, user.UserID, nil, user)
+}
+
+func (c *DatabaseConnector) CreateUser(user *User) error {
+	err := user.check()
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	err = c.insertUser(tx, user)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c *DatabaseConnector) BootstrapUser(user *User) error {
+	err := user.check()
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO user_bootstrap (bootstrap_id, created_at) VALUES (?, ?)", userBootstrapKey, time.Now().Unix())
+	if err != nil {
+		err = c.Dialect().ConstraintError(err)
+		if errors.Is(err, ErrDuplicate) {
+			err = ErrUsersExist
+		}
+	}
+	var count int
+	if err == nil {
+		err = queryRow(tx, "SELECT COUNT(*) FROM users").Scan(&count)
+	}
+	if err == nil && count > 0 {
+		err = ErrUsersExist
+	}
+	if err == nil {
+		err = c.insertUser(tx, user)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c *DatabaseConnector) QueryUser(userId int) (*User, error) {
+	user, err := scanUser(c.DB.QueryRow("SELECT * FROM users WHERE user_id = ?", userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (c *DatabaseConnector) QueryUserByName(username string) (*User, error) {
+	user, err := scanUser(c.DB.QueryRow("SELECT * FROM users WHERE username = ?", username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (c *DatabaseConnector) ShowUsers() (*UserList, error) {
+	rows, err := c.DB.Query("SELECT * FROM users ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := UserList{Users: []User{}}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		list.Users = append(list.Users, *user)
+	}
+	list.Count = len(list.Users)
+	return &list, rows.Err()
+}
+
+// checkLastAdmin locks the admins and fails with ErrLastAdmin if the user is the only one
+func (c *DatabaseConnector) checkLastAdmin(tx *Tx, userId int) error {
+	rows, err := tx.Query("SELECT user_id FROM users WHERE role = ?"+c.Dialect().LockForUpdate(), UserAdmin)
+	if err != nil {
+		return err
+	}
+	var admins []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		admins = append(admins, id)
+	}
+	rows.Close()
+	if len(admins) == 1 && admins[0] == userId {
+		return ErrLastAdmin
+	}
+	return rows.Err()
+}
+
+func (c *DatabaseConnector) SetUserRole(userId int, role UserRole) error {
+	if !role.Valid() {
+		return ErrInvalidUserRole
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	current, err := scanUser(queryRow(tx, "SELECT * FROM users WHERE user_id = ?"+c.Dialect().LockForUpdate(), userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrUserNotFound
+	}
+	if err == nil && current.Role == UserAdmin && role != UserAdmin {
+		err = c.checkLastAdmin(tx, userId)
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE users SET role = ? WHERE user_id = ?", role, userId)
+	}
+	if err == nil {
+		updated := *current
+		updated.Role = role
+		err = c.writeAudit(tx, "role", AuditUser, userId, current, &updated)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c *DatabaseConnector) SetUserPassword(userId int, passwordHash string) error {
+	if passwordHash == "" {
+		return ErrMissingPassword
+	}
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE users SET password_hash = ? WHERE user_id = ?", passwordHash, userId)
+	if err == nil {
+		var affected int64
+		affected, err = result.RowsAffected()
+		if err == nil && affected == 0 {
+			err = ErrUserNotFound
+		}
+	}
+	// a new password signs the user out everywhere
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM user_session WHERE user_id = ?", userId)
+	}
+	if err == nil {
+		err = c.writeAudit(tx, "password", AuditUser, userId, nil, nil)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c *DatabaseConnector) RemoveUser(userId int) error {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	user, err := scanUser(queryRow(tx, "SELECT * FROM users WHERE user_id = ?"+c.Dialect().LockForUpdate(), userId))
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrUserNotFound
+	}
+	if err == nil && user.Role == UserAdmin {
+		err = c.checkLastAdmin(tx, userId)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM user_session WHERE user_id = ?", userId)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM users WHERE user_id = ?", userId)
+	}
+	if err == nil {
+		err = c.writeAudit(tx, "remove", AuditUser, userId, user, nil)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c *DatabaseConnector) CreateSession(session *UserSession) error {
+	if session == nil {
+		return ErrNilSession
+	}
+	// the expired sessions are cleared as new ones open
+	_, err := c.DB.Exec("DELETE FROM user_session WHERE expire_time <= ?", session.CreatedAt)
+	if err != nil {
+		return err
+	}
+	_, err = c.DB.Exec("INSERT INTO user_session (token_hash, user_id, created_at, expire_time) VALUES (?, ?, ?, ?)",
+		session.TokenHash, session.UserID, session.CreatedAt, session.ExpireTime)
+	return err
+}
+
+func (c *DatabaseConnector) QuerySession(tokenHash string) (*User, error) {
+	user, err := scanUser(c.DB.QueryRow("SELECT u.* FROM user_session s JOIN users u ON u.user_id = s.user_id"+
+		" WHERE s.token_hash = ? AND s.expire_time > ?", tokenHash, time.Now().Unix()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	return user, err
+}
+
+func (c *DatabaseConnector) RemoveSession(tokenHash string) error {
+	_, err := c.DB.Exec("DELETE FROM user_session WHERE token_hash = ?", tokenHash)
+	return err
+}
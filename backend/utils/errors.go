@@ -1,19 +1,79 @@
 package utils
 
+import "net/http"
+
 type ErrorCode int
 
 const (
 	SUCCESS                 ErrorCode = 0
 	E_BAD_PARAM             ErrorCode = 30002
+	E_VALIDATION            ErrorCode = 30003
+	E_UNAUTHORIZED          ErrorCode = 40100
+	E_FORBIDDEN             ErrorCode = 40300
+	E_NOT_FOUND             ErrorCode = 40400
+	E_CONFLICT              ErrorCode = 40900
+	E_DUPLICATE             ErrorCode = 40901
+	E_INSUFFICIENT_STOCK    ErrorCode = 40902
+	E_LOAN_LIMIT            ErrorCode = 40903
+	E_TOO_MANY_ATTEMPTS     ErrorCode = 42900
 	E_INTERNAL_SERVER_ERROR ErrorCode = 50000
 )
 
+var errorStatus = map[ErrorCode]int{
+	SUCCESS:                 http.StatusOK,
+	E_BAD_PARAM:             http.StatusBadRequest,
+	E_VALIDATION:            http.StatusUnprocessableEntity,
+	E_UNAUTHORIZED:          http.StatusUnauthorized,
+	E_FORBIDDEN:             http.StatusForbidden,
+	E_NOT_FOUND:             http.StatusNotFound,
+	E_CONFLICT:              http.StatusConflict,
+	E_DUPLICATE:             http.StatusConflict,
+	E_INSUFFICIENT_STOCK:    http.StatusConflict,
+	E_LOAN_LIMIT:            http.StatusConflict,
+	E_TOO_MANY_ATTEMPTS:     http.StatusTooManyRequests,
+	E_INTERNAL_SERVER_ERROR: http.StatusInternalServerError,
+}
+
+// HTTPStatus returns the status of the responses carrying the code
+func (code ErrorCode) HTTPStatus() int {
+	if status, ok := errorStatus[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorCodeOf returns the code of a response with an HTTP status not produced by the application
+func ErrorCodeOf(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return E_BAD_PARAM
+	case http.StatusUnprocessableEntity:
+		return E_VALIDATION
+	case http.StatusUnauthorized:
+		return E_UNAUTHORIZED
+	case http.StatusForbidden:
+		return E_FORBIDDEN
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return E_NOT_FOUND
+	case http.StatusConflict:
+		return E_CONFLICT
+	case http.StatusTooManyRequests:
+		return E_TOO_MANY_ATTEMPTS
+	}
+	return E_INTERNAL_SERVER_ERROR
+}
+
 type Error struct {
 	Code ErrorCode   `json:"code"`
 	Msg  string      `json:"msg"`
 	Data interface{} `json:"data"`
 }
 
+// Error makes a failed response returnable from a handler, the HTTP error handler writes it with the status of its code
+func (e Error) Error() string {
+	return e.Msg
+}
+
 func Success(data interface{}) Error {
 	return Error{
 		Code: SUCCESS,
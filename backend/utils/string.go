@@ -5,8 +5,13 @@ import (
 	"regexp"
 )
 
+var (
+	lowerUpperPattern   = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+	acronymUpperPattern = regexp.MustCompile(`([A-Z]+)([A-Z][a-z])`)
+)
+
 func ToSnake(str string) string {
-	reg := regexp.MustCompile(`(\w)([A-Z])`)
-	res := bytes.ToLower(reg.ReplaceAll([]byte(str), []byte("${1}_${2}")))
-	return string(res)
+	res := acronymUpperPattern.ReplaceAll([]byte(str), []byte("${1}_${2}"))
+	res = lowerUpperPattern.ReplaceAll(res, []byte("${1}_${2}"))
+	return string(bytes.ToLower(res))
 }
@@ -0,0 +1,91 @@
+package web
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// anonymousActor is the actor of the changes made by requests signed in as nobody
+const anonymousActor = "anonymous"
+
+// lms returns the system recording the changes the request makes as made by whoever signed it in, in the request
+// of its X-Request-ID
+func lms(c echo.Context) app.LibraryManagementSystem {
+	actor := anonymousActor
+	if user := currentUser(c); user != nil {
+		actor = user.Username
+	} else if cardId := currentCard(c); cardId != 0 {
+		actor = fmt.Sprintf("card:%d", cardId)
+	}
+	return app.LMS.WithAudit(&model.AuditContext{
+		Actor:     actor,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	})
+}
+
+func listAudit(c echo.Context) error {
+	query := model.AuditQuery{Page: &model.Page{}}
+	err := echo.QueryParamsBinder(c).
+		String("actor", &query.Actor).
+		String("action", &query.Action).
+		String("entity", &query.EntityType).
+		String("id", &query.EntityID).
+		String("request_id", &query.RequestID).
+		Int64("since", &query.Since).
+		Int64("until", &query.Until).
+		Int("offset", &query.Page.Offset).
+		Int("limit", &query.Page.Limit).
+		String("cursor", &query.Page.Cursor).
+		BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind audit query params",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ShowAudit(&query)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryBookHistory(c echo.Context) error {
+	var (
+		bid  int
+		page model.Page
+	)
+	err := echo.PathParamsBinder(c).MustInt("id", &bid).BindError()
+	if err == nil {
+		err = echo.QueryParamsBinder(c).
+			Int("offset", &page.Offset).
+			Int("limit", &page.Limit).
+			String("cursor", &page.Cursor).
+			BindError()
+	}
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind book history params",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ShowBookHistory(bid, &page)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
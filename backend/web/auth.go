@@ -0,0 +1,162 @@
+package web
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bearerPrefix = "Bearer "
+	contextUser  = "user"
+)
+
+// publicRoutes are the routes served without a token
+var publicRoutes = map[string]bool{
+	"/ping":           true,
+	"/auth/login":     true,
+	"/auth/bootstrap": true,
+	"/me/login":       true,
+}
+
+// bearerToken returns the token of the Authorization header of the request, empty if it has none
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(header) <= len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(bearerPrefix):])
+}
+
+// authenticate signs the requests in with the token of their Authorization header, all but the public routes need one.
+// The routes of the patron portal are signed in by authenticatePatron instead.
+func authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if publicRoutes[c.Path()] || isPatronRoute(c.Path()) {
+			return next(c)
+		}
+		result := app.LMS.Authenticate(bearerToken(c))
+		if !result.OK {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+			return result.Err()
+		}
+		c.Set(contextUser, result.Payload)
+		return next(c)
+	}
+}
+
+// currentUser returns the user the request was signed in as by authenticate
+func currentUser(c echo.Context) *model.User {
+	user, _ := c.Get(contextUser).(*model.User)
+	return user
+}
+
+// requireRole refuses the requests of the users whose role does not allow what needs role
+func requireRole(role model.UserRole) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := currentUser(c)
+			if user == nil || !user.Role.Allows(role) {
+				return app.Failure(model.ErrPermissionDenied).Err()
+			}
+			return next(c)
+		}
+	}
+}
+
+func bindLoginRequest(c echo.Context) (*model.LoginRequest, error) {
+	var request model.LoginRequest
+
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return nil, c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind login request",
+			Data: nil,
+		})
+	}
+
+	if request.Username == nil || request.Password == nil {
+		return nil, c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+	return &request, nil
+}
+
+func login(c echo.Context) error {
+	request, err := bindLoginRequest(c)
+	if request == nil {
+		return err
+	}
+
+	result := lms(c).Login(*request.Username, *request.Password)
+	if !result.OK {
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func bootstrapAdmin(c echo.Context) error {
+	request, err := bindLoginRequest(c)
+	if request == nil {
+		return err
+	}
+
+	result := lms(c).BootstrapAdmin(*request.Username, *request.Password)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func logout(c echo.Context) error {
+	result := lms(c).Logout(bearerToken(c))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func queryCurrentUser(c echo.Context) error {
+	return c.JSON(http.StatusOK, utils.Success(currentUser(c)))
+}
+
+func changePassword(c echo.Context) error {
+	var request model.UserPasswordRequest
+
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind password request",
+			Data: nil,
+		})
+	}
+
+	if request.OldPassword == nil || request.Password == nil {
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ChangePassword(currentUser(c).UserID, *request.OldPassword, *request.Password)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
@@ -0,0 +1,38 @@
+package web
+
+import (
+	"LibManSys/utils"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+func listAuthors(c echo.Context) error {
+	result := lms(c).ShowAuthors(c.QueryParam("name"))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryAuthorWorks(c echo.Context) error {
+	var aid int
+	err := echo.QueryParamsBinder(c).MustInt("aid", &aid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind author id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ShowAuthorWorks(aid)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
@@ -23,11 +23,12 @@ func createBook(c echo.Context) error {
 		})
 	}
 
+	// the author may be given as a list of contributors instead
 	if request.Category == nil ||
 		request.Title == nil ||
 		request.Press == nil ||
 		request.PublishYear == nil ||
-		request.Author == nil ||
+		(request.Author == nil && len(request.Contributors) == 0) ||
 		request.Price == nil ||
 		request.Stock == nil {
 		return c.JSON(http.StatusBadRequest, utils.Error{
@@ -38,22 +39,22 @@ func createBook(c echo.Context) error {
 	}
 
 	book := model.Book{
-		Category:    *request.Category,
-		Title:       *request.Title,
-		Press:       *request.Press,
-		PublishYear: *request.PublishYear,
-		Author:      *request.Author,
-		Price:       *request.Price,
-		Stock:       *request.Stock,
-	}
-	result := app.LMS.StoreBook(&book)
+		Category:     *request.Category,
+		Title:        *request.Title,
+		Press:        *request.Press,
+		PublishYear:  *request.PublishYear,
+		Price:        *request.Price,
+		Stock:        *request.Stock,
+		ISBN:         request.ISBN,
+		Contributors: request.Contributors,
+	}
+	if request.Author != nil {
+		book.Author = *request.Author
+	}
+	result := lms(c).StoreBook(&book)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
-			Data: nil,
-		})
+		return result.Err()
 	}
 
 	return c.JSON(http.StatusOK, utils.Success(book.BookID))
@@ -78,7 +79,7 @@ func createBookBatch(c echo.Context) error {
 			book.Title == nil ||
 			book.Press == nil ||
 			book.PublishYear == nil ||
-			book.Author == nil ||
+			(book.Author == nil && len(book.Contributors) == 0) ||
 			book.Price == nil ||
 			book.Stock == nil {
 			return c.JSON(http.StatusBadRequest, utils.Error{
@@ -87,25 +88,33 @@ func createBookBatch(c echo.Context) error {
 				Data: nil,
 			})
 		}
-		books = append(books, model.Book{
-			Category:    *book.Category,
-			Title:       *book.Title,
-			Press:       *book.Press,
-			PublishYear: *book.PublishYear,
-			Author:      *book.Author,
-			Price:       *book.Price,
-			Stock:       *book.Stock,
-		})
+		stored := model.Book{
+			Category:     *book.Category,
+			Title:        *book.Title,
+			Press:        *book.Press,
+			PublishYear:  *book.PublishYear,
+			Price:        *book.Price,
+			Stock:        *book.Stock,
+			ISBN:         book.ISBN,
+			Contributors: book.Contributors,
+		}
+		if book.Author != nil {
+			stored.Author = *book.Author
+		}
+		books = append(books, stored)
+	}
+
+	// the same ISBN listed twice is one book with the stock of both
+	books, err = model.DedupeBooksByISBN(books)
+	if err != nil {
+		logrus.Error(err)
+		return app.Failure(err).Err()
 	}
 
-	result := app.LMS.StoreBooks(books)
+	result := lms(c).StoreBooks(books)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
-			Data: nil,
-		})
+		return result.Err()
 	}
 
 	return c.JSON(http.StatusOK, utils.Success(nil))
@@ -124,16 +133,121 @@ func listAllBooksMatched(c echo.Context) error {
 		})
 	}
 
-	result := app.LMS.QueryBook(&request)
+	// the query string may also give the filter and the sort in their compact syntax
+	var (
+		filter, sort string
+		facets       bool
+		facetOptions model.BookFacetOptions
+	)
+	err = echo.QueryParamsBinder(c).
+		String("filter", &filter).
+		String("sort", &sort).
+		Bool("available", &request.AvailableOnly).
+		Bool("facets", &facets).
+		Int("facet_top", &facetOptions.Top).
+		Int("year_width", &facetOptions.YearWidth).
+		Float32("price_width", &facetOptions.PriceWidth).
+		Int("offset", &request.Offset).
+		Int("limit", &request.Limit).
+		String("cursor", &request.Cursor).
+		BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind list params",
+			Data: nil,
+		})
+	}
+	if filter != "" {
+		parsed, err := model.ParseBookFilter(filter)
+		if err != nil {
+			logrus.Error(err)
+			return app.Failure(err).Err()
+		}
+		if request.Filter != nil {
+			parsed = &model.BookFilter{And: []model.BookFilter{*request.Filter, *parsed}}
+		}
+		request.Filter = parsed
+	}
+	if facets && request.Facets == nil {
+		request.Facets = &facetOptions
+	}
+	if sort != "" {
+		request.Sort, err = model.ParseBookSort(sort)
+		if err != nil {
+			logrus.Error(err)
+			return app.Failure(err).Err()
+		}
+	}
+
+	result := lms(c).QueryBook(&request)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryBookByISBN(c echo.Context) error {
+	result := lms(c).QueryBookByISBN(c.Param("isbn"))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+
+	c.Response().Header().Set(headerETag, etag(result.Payload.(model.Book).Version))
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryBook(c echo.Context) error {
+	var bid int
+	err := echo.PathParamsBinder(c).MustInt("id", &bid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind book id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).QueryBookByID(bid)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
+		return result.Err()
+	}
+
+	version := result.Payload.(model.Book).Version
+	c.Response().Header().Set(headerETag, etag(version))
+	if notModified(c, version) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func searchBooks(c echo.Context) error {
+	var (
+		query string
+		limit int
+	)
+	err := echo.QueryParamsBinder(c).MustString("q", &query).Int("limit", &limit).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind search params",
 			Data: nil,
 		})
 	}
 
+	result := lms(c).SearchBooks(query, limit)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+
 	return c.JSON(http.StatusOK, utils.Success(result.Payload))
 }
 
@@ -150,17 +264,55 @@ func updateBook(c echo.Context) error {
 		})
 	}
 
-	result := app.LMS.ModifyBookInfo(&request)
+	result := lms(c).ModifyBookInfo(&request)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
+		return result.Err()
+	}
+
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func patchBook(c echo.Context) error {
+	var (
+		bid     int
+		request model.BookPatch
+	)
+	err := echo.PathParamsBinder(c).MustInt("id", &bid).BindError()
+	if err == nil {
+		err = c.Bind(&request)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind patch request",
 			Data: nil,
 		})
 	}
 
-	return c.JSON(http.StatusOK, utils.Success(nil))
+	// If-Match takes precedence over the version in the body
+	version, err := ifMatch(c)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  err.Error(),
+			Data: nil,
+		})
+	}
+	if version != nil {
+		request.Version = version
+	}
+
+	result := lms(c).PatchBook(bid, &request)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+
+	c.Response().Header().Set(headerETag, etag(result.Payload.(*model.Book).Version))
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
 }
 
 func updateBookStock(c echo.Context) error {
@@ -197,14 +349,10 @@ func updateBookStock(c echo.Context) error {
 		})
 	}
 
-	result := app.LMS.IncBookStock(*request.BookID, delta)
+	result := lms(c).IncBookStock(*request.BookID, delta)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
-			Data: nil,
-		})
+		return result.Err()
 	}
 
 	return c.JSON(http.StatusOK, utils.Success(nil))
@@ -222,14 +370,10 @@ func removeBook(c echo.Context) error {
 		})
 	}
 
-	result := app.LMS.RemoveBook(bid)
+	result := lms(c).RemoveBook(bid)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
-			Data: nil,
-		})
+		return result.Err()
 	}
 	return c.JSON(http.StatusOK, utils.Success(nil))
 }
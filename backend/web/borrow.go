@@ -1,7 +1,6 @@
 package web
 
 import (
-	"LibManSys/app"
 	"LibManSys/model"
 	"LibManSys/utils"
 	"net/http"
@@ -22,7 +21,8 @@ func borrowBook(c echo.Context) error {
 		})
 	}
 
-	if request.CardID == nil || request.BookID == nil || request.BorrowTime == nil {
+	// a copy may be borrowed by its barcode alone
+	if request.CardID == nil || (request.BookID == nil && request.Barcode == nil) || request.BorrowTime == nil {
 		logrus.Error("missing required field")
 		return c.JSON(http.StatusBadRequest, utils.Error{
 			Code: utils.E_BAD_PARAM,
@@ -31,21 +31,27 @@ func borrowBook(c echo.Context) error {
 		})
 	}
 
-	result := app.LMS.BorrowBook(&model.Borrow{
+	borrow := model.Borrow{
 		CardID:     *request.CardID,
-		BookID:     *request.BookID,
 		BorrowTime: *request.BorrowTime,
-		ReturnTime: *request.ReturnTime,
-	})
+	}
+	if request.BookID != nil {
+		borrow.BookID = *request.BookID
+	}
+	if request.Barcode != nil {
+		borrow.Barcode = *request.Barcode
+	}
+	// librarians may set the due date instead of the loan policy
+	if request.DueTime != nil {
+		borrow.DueTime = *request.DueTime
+	}
+
+	result := lms(c).LendBook(currentUser(c).UserID, &borrow)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
-			Data: nil,
-		})
+		return result.Err()
 	}
-	return c.JSON(http.StatusOK, utils.Success(nil))
+	return c.JSON(http.StatusOK, utils.Success(borrow.Barcode))
 }
 
 func returnBook(c echo.Context) error {
@@ -60,7 +66,8 @@ func returnBook(c echo.Context) error {
 		})
 	}
 
-	if request.CardID == nil || request.BookID == nil || request.ReturnTime == nil {
+	// a copy may be returned by its barcode alone
+	if (request.Barcode == nil && (request.CardID == nil || request.BookID == nil)) || request.ReturnTime == nil {
 		logrus.Error("missing required field")
 		return c.JSON(http.StatusBadRequest, utils.Error{
 			Code: utils.E_BAD_PARAM,
@@ -69,44 +76,89 @@ func returnBook(c echo.Context) error {
 		})
 	}
 
-	result := app.LMS.ReturnBook(&model.Borrow{
-		CardID:     *request.CardID,
-		BookID:     *request.BookID,
-		BorrowTime: *request.BorrowTime,
+	borrow := model.Borrow{
 		ReturnTime: *request.ReturnTime,
-	})
+	}
+	if request.CardID != nil {
+		borrow.CardID = *request.CardID
+	}
+	if request.BookID != nil {
+		borrow.BookID = *request.BookID
+	}
+	if request.Barcode != nil {
+		borrow.Barcode = *request.Barcode
+	}
+	if request.BorrowTime != nil {
+		borrow.BorrowTime = *request.BorrowTime
+	}
+
+	result := lms(c).ReturnBook(&borrow)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
-			Data: nil,
-		})
+		return result.Err()
 	}
 	return c.JSON(http.StatusOK, utils.Success(nil))
 }
 
-func queryBorrowHistory(c echo.Context) error {
-	var cid int
-	err := echo.QueryParamsBinder(c).MustInt("cid", &cid).BindError()
+func renewBook(c echo.Context) error {
+	var request model.BorrowRequest
+	err := c.Bind(&request)
 	if err != nil {
 		logrus.Error(err)
 		return c.JSON(http.StatusBadRequest, utils.Error{
 			Code: utils.E_BAD_PARAM,
-			Msg:  "failed to bind card id param",
+			Msg:  "failed to bind renew request",
 			Data: nil,
 		})
 	}
 
-	result := app.LMS.ShowBorrowHistory(cid)
+	if request.CardID == nil || request.BookID == nil {
+		logrus.Error("missing required field")
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	borrow := model.Borrow{
+		CardID: *request.CardID,
+		BookID: *request.BookID,
+	}
+
+	result := lms(c).RenewBook(&borrow)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(borrow.DueTime))
+}
+
+func queryBorrowHistory(c echo.Context) error {
+	var (
+		cid  int
+		page model.Page
+	)
+	err := echo.QueryParamsBinder(c).
+		MustInt("cid", &cid).
+		Int("offset", &page.Offset).
+		Int("limit", &page.Limit).
+		String("cursor", &page.Cursor).
+		BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind card id or page params",
 			Data: nil,
 		})
 	}
 
+	result := lms(c).ShowBorrowHistory(cid, &page)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+
 	return c.JSON(http.StatusOK, utils.Success(result.Payload))
 }
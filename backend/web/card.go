@@ -1,7 +1,6 @@
 package web
 
 import (
-	"LibManSys/app"
 	"LibManSys/model"
 	"LibManSys/utils"
 	"net/http"
@@ -37,14 +36,10 @@ func createCard(c echo.Context) error {
 		Type:       *request.Type,
 	}
 
-	result := app.LMS.RegisterCard(&card)
+	result := lms(c).RegisterCard(&card)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
-			Data: nil,
-		})
+		return result.Err()
 	}
 	return c.JSON(http.StatusOK, utils.Success(card.CardID))
 }
@@ -61,29 +56,79 @@ func queryCard(c echo.Context) error {
 		})
 	}
 
-	result := app.LMS.QueryCard(cid)
+	result := lms(c).QueryCard(cid)
 	if !result.OK {
 		logrus.Error(err)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
+		return result.Err()
+	}
+	c.Response().Header().Set(headerETag, etag(result.Payload.(*model.Card).Version))
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func patchCard(c echo.Context) error {
+	var (
+		cid     int
+		request model.CardPatch
+	)
+	err := echo.PathParamsBinder(c).MustInt("id", &cid).BindError()
+	if err == nil {
+		err = c.Bind(&request)
+	}
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind patch request",
+			Data: nil,
+		})
+	}
+
+	// If-Match takes precedence over the version in the body
+	version, err := ifMatch(c)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  err.Error(),
 			Data: nil,
 		})
 	}
+	if version != nil {
+		request.Version = version
+	}
+
+	result := lms(c).PatchCard(cid, &request)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+
+	c.Response().Header().Set(headerETag, etag(result.Payload.(*model.Card).Version))
 	return c.JSON(http.StatusOK, utils.Success(result.Payload))
 }
 
 func listCards(c echo.Context) error {
-	result := app.LMS.ShowCards()
-	if !result.OK {
-		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
+	var page model.Page
+	err := echo.QueryParamsBinder(c).
+		Int("offset", &page.Offset).
+		Int("limit", &page.Limit).
+		String("cursor", &page.Cursor).
+		BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind page params",
 			Data: nil,
 		})
 	}
 
+	result := lms(c).ShowCards(&page)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+
 	return c.JSON(http.StatusOK, utils.Success(result.Payload))
 }
 
@@ -99,14 +144,10 @@ func removeCard(c echo.Context) error {
 		})
 	}
 
-	result := app.LMS.RemoveCard(cid)
+	result := lms(c).RemoveCard(cid)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
-			Data: nil,
-		})
+		return result.Err()
 	}
 	return c.JSON(http.StatusOK, utils.Success(nil))
 }
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"LibManSys/model"
+	"LibManSys/utils"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+func listBookCopies(c echo.Context) error {
+	var bid int
+	err := echo.QueryParamsBinder(c).MustInt("bid", &bid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind book id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ShowCopies(bid)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func addBookCopies(c echo.Context) error {
+	var request model.CopyAddRequest
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind copy request",
+			Data: nil,
+		})
+	}
+
+	// either a number of copies given generated barcodes, or the copies themselves
+	if request.BookID == nil || (request.Count == nil && len(request.Copies) == 0) {
+		logrus.Error("missing required field")
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	var copies []model.BookCopy
+	if len(request.Copies) == 0 {
+		if *request.Count <= 0 {
+			return c.JSON(http.StatusBadRequest, utils.Error{
+				Code: utils.E_BAD_PARAM,
+				Msg:  "invalid count",
+				Data: nil,
+			})
+		}
+		copies = make([]model.BookCopy, *request.Count)
+	}
+	for _, r := range request.Copies {
+		var bookCopy model.BookCopy
+		if r.Barcode != nil {
+			bookCopy.Barcode = *r.Barcode
+		}
+		if r.Location != nil {
+			bookCopy.Location = *r.Location
+		}
+		copies = append(copies, bookCopy)
+	}
+
+	result := lms(c).AddCopies(*request.BookID, copies)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func withdrawBookCopies(c echo.Context) error {
+	var request model.CopyWithdrawRequest
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind withdraw request",
+			Data: nil,
+		})
+	}
+
+	if len(request.Barcodes) == 0 {
+		logrus.Error("missing required field")
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).WithdrawCopies(request.Barcodes)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func updateBookCopy(c echo.Context) error {
+	var request model.CopyRequest
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind copy request",
+			Data: nil,
+		})
+	}
+
+	if request.Barcode == nil || (request.Status == nil && request.Location == nil) {
+		logrus.Error("missing required field")
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	bookCopy := model.BookCopy{Barcode: *request.Barcode}
+	if request.Status != nil {
+		bookCopy.Status = *request.Status
+	}
+	if request.Location != nil {
+		bookCopy.Location = *request.Location
+	}
+
+	result := lms(c).UpdateCopy(&bookCopy)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
@@ -1,23 +1,101 @@
 package web
 
 import (
-	"LibManSys/app"
+	"LibManSys/model"
 	"LibManSys/utils"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 )
 
-func resetDatabase(c echo.Context) error {
-	result := app.LMS.ResetDatabase()
+func bindResetRequest(c echo.Context) (*model.ResetRequest, error) {
+	var request model.ResetRequest
+
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return nil, c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind reset request",
+			Data: nil,
+		})
+	}
+	return &request, nil
+}
+
+func prepareReset(c echo.Context) error {
+	request, err := bindResetRequest(c)
+	if request == nil {
+		return err
+	}
+
+	options := model.ResetOptions{Tables: request.Tables}
+	if request.Fixture != nil {
+		options.Fixture = *request.Fixture
+	}
+	result := lms(c).PrepareReset(currentUser(c).UserID, &options)
 	if !result.OK {
 		logrus.Error(result.Message)
-		return c.JSON(http.StatusInternalServerError, utils.Error{
-			Code: utils.E_INTERNAL_SERVER_ERROR,
-			Msg:  result.Message,
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func resetDatabase(c echo.Context) error {
+	request, err := bindResetRequest(c)
+	if request == nil {
+		return err
+	}
+
+	if request.Confirm == nil {
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
 			Data: nil,
 		})
 	}
-	return c.JSON(http.StatusOK, utils.Success(nil))
+
+	result := lms(c).ConfirmReset(currentUser(c).UserID, *request.Confirm)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+// backupDatabase streams an archive of the library. The archive is made before its first byte is sent, a failure
+// is then answered as any other.
+func backupDatabase(c echo.Context) error {
+	response := c.Response()
+	filename := "library-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	response.Header().Set(echo.HeaderContentType, "application/gzip")
+	response.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	result := lms(c).Backup(response)
+	if !result.OK {
+		logrus.Error(result.Message)
+		if response.Committed {
+			// the client is left with a truncated archive, which fails its checksums
+			return nil
+		}
+		response.Header().Del(echo.HeaderContentType)
+		response.Header().Del(echo.HeaderContentDisposition)
+		return result.Err()
+	}
+	return nil
+}
+
+// restoreDatabase restores the archive streamed in the request body into the empty database, the body is cut at the
+// largest upload of the archive policy
+func restoreDatabase(c echo.Context) error {
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, model.CurrentArchivePolicy().MaxUpload)
+	result := lms(c).Restore(body)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
 }
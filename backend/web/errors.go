@@ -0,0 +1,52 @@
+package web
+
+import (
+	"LibManSys/utils"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// httpErrorHandler writes the errors returned by handlers as utils.Error with the HTTP status of their code
+func httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var (
+		response  utils.Error
+		httpError *echo.HTTPError
+		status    int
+	)
+	switch {
+	case errors.As(err, &response):
+		status = response.Code.HTTPStatus()
+	case errors.As(err, &httpError):
+		// errors of echo itself, e.g. unknown routes
+		status = httpError.Code
+		response = utils.Error{
+			Code: utils.ErrorCodeOf(status),
+			Msg:  fmt.Sprint(httpError.Message),
+			Data: nil,
+		}
+	default:
+		status = http.StatusInternalServerError
+		response = utils.Error{
+			Code: utils.E_INTERNAL_SERVER_ERROR,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, response)
+	}
+	if err != nil {
+		logrus.Error(err)
+	}
+}
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+var errInvalidIfMatch = errors.New("If-Match must be * or a single ETag")
+
+// etag is the entity tag of a version of a book or a card, weak since the stock of a book does not change its version
+func etag(version int) string {
+	return `W/"` + strconv.Itoa(version) + `"`
+}
+
+// parseETag returns the version of an entity tag made by etag, strong or weak
+func parseETag(tag string) (int, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	return version, err == nil
+}
+
+// ifMatch returns the version the If-Match header of the request expects, nil if any version will do
+func ifMatch(c echo.Context) (*int, error) {
+	header := strings.TrimSpace(c.Request().Header.Get(headerIfMatch))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+	version, ok := parseETag(header)
+	if !ok {
+		return nil, errInvalidIfMatch
+	}
+	return &version, nil
+}
+
+// notModified reports whether the If-None-Match header of the request has the tag of version
+func notModified(c echo.Context, version int) bool {
+	for _, tag := range strings.Split(c.Request().Header.Get(headerIfNoneMatch), ",") {
+		if strings.TrimSpace(tag) == "*" {
+			return true
+		}
+		if v, ok := parseETag(tag); ok && v == version {
+			return true
+		}
+	}
+	return false
+}
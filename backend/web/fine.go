@@ -0,0 +1,68 @@
+package web
+
+import (
+	"LibManSys/model"
+	"LibManSys/utils"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+func queryFines(c echo.Context) error {
+	var cid int
+	err := echo.QueryParamsBinder(c).MustInt("cid", &cid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind card id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ShowFines(cid)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func payFine(c echo.Context) error {
+	return settleFine(c, model.FinePayment)
+}
+
+func waiveFine(c echo.Context) error {
+	return settleFine(c, model.FineWaiver)
+}
+
+func settleFine(c echo.Context, kind model.FineKind) error {
+	var request model.FineSettleRequest
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind " + string(kind) + " request",
+			Data: nil,
+		})
+	}
+
+	if request.CardID == nil || request.Amount == nil || request.Reason == nil {
+		logrus.Error("missing required field")
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	entry := model.NewSettlement(*request.CardID, kind, float64(*request.Amount), *request.Reason)
+	result := lms(c).SettleFine(entry)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(entry.FineID))
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"LibManSys/model"
+	"LibManSys/utils"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+func placeHold(c echo.Context) error {
+	var request model.HoldRequest
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind hold request",
+			Data: nil,
+		})
+	}
+
+	if request.CardID == nil || request.BookID == nil {
+		logrus.Error("missing required field")
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	hold := model.Hold{
+		CardID: *request.CardID,
+		BookID: *request.BookID,
+	}
+
+	result := lms(c).PlaceHold(&hold)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func cancelHold(c echo.Context) error {
+	var hid int
+	err := echo.QueryParamsBinder(c).MustInt("hid", &hid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind hold id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).CancelHold(hid)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func queryCardHolds(c echo.Context) error {
+	var cid int
+	err := echo.QueryParamsBinder(c).MustInt("cid", &cid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind card id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ShowCardHolds(cid)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryBookHolds(c echo.Context) error {
+	var bid int
+	err := echo.QueryParamsBinder(c).MustInt("bid", &bid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind book id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ShowBookHolds(bid)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
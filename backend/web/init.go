@@ -11,13 +11,20 @@ import (
 
 var e *echo.Echo
 
+// initCors allows the origins of server.cors_origins, or any origin if it is not set. Credentials are not allowed
+// since the API takes a bearer token instead of cookies.
 func initCors(e *echo.Echo) {
+	origins := viper.GetStringSlice("server.cors_origins")
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
 	corsConf := echo_middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
+		AllowOrigins: origins,
 		AllowMethods: []string{
 			echo.GET,
 			echo.POST,
 			echo.PUT,
+			echo.PATCH,
 			echo.DELETE,
 			echo.OPTIONS,
 		},
@@ -26,12 +33,16 @@ func initCors(e *echo.Echo) {
 			echo.HeaderContentType,
 			echo.HeaderContentLength,
 			echo.HeaderAccept,
+			echo.HeaderAuthorization,
+			headerIfMatch,
+			headerIfNoneMatch,
+			echo.HeaderXRequestID,
 		},
-		AllowCredentials: true,
-		MaxAge:           3600,
+		MaxAge: 3600,
 		ExposeHeaders: []string{
-			echo.HeaderAuthorization,
-			echo.HeaderSetCookie,
+			headerETag,
+			echo.HeaderXRequestID,
+			echo.HeaderContentDisposition,
 		},
 	}
 	e.Use(echo_middleware.CORSWithConfig(corsConf))
@@ -40,11 +51,15 @@ func initCors(e *echo.Echo) {
 func InitWebFramework() {
 	e = echo.New()
 	e.HideBanner = true
+	e.HTTPErrorHandler = httpErrorHandler
+	// every request gets an id, or keeps the one of its X-Request-ID, to find the changes it made in the audit log
+	e.Use(echo_middleware.RequestID())
 	e.Use(echo_middleware.LoggerWithConfig(echo_middleware.LoggerConfig{
-		Format: "method=${method}, remote_ip=${remote_ip}, uri=${uri}, status=${status}\n",
+		Format: "id=${id}, method=${method}, remote_ip=${remote_ip}, uri=${uri}, status=${status}\n",
 	}))
 
 	initCors(e)
+	e.Use(authenticate)
 	addRoutes(e)
 
 	logrus.Info("Echo framework initialized")
@@ -0,0 +1,283 @@
+package web
+
+import (
+	"LibManSys/app"
+	"LibManSys/model"
+	"LibManSys/utils"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	patronPrefix = "/me"
+	contextCard  = "card"
+)
+
+// isPatronRoute reports whether path is a route of the patron portal, which staff tokens do not open
+func isPatronRoute(path string) bool {
+	return path == patronPrefix || strings.HasPrefix(path, patronPrefix+"/")
+}
+
+// authenticatePatron signs the requests to the portal in with the token of a patron, every route of it is scoped to the
+// card of the token
+func authenticatePatron(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		result := app.LMS.AuthenticatePatron(bearerToken(c))
+		if !result.OK {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+			return result.Err()
+		}
+		c.Set(contextCard, result.Payload)
+		return next(c)
+	}
+}
+
+// currentCard returns the id of the card the request was signed in as by authenticatePatron
+func currentCard(c echo.Context) int {
+	cardId, _ := c.Get(contextCard).(int)
+	return cardId
+}
+
+func patronLogin(c echo.Context) error {
+	var request model.PatronLoginRequest
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind login request",
+			Data: nil,
+		})
+	}
+
+	if request.CardID == nil || request.PIN == nil {
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).PatronLogin(*request.CardID, *request.PIN)
+	if !result.OK {
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func patronLogout(c echo.Context) error {
+	result := lms(c).PatronLogout(bearerToken(c))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func queryPatronCard(c echo.Context) error {
+	result := lms(c).QueryCard(currentCard(c))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryPatronLoans(c echo.Context) error {
+	result := lms(c).ShowLoans(currentCard(c))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryPatronHistory(c echo.Context) error {
+	var page model.Page
+	err := echo.QueryParamsBinder(c).
+		Int("offset", &page.Offset).
+		Int("limit", &page.Limit).
+		String("cursor", &page.Cursor).
+		BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind page params",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ShowBorrowHistory(currentCard(c), &page)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryPatronHolds(c echo.Context) error {
+	result := lms(c).ShowCardHolds(currentCard(c))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func queryPatronFines(c echo.Context) error {
+	result := lms(c).ShowFines(currentCard(c))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+// bindPatronBook returns the book id of a request of the portal, the card is always the patron's own
+func bindPatronBook(c echo.Context, what string) (*int, error) {
+	var request model.BorrowRequest
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return nil, c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind " + what + " request",
+			Data: nil,
+		})
+	}
+
+	if request.BookID == nil {
+		return nil, c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+	return request.BookID, nil
+}
+
+func renewPatronLoan(c echo.Context) error {
+	bookId, err := bindPatronBook(c, "renew")
+	if bookId == nil {
+		return err
+	}
+
+	borrow := model.Borrow{
+		CardID: currentCard(c),
+		BookID: *bookId,
+	}
+
+	result := lms(c).RenewBook(&borrow)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(borrow.DueTime))
+}
+
+func placePatronHold(c echo.Context) error {
+	bookId, err := bindPatronBook(c, "hold")
+	if bookId == nil {
+		return err
+	}
+
+	hold := model.Hold{
+		CardID: currentCard(c),
+		BookID: *bookId,
+	}
+
+	result := lms(c).PlaceHold(&hold)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func cancelPatronHold(c echo.Context) error {
+	var hid int
+	err := echo.QueryParamsBinder(c).MustInt("hid", &hid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind hold id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).CancelCardHold(currentCard(c), hid)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func bindCardPINRequest(c echo.Context) (*model.CardPINRequest, error) {
+	var request model.CardPINRequest
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return nil, c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind PIN request",
+			Data: nil,
+		})
+	}
+
+	if request.PIN == nil {
+		return nil, c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+	return &request, nil
+}
+
+func changePatronPIN(c echo.Context) error {
+	request, err := bindCardPINRequest(c)
+	if request == nil {
+		return err
+	}
+	if request.OldPIN == nil {
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).ChangeCardPIN(currentCard(c), *request.OldPIN, *request.PIN)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func setCardPIN(c echo.Context) error {
+	request, err := bindCardPINRequest(c)
+	if request == nil {
+		return err
+	}
+	if request.CardID == nil {
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).SetCardPIN(*request.CardID, *request.PIN)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
@@ -1,36 +1,105 @@
 package web
 
 import (
+	"LibManSys/model"
 	"LibManSys/utils"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 )
 
+// addRoutes registers the routes, the routes with no role are open to every user signed in, see publicRoutes for
+// the ones open to anyone
 func addRoutes(e *echo.Echo) {
+	circulation := requireRole(model.UserCirculation)
+	librarian := requireRole(model.UserLibrarian)
+	admin := requireRole(model.UserAdmin)
+
 	e.GET("/ping", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, utils.Success("pong"))
 	})
 
 	book := e.Group("/book")
-	book.POST("/create", createBook)
-	book.POST("/create/batch", createBookBatch)
+	book.POST("/create", createBook, librarian)
+	book.POST("/create/batch", createBookBatch, librarian)
+	book.GET("/list", listAllBooksMatched)
 	book.POST("/list", listAllBooksMatched)
-	book.PUT("/update", updateBook)
-	book.PUT("/stock/update", updateBookStock)
-	book.DELETE("/remove", removeBook)
+	book.GET("/isbn/:isbn", queryBookByISBN)
+	book.GET("/search", searchBooks)
+	book.PUT("/update", updateBook, librarian)
+	book.PUT("/stock/update", updateBookStock, librarian)
+	book.DELETE("/remove", removeBook, librarian)
+	book.GET("/copies", listBookCopies)
+	book.POST("/copies/add", addBookCopies, librarian)
+	book.POST("/copies/withdraw", withdrawBookCopies, librarian)
+	book.PUT("/copy/update", updateBookCopy, librarian)
+	book.GET("/:id", queryBook)
+	book.PATCH("/:id", patchBook, librarian)
+	book.GET("/:id/history", queryBookHistory, librarian)
+
+	author := e.Group("/author")
+	author.GET("/list", listAuthors)
+	author.GET("/works", queryAuthorWorks)
 
 	card := e.Group("/card")
-	card.POST("/create", createCard)
+	card.POST("/create", createCard, circulation)
 	card.GET("/get", queryCard)
 	card.GET("/list", listCards)
-	card.DELETE("/remove", removeCard)
+	card.DELETE("/remove", removeCard, librarian)
+	card.PATCH("/:id", patchCard, circulation)
+	card.PUT("/pin", setCardPIN, circulation)
 
 	borrow := e.Group("/borrow")
 	borrow.GET("/list", queryBorrowHistory)
-	borrow.PUT("/borrow", borrowBook)
-	borrow.PUT("/return", returnBook)
+	borrow.PUT("/borrow", borrowBook, circulation)
+	borrow.PUT("/return", returnBook, circulation)
+	borrow.PUT("/renew", renewBook, circulation)
+
+	fine := e.Group("/fine")
+	fine.GET("/list", queryFines)
+	fine.POST("/pay", payFine, circulation)
+	fine.POST("/waive", waiveFine, librarian)
+
+	hold := e.Group("/hold")
+	hold.POST("/place", placeHold, circulation)
+	hold.DELETE("/cancel", cancelHold, circulation)
+	hold.GET("/list", queryCardHolds)
+	hold.GET("/queue", queryBookHolds)
+
+	auth := e.Group("/auth")
+	auth.POST("/login", login)
+	auth.POST("/bootstrap", bootstrapAdmin)
+	auth.POST("/logout", logout)
+	auth.GET("/me", queryCurrentUser)
+	auth.PUT("/password", changePassword)
+
+	user := e.Group("/user", admin)
+	user.GET("/list", listUsers)
+	user.POST("/create", createUser)
+	user.PUT("/role", setUserRole)
+	user.PUT("/password", setUserPassword)
+	user.DELETE("/remove", removeUser)
+
+	// the patron portal, every route is scoped to the card signed in
+	e.POST("/me/login", patronLogin)
+	me := e.Group(patronPrefix, authenticatePatron)
+	me.GET("", queryPatronCard)
+	me.GET("/loans", queryPatronLoans)
+	me.GET("/history", queryPatronHistory)
+	me.GET("/holds", queryPatronHolds)
+	me.GET("/fines", queryPatronFines)
+	me.PUT("/renew", renewPatronLoan)
+	me.POST("/hold/place", placePatronHold)
+	me.DELETE("/hold/cancel", cancelPatronHold)
+	me.PUT("/pin", changePatronPIN)
+	me.POST("/logout", patronLogout)
+
+	e.GET("/audit", listAudit, librarian)
 
-	db := e.Group("/db")
+	// resets are asked for, then confirmed with the token of the confirmation, and only in dev and test
+	db := e.Group("/db", admin)
+	db.POST("/reset/prepare", prepareReset)
 	db.POST("/reset", resetDatabase)
+	db.GET("/backup", backupDatabase)
+	db.POST("/restore", restoreDatabase)
 }
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"LibManSys/model"
+	"LibManSys/utils"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+func listUsers(c echo.Context) error {
+	result := lms(c).ShowUsers()
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func createUser(c echo.Context) error {
+	var request model.UserCreateRequest
+
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind create request",
+			Data: nil,
+		})
+	}
+
+	if request.Username == nil || request.Password == nil || request.Role == nil {
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).CreateUser(*request.Username, *request.Password, model.UserRole(*request.Role))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(result.Payload))
+}
+
+func setUserRole(c echo.Context) error {
+	var request model.UserRoleRequest
+
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind role request",
+			Data: nil,
+		})
+	}
+
+	if request.UserID == nil || request.Role == nil {
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).SetUserRole(*request.UserID, model.UserRole(*request.Role))
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func setUserPassword(c echo.Context) error {
+	var request model.UserPasswordRequest
+
+	err := c.Bind(&request)
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind password request",
+			Data: nil,
+		})
+	}
+
+	if request.UserID == nil || request.Password == nil {
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "missing required field",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).SetUserPassword(*request.UserID, *request.Password)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
+
+func removeUser(c echo.Context) error {
+	var uid int
+	err := echo.QueryParamsBinder(c).MustInt("uid", &uid).BindError()
+	if err != nil {
+		logrus.Error(err)
+		return c.JSON(http.StatusBadRequest, utils.Error{
+			Code: utils.E_BAD_PARAM,
+			Msg:  "failed to bind user id param",
+			Data: nil,
+		})
+	}
+
+	result := lms(c).RemoveUser(uid)
+	if !result.OK {
+		logrus.Error(result.Message)
+		return result.Err()
+	}
+	return c.JSON(http.StatusOK, utils.Success(nil))
+}
@@ -5,12 +5,15 @@ import Welcome from "./components/Welcome.vue";
 import Book from "./components/Book.vue";
 import Card from "./components/Card.vue";
 import Borrow from "./components/Borrow.vue";
+import Login from "./components/Login.vue";
+import axios from "axios";
 
 const routes = [
     { path: "/", component: Welcome},
     { name: "book", path: "/book", component: Book },
     { name: "card", path: "/card", component: Card },
-    { name: "borrow", path : "/borrow", component: Borrow}
+    { name: "borrow", path : "/borrow", component: Borrow},
+    { name: "login", path: "/login", component: Login }
 ];
 
 const router = createRouter({
@@ -18,6 +21,29 @@ const router = createRouter({
     routes,
 });
 
+// every request carries the token of the login, the login page is shown when there is none or it expired
+axios.interceptors.request.use(config => {
+    const token = localStorage.getItem("token");
+    if (token) {
+        config.headers.Authorization = "Bearer " + token;
+    }
+    return config;
+});
+
+axios.interceptors.response.use(res => res, err => {
+    if (err.response && err.response.status === 401) {
+        localStorage.removeItem("token");
+        router.push({ name: "login" });
+    }
+    return Promise.reject(err);
+});
+
+router.beforeEach(to => {
+    if (to.name !== "login" && !localStorage.getItem("token")) {
+        return { name: "login" };
+    }
+});
+
 const app = createApp(App);
 
 app.use(router);